@@ -17,6 +17,9 @@ limitations under the License.
 package v1alpha1
 
 import (
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -44,6 +47,427 @@ type SynapseSpec struct {
 	// Set to true to create a new PostreSQL instance. The homeserver.yaml
 	// 'database' section will be overwritten.
 	CreateNewPostgreSQL bool `json:"createNewPostgreSQL,omitempty"`
+
+	// +kubebuilder:validation:Enum=Delete;Retain;Snapshot
+	// +kubebuilder:default:=Delete
+
+	// Controls what happens to the Synapse data when the Synapse instance is
+	// deleted:
+	// * Delete removes the PVC, the PostgreSQL database and the generated
+	//   Secrets along with the Synapse instance.
+	// * Retain keeps the PVC, the PostgreSQL database and the generated
+	//   Secrets. They are adopted again by a new Synapse instance with the
+	//   same name.
+	// * Snapshot creates a VolumeSnapshot of the PVC before deleting the
+	//   Synapse data.
+	DeletionPolicy SynapseDeletionPolicy `json:"deletionPolicy,omitempty"`
+
+	// Container image of Synapse. Defaults to the version of Synapse the
+	// Synapse Operator is tested with.
+	Image string `json:"image,omitempty"`
+
+	// +kubebuilder:validation:Type=object
+	// +kubebuilder:validation:Schemaless
+	// +kubebuilder:pruning:PreserveUnknownFields
+
+	// Pod template merged into the pod template generated for Synapse, with
+	// the semantics of a strategic merge patch. The Synapse container is
+	// named "synapse". The volumes required by Synapse can't be changed.
+	PodTemplate *corev1.PodTemplateSpec `json:"podTemplate,omitempty"`
+
+	// Configures the PersistentVolumeClaim holding the Synapse data
+	Storage SynapseStorage `json:"storage,omitempty"`
+
+	// Configures the logging of Synapse. The Synapse Operator renders the
+	// corresponding log configuration and sets 'log_config' in
+	// homeserver.yaml. When using an existing homeserver.yaml, its own
+	// 'log_config' is kept unless this section is set.
+	Logging *SynapseLogging `json:"logging,omitempty"`
+
+	// Exposes Synapse with an Ingress, serving the client and federation
+	// APIs on the given host. When set, 'public_baseurl' in homeserver.yaml
+	// is derived from the Ingress host.
+	Ingress *SynapseIngress `json:"ingress,omitempty"`
+
+	// Exposes Synapse with an OpenShift Route. Only available on clusters
+	// serving the route.openshift.io API.
+	Route *SynapseRoute `json:"route,omitempty"`
+
+	// Publishes the /.well-known/matrix/server and /.well-known/matrix/client
+	// documents on the server_name domain, so that Synapse can be hosted on
+	// a different host, e.g. a subdomain.
+	WellKnown *SynapseWellKnown `json:"wellKnown,omitempty"`
+
+	// Configures the federation of Synapse with other homeservers. When
+	// set, Synapse serves the federation API on a dedicated listener,
+	// exposed by the <name>-federation Service. Takes precedence over the
+	// federation options of Spec.Homeserver.Values.
+	Federation *SynapseFederationListener `json:"federation,omitempty"`
+
+	// +listType=map
+	// +listMapKey=name
+
+	// Runs Synapse in worker mode, with the given pools of workers. Each
+	// pool is deployed by its own Deployment and Service. The main Synapse
+	// process and the workers communicate over a Redis instance deployed by
+	// the Synapse Operator. The requests sent to Synapse are routed to the
+	// workers by a reverse proxy deployed by the Synapse Operator.
+	Workers []SynapseWorkerPool `json:"workers,omitempty"`
+
+	// Configures the Redis instance used by the main Synapse process and
+	// its workers to communicate. Only used when Spec.Workers is set. By
+	// default, a Redis instance is deployed by the Synapse Operator.
+	Redis *SynapseRedis `json:"redis,omitempty"`
+
+	// Configures the reverse proxy routing the requests sent to Synapse to
+	// the main Synapse process or to its workers. Only used when
+	// Spec.Workers is set.
+	Proxy *SynapseProxy `json:"proxy,omitempty"`
+}
+
+// SynapseDeletionPolicy describes what happens to the Synapse data when the
+// Synapse instance is deleted
+type SynapseDeletionPolicy string
+
+const (
+	// Delete the Synapse data
+	DeletionPolicyDelete SynapseDeletionPolicy = "Delete"
+
+	// Keep the Synapse data, so that it can be adopted again
+	DeletionPolicyRetain SynapseDeletionPolicy = "Retain"
+
+	// Snapshot the Synapse PVC, then delete the Synapse data
+	DeletionPolicySnapshot SynapseDeletionPolicy = "Snapshot"
+)
+
+type SynapseStorage struct {
+	// Size of the PersistentVolumeClaim. It can be increased if the
+	// StorageClass allows volume expansion, but can't be decreased. Defaults
+	// to 5Gi.
+	Size *resource.Quantity `json:"size,omitempty"`
+}
+
+type SynapseIngress struct {
+	// +kubebuilder:validation:Required
+
+	// Public host name of Synapse, e.g. matrix.example.com
+	Host string `json:"host"`
+
+	// Name of the IngressClass implementing the Ingress. If left empty, the
+	// default IngressClass of the cluster is used.
+	IngressClassName *string `json:"ingressClassName,omitempty"`
+
+	// Additional annotations of the Ingress, e.g. to configure the Ingress
+	// controller
+	Annotations map[string]string `json:"annotations,omitempty"`
+
+	// TLS configuration of the Ingress. If left empty, Synapse is served
+	// over plain HTTP.
+	TLS *SynapseIngressTLS `json:"tls,omitempty"`
+}
+
+type SynapseIngressTLS struct {
+	// Name of the Secret holding the TLS certificate and key for the host.
+	// When an Issuer is set, the Secret is created by cert-manager. Defaults
+	// to <name>-tls.
+	SecretName string `json:"secretName,omitempty"`
+
+	// cert-manager issuer used to obtain the TLS certificate. If left empty,
+	// the Secret must be provided.
+	Issuer *SynapseIngressIssuer `json:"issuer,omitempty"`
+}
+
+type SynapseIngressIssuer struct {
+	// +kubebuilder:validation:Required
+
+	// Name of the cert-manager Issuer or ClusterIssuer
+	Name string `json:"name"`
+
+	// +kubebuilder:validation:Enum=Issuer;ClusterIssuer
+	// +kubebuilder:default:=Issuer
+
+	// Kind of the issuer: a namespaced Issuer or a ClusterIssuer
+	Kind string `json:"kind,omitempty"`
+}
+
+type SynapseRoute struct {
+	// Host name of the Route. If left empty, OpenShift generates a host name
+	// from the name and namespace of the Route.
+	Host string `json:"host,omitempty"`
+
+	// +kubebuilder:validation:Enum=edge;reencrypt
+	// +kubebuilder:default:=edge
+
+	// TLS termination of the Route:
+	// * edge terminates TLS at the router, which forwards plain HTTP to
+	//   Synapse.
+	// * reencrypt terminates TLS at the router, which opens a new TLS
+	//   connection to Synapse. Synapse serves the client and federation APIs
+	//   on an additional TLS listener on port 8443, with a certificate
+	//   issued by the OpenShift service CA.
+	Termination string `json:"termination,omitempty"`
+}
+
+type SynapseWellKnown struct {
+	// +kubebuilder:validation:Enum=Deployment;Synapse
+	// +kubebuilder:default:=Deployment
+
+	// How the documents are served:
+	// * Deployment: by a dedicated web server, exposed by a Service and an
+	//   Ingress on the server_name domain.
+	// * Synapse: by Synapse itself, with 'serve_server_wellknown'. The
+	//   server_name domain must then be routed to Synapse. When Spec.Ingress
+	//   is set, the Synapse Ingress routes /.well-known/matrix on the
+	//   server_name domain to Synapse.
+	Mode string `json:"mode,omitempty"`
+
+	// Address of the federation API, as host:port, published in
+	// /.well-known/matrix/server. Defaults to the host of the Synapse
+	// Ingress or Route, on port 443. Only used by the Deployment mode, as
+	// Synapse publishes the host of its public base URL.
+	Server string `json:"server,omitempty"`
+
+	// Base URL of the homeserver published in /.well-known/matrix/client.
+	// Defaults to the public base URL of Synapse. Only used by the
+	// Deployment mode, as Synapse publishes its public base URL.
+	HomeserverBaseURL string `json:"homeserverBaseURL,omitempty"`
+
+	// Base URL of the identity server published in
+	// /.well-known/matrix/client
+	IdentityServerBaseURL string `json:"identityServerBaseURL,omitempty"`
+
+	// Container image of the web server of the Deployment mode. Defaults to
+	// an unprivileged nginx image.
+	Image string `json:"image,omitempty"`
+
+	// Ingress exposing the web server of the Deployment mode on the
+	// server_name domain. Defaults to the IngressClass, annotations and
+	// cert-manager issuer of Spec.Ingress, if set.
+	Ingress *SynapseWellKnownIngress `json:"ingress,omitempty"`
+}
+
+type SynapseWellKnownIngress struct {
+	// Name of the IngressClass implementing the Ingress. If left empty, the
+	// default IngressClass of the cluster is used.
+	IngressClassName *string `json:"ingressClassName,omitempty"`
+
+	// Additional annotations of the Ingress
+	Annotations map[string]string `json:"annotations,omitempty"`
+
+	// TLS configuration of the Ingress. If left empty, the documents are
+	// served over plain HTTP, which Matrix clients and servers don't
+	// accept.
+	TLS *SynapseIngressTLS `json:"tls,omitempty"`
+}
+
+type SynapseFederationListener struct {
+	// Whether federation is enabled. When disabled, the federation API is
+	// not served and federation is refused with all domains. Defaults to
+	// true.
+	Enabled *bool `json:"enabled,omitempty"`
+
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=65535
+	// +kubebuilder:default:=8448
+
+	// Port of the federation listener and Service
+	Port int32 `json:"port,omitempty"`
+
+	// +kubebuilder:validation:Enum=ClusterIP;NodePort;LoadBalancer
+	// +kubebuilder:default:=ClusterIP
+
+	// Type of the federation Service
+	ServiceType corev1.ServiceType `json:"serviceType,omitempty"`
+
+	// Name of a Secret of type kubernetes.io/tls. When set, the federation
+	// listener terminates TLS with this certificate. Otherwise, TLS must be
+	// terminated in front of the federation Service, e.g. by a load
+	// balancer.
+	TLSSecretName string `json:"tlsSecretName,omitempty"`
+
+	// Restricts federation to the given list of domains. If empty,
+	// federation is allowed with all domains.
+	DomainWhitelist []string `json:"domainWhitelist,omitempty"`
+
+	// IP ranges which Synapse must not access when making outbound
+	// federation requests. Defaults to the private and loopback IP ranges.
+	IPRangeBlacklist []string `json:"ipRangeBlacklist,omitempty"`
+}
+
+// +kubebuilder:validation:Enum=generic_worker;federation_sender;pusher;media_repository;event_persister;appservice
+
+// SynapseWorkerType is the type of the workers of a pool
+type SynapseWorkerType string
+
+const (
+	// Serves the client and federation APIs which can be handled by
+	// workers
+	WorkerTypeGenericWorker SynapseWorkerType = "generic_worker"
+
+	// Sends the outbound federation traffic
+	WorkerTypeFederationSender SynapseWorkerType = "federation_sender"
+
+	// Sends the push notifications
+	WorkerTypePusher SynapseWorkerType = "pusher"
+
+	// Serves the media repository APIs
+	WorkerTypeMediaRepository SynapseWorkerType = "media_repository"
+
+	// Persists the events in the database
+	WorkerTypeEventPersister SynapseWorkerType = "event_persister"
+
+	// Sends the events to the application services, e.g. bridges
+	WorkerTypeAppservice SynapseWorkerType = "appservice"
+)
+
+// IsScalable returns true if the workers of the given type are
+// interchangeable, and can therefore be replicated. Other workers are
+// addressed by name in homeserver.yaml. The media_repository workers store
+// the media on the ReadWriteOnce Synapse PVC, and aren't replicated either.
+func (t SynapseWorkerType) IsScalable() bool {
+	return t == WorkerTypeGenericWorker
+}
+
+// IsAutoscalable returns true if the pools of workers of the given type can
+// be scaled by a HorizontalPodAutoscaler. The federation_sender workers are
+// run by a StatefulSet, whose pods have stable names listed in
+// homeserver.yaml.
+func (t SynapseWorkerType) IsAutoscalable() bool {
+	return t.IsScalable() || t == WorkerTypeFederationSender
+}
+
+type SynapseWorkerPool struct {
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:MaxLength=40
+	// +kubebuilder:validation:Pattern=`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`
+
+	// Name of the pool. The resources of the pool are named
+	// <name>-worker-<pool name>.
+	Name string `json:"name"`
+
+	// +kubebuilder:validation:Required
+
+	// Type of the workers of the pool
+	Type SynapseWorkerType `json:"type"`
+
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:default:=1
+
+	// Number of workers in the pool. Only generic_worker pools can have
+	// more than one worker. Other worker types are sharded by declaring
+	// several pools, except for media_repository and appservice which are
+	// limited to a single pool. Ignored when Autoscaling is set.
+	Replicas *int32 `json:"replicas,omitempty"`
+
+	// Compute resources of the workers of the pool. CPU requests are
+	// required to autoscale the pool on its CPU utilization.
+	Resources corev1.ResourceRequirements `json:"resources,omitempty"`
+
+	// Scales the pool with a HorizontalPodAutoscaler. Only generic_worker
+	// and federation_sender pools can be autoscaled.
+	Autoscaling *SynapseWorkerPoolAutoscaling `json:"autoscaling,omitempty"`
+}
+
+type SynapseWorkerPoolAutoscaling struct {
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:default:=1
+
+	// Minimum number of workers in the pool
+	MinReplicas *int32 `json:"minReplicas,omitempty"`
+
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:Minimum=1
+
+	// Maximum number of workers in the pool
+	MaxReplicas int32 `json:"maxReplicas"`
+
+	// +kubebuilder:validation:Minimum=1
+
+	// Target average CPU utilization of the workers, in percent of their
+	// CPU requests. Defaults to 80 when no Metrics are set.
+	TargetCPUUtilizationPercentage *int32 `json:"targetCPUUtilizationPercentage,omitempty"`
+
+	// Additional metrics used to scale the pool, e.g. custom or external
+	// metrics. See
+	// https://kubernetes.io/docs/tasks/run-application/horizontal-pod-autoscale/
+	Metrics []autoscalingv2.MetricSpec `json:"metrics,omitempty"`
+}
+
+type SynapseRedis struct {
+	// External Redis instance to use. When set, no Redis instance is
+	// deployed by the Synapse Operator.
+	External *SynapseExternalRedis `json:"external,omitempty"`
+
+	// Container image of the Redis instance deployed by the Synapse
+	// Operator. Defaults to the version of Redis the Synapse Operator is
+	// tested with.
+	Image string `json:"image,omitempty"`
+
+	// +kubebuilder:default:=false
+
+	// Set to true to protect the Redis instance deployed by the Synapse
+	// Operator with a random password, stored in the <name>-redis Secret.
+	GeneratePassword bool `json:"generatePassword,omitempty"`
+}
+
+type SynapseExternalRedis struct {
+	// +kubebuilder:validation:Required
+
+	// Host of the Redis instance
+	Host string `json:"host"`
+
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=65535
+	// +kubebuilder:default:=6379
+
+	// Port of the Redis instance
+	Port int32 `json:"port,omitempty"`
+
+	// Key of a Secret of the Synapse namespace holding the password of the
+	// Redis instance, if any
+	PasswordSecretKeyRef *corev1.SecretKeySelector `json:"passwordSecretKeyRef,omitempty"`
+}
+
+type SynapseProxy struct {
+	// Container image of the nginx reverse proxy. Defaults to the version
+	// of nginx the Synapse Operator is tested with.
+	Image string `json:"image,omitempty"`
+
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:default:=1
+
+	// Number of replicas of the reverse proxy
+	Replicas *int32 `json:"replicas,omitempty"`
+}
+
+// +kubebuilder:validation:Enum=DEBUG;INFO;WARNING;ERROR;CRITICAL
+
+// SynapseLogLevel is the level of a Python logger
+type SynapseLogLevel string
+
+type SynapseLogging struct {
+	// +kubebuilder:default:=INFO
+
+	// Log level of the root logger
+	Level SynapseLogLevel `json:"level,omitempty"`
+
+	// Log level of specific loggers, by logger name (e.g.
+	// synapse.storage.SQL)
+	Loggers map[string]SynapseLogLevel `json:"loggers,omitempty"`
+
+	// +kubebuilder:validation:Enum=text;json
+	// +kubebuilder:default:=text
+
+	// Format of the log lines, either human-readable text or structured
+	// JSON
+	Format string `json:"format,omitempty"`
+
+	// +kubebuilder:validation:Enum=console;file
+	// +kubebuilder:default:=console
+
+	// Where logs are written: to the console, or to a rotated
+	// homeserver.log file on the Synapse PVC
+	Output string `json:"output,omitempty"`
 }
 
 type SynapseHomeserver struct {
@@ -55,6 +479,86 @@ type SynapseHomeserver struct {
 	// Holds the required values for the creation of a homeserver.yaml
 	// configuration file by the Synapse Operator
 	Values *SynapseHomeserverValues `json:"values,omitempty"`
+
+	// Holds information about the Secret containing the
+	// registration_shared_secret, macaroon_secret_key and form_secret values
+	// to be used by Synapse. If left empty, the Synapse Operator generates
+	// random values and stores them in a Secret owned by the Synapse
+	// instance.
+	Secret *SynapseHomeserverSecret `json:"secret,omitempty"`
+
+	// Holds information about the Secret containing the ed25519 signing key
+	// of Synapse. If left empty, the Synapse Operator generates a signing key
+	// and stores it in a Secret owned by the Synapse instance. The rotation
+	// of a generated signing key is requested with the
+	// synapse.opdev.io/rotate-signing-key annotation.
+	SigningKeySecret *SynapseHomeserverSigningKeySecret `json:"signingKeySecret,omitempty"`
+
+	// List of homeserver.yaml fragments, deep-merged in order on top of the
+	// homeserver.yaml generated from Values or copied from ConfigMap. Keys
+	// managed by the Synapse Operator, like 'database' when
+	// CreateNewPostgreSQL is true, can't be overridden.
+	Overrides []SynapseHomeserverOverride `json:"overrides,omitempty"`
+}
+
+// SynapseHomeserverOverride holds a homeserver.yaml fragment. Exactly one of
+// Inline, ConfigMap or Secret must be set.
+type SynapseHomeserverOverride struct {
+	// Inline YAML fragment
+	Inline string `json:"inline,omitempty"`
+
+	// Reference to a ConfigMap key holding a YAML fragment
+	ConfigMap *SynapseHomeserverOverrideConfigMap `json:"configMap,omitempty"`
+
+	// Reference to a Secret key holding a YAML fragment. The Secret must
+	// live in the Synapse namespace.
+	Secret *SynapseHomeserverOverrideSecret `json:"secret,omitempty"`
+}
+
+type SynapseHomeserverOverrideConfigMap struct {
+	// +kubebuilder:validation:Required
+
+	// Name of the ConfigMap in the given Namespace.
+	Name string `json:"name"`
+
+	// Namespace in which the ConfigMap is living. If left empty, the Synapse
+	// namespace is used.
+	Namespace string `json:"namespace,omitempty"`
+
+	// +kubebuilder:validation:Required
+
+	// Key of the ConfigMap data holding the YAML fragment
+	Key string `json:"key"`
+}
+
+type SynapseHomeserverOverrideSecret struct {
+	// +kubebuilder:validation:Required
+
+	// Name of the Secret in the Synapse namespace.
+	Name string `json:"name"`
+
+	// +kubebuilder:validation:Required
+
+	// Key of the Secret data holding the YAML fragment
+	Key string `json:"key"`
+}
+
+type SynapseHomeserverSigningKeySecret struct {
+	// +kubebuilder:validation:Required
+
+	// Name of the Secret in the Synapse namespace. It must contain a
+	// 'signing.key' key, in the format used by Synapse (e.g.
+	// 'ed25519 a_AbCd <base64-encoded seed>').
+	Name string `json:"name"`
+}
+
+type SynapseHomeserverSecret struct {
+	// +kubebuilder:validation:Required
+
+	// Name of the Secret in the Synapse namespace. It must contain the
+	// 'registration_shared_secret', 'macaroon_secret_key' and 'form_secret'
+	// keys.
+	Name string `json:"name"`
 }
 
 type SynapseHomeserverConfigMap struct {
@@ -78,6 +582,206 @@ type SynapseHomeserverValues struct {
 
 	// Whether or not to report anonymized homeserver usage statistics
 	ReportStats bool `json:"reportStats"`
+
+	// The public-facing base URL that clients use to access this homeserver.
+	// Defaults to https://<serverName>/.
+	PublicBaseURL string `json:"publicBaseURL,omitempty"`
+
+	// List of ports that Synapse should listen on. Defaults to a single HTTP
+	// listener on port 8008, serving the client and federation APIs.
+	Listeners []SynapseListener `json:"listeners,omitempty"`
+
+	// Registration of new users on the homeserver
+	Registration *SynapseRegistration `json:"registration,omitempty"`
+
+	// Federation with other homeservers
+	Federation *SynapseFederation `json:"federation,omitempty"`
+
+	// Rate limits applied to the clients of the homeserver
+	RateLimits *SynapseRateLimits `json:"rateLimits,omitempty"`
+
+	// Limits of the media repository
+	Media *SynapseMedia `json:"media,omitempty"`
+
+	// Generation of URL previews
+	URLPreviews *SynapseURLPreviews `json:"urlPreviews,omitempty"`
+
+	// Tracking of the users presence
+	Presence *SynapsePresence `json:"presence,omitempty"`
+
+	// Message retention policies
+	Retention *SynapseRetention `json:"retention,omitempty"`
+}
+
+type SynapseListener struct {
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=65535
+
+	// Port on which the listener is bound
+	Port int32 `json:"port"`
+
+	// Local addresses to listen on. Defaults to all addresses.
+	BindAddresses []string `json:"bindAddresses,omitempty"`
+
+	// +kubebuilder:validation:Enum=http;manhole;metrics;replication
+	// +kubebuilder:default:=http
+
+	// Type of the listener
+	Type string `json:"type,omitempty"`
+
+	// Whether the listener uses TLS
+	TLS bool `json:"tls,omitempty"`
+
+	// Whether to use the X-Forwarded-For header as the client IP address.
+	// Useful when Synapse is behind a reverse proxy.
+	XForwarded bool `json:"xForwarded,omitempty"`
+
+	// Resources served by an http listener
+	Resources []SynapseListenerResource `json:"resources,omitempty"`
+}
+
+type SynapseListenerResource struct {
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:MinItems=1
+
+	// Names of the resources. Valid names include client, federation,
+	// keys, media, metrics, openid, replication, static and health.
+	Names []string `json:"names"`
+
+	// Whether to enable the HTTP compression for the resources
+	Compress bool `json:"compress,omitempty"`
+}
+
+type SynapseRegistration struct {
+	// Whether new users can register on the homeserver
+	Enabled bool `json:"enabled,omitempty"`
+
+	// Whether new users can register without any verification, like an
+	// email, a captcha or a registration token. Required to enable the
+	// registration without any verification.
+	EnabledWithoutVerification bool `json:"enabledWithoutVerification,omitempty"`
+
+	// Whether a registration token is required to register
+	RequiresToken bool `json:"requiresToken,omitempty"`
+
+	// Whether guest users can access the homeserver
+	AllowGuestAccess bool `json:"allowGuestAccess,omitempty"`
+
+	// Rooms that new users automatically join
+	AutoJoinRooms []string `json:"autoJoinRooms,omitempty"`
+}
+
+type SynapseFederation struct {
+	// Whether federation is enabled. Defaults to true.
+	Enabled *bool `json:"enabled,omitempty"`
+
+	// Restricts federation to the given list of domains. If empty,
+	// federation is allowed with all domains.
+	DomainWhitelist []string `json:"domainWhitelist,omitempty"`
+
+	// Whether the public room directory can be accessed by other
+	// homeservers
+	AllowPublicRoomsOverFederation bool `json:"allowPublicRoomsOverFederation,omitempty"`
+
+	// Servers trusted to provide the signing keys of other homeservers.
+	// Defaults to matrix.org.
+	TrustedKeyServers []string `json:"trustedKeyServers,omitempty"`
+}
+
+type SynapseRateLimits struct {
+	// Number of messages a client can send
+	Message *SynapseRateLimit `json:"message,omitempty"`
+
+	// Number of registration requests a client can send
+	Registration *SynapseRateLimit `json:"registration,omitempty"`
+
+	// Number of login requests a client can send, per address and per
+	// account
+	Login *SynapseRateLimit `json:"login,omitempty"`
+}
+
+type SynapseRateLimit struct {
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:Pattern=`^[0-9]+(\.[0-9]+)?$`
+
+	// Number of requests per second allowed on average, as a decimal number
+	PerSecond string `json:"perSecond"`
+
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:Minimum=1
+
+	// Number of requests allowed in a burst
+	BurstCount int32 `json:"burstCount"`
+}
+
+type SynapseMedia struct {
+	// +kubebuilder:validation:Pattern=`^[0-9]+[KMG]?$`
+
+	// Largest allowed upload size, for example 50M. Defaults to 50M.
+	MaxUploadSize string `json:"maxUploadSize,omitempty"`
+
+	// +kubebuilder:validation:Pattern=`^[0-9]+[KMG]?$`
+
+	// Maximum number of pixels of an image to be thumbnailed. Defaults to
+	// 32M.
+	MaxImagePixels string `json:"maxImagePixels,omitempty"`
+
+	// Whether to generate thumbnails on the fly, matching the size
+	// requested by the clients
+	DynamicThumbnails bool `json:"dynamicThumbnails,omitempty"`
+}
+
+type SynapseURLPreviews struct {
+	// Whether URL previews are enabled
+	Enabled bool `json:"enabled,omitempty"`
+
+	// IP ranges which Synapse must not access when generating URL
+	// previews. Defaults to the private and loopback IP ranges.
+	IPRangeBlacklist []string `json:"ipRangeBlacklist,omitempty"`
+
+	// +kubebuilder:validation:Pattern=`^[0-9]+[KMG]?$`
+
+	// Largest allowed size of a page to preview. Defaults to 10M.
+	MaxSpiderSize string `json:"maxSpiderSize,omitempty"`
+}
+
+type SynapsePresence struct {
+	// +kubebuilder:default:=true
+
+	// Whether the presence of users is tracked. Disabling presence reduces
+	// the load on the homeserver.
+	Enabled *bool `json:"enabled,omitempty"`
+}
+
+type SynapseRetention struct {
+	// Whether message retention policies are enforced
+	Enabled bool `json:"enabled,omitempty"`
+
+	// Retention policy applied to rooms without a policy
+	DefaultPolicy *SynapseRetentionPolicy `json:"defaultPolicy,omitempty"`
+
+	// +kubebuilder:validation:Pattern=`^[0-9]+[smhdwy]$`
+
+	// Smallest lifetime allowed in a room policy, for example 1d
+	AllowedLifetimeMin string `json:"allowedLifetimeMin,omitempty"`
+
+	// +kubebuilder:validation:Pattern=`^[0-9]+[smhdwy]$`
+
+	// Largest lifetime allowed in a room policy, for example 1y
+	AllowedLifetimeMax string `json:"allowedLifetimeMax,omitempty"`
+}
+
+type SynapseRetentionPolicy struct {
+	// +kubebuilder:validation:Pattern=`^[0-9]+[smhdwy]$`
+
+	// Minimum lifetime of the messages, for example 1d
+	MinLifetime string `json:"minLifetime,omitempty"`
+
+	// +kubebuilder:validation:Pattern=`^[0-9]+[smhdwy]$`
+
+	// Maximum lifetime of the messages, for example 1y
+	MaxLifetime string `json:"maxLifetime,omitempty"`
 }
 
 type SynapseBridges struct {
@@ -97,6 +801,20 @@ type SynapseHeisenbridge struct {
 	// Whether to deploy Heisenbridge or not
 	Enabled bool `json:"enabled,omitempty"`
 
+	// Container image of Heisenbridge. Defaults to the version of
+	// Heisenbridge the Synapse Operator is tested with.
+	Image string `json:"image,omitempty"`
+
+	// +kubebuilder:validation:Type=object
+	// +kubebuilder:validation:Schemaless
+	// +kubebuilder:pruning:PreserveUnknownFields
+
+	// Pod template merged into the pod template generated for Heisenbridge,
+	// with the semantics of a strategic merge patch. The Heisenbridge
+	// container is named "heisenbridge". The volumes required by
+	// Heisenbridge can't be changed.
+	PodTemplate *corev1.PodTemplateSpec `json:"podTemplate,omitempty"`
+
 	// Holds information about the ConfigMap containing the heisenbridge.yaml
 	// configuration file to be used as input for the configuration of the
 	// Heisenbridge IRC Bridge.
@@ -137,14 +855,76 @@ type SynapseStatus struct {
 	// Holds configuration information for Synapse
 	HomeserverConfiguration SynapseStatusHomeserverConfiguration `json:"homeserverConfiguration,omitempty"`
 
-	// Synapse IP address (corresponding to the Synapse Service IP address)
+	// Synapse IP address (corresponding to the Synapse Service IP address).
+	// Informational only, Synapse is addressed by its Service DNS name.
 	IP string `json:"ip,omitempty"`
 
+	// Status of the OpenShift Route exposing Synapse
+	Route SynapseStatusRoute `json:"route,omitempty"`
+
 	// State of the Synapse instance
 	State string `json:"state,omitempty"`
 
 	// Reason for the current Synapse State
 	Reason string `json:"reason,omitempty"`
+
+	// +listType=map
+	// +listMapKey=type
+	// +patchStrategy=merge
+	// +patchMergeKey=type
+
+	// Conditions representing the latest available observations of the
+	// Synapse state
+	Conditions []metav1.Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type"`
+
+	// Generation of the Synapse observed by the Synapse Operator
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+}
+
+// Condition types reported in the Synapse Status
+const (
+	// The Synapse instance is fully reconciled and available
+	ConditionTypeReady = "Ready"
+
+	// The configuration provided for Synapse and its bridges is valid
+	ConditionTypeConfigurationValid = "ConfigurationValid"
+
+	// The PostgreSQL database created for Synapse is ready. Only set when
+	// Spec.CreateNewPostgreSQL is true.
+	ConditionTypeDatabaseReady = "DatabaseReady"
+
+	// The Heisenbridge Deployment is available. Only set when Heisenbridge
+	// is enabled.
+	ConditionTypeHeisenbridgeReady = "HeisenbridgeReady"
+
+	// The Synapse Deployment is available
+	ConditionTypeDeploymentAvailable = "DeploymentAvailable"
+
+	// The homeserver.yaml overrides are applied without conflicting with
+	// the keys managed by the Synapse Operator. Only set when
+	// Spec.Homeserver.Overrides is not empty.
+	ConditionTypeOverridesApplied = "OverridesApplied"
+
+	// The OpenShift Route exposing Synapse is admitted by the router. Only
+	// set when Spec.Route is set.
+	ConditionTypeRouteAdmitted = "RouteAdmitted"
+
+	// The Redis instance used by the workers is ready. Only set when
+	// Spec.Workers is set.
+	ConditionTypeRedisReady = "RedisReady"
+
+	// The reverse proxy routing the requests to the workers is available.
+	// Only set when Spec.Workers is set.
+	ConditionTypeProxyReady = "ProxyReady"
+
+	// The VolumeSnapshot of the Synapse PVC is ready to use. Only set while
+	// a Synapse instance with the Snapshot DeletionPolicy is being deleted.
+	ConditionTypeSnapshotReady = "SnapshotReady"
+)
+
+type SynapseStatusRoute struct {
+	// Host name admitted by the OpenShift router
+	Host string `json:"host,omitempty"`
 }
 
 type SynapseStatusBridgesConfiguration struct {
@@ -153,7 +933,8 @@ type SynapseStatusBridgesConfiguration struct {
 }
 
 type SynapseStatusHeisenbridge struct {
-	// IP at which the Heisenbridge is available
+	// IP at which the Heisenbridge is available. Informational only, the
+	// Heisenbridge is addressed by its Service DNS name.
 	IP string `json:"ip,omitempty"`
 }
 
@@ -167,19 +948,39 @@ type SynapseStatusDatabaseConnectionInfo struct {
 	// User allowed to query the given database
 	User string `json:"user,omitempty"`
 
-	// Base64 encoded password
+	// Reference to the Secret key holding the password of the database user
+	PasswordSecretKeyRef SynapseStatusSecretKeyRef `json:"passwordSecretKeyRef,omitempty"`
+
+	// Deprecated: the password is no longer stored in the Synapse Status, see
+	// PasswordSecretKeyRef instead. This field is only kept for the migration
+	// of existing Synapse instances, and is cleared by the Synapse Operator.
 	Password string `json:"password,omitempty"`
 
 	// State of the PostgreSQL database
 	State string `json:"State,omitempty"`
 }
 
+type SynapseStatusSecretKeyRef struct {
+	// Name of the Secret in the Synapse namespace
+	Name string `json:"name,omitempty"`
+
+	// Key of the Secret data holding the value
+	Key string `json:"key,omitempty"`
+}
+
 type SynapseStatusHomeserverConfiguration struct {
 	// The public-facing domain of the server
 	ServerName string `json:"serverName,omitempty"`
 
 	// Whether or not to report anonymized homeserver usage statistics
 	ReportStats bool `json:"reportStats,omitempty"`
+
+	// ID of the signing key currently used by Synapse (e.g. ed25519:a_AbCd)
+	SigningKeyID string `json:"signingKeyID,omitempty"`
+
+	// Warnings raised while validating the user-provided homeserver.yaml,
+	// such as unknown options
+	Warnings []string `json:"warnings,omitempty"`
 }
 
 //+kubebuilder:object:root=true
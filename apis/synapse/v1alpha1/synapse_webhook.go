@@ -0,0 +1,509 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+)
+
+// Default values of the Synapse Spec
+const (
+	DefaultSynapseImage      = "matrixdotorg/synapse:v1.60.0"
+	DefaultHeisenbridgeImage = "hif1/heisenbridge:1.13"
+	DefaultStorageSize       = "5Gi"
+	DefaultWellKnownImage    = "nginxinc/nginx-unprivileged:1.23-alpine"
+	DefaultFederationPort    = 8448
+	DefaultRedisImage        = "redis:6.2-alpine"
+	DefaultRedisPort         = 6379
+	DefaultProxyImage        = "nginxinc/nginx-unprivileged:1.23-alpine"
+	DefaultTargetCPU         = 80
+)
+
+// log is for logging in this package.
+var synapselog = logf.Log.WithName("synapse-resource")
+
+// SetupWebhookWithManager registers the defaulting and validating webhooks
+// of the Synapse resource. The validating webhook reads the ConfigMaps
+// referenced by the Synapse instances with the client of the manager.
+func (r *Synapse) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(r).
+		WithValidator(&SynapseValidator{Client: mgr.GetClient()}).
+		Complete()
+}
+
+//+kubebuilder:webhook:path=/mutate-synapse-opdev-io-v1alpha1-synapse,mutating=true,failurePolicy=fail,sideEffects=None,groups=synapse.opdev.io,resources=synapses,verbs=create;update,versions=v1alpha1,name=msynapse.kb.io,admissionReviewVersions=v1
+
+var _ webhook.Defaulter = &Synapse{}
+
+// Default implements webhook.Defaulter so a webhook will be registered for
+// the type. It is also used by the Synapse Operator, so that the same
+// defaults apply when the webhooks are not deployed.
+func (r *Synapse) Default() {
+	synapselog.V(1).Info("default", "name", r.Name)
+
+	if r.Spec.Image == "" {
+		r.Spec.Image = DefaultSynapseImage
+	}
+
+	if r.Spec.Storage.Size == nil {
+		size := resource.MustParse(DefaultStorageSize)
+		r.Spec.Storage.Size = &size
+	}
+
+	if r.Spec.WellKnown != nil && r.Spec.WellKnown.Image == "" {
+		r.Spec.WellKnown.Image = DefaultWellKnownImage
+	}
+
+	if federation := r.Spec.Federation; federation != nil {
+		if federation.Port == 0 {
+			federation.Port = DefaultFederationPort
+		}
+		if federation.ServiceType == "" {
+			federation.ServiceType = corev1.ServiceTypeClusterIP
+		}
+	}
+
+	for i := range r.Spec.Workers {
+		if r.Spec.Workers[i].Replicas == nil {
+			replicas := int32(1)
+			r.Spec.Workers[i].Replicas = &replicas
+		}
+
+		if autoscaling := r.Spec.Workers[i].Autoscaling; autoscaling != nil {
+			if autoscaling.MinReplicas == nil {
+				minReplicas := int32(1)
+				autoscaling.MinReplicas = &minReplicas
+			}
+			if autoscaling.TargetCPUUtilizationPercentage == nil && len(autoscaling.Metrics) == 0 {
+				targetCPU := int32(DefaultTargetCPU)
+				autoscaling.TargetCPUUtilizationPercentage = &targetCPU
+			}
+		}
+	}
+
+	// The workers communicate with the main Synapse process over Redis
+	if len(r.Spec.Workers) != 0 && r.Spec.Redis == nil {
+		r.Spec.Redis = &SynapseRedis{}
+	}
+	if redis := r.Spec.Redis; redis != nil {
+		if redis.External == nil && redis.Image == "" {
+			redis.Image = DefaultRedisImage
+		}
+		if redis.External != nil && redis.External.Port == 0 {
+			redis.External.Port = DefaultRedisPort
+		}
+	}
+
+	// The requests are routed to the workers by a reverse proxy
+	if len(r.Spec.Workers) != 0 && r.Spec.Proxy == nil {
+		r.Spec.Proxy = &SynapseProxy{}
+	}
+	if proxy := r.Spec.Proxy; proxy != nil {
+		if proxy.Image == "" {
+			proxy.Image = DefaultProxyImage
+		}
+		if proxy.Replicas == nil {
+			replicas := int32(1)
+			proxy.Replicas = &replicas
+		}
+	}
+
+	heisenbridge := &r.Spec.Bridges.Heisenbridge
+	if heisenbridge.Enabled {
+		if heisenbridge.Image == "" {
+			heisenbridge.Image = DefaultHeisenbridgeImage
+		}
+		if heisenbridge.ConfigMap.Name != "" && heisenbridge.ConfigMap.Namespace == "" {
+			heisenbridge.ConfigMap.Namespace = r.Namespace
+		}
+	}
+}
+
+//+kubebuilder:webhook:path=/validate-synapse-opdev-io-v1alpha1-synapse,mutating=false,failurePolicy=fail,sideEffects=None,groups=synapse.opdev.io,resources=synapses,verbs=create;update,versions=v1alpha1,name=vsynapse.kb.io,admissionReviewVersions=v1
+
+//+kubebuilder:object:generate=false
+
+// SynapseValidator validates the Synapse resources. It checks the
+// references to ConfigMaps with the given client.
+type SynapseValidator struct {
+	Client client.Reader
+}
+
+var _ webhook.CustomValidator = &SynapseValidator{}
+
+// ValidateCreate implements webhook.CustomValidator so a webhook will be
+// registered for the type
+func (v *SynapseValidator) ValidateCreate(ctx context.Context, obj runtime.Object) error {
+	synapse, ok := obj.(*Synapse)
+	if !ok {
+		return fmt.Errorf("expected a Synapse but got a %T", obj)
+	}
+	synapselog.V(1).Info("validate create", "name", synapse.Name)
+
+	return synapseInvalidError(synapse, v.validateSynapse(ctx, synapse, nil))
+}
+
+// ValidateUpdate implements webhook.CustomValidator so a webhook will be
+// registered for the type
+func (v *SynapseValidator) ValidateUpdate(ctx context.Context, oldObj, newObj runtime.Object) error {
+	oldSynapse, ok := oldObj.(*Synapse)
+	if !ok {
+		return fmt.Errorf("expected a Synapse but got a %T", oldObj)
+	}
+	synapse, ok := newObj.(*Synapse)
+	if !ok {
+		return fmt.Errorf("expected a Synapse but got a %T", newObj)
+	}
+	synapselog.V(1).Info("validate update", "name", synapse.Name)
+
+	// The finalizer of a Synapse being deleted must always be removable
+	if synapse.DeletionTimestamp != nil {
+		return nil
+	}
+
+	errs := v.validateSynapse(ctx, synapse, oldSynapse)
+
+	// Synapse can't handle a change of server name once its database is
+	// initialized
+	oldServerName := oldSynapse.Status.HomeserverConfiguration.ServerName
+	if oldServerName == "" && oldSynapse.Spec.Homeserver.Values != nil {
+		oldServerName = oldSynapse.Spec.Homeserver.Values.ServerName
+	}
+	if values := synapse.Spec.Homeserver.Values; values != nil && oldServerName != "" && values.ServerName != oldServerName {
+		errs = append(errs, field.Forbidden(
+			field.NewPath("spec", "homeserver", "values", "serverName"),
+			"the server name can't be changed from "+oldServerName+" once Synapse is deployed",
+		))
+	}
+
+	// The PostgreSQL database created for Synapse holds its data
+	if oldSynapse.Spec.CreateNewPostgreSQL && !synapse.Spec.CreateNewPostgreSQL &&
+		oldSynapse.Status.DatabaseConnectionInfo.ConnectionURL != "" {
+		errs = append(errs, field.Forbidden(
+			field.NewPath("spec", "createNewPostgreSQL"),
+			"can't be disabled once the PostgreSQL database holding the Synapse data is created",
+		))
+	}
+
+	// PersistentVolumeClaims can't be shrunk
+	if oldSize, size := oldSynapse.Spec.Storage.Size, synapse.Spec.Storage.Size; oldSize != nil && size != nil && size.Cmp(*oldSize) < 0 {
+		errs = append(errs, field.Forbidden(
+			field.NewPath("spec", "storage", "size"),
+			"can't be decreased from "+oldSize.String(),
+		))
+	}
+
+	return synapseInvalidError(synapse, errs)
+}
+
+// ValidateDelete implements webhook.CustomValidator so a webhook will be
+// registered for the type
+func (v *SynapseValidator) ValidateDelete(ctx context.Context, obj runtime.Object) error {
+	return nil
+}
+
+// validateSynapse checks the fields of a Synapse which are validated both on
+// creation and on update. On update, the previous version of the Synapse is
+// given in oldSynapse, and only the fields which changed are checked, so that
+// existing Synapse instances can still be updated.
+func (v *SynapseValidator) validateSynapse(ctx context.Context, synapse *Synapse, oldSynapse *Synapse) field.ErrorList {
+	var errs field.ErrorList
+
+	if oldSynapse == nil {
+		oldSynapse = &Synapse{}
+	}
+
+	if values := synapse.Spec.Homeserver.Values; values != nil &&
+		(oldSynapse.Spec.Homeserver.Values == nil || oldSynapse.Spec.Homeserver.Values.ServerName != values.ServerName) {
+		if err := ValidateServerName(values.ServerName); err != nil {
+			errs = append(errs, field.Invalid(
+				field.NewPath("spec", "homeserver", "values", "serverName"),
+				values.ServerName,
+				err.Error(),
+			))
+		}
+	}
+
+	// public_baseurl is derived from the Ingress host
+	if hasPublicBaseURLAndIngress(synapse) && !hasPublicBaseURLAndIngress(oldSynapse) {
+		errs = append(errs, field.Forbidden(
+			field.NewPath("spec", "homeserver", "values", "publicBaseURL"),
+			"can't be set together with spec.ingress, which defines the public base URL",
+		))
+	}
+
+	// The federation options of Spec.Federation and Spec.Homeserver.Values
+	// are mutually exclusive
+	if hasFederationInValuesAndSpec(synapse) && !hasFederationInValuesAndSpec(oldSynapse) {
+		errs = append(errs, field.Forbidden(
+			field.NewPath("spec", "homeserver", "values", "federation"),
+			"enabled and domainWhitelist can't be set together with spec.federation",
+		))
+	}
+
+	// The TLS certificate of Synapse is used by a single listener
+	if hasFederationTLSAndReencryptRoute(synapse) && !hasFederationTLSAndReencryptRoute(oldSynapse) {
+		errs = append(errs, field.Forbidden(
+			field.NewPath("spec", "federation", "tlsSecretName"),
+			"can't be set together with a reencrypt spec.route",
+		))
+	}
+
+	// The password of an external Redis instance is provided by the user
+	if hasExternalRedisAndGeneratedPassword(synapse) && !hasExternalRedisAndGeneratedPassword(oldSynapse) {
+		errs = append(errs, field.Forbidden(
+			field.NewPath("spec", "redis", "generatePassword"),
+			"can't be set together with spec.redis.external, use passwordSecretKeyRef instead",
+		))
+	}
+
+	// Workers which are addressed by name in homeserver.yaml can't be
+	// replicated
+	oldReplicatedWorkers := replicatedNamedWorkers(oldSynapse)
+	for i, pool := range synapse.Spec.Workers {
+		if !pool.Type.IsScalable() && pool.Replicas != nil && *pool.Replicas > 1 &&
+			!containsString(oldReplicatedWorkers, pool.Name) {
+			errs = append(errs, field.Invalid(
+				field.NewPath("spec", "workers").Index(i).Child("replicas"),
+				*pool.Replicas,
+				"a "+string(pool.Type)+" pool can't have more than one worker"+shardingHint(pool.Type),
+			))
+		}
+	}
+
+	// Only the generic_worker and federation_sender pools can be autoscaled
+	oldAutoscalingErrors := map[string]string{}
+	for _, pool := range oldSynapse.Spec.Workers {
+		oldAutoscalingErrors[pool.Name] = workerPoolAutoscalingError(pool)
+	}
+	for i, pool := range synapse.Spec.Workers {
+		if msg := workerPoolAutoscalingError(pool); msg != "" && msg != oldAutoscalingErrors[pool.Name] {
+			errs = append(errs, field.Forbidden(
+				field.NewPath("spec", "workers").Index(i).Child("autoscaling"),
+				msg,
+			))
+		}
+	}
+
+	// Only one worker can notify the application services
+	if countWorkerPools(synapse, WorkerTypeAppservice) > 1 && countWorkerPools(oldSynapse, WorkerTypeAppservice) <= 1 {
+		errs = append(errs, field.Forbidden(
+			field.NewPath("spec", "workers"),
+			"only one appservice pool can be declared",
+		))
+	}
+
+	// The media repository is stored on the ReadWriteOnce Synapse PVC
+	if countWorkerPools(synapse, WorkerTypeMediaRepository) > 1 && countWorkerPools(oldSynapse, WorkerTypeMediaRepository) <= 1 {
+		errs = append(errs, field.Forbidden(
+			field.NewPath("spec", "workers"),
+			"only one media_repository pool can be declared",
+		))
+	}
+
+	heisenbridge := synapse.Spec.Bridges.Heisenbridge
+	oldHeisenbridge := oldSynapse.Spec.Bridges.Heisenbridge
+	if heisenbridge.Enabled && heisenbridge.ConfigMap.Name != "" &&
+		(!oldHeisenbridge.Enabled || oldHeisenbridge.ConfigMap != heisenbridge.ConfigMap) {
+		path := field.NewPath("spec", "bridges", "heisenbridge", "configMap")
+
+		namespace := heisenbridge.ConfigMap.Namespace
+		if namespace == "" {
+			namespace = synapse.Namespace
+		}
+
+		var cm corev1.ConfigMap
+		if err := v.Client.Get(ctx, types.NamespacedName{Name: heisenbridge.ConfigMap.Name, Namespace: namespace}, &cm); err != nil {
+			if apierrors.IsNotFound(err) {
+				errs = append(errs, field.NotFound(path.Child("name"), heisenbridge.ConfigMap.Name))
+			} else {
+				errs = append(errs, field.InternalError(path.Child("name"), err))
+			}
+		} else if _, ok := cm.Data["heisenbridge.yaml"]; !ok {
+			errs = append(errs, field.Invalid(
+				path.Child("name"),
+				heisenbridge.ConfigMap.Name,
+				"ConfigMap must contain a heisenbridge.yaml key",
+			))
+		}
+	}
+
+	return errs
+}
+
+// hasPublicBaseURLAndIngress returns true if both a public base URL and an
+// Ingress are defined for the given Synapse.
+func hasPublicBaseURLAndIngress(synapse *Synapse) bool {
+	values := synapse.Spec.Homeserver.Values
+	return synapse.Spec.Ingress != nil && values != nil && values.PublicBaseURL != ""
+}
+
+// hasFederationInValuesAndSpec returns true if federation is configured both
+// in Spec.Federation and in Spec.Homeserver.Values.
+func hasFederationInValuesAndSpec(synapse *Synapse) bool {
+	values := synapse.Spec.Homeserver.Values
+	return synapse.Spec.Federation != nil && values != nil && values.Federation != nil &&
+		(values.Federation.Enabled != nil || len(values.Federation.DomainWhitelist) != 0)
+}
+
+// hasFederationTLSAndReencryptRoute returns true if both the federation
+// listener and the reencrypt Route require a TLS certificate.
+func hasFederationTLSAndReencryptRoute(synapse *Synapse) bool {
+	return synapse.Spec.Federation != nil && synapse.Spec.Federation.TLSSecretName != "" &&
+		synapse.Spec.Route != nil && synapse.Spec.Route.Termination == "reencrypt"
+}
+
+// hasExternalRedisAndGeneratedPassword returns true if a password is to be
+// generated for an external Redis instance.
+func hasExternalRedisAndGeneratedPassword(synapse *Synapse) bool {
+	return synapse.Spec.Redis != nil && synapse.Spec.Redis.External != nil && synapse.Spec.Redis.GeneratePassword
+}
+
+// replicatedNamedWorkers returns the names of the pools of workers which
+// are addressed by name in homeserver.yaml, and have more than one worker.
+func replicatedNamedWorkers(synapse *Synapse) []string {
+	var names []string
+	for _, pool := range synapse.Spec.Workers {
+		if !pool.Type.IsScalable() && pool.Replicas != nil && *pool.Replicas > 1 {
+			names = append(names, pool.Name)
+		}
+	}
+	return names
+}
+
+// workerPoolAutoscalingError returns the reason why the autoscaling of a
+// pool of workers is invalid, or an empty string if it's valid.
+func workerPoolAutoscalingError(pool SynapseWorkerPool) string {
+	autoscaling := pool.Autoscaling
+	if autoscaling == nil {
+		return ""
+	}
+
+	if !pool.Type.IsAutoscalable() {
+		return "a " + string(pool.Type) + " pool can't be autoscaled" + shardingHint(pool.Type)
+	}
+
+	if autoscaling.MinReplicas != nil && *autoscaling.MinReplicas > autoscaling.MaxReplicas {
+		return "minReplicas can't be greater than maxReplicas"
+	}
+
+	// Requests default to the limits
+	if autoscaling.TargetCPUUtilizationPercentage != nil {
+		_, hasRequest := pool.Resources.Requests[corev1.ResourceCPU]
+		_, hasLimit := pool.Resources.Limits[corev1.ResourceCPU]
+		if !hasRequest && !hasLimit {
+			return "resources.requests.cpu must be set to autoscale the pool on its CPU utilization"
+		}
+	}
+
+	return ""
+}
+
+// shardingHint returns the advice given when a pool of workers which can't
+// be replicated is scaled. Only the worker types which can be declared in
+// several pools can be sharded.
+func shardingHint(workerType SynapseWorkerType) string {
+	if workerType == WorkerTypeMediaRepository || workerType == WorkerTypeAppservice {
+		return ""
+	}
+	return ", declare several pools instead"
+}
+
+// countWorkerPools returns the number of pools of workers of the given type.
+func countWorkerPools(synapse *Synapse, workerType SynapseWorkerType) int {
+	count := 0
+	for _, pool := range synapse.Spec.Workers {
+		if pool.Type == workerType {
+			count++
+		}
+	}
+	return count
+}
+
+// containsString returns true if the given slice contains the given string.
+func containsString(slice []string, s string) bool {
+	for _, item := range slice {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// synapseInvalidError returns an Invalid API error for the given field
+// errors, or nil if there are none.
+func synapseInvalidError(synapse *Synapse, errs field.ErrorList) error {
+	if len(errs) == 0 {
+		return nil
+	}
+	return apierrors.NewInvalid(GroupVersion.WithKind("Synapse").GroupKind(), synapse.Name, errs)
+}
+
+// dnsNameRegexp matches the DNS names allowed in a Matrix server name
+var dnsNameRegexp = regexp.MustCompile(`^[0-9A-Za-z.-]{1,255}$`)
+
+// ValidateServerName checks that the given server name is valid according to
+// the Matrix specification: a DNS name, an IPv4 address or an IPv6 literal
+// enclosed in square brackets, optionally followed by a port.
+// See https://spec.matrix.org/v1.2/appendices/#server-name
+func ValidateServerName(serverName string) error {
+	host := serverName
+
+	// Split the optional port
+	if i := strings.LastIndex(serverName, ":"); i != -1 && !strings.HasSuffix(serverName, "]") {
+		host = serverName[:i]
+		port, err := strconv.Atoi(serverName[i+1:])
+		if err != nil || port < 1 || port > 65535 || len(serverName[i+1:]) > 5 {
+			return fmt.Errorf("invalid port %q", serverName[i+1:])
+		}
+	}
+
+	if strings.HasPrefix(host, "[") && strings.HasSuffix(host, "]") {
+		ip := net.ParseIP(host[1 : len(host)-1])
+		if ip == nil || ip.To4() != nil {
+			return fmt.Errorf("invalid IPv6 address %q", host)
+		}
+		return nil
+	}
+
+	if !dnsNameRegexp.MatchString(host) {
+		return fmt.Errorf("must be a DNS name or an IP address, optionally followed by a port")
+	}
+
+	if strings.HasPrefix(host, ".") || strings.HasSuffix(host, ".") || strings.Contains(host, "..") {
+		return fmt.Errorf("invalid DNS name %q", host)
+	}
+
+	return nil
+}
@@ -0,0 +1,568 @@
+package v1alpha1
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/envtest"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+)
+
+var _ = Describe("Unit tests for the Synapse webhooks", Label("unit"), func() {
+	var ctx context.Context
+	var s Synapse
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		s = Synapse{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "test",
+				Namespace: "default",
+			},
+			Spec: SynapseSpec{
+				Homeserver: SynapseHomeserver{
+					Values: &SynapseHomeserverValues{
+						ServerName:  "example.com",
+						ReportStats: false,
+					},
+				},
+			},
+		}
+	})
+
+	Context("When defaulting a Synapse", func() {
+		It("Should set the default image and storage size", func() {
+			s.Default()
+
+			Expect(s.Spec.Image).To(Equal(DefaultSynapseImage))
+			Expect(s.Spec.Storage.Size).NotTo(BeNil())
+			Expect(s.Spec.Storage.Size.String()).To(Equal(DefaultStorageSize))
+			Expect(s.Spec.Bridges.Heisenbridge.Image).To(BeEmpty())
+		})
+
+		It("Should keep the values set by the user", func() {
+			size := resource.MustParse("20Gi")
+			s.Spec.Image = "matrixdotorg/synapse:latest"
+			s.Spec.Storage.Size = &size
+
+			s.Default()
+
+			Expect(s.Spec.Image).To(Equal("matrixdotorg/synapse:latest"))
+			Expect(s.Spec.Storage.Size.String()).To(Equal("20Gi"))
+		})
+
+		It("Should set the default image of the .well-known web server", func() {
+			s.Spec.WellKnown = &SynapseWellKnown{}
+
+			s.Default()
+
+			Expect(s.Spec.WellKnown.Image).To(Equal(DefaultWellKnownImage))
+		})
+
+		It("Should set the default port and Service type of the federation listener", func() {
+			s.Spec.Federation = &SynapseFederationListener{}
+
+			s.Default()
+
+			Expect(s.Spec.Federation.Port).To(Equal(int32(DefaultFederationPort)))
+			Expect(s.Spec.Federation.ServiceType).To(Equal(corev1.ServiceTypeClusterIP))
+		})
+
+		It("Should set the default number of workers of a pool", func() {
+			s.Spec.Workers = []SynapseWorkerPool{{Name: "generic", Type: WorkerTypeGenericWorker}}
+
+			s.Default()
+
+			Expect(*s.Spec.Workers[0].Replicas).To(Equal(int32(1)))
+		})
+
+		It("Should autoscale a pool on its CPU utilization by default", func() {
+			s.Spec.Workers = []SynapseWorkerPool{{
+				Name:        "generic",
+				Type:        WorkerTypeGenericWorker,
+				Autoscaling: &SynapseWorkerPoolAutoscaling{MaxReplicas: 5},
+			}}
+
+			s.Default()
+
+			Expect(*s.Spec.Workers[0].Autoscaling.MinReplicas).To(Equal(int32(1)))
+			Expect(*s.Spec.Workers[0].Autoscaling.TargetCPUUtilizationPercentage).To(Equal(int32(DefaultTargetCPU)))
+		})
+
+		It("Should deploy a Redis instance and a reverse proxy for the workers", func() {
+			s.Spec.Workers = []SynapseWorkerPool{{Name: "generic", Type: WorkerTypeGenericWorker}}
+
+			s.Default()
+
+			Expect(s.Spec.Redis).NotTo(BeNil())
+			Expect(s.Spec.Redis.Image).To(Equal(DefaultRedisImage))
+			Expect(s.Spec.Proxy).NotTo(BeNil())
+			Expect(s.Spec.Proxy.Image).To(Equal(DefaultProxyImage))
+			Expect(*s.Spec.Proxy.Replicas).To(Equal(int32(1)))
+		})
+
+		It("Should set the default port of an external Redis instance", func() {
+			s.Spec.Redis = &SynapseRedis{External: &SynapseExternalRedis{Host: "redis.example.com"}}
+
+			s.Default()
+
+			Expect(s.Spec.Redis.External.Port).To(Equal(int32(DefaultRedisPort)))
+			Expect(s.Spec.Redis.Image).To(BeEmpty())
+		})
+
+		It("Should set the defaults of an enabled Heisenbridge", func() {
+			s.Spec.Bridges.Heisenbridge.Enabled = true
+			s.Spec.Bridges.Heisenbridge.ConfigMap.Name = "heisenbridge"
+
+			s.Default()
+
+			Expect(s.Spec.Bridges.Heisenbridge.Image).To(Equal(DefaultHeisenbridgeImage))
+			Expect(s.Spec.Bridges.Heisenbridge.ConfigMap.Namespace).To(Equal("default"))
+		})
+	})
+
+	Context("When validating a server name", func() {
+		DescribeTable("Should accept valid server names",
+			func(serverName string) {
+				Expect(ValidateServerName(serverName)).To(Succeed())
+			},
+			Entry("DNS name", "matrix.example.com"),
+			Entry("DNS name with port", "example.com:8448"),
+			Entry("IPv4 address", "1.2.3.4"),
+			Entry("IPv6 literal", "[1234:5678::abcd]"),
+			Entry("IPv6 literal with port", "[1234:5678::abcd]:5678"),
+		)
+
+		DescribeTable("Should reject invalid server names",
+			func(serverName string) {
+				Expect(ValidateServerName(serverName)).NotTo(Succeed())
+			},
+			Entry("empty", ""),
+			Entry("invalid characters", "example_com"),
+			Entry("trailing dot", "example.com."),
+			Entry("invalid port", "example.com:port"),
+			Entry("out of range port", "example.com:70000"),
+			Entry("IPv4 in brackets", "[1.2.3.4]"),
+			Entry("unbracketed IPv6", "1234:5678::abcd"),
+		)
+	})
+
+	Context("When validating a Synapse", func() {
+		var v SynapseValidator
+		var objs []client.Object
+
+		JustBeforeEach(func() {
+			v = SynapseValidator{
+				Client: fake.NewClientBuilder().WithObjects(objs...).Build(),
+			}
+		})
+
+		AfterEach(func() {
+			objs = nil
+		})
+
+		It("Should accept a valid Synapse", func() {
+			Expect(v.ValidateCreate(ctx, &s)).To(Succeed())
+		})
+
+		It("Should reject an invalid server name", func() {
+			s.Spec.Homeserver.Values.ServerName = "example_com"
+
+			err := v.ValidateCreate(ctx, &s)
+			Expect(apierrors.IsInvalid(err)).To(BeTrue())
+			Expect(err.Error()).To(ContainSubstring("spec.homeserver.values.serverName"))
+		})
+
+		It("Should reject a missing Heisenbridge ConfigMap", func() {
+			s.Spec.Bridges.Heisenbridge.Enabled = true
+			s.Spec.Bridges.Heisenbridge.ConfigMap.Name = "heisenbridge"
+
+			err := v.ValidateCreate(ctx, &s)
+			Expect(apierrors.IsInvalid(err)).To(BeTrue())
+			Expect(err.Error()).To(ContainSubstring("spec.bridges.heisenbridge.configMap.name"))
+		})
+
+		When("The Heisenbridge ConfigMap exists", func() {
+			var data map[string]string
+
+			JustBeforeEach(func() {
+				Expect(v.Client.(client.Client).Create(ctx, &corev1.ConfigMap{
+					ObjectMeta: metav1.ObjectMeta{Name: "heisenbridge", Namespace: "default"},
+					Data:       data,
+				})).To(Succeed())
+
+				s.Spec.Bridges.Heisenbridge.Enabled = true
+				s.Spec.Bridges.Heisenbridge.ConfigMap.Name = "heisenbridge"
+			})
+
+			Context("With a heisenbridge.yaml key", func() {
+				BeforeEach(func() {
+					data = map[string]string{"heisenbridge.yaml": ""}
+				})
+
+				It("Should accept the Synapse", func() {
+					Expect(v.ValidateCreate(ctx, &s)).To(Succeed())
+				})
+			})
+
+			Context("Without a heisenbridge.yaml key", func() {
+				BeforeEach(func() {
+					data = map[string]string{"config.yaml": ""}
+				})
+
+				It("Should reject the Synapse", func() {
+					Expect(apierrors.IsInvalid(v.ValidateCreate(ctx, &s))).To(BeTrue())
+				})
+			})
+		})
+
+		It("Should reject a public base URL set together with an Ingress", func() {
+			s.Spec.Homeserver.Values.PublicBaseURL = "https://matrix.example.com/"
+			s.Spec.Ingress = &SynapseIngress{Host: "matrix.example.com"}
+
+			err := v.ValidateCreate(ctx, &s)
+			Expect(apierrors.IsInvalid(err)).To(BeTrue())
+			Expect(err.Error()).To(ContainSubstring("spec.homeserver.values.publicBaseURL"))
+		})
+
+		It("Should reject federation options set both in the values and in spec.federation", func() {
+			s.Spec.Homeserver.Values.Federation = &SynapseFederation{DomainWhitelist: []string{"matrix.org"}}
+			s.Spec.Federation = &SynapseFederationListener{}
+
+			err := v.ValidateCreate(ctx, &s)
+			Expect(apierrors.IsInvalid(err)).To(BeTrue())
+			Expect(err.Error()).To(ContainSubstring("spec.homeserver.values.federation"))
+		})
+
+		It("Should reject a federation TLS certificate with a reencrypt Route", func() {
+			s.Spec.Federation = &SynapseFederationListener{TLSSecretName: "federation-tls"}
+			s.Spec.Route = &SynapseRoute{Termination: "reencrypt"}
+
+			err := v.ValidateCreate(ctx, &s)
+			Expect(apierrors.IsInvalid(err)).To(BeTrue())
+			Expect(err.Error()).To(ContainSubstring("spec.federation.tlsSecretName"))
+		})
+
+		It("Should reject a replicated pool of workers addressed by name", func() {
+			replicas := int32(2)
+			s.Spec.Workers = []SynapseWorkerPool{
+				{Name: "generic", Type: WorkerTypeGenericWorker, Replicas: &replicas},
+				{Name: "sender", Type: WorkerTypeFederationSender, Replicas: &replicas},
+			}
+
+			err := v.ValidateCreate(ctx, &s)
+			Expect(apierrors.IsInvalid(err)).To(BeTrue())
+			Expect(err.Error()).To(ContainSubstring("spec.workers[1].replicas"))
+			Expect(err.Error()).NotTo(ContainSubstring("spec.workers[0].replicas"))
+		})
+
+		It("Should reject the autoscaling of a pool of workers addressed by name", func() {
+			s.Spec.Workers = []SynapseWorkerPool{{
+				Name:        "pusher",
+				Type:        WorkerTypePusher,
+				Autoscaling: &SynapseWorkerPoolAutoscaling{MaxReplicas: 5},
+			}}
+
+			err := v.ValidateCreate(ctx, &s)
+			Expect(apierrors.IsInvalid(err)).To(BeTrue())
+			Expect(err.Error()).To(ContainSubstring("spec.workers[0].autoscaling"))
+			Expect(err.Error()).To(ContainSubstring("can't be autoscaled"))
+		})
+
+		It("Should accept the autoscaling of a federation_sender pool", func() {
+			s.Spec.Workers = []SynapseWorkerPool{{
+				Name:        "sender",
+				Type:        WorkerTypeFederationSender,
+				Autoscaling: &SynapseWorkerPoolAutoscaling{MaxReplicas: 5},
+			}}
+
+			Expect(v.ValidateCreate(ctx, &s)).To(Succeed())
+		})
+
+		It("Should reject the autoscaling of a pool on its CPU utilization without CPU requests", func() {
+			targetCPU := int32(80)
+			s.Spec.Workers = []SynapseWorkerPool{{
+				Name: "generic",
+				Type: WorkerTypeGenericWorker,
+				Autoscaling: &SynapseWorkerPoolAutoscaling{
+					MaxReplicas:                    5,
+					TargetCPUUtilizationPercentage: &targetCPU,
+				},
+			}}
+
+			err := v.ValidateCreate(ctx, &s)
+			Expect(apierrors.IsInvalid(err)).To(BeTrue())
+			Expect(err.Error()).To(ContainSubstring("resources.requests.cpu"))
+
+			s.Spec.Workers[0].Resources.Requests = corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("500m")}
+			Expect(v.ValidateCreate(ctx, &s)).To(Succeed())
+		})
+
+		It("Should reject a minimum number of workers greater than the maximum", func() {
+			minReplicas := int32(3)
+			s.Spec.Workers = []SynapseWorkerPool{{
+				Name: "generic",
+				Type: WorkerTypeGenericWorker,
+				Autoscaling: &SynapseWorkerPoolAutoscaling{
+					MinReplicas: &minReplicas,
+					MaxReplicas: 2,
+				},
+			}}
+
+			err := v.ValidateCreate(ctx, &s)
+			Expect(apierrors.IsInvalid(err)).To(BeTrue())
+			Expect(err.Error()).To(ContainSubstring("minReplicas can't be greater than maxReplicas"))
+		})
+
+		It("Should reject more than one media_repository worker", func() {
+			replicas := int32(2)
+			s.Spec.Workers = []SynapseWorkerPool{{Name: "media", Type: WorkerTypeMediaRepository, Replicas: &replicas}}
+
+			err := v.ValidateCreate(ctx, &s)
+			Expect(apierrors.IsInvalid(err)).To(BeTrue())
+			Expect(err.Error()).To(ContainSubstring("a media_repository pool can't have more than one worker"))
+
+			s.Spec.Workers = []SynapseWorkerPool{
+				{Name: "media1", Type: WorkerTypeMediaRepository},
+				{Name: "media2", Type: WorkerTypeMediaRepository},
+			}
+			err = v.ValidateCreate(ctx, &s)
+			Expect(apierrors.IsInvalid(err)).To(BeTrue())
+			Expect(err.Error()).To(ContainSubstring("only one media_repository pool"))
+
+			s.Spec.Workers = []SynapseWorkerPool{{
+				Name:        "media",
+				Type:        WorkerTypeMediaRepository,
+				Autoscaling: &SynapseWorkerPoolAutoscaling{MaxReplicas: 2},
+			}}
+			err = v.ValidateCreate(ctx, &s)
+			Expect(apierrors.IsInvalid(err)).To(BeTrue())
+			Expect(err.Error()).To(ContainSubstring("a media_repository pool can't be autoscaled"))
+		})
+
+		It("Should reject several appservice pools", func() {
+			s.Spec.Workers = []SynapseWorkerPool{
+				{Name: "appservice1", Type: WorkerTypeAppservice},
+				{Name: "appservice2", Type: WorkerTypeAppservice},
+			}
+
+			err := v.ValidateCreate(ctx, &s)
+			Expect(apierrors.IsInvalid(err)).To(BeTrue())
+			Expect(err.Error()).To(ContainSubstring("only one appservice pool"))
+		})
+
+		It("Should reject a generated password for an external Redis instance", func() {
+			s.Spec.Redis = &SynapseRedis{
+				External:         &SynapseExternalRedis{Host: "redis.example.com"},
+				GeneratePassword: true,
+			}
+
+			err := v.ValidateCreate(ctx, &s)
+			Expect(apierrors.IsInvalid(err)).To(BeTrue())
+			Expect(err.Error()).To(ContainSubstring("spec.redis.generatePassword"))
+		})
+
+		It("Should forbid changing the server name", func() {
+			oldSynapse := s.DeepCopy()
+			oldSynapse.Status.HomeserverConfiguration.ServerName = "example.com"
+			s.Spec.Homeserver.Values.ServerName = "example.org"
+
+			err := v.ValidateUpdate(ctx, oldSynapse, &s)
+			Expect(apierrors.IsInvalid(err)).To(BeTrue())
+			Expect(err.Error()).To(ContainSubstring("the server name can't be changed"))
+		})
+
+		It("Should forbid disabling createNewPostgreSQL once the database is created", func() {
+			s.Spec.CreateNewPostgreSQL = true
+			oldSynapse := s.DeepCopy()
+			oldSynapse.Status.DatabaseConnectionInfo.ConnectionURL = "test-synapse-primary.default.svc:5432"
+			s.Spec.CreateNewPostgreSQL = false
+
+			err := v.ValidateUpdate(ctx, oldSynapse, &s)
+			Expect(apierrors.IsInvalid(err)).To(BeTrue())
+			Expect(err.Error()).To(ContainSubstring("spec.createNewPostgreSQL"))
+		})
+
+		It("Should allow disabling createNewPostgreSQL before the database is created", func() {
+			s.Spec.CreateNewPostgreSQL = true
+			oldSynapse := s.DeepCopy()
+			s.Spec.CreateNewPostgreSQL = false
+
+			Expect(v.ValidateUpdate(ctx, oldSynapse, &s)).To(Succeed())
+		})
+
+		It("Should forbid decreasing the storage size", func() {
+			oldSize := resource.MustParse("10Gi")
+			size := resource.MustParse("5Gi")
+			oldSynapse := s.DeepCopy()
+			oldSynapse.Spec.Storage.Size = &oldSize
+			s.Spec.Storage.Size = &size
+
+			err := v.ValidateUpdate(ctx, oldSynapse, &s)
+			Expect(apierrors.IsInvalid(err)).To(BeTrue())
+			Expect(err.Error()).To(ContainSubstring("spec.storage.size"))
+		})
+
+		It("Should allow the update of a Synapse being deleted", func() {
+			oldSynapse := s.DeepCopy()
+			oldSynapse.Status.HomeserverConfiguration.ServerName = "example.com"
+			s.Spec.Homeserver.Values.ServerName = "example.org"
+			s.DeletionTimestamp = &metav1.Time{Time: time.Now()}
+
+			Expect(v.ValidateUpdate(ctx, oldSynapse, &s)).To(Succeed())
+		})
+	})
+})
+
+var _ = Describe("Integration tests for the Synapse webhooks", Ordered, Label("integration"), func() {
+	const (
+		SynapseNamespace = "default"
+
+		timeout  = time.Second * 2
+		interval = time.Millisecond * 250
+	)
+
+	var k8sClient client.Client
+	var testEnv *envtest.Environment
+	var ctx context.Context
+	var cancel context.CancelFunc
+
+	BeforeAll(func() {
+		logf.SetLogger(zap.New(zap.WriteTo(GinkgoWriter), zap.UseDevMode(true)))
+
+		ctx, cancel = context.WithCancel(context.TODO())
+
+		By("bootstrapping test environment")
+		testEnv = &envtest.Environment{
+			CRDDirectoryPaths: []string{
+				filepath.Join("..", "..", "..", "bundle", "manifests", "synapse.opdev.io_synapses.yaml"),
+			},
+			ErrorIfCRDPathMissing: true,
+			WebhookInstallOptions: envtest.WebhookInstallOptions{
+				Paths: []string{filepath.Join("..", "..", "..", "config", "webhook")},
+			},
+		}
+
+		cfg, err := testEnv.Start()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cfg).NotTo(BeNil())
+
+		scheme := runtime.NewScheme()
+		Expect(AddToScheme(scheme)).To(Succeed())
+		Expect(corev1.AddToScheme(scheme)).To(Succeed())
+		Expect(admissionv1.AddToScheme(scheme)).To(Succeed())
+
+		k8sClient, err = client.New(cfg, client.Options{Scheme: scheme})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(k8sClient).NotTo(BeNil())
+
+		// start webhook server using Manager
+		webhookInstallOptions := &testEnv.WebhookInstallOptions
+		mgr, err := ctrl.NewManager(cfg, ctrl.Options{
+			Scheme:             scheme,
+			Host:               webhookInstallOptions.LocalServingHost,
+			Port:               webhookInstallOptions.LocalServingPort,
+			CertDir:            webhookInstallOptions.LocalServingCertDir,
+			LeaderElection:     false,
+			MetricsBindAddress: "0",
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect((&Synapse{}).SetupWebhookWithManager(mgr)).To(Succeed())
+
+		go func() {
+			defer GinkgoRecover()
+			Expect(mgr.Start(ctx)).To(Succeed())
+		}()
+
+		// wait for the webhook server to get ready
+		dialer := &net.Dialer{Timeout: time.Second}
+		addrPort := fmt.Sprintf("%s:%d", webhookInstallOptions.LocalServingHost, webhookInstallOptions.LocalServingPort)
+		Eventually(func() error {
+			conn, err := tls.DialWithDialer(dialer, "tcp", addrPort, &tls.Config{InsecureSkipVerify: true})
+			if err != nil {
+				return err
+			}
+			conn.Close()
+			return nil
+		}, timeout, interval).Should(Succeed())
+	})
+
+	AfterAll(func() {
+		cancel()
+		By("tearing down the test environment")
+		Expect(testEnv.Stop()).To(Succeed())
+	})
+
+	var newSynapse = func(name string, serverName string) *Synapse {
+		return &Synapse{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: SynapseNamespace,
+			},
+			Spec: SynapseSpec{
+				Homeserver: SynapseHomeserver{
+					Values: &SynapseHomeserverValues{
+						ServerName:  serverName,
+						ReportStats: false,
+					},
+				},
+			},
+		}
+	}
+
+	It("Should set the default values on creation", func() {
+		synapse := newSynapse("test-defaults", "example.com")
+		Expect(k8sClient.Create(ctx, synapse)).To(Succeed())
+
+		created := &Synapse{}
+		Expect(k8sClient.Get(ctx, types.NamespacedName{Name: "test-defaults", Namespace: SynapseNamespace}, created)).To(Succeed())
+		Expect(created.Spec.Image).To(Equal(DefaultSynapseImage))
+		Expect(created.Spec.Storage.Size).NotTo(BeNil())
+		Expect(created.Spec.Storage.Size.String()).To(Equal(DefaultStorageSize))
+
+		Expect(k8sClient.Delete(ctx, created)).To(Succeed())
+	})
+
+	It("Should reject an invalid server name", func() {
+		err := k8sClient.Create(ctx, newSynapse("test-invalid", "example_com"))
+		Expect(apierrors.IsInvalid(err)).To(BeTrue())
+	})
+
+	It("Should reject a change of server name", func() {
+		synapse := newSynapse("test-server-name", "example.com")
+		Expect(k8sClient.Create(ctx, synapse)).To(Succeed())
+
+		synapse.Spec.Homeserver.Values.ServerName = "example.org"
+		err := k8sClient.Update(ctx, synapse)
+		Expect(apierrors.IsInvalid(err)).To(BeTrue())
+
+		Expect(k8sClient.Delete(ctx, synapse)).To(Succeed())
+	})
+
+	It("Should reject a missing Heisenbridge ConfigMap", func() {
+		synapse := newSynapse("test-heisenbridge", "example.com")
+		synapse.Spec.Bridges.Heisenbridge.Enabled = true
+		synapse.Spec.Bridges.Heisenbridge.ConfigMap.Name = "missing"
+
+		err := k8sClient.Create(ctx, synapse)
+		Expect(apierrors.IsInvalid(err)).To(BeTrue())
+	})
+})
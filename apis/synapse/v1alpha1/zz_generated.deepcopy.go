@@ -22,7 +22,10 @@ limitations under the License.
 package v1alpha1
 
 import (
-	runtime "k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/api/autoscaling/v2"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 )
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
@@ -31,7 +34,7 @@ func (in *Synapse) DeepCopyInto(out *Synapse) {
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
 	in.Spec.DeepCopyInto(&out.Spec)
-	out.Status = in.Status
+	in.Status.DeepCopyInto(&out.Status)
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Synapse.
@@ -55,7 +58,7 @@ func (in *Synapse) DeepCopyObject() runtime.Object {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *SynapseBridges) DeepCopyInto(out *SynapseBridges) {
 	*out = *in
-	out.Heisenbridge = in.Heisenbridge
+	in.Heisenbridge.DeepCopyInto(&out.Heisenbridge)
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SynapseBridges.
@@ -68,9 +71,94 @@ func (in *SynapseBridges) DeepCopy() *SynapseBridges {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SynapseExternalRedis) DeepCopyInto(out *SynapseExternalRedis) {
+	*out = *in
+	if in.PasswordSecretKeyRef != nil {
+		in, out := &in.PasswordSecretKeyRef, &out.PasswordSecretKeyRef
+		*out = new(v1.SecretKeySelector)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SynapseExternalRedis.
+func (in *SynapseExternalRedis) DeepCopy() *SynapseExternalRedis {
+	if in == nil {
+		return nil
+	}
+	out := new(SynapseExternalRedis)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SynapseFederation) DeepCopyInto(out *SynapseFederation) {
+	*out = *in
+	if in.Enabled != nil {
+		in, out := &in.Enabled, &out.Enabled
+		*out = new(bool)
+		**out = **in
+	}
+	if in.DomainWhitelist != nil {
+		in, out := &in.DomainWhitelist, &out.DomainWhitelist
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.TrustedKeyServers != nil {
+		in, out := &in.TrustedKeyServers, &out.TrustedKeyServers
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SynapseFederation.
+func (in *SynapseFederation) DeepCopy() *SynapseFederation {
+	if in == nil {
+		return nil
+	}
+	out := new(SynapseFederation)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SynapseFederationListener) DeepCopyInto(out *SynapseFederationListener) {
+	*out = *in
+	if in.Enabled != nil {
+		in, out := &in.Enabled, &out.Enabled
+		*out = new(bool)
+		**out = **in
+	}
+	if in.DomainWhitelist != nil {
+		in, out := &in.DomainWhitelist, &out.DomainWhitelist
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.IPRangeBlacklist != nil {
+		in, out := &in.IPRangeBlacklist, &out.IPRangeBlacklist
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SynapseFederationListener.
+func (in *SynapseFederationListener) DeepCopy() *SynapseFederationListener {
+	if in == nil {
+		return nil
+	}
+	out := new(SynapseFederationListener)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *SynapseHeisenbridge) DeepCopyInto(out *SynapseHeisenbridge) {
 	*out = *in
+	if in.PodTemplate != nil {
+		in, out := &in.PodTemplate, &out.PodTemplate
+		*out = new(v1.PodTemplateSpec)
+		(*in).DeepCopyInto(*out)
+	}
 	out.ConfigMap = in.ConfigMap
 }
 
@@ -110,8 +198,25 @@ func (in *SynapseHomeserver) DeepCopyInto(out *SynapseHomeserver) {
 	if in.Values != nil {
 		in, out := &in.Values, &out.Values
 		*out = new(SynapseHomeserverValues)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Secret != nil {
+		in, out := &in.Secret, &out.Secret
+		*out = new(SynapseHomeserverSecret)
+		**out = **in
+	}
+	if in.SigningKeySecret != nil {
+		in, out := &in.SigningKeySecret, &out.SigningKeySecret
+		*out = new(SynapseHomeserverSigningKeySecret)
 		**out = **in
 	}
+	if in.Overrides != nil {
+		in, out := &in.Overrides, &out.Overrides
+		*out = make([]SynapseHomeserverOverride, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SynapseHomeserver.
@@ -139,9 +244,136 @@ func (in *SynapseHomeserverConfigMap) DeepCopy() *SynapseHomeserverConfigMap {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SynapseHomeserverOverride) DeepCopyInto(out *SynapseHomeserverOverride) {
+	*out = *in
+	if in.ConfigMap != nil {
+		in, out := &in.ConfigMap, &out.ConfigMap
+		*out = new(SynapseHomeserverOverrideConfigMap)
+		**out = **in
+	}
+	if in.Secret != nil {
+		in, out := &in.Secret, &out.Secret
+		*out = new(SynapseHomeserverOverrideSecret)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SynapseHomeserverOverride.
+func (in *SynapseHomeserverOverride) DeepCopy() *SynapseHomeserverOverride {
+	if in == nil {
+		return nil
+	}
+	out := new(SynapseHomeserverOverride)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SynapseHomeserverOverrideConfigMap) DeepCopyInto(out *SynapseHomeserverOverrideConfigMap) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SynapseHomeserverOverrideConfigMap.
+func (in *SynapseHomeserverOverrideConfigMap) DeepCopy() *SynapseHomeserverOverrideConfigMap {
+	if in == nil {
+		return nil
+	}
+	out := new(SynapseHomeserverOverrideConfigMap)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SynapseHomeserverOverrideSecret) DeepCopyInto(out *SynapseHomeserverOverrideSecret) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SynapseHomeserverOverrideSecret.
+func (in *SynapseHomeserverOverrideSecret) DeepCopy() *SynapseHomeserverOverrideSecret {
+	if in == nil {
+		return nil
+	}
+	out := new(SynapseHomeserverOverrideSecret)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SynapseHomeserverSecret) DeepCopyInto(out *SynapseHomeserverSecret) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SynapseHomeserverSecret.
+func (in *SynapseHomeserverSecret) DeepCopy() *SynapseHomeserverSecret {
+	if in == nil {
+		return nil
+	}
+	out := new(SynapseHomeserverSecret)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SynapseHomeserverSigningKeySecret) DeepCopyInto(out *SynapseHomeserverSigningKeySecret) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SynapseHomeserverSigningKeySecret.
+func (in *SynapseHomeserverSigningKeySecret) DeepCopy() *SynapseHomeserverSigningKeySecret {
+	if in == nil {
+		return nil
+	}
+	out := new(SynapseHomeserverSigningKeySecret)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *SynapseHomeserverValues) DeepCopyInto(out *SynapseHomeserverValues) {
 	*out = *in
+	if in.Listeners != nil {
+		in, out := &in.Listeners, &out.Listeners
+		*out = make([]SynapseListener, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Registration != nil {
+		in, out := &in.Registration, &out.Registration
+		*out = new(SynapseRegistration)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Federation != nil {
+		in, out := &in.Federation, &out.Federation
+		*out = new(SynapseFederation)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.RateLimits != nil {
+		in, out := &in.RateLimits, &out.RateLimits
+		*out = new(SynapseRateLimits)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Media != nil {
+		in, out := &in.Media, &out.Media
+		*out = new(SynapseMedia)
+		**out = **in
+	}
+	if in.URLPreviews != nil {
+		in, out := &in.URLPreviews, &out.URLPreviews
+		*out = new(SynapseURLPreviews)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Presence != nil {
+		in, out := &in.Presence, &out.Presence
+		*out = new(SynapsePresence)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Retention != nil {
+		in, out := &in.Retention, &out.Retention
+		*out = new(SynapseRetention)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SynapseHomeserverValues.
@@ -154,6 +386,73 @@ func (in *SynapseHomeserverValues) DeepCopy() *SynapseHomeserverValues {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SynapseIngress) DeepCopyInto(out *SynapseIngress) {
+	*out = *in
+	if in.IngressClassName != nil {
+		in, out := &in.IngressClassName, &out.IngressClassName
+		*out = new(string)
+		**out = **in
+	}
+	if in.Annotations != nil {
+		in, out := &in.Annotations, &out.Annotations
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.TLS != nil {
+		in, out := &in.TLS, &out.TLS
+		*out = new(SynapseIngressTLS)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SynapseIngress.
+func (in *SynapseIngress) DeepCopy() *SynapseIngress {
+	if in == nil {
+		return nil
+	}
+	out := new(SynapseIngress)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SynapseIngressIssuer) DeepCopyInto(out *SynapseIngressIssuer) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SynapseIngressIssuer.
+func (in *SynapseIngressIssuer) DeepCopy() *SynapseIngressIssuer {
+	if in == nil {
+		return nil
+	}
+	out := new(SynapseIngressIssuer)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SynapseIngressTLS) DeepCopyInto(out *SynapseIngressTLS) {
+	*out = *in
+	if in.Issuer != nil {
+		in, out := &in.Issuer, &out.Issuer
+		*out = new(SynapseIngressIssuer)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SynapseIngressTLS.
+func (in *SynapseIngressTLS) DeepCopy() *SynapseIngressTLS {
+	if in == nil {
+		return nil
+	}
+	out := new(SynapseIngressTLS)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *SynapseList) DeepCopyInto(out *SynapseList) {
 	*out = *in
@@ -186,11 +485,318 @@ func (in *SynapseList) DeepCopyObject() runtime.Object {
 	return nil
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SynapseListener) DeepCopyInto(out *SynapseListener) {
+	*out = *in
+	if in.BindAddresses != nil {
+		in, out := &in.BindAddresses, &out.BindAddresses
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Resources != nil {
+		in, out := &in.Resources, &out.Resources
+		*out = make([]SynapseListenerResource, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SynapseListener.
+func (in *SynapseListener) DeepCopy() *SynapseListener {
+	if in == nil {
+		return nil
+	}
+	out := new(SynapseListener)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SynapseListenerResource) DeepCopyInto(out *SynapseListenerResource) {
+	*out = *in
+	if in.Names != nil {
+		in, out := &in.Names, &out.Names
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SynapseListenerResource.
+func (in *SynapseListenerResource) DeepCopy() *SynapseListenerResource {
+	if in == nil {
+		return nil
+	}
+	out := new(SynapseListenerResource)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SynapseLogging) DeepCopyInto(out *SynapseLogging) {
+	*out = *in
+	if in.Loggers != nil {
+		in, out := &in.Loggers, &out.Loggers
+		*out = make(map[string]SynapseLogLevel, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SynapseLogging.
+func (in *SynapseLogging) DeepCopy() *SynapseLogging {
+	if in == nil {
+		return nil
+	}
+	out := new(SynapseLogging)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SynapseMedia) DeepCopyInto(out *SynapseMedia) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SynapseMedia.
+func (in *SynapseMedia) DeepCopy() *SynapseMedia {
+	if in == nil {
+		return nil
+	}
+	out := new(SynapseMedia)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SynapsePresence) DeepCopyInto(out *SynapsePresence) {
+	*out = *in
+	if in.Enabled != nil {
+		in, out := &in.Enabled, &out.Enabled
+		*out = new(bool)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SynapsePresence.
+func (in *SynapsePresence) DeepCopy() *SynapsePresence {
+	if in == nil {
+		return nil
+	}
+	out := new(SynapsePresence)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SynapseProxy) DeepCopyInto(out *SynapseProxy) {
+	*out = *in
+	if in.Replicas != nil {
+		in, out := &in.Replicas, &out.Replicas
+		*out = new(int32)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SynapseProxy.
+func (in *SynapseProxy) DeepCopy() *SynapseProxy {
+	if in == nil {
+		return nil
+	}
+	out := new(SynapseProxy)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SynapseRateLimit) DeepCopyInto(out *SynapseRateLimit) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SynapseRateLimit.
+func (in *SynapseRateLimit) DeepCopy() *SynapseRateLimit {
+	if in == nil {
+		return nil
+	}
+	out := new(SynapseRateLimit)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SynapseRateLimits) DeepCopyInto(out *SynapseRateLimits) {
+	*out = *in
+	if in.Message != nil {
+		in, out := &in.Message, &out.Message
+		*out = new(SynapseRateLimit)
+		**out = **in
+	}
+	if in.Registration != nil {
+		in, out := &in.Registration, &out.Registration
+		*out = new(SynapseRateLimit)
+		**out = **in
+	}
+	if in.Login != nil {
+		in, out := &in.Login, &out.Login
+		*out = new(SynapseRateLimit)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SynapseRateLimits.
+func (in *SynapseRateLimits) DeepCopy() *SynapseRateLimits {
+	if in == nil {
+		return nil
+	}
+	out := new(SynapseRateLimits)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SynapseRedis) DeepCopyInto(out *SynapseRedis) {
+	*out = *in
+	if in.External != nil {
+		in, out := &in.External, &out.External
+		*out = new(SynapseExternalRedis)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SynapseRedis.
+func (in *SynapseRedis) DeepCopy() *SynapseRedis {
+	if in == nil {
+		return nil
+	}
+	out := new(SynapseRedis)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SynapseRegistration) DeepCopyInto(out *SynapseRegistration) {
+	*out = *in
+	if in.AutoJoinRooms != nil {
+		in, out := &in.AutoJoinRooms, &out.AutoJoinRooms
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SynapseRegistration.
+func (in *SynapseRegistration) DeepCopy() *SynapseRegistration {
+	if in == nil {
+		return nil
+	}
+	out := new(SynapseRegistration)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SynapseRetention) DeepCopyInto(out *SynapseRetention) {
+	*out = *in
+	if in.DefaultPolicy != nil {
+		in, out := &in.DefaultPolicy, &out.DefaultPolicy
+		*out = new(SynapseRetentionPolicy)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SynapseRetention.
+func (in *SynapseRetention) DeepCopy() *SynapseRetention {
+	if in == nil {
+		return nil
+	}
+	out := new(SynapseRetention)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SynapseRetentionPolicy) DeepCopyInto(out *SynapseRetentionPolicy) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SynapseRetentionPolicy.
+func (in *SynapseRetentionPolicy) DeepCopy() *SynapseRetentionPolicy {
+	if in == nil {
+		return nil
+	}
+	out := new(SynapseRetentionPolicy)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SynapseRoute) DeepCopyInto(out *SynapseRoute) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SynapseRoute.
+func (in *SynapseRoute) DeepCopy() *SynapseRoute {
+	if in == nil {
+		return nil
+	}
+	out := new(SynapseRoute)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *SynapseSpec) DeepCopyInto(out *SynapseSpec) {
 	*out = *in
 	in.Homeserver.DeepCopyInto(&out.Homeserver)
-	out.Bridges = in.Bridges
+	in.Bridges.DeepCopyInto(&out.Bridges)
+	if in.PodTemplate != nil {
+		in, out := &in.PodTemplate, &out.PodTemplate
+		*out = new(v1.PodTemplateSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	in.Storage.DeepCopyInto(&out.Storage)
+	if in.Logging != nil {
+		in, out := &in.Logging, &out.Logging
+		*out = new(SynapseLogging)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Ingress != nil {
+		in, out := &in.Ingress, &out.Ingress
+		*out = new(SynapseIngress)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Route != nil {
+		in, out := &in.Route, &out.Route
+		*out = new(SynapseRoute)
+		**out = **in
+	}
+	if in.WellKnown != nil {
+		in, out := &in.WellKnown, &out.WellKnown
+		*out = new(SynapseWellKnown)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Federation != nil {
+		in, out := &in.Federation, &out.Federation
+		*out = new(SynapseFederationListener)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Workers != nil {
+		in, out := &in.Workers, &out.Workers
+		*out = make([]SynapseWorkerPool, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Redis != nil {
+		in, out := &in.Redis, &out.Redis
+		*out = new(SynapseRedis)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Proxy != nil {
+		in, out := &in.Proxy, &out.Proxy
+		*out = new(SynapseProxy)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SynapseSpec.
@@ -208,7 +814,15 @@ func (in *SynapseStatus) DeepCopyInto(out *SynapseStatus) {
 	*out = *in
 	out.BridgesConfiguration = in.BridgesConfiguration
 	out.DatabaseConnectionInfo = in.DatabaseConnectionInfo
-	out.HomeserverConfiguration = in.HomeserverConfiguration
+	in.HomeserverConfiguration.DeepCopyInto(&out.HomeserverConfiguration)
+	out.Route = in.Route
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]metav1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SynapseStatus.
@@ -240,6 +854,7 @@ func (in *SynapseStatusBridgesConfiguration) DeepCopy() *SynapseStatusBridgesCon
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *SynapseStatusDatabaseConnectionInfo) DeepCopyInto(out *SynapseStatusDatabaseConnectionInfo) {
 	*out = *in
+	out.PasswordSecretKeyRef = in.PasswordSecretKeyRef
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SynapseStatusDatabaseConnectionInfo.
@@ -270,6 +885,11 @@ func (in *SynapseStatusHeisenbridge) DeepCopy() *SynapseStatusHeisenbridge {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *SynapseStatusHomeserverConfiguration) DeepCopyInto(out *SynapseStatusHomeserverConfiguration) {
 	*out = *in
+	if in.Warnings != nil {
+		in, out := &in.Warnings, &out.Warnings
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SynapseStatusHomeserverConfiguration.
@@ -281,3 +901,183 @@ func (in *SynapseStatusHomeserverConfiguration) DeepCopy() *SynapseStatusHomeser
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SynapseStatusRoute) DeepCopyInto(out *SynapseStatusRoute) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SynapseStatusRoute.
+func (in *SynapseStatusRoute) DeepCopy() *SynapseStatusRoute {
+	if in == nil {
+		return nil
+	}
+	out := new(SynapseStatusRoute)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SynapseStatusSecretKeyRef) DeepCopyInto(out *SynapseStatusSecretKeyRef) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SynapseStatusSecretKeyRef.
+func (in *SynapseStatusSecretKeyRef) DeepCopy() *SynapseStatusSecretKeyRef {
+	if in == nil {
+		return nil
+	}
+	out := new(SynapseStatusSecretKeyRef)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SynapseStorage) DeepCopyInto(out *SynapseStorage) {
+	*out = *in
+	if in.Size != nil {
+		in, out := &in.Size, &out.Size
+		x := (*in).DeepCopy()
+		*out = &x
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SynapseStorage.
+func (in *SynapseStorage) DeepCopy() *SynapseStorage {
+	if in == nil {
+		return nil
+	}
+	out := new(SynapseStorage)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SynapseURLPreviews) DeepCopyInto(out *SynapseURLPreviews) {
+	*out = *in
+	if in.IPRangeBlacklist != nil {
+		in, out := &in.IPRangeBlacklist, &out.IPRangeBlacklist
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SynapseURLPreviews.
+func (in *SynapseURLPreviews) DeepCopy() *SynapseURLPreviews {
+	if in == nil {
+		return nil
+	}
+	out := new(SynapseURLPreviews)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SynapseWellKnown) DeepCopyInto(out *SynapseWellKnown) {
+	*out = *in
+	if in.Ingress != nil {
+		in, out := &in.Ingress, &out.Ingress
+		*out = new(SynapseWellKnownIngress)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SynapseWellKnown.
+func (in *SynapseWellKnown) DeepCopy() *SynapseWellKnown {
+	if in == nil {
+		return nil
+	}
+	out := new(SynapseWellKnown)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SynapseWellKnownIngress) DeepCopyInto(out *SynapseWellKnownIngress) {
+	*out = *in
+	if in.IngressClassName != nil {
+		in, out := &in.IngressClassName, &out.IngressClassName
+		*out = new(string)
+		**out = **in
+	}
+	if in.Annotations != nil {
+		in, out := &in.Annotations, &out.Annotations
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.TLS != nil {
+		in, out := &in.TLS, &out.TLS
+		*out = new(SynapseIngressTLS)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SynapseWellKnownIngress.
+func (in *SynapseWellKnownIngress) DeepCopy() *SynapseWellKnownIngress {
+	if in == nil {
+		return nil
+	}
+	out := new(SynapseWellKnownIngress)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SynapseWorkerPool) DeepCopyInto(out *SynapseWorkerPool) {
+	*out = *in
+	if in.Replicas != nil {
+		in, out := &in.Replicas, &out.Replicas
+		*out = new(int32)
+		**out = **in
+	}
+	in.Resources.DeepCopyInto(&out.Resources)
+	if in.Autoscaling != nil {
+		in, out := &in.Autoscaling, &out.Autoscaling
+		*out = new(SynapseWorkerPoolAutoscaling)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SynapseWorkerPool.
+func (in *SynapseWorkerPool) DeepCopy() *SynapseWorkerPool {
+	if in == nil {
+		return nil
+	}
+	out := new(SynapseWorkerPool)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SynapseWorkerPoolAutoscaling) DeepCopyInto(out *SynapseWorkerPoolAutoscaling) {
+	*out = *in
+	if in.MinReplicas != nil {
+		in, out := &in.MinReplicas, &out.MinReplicas
+		*out = new(int32)
+		**out = **in
+	}
+	if in.TargetCPUUtilizationPercentage != nil {
+		in, out := &in.TargetCPUUtilizationPercentage, &out.TargetCPUUtilizationPercentage
+		*out = new(int32)
+		**out = **in
+	}
+	if in.Metrics != nil {
+		in, out := &in.Metrics, &out.Metrics
+		*out = make([]v2.MetricSpec, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SynapseWorkerPoolAutoscaling.
+func (in *SynapseWorkerPoolAutoscaling) DeepCopy() *SynapseWorkerPoolAutoscaling {
+	if in == nil {
+		return nil
+	}
+	out := new(SynapseWorkerPoolAutoscaling)
+	in.DeepCopyInto(out)
+	return out
+}
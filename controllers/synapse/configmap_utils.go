@@ -26,44 +26,44 @@ import (
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
 // Defines a function type. Functions of the updateDataFunc type implements the
 // logic to update the data of a configmap, defined by the 'data' argument.
 type updateDataFunc func(s synapsev1alpha1.Synapse, data map[string]interface{}) error
 
-// A generic function to update an existing ConfigMap. It takes as arguments:
-// * The context
-// * The name of the ConfigMap to update
-// * The Synapse object being reconciled
-// * The function to be called to actually update the ConfigMap's content
-// * The name of the file to update in the ConfigMap
-func (r *SynapseReconciler) updateConfigMap(
-	ctx context.Context,
-	cm *corev1.ConfigMap,
-	s synapsev1alpha1.Synapse,
-	updateData updateDataFunc,
+// withConfigMapUpdates returns a function of type createResourceFunc, to be
+// passed as an argument in a call to reconcileResource.
+//
+// The returned function generates a ConfigMap with the given
+// createResourceFunc, then calls each of the given updateDataFunc on the
+// file 'filename' of the ConfigMap. The complete content of the ConfigMap is
+// therefore computed before being applied.
+func (r *SynapseReconciler) withConfigMapUpdates(
+	createResource createResourceFunc,
 	filename string,
-) error {
-	// Get latest ConfigMap version
-	if err := r.Get(
-		ctx,
-		types.NamespacedName{Name: cm.Name, Namespace: cm.Namespace},
-		cm,
-	); err != nil {
-		return err
-	}
-
-	if err := r.updateConfigMapData(cm, s, updateData, filename); err != nil {
-		return err
+	updates ...updateDataFunc,
+) createResourceFunc {
+	return func(s *synapsev1alpha1.Synapse, objectMeta metav1.ObjectMeta) (client.Object, error) {
+		resource, err := createResource(s, objectMeta)
+		if err != nil {
+			return &corev1.ConfigMap{}, err
+		}
+
+		cm, ok := resource.(*corev1.ConfigMap)
+		if !ok {
+			return &corev1.ConfigMap{}, errors.New("generated resource is not a ConfigMap")
+		}
+
+		for _, updateData := range updates {
+			if err := r.updateConfigMapData(cm, *s, updateData, filename); err != nil {
+				return &corev1.ConfigMap{}, err
+			}
+		}
+
+		return cm, nil
 	}
-
-	// Update ConfigMap
-	if err := r.Client.Update(ctx, cm); err != nil {
-		return err
-	}
-
-	return nil
 }
 
 func (r *SynapseReconciler) updateConfigMapData(
@@ -172,3 +172,34 @@ func (r *SynapseReconciler) getConfigMapCopy(
 
 	return copyConfigMap, nil
 }
+
+// inputConfigMapsForSynapse returns the user-provided ConfigMaps referenced
+// by a Synapse instance.
+func (r *SynapseReconciler) inputConfigMapsForSynapse(s synapsev1alpha1.Synapse) []types.NamespacedName {
+	var inputConfigMaps []types.NamespacedName
+
+	if s.Spec.Homeserver.ConfigMap != nil {
+		inputConfigMaps = append(inputConfigMaps, types.NamespacedName{
+			Name:      s.Spec.Homeserver.ConfigMap.Name,
+			Namespace: r.getConfigMapNamespace(s, s.Spec.Homeserver.ConfigMap.Namespace),
+		})
+	}
+
+	if s.Spec.Bridges.Heisenbridge.Enabled && s.Spec.Bridges.Heisenbridge.ConfigMap.Name != "" {
+		inputConfigMaps = append(inputConfigMaps, types.NamespacedName{
+			Name:      s.Spec.Bridges.Heisenbridge.ConfigMap.Name,
+			Namespace: r.getConfigMapNamespace(s, s.Spec.Bridges.Heisenbridge.ConfigMap.Namespace),
+		})
+	}
+
+	for _, override := range s.Spec.Homeserver.Overrides {
+		if override.ConfigMap != nil {
+			inputConfigMaps = append(inputConfigMaps, types.NamespacedName{
+				Name:      override.ConfigMap.Name,
+				Namespace: r.getConfigMapNamespace(s, override.ConfigMap.Namespace),
+			})
+		}
+	}
+
+	return inputConfigMaps
+}
@@ -2,19 +2,44 @@ package synapse
 
 import (
 	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"reflect"
 
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
 	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
 
+	"k8s.io/apimachinery/pkg/api/equality"
 	k8serrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/diff"
 
 	synapsev1alpha1 "github.com/opdev/synapse-operator/apis/synapse/v1alpha1"
 )
 
+// synapseFieldManager is the field manager used by the Synapse Operator when
+// applying resources. Only the fields set by the Synapse Operator are owned
+// by this field manager.
+const synapseFieldManager = "synapse-operator"
+
 type createResourceFunc func(s *synapsev1alpha1.Synapse, objectMeta metav1.ObjectMeta) (client.Object, error)
 
+// renderedResource returns a function of type createResourceFunc, to be
+// passed as an argument in a call to reconcileResource.
+//
+// The returned function returns a copy of a resource already generated by a
+// createResourceFunc, e.g. to validate the resource before applying it.
+func renderedResource(resource client.Object) createResourceFunc {
+	return func(s *synapsev1alpha1.Synapse, objectMeta metav1.ObjectMeta) (client.Object, error) {
+		return resource.DeepCopyObject().(client.Object), nil
+	}
+}
+
 func setObjectMeta(name string, namespace string, labels map[string]string) metav1.ObjectMeta {
 	objectMeta := metav1.ObjectMeta{
 		Name:      name,
@@ -24,6 +49,12 @@ func setObjectMeta(name string, namespace string, labels map[string]string) meta
 	return objectMeta
 }
 
+// reconcileResource ensures that a resource owned by Synapse is in its
+// desired state. The desired resource is computed by the createResourceFunc,
+// and applied with server-side apply. Fields which are not set by the
+// createResourceFunc, like the ClusterIP of a Service, are preserved.
+//
+// On success, the given resource is populated with the applied object.
 func (r *SynapseReconciler) reconcileResource(
 	ctx context.Context,
 	createResource createResourceFunc,
@@ -39,57 +70,177 @@ func (r *SynapseReconciler) reconcileResource(
 		"Namespace", objectMeta.Namespace,
 	)
 
+	desiredResource, err := createResource(s, objectMeta)
+	if err != nil {
+		log.Error(
+			err,
+			"Failed to generate the desired resource for Synapse",
+			"Kind", desiredResource.GetObjectKind(),
+			"Name", objectMeta.Name,
+			"Namespace", objectMeta.Namespace,
+		)
+		return err
+	}
+
+	if reflect.TypeOf(desiredResource) != reflect.TypeOf(resource) {
+		return errors.New("generated resource type doesn't match the reconciled resource type")
+	}
+
+	// Server-side apply requires the GroupVersionKind to be set
+	gvk, err := apiutil.GVKForObject(desiredResource, r.Scheme)
+	if err != nil {
+		return err
+	}
+	desiredResource.GetObjectKind().SetGroupVersionKind(gvk)
+
+	applyOptions := []client.PatchOption{client.FieldOwner(synapseFieldManager), client.ForceOwnership}
+
 	if err := r.Client.Get(ctx, types.NamespacedName{Name: objectMeta.Name, Namespace: objectMeta.Namespace}, resource); err != nil {
-		if k8serrors.IsNotFound(err) {
-			log.Info(
-				"Creating a new resource for Synapse",
-				"Kind", resource.GetObjectKind(),
+		if !k8serrors.IsNotFound(err) {
+			log.Error(
+				err,
+				"Error reading resource",
+				"Kind", gvk.Kind,
 				"Name", objectMeta.Name,
 				"Namespace", objectMeta.Namespace,
 			)
+			return err
+		}
 
-			resource, err := createResource(s, objectMeta)
-			if err != nil {
-				log.Error(
-					err,
-					"Failed to generate a new resource for Synapse",
-					"Kind", resource.GetObjectKind(),
-					"Name", objectMeta.Name,
-					"Namespace", objectMeta.Namespace,
-				)
-				return err
-			}
-
-			err = r.Client.Create(ctx, resource)
-			if err != nil {
-				log.Error(
-					err,
-					"Failed to create a new resource for Synapse",
-					"Kind", resource.GetObjectKind(),
-					"Name", objectMeta.Name,
-					"Namespace", objectMeta.Namespace,
-				)
-				return err
-			}
+		log.Info(
+			"Creating a new resource for Synapse",
+			"Kind", gvk.Kind,
+			"Name", objectMeta.Name,
+			"Namespace", objectMeta.Namespace,
+		)
+	} else {
+		// Compute the result of the apply with a dry-run, in order to detect
+		// and log the changes to the existing resource.
+		dryRunResource := desiredResource.DeepCopyObject().(client.Object)
+		if err := r.Client.Patch(
+			ctx,
+			dryRunResource,
+			client.Apply,
+			append(applyOptions, client.DryRunAll)...,
+		); err != nil {
+			log.Error(
+				err,
+				"Failed to apply resource for Synapse in dry-run mode",
+				"Kind", gvk.Kind,
+				"Name", objectMeta.Name,
+				"Namespace", objectMeta.Namespace,
+			)
+			return err
+		}
 
+		resourceDiff := diffResources(resource, dryRunResource)
+		if resourceDiff == "" {
 			return nil
 		}
 
+		log.Info(
+			"Updating resource for Synapse",
+			"Kind", gvk.Kind,
+			"Name", objectMeta.Name,
+			"Namespace", objectMeta.Namespace,
+			"Diff", resourceDiff,
+		)
+	}
+
+	if err := r.Client.Patch(ctx, desiredResource, client.Apply, applyOptions...); err != nil {
 		log.Error(
 			err,
-			"Error reading resource",
-			"Kind", resource.GetObjectKind(),
+			"Failed to apply resource for Synapse",
+			"Kind", gvk.Kind,
 			"Name", objectMeta.Name,
 			"Namespace", objectMeta.Namespace,
 		)
 		return err
 	}
 
+	// Populate the given resource with the applied object
+	reflect.ValueOf(resource).Elem().Set(reflect.ValueOf(desiredResource).Elem())
+	return nil
+}
+
+// deleteResource deletes a resource previously created for Synapse, e.g.
+// when the corresponding feature is disabled in the Synapse Spec. Resources
+// which don't exist, or which are not controlled by the Synapse instance, are
+// left untouched.
+func (r *SynapseReconciler) deleteResource(
+	ctx context.Context,
+	s *synapsev1alpha1.Synapse,
+	resource client.Object,
+	objectMeta metav1.ObjectMeta) error {
+
+	log := ctrllog.FromContext(ctx)
+
+	if err := r.Client.Get(ctx, types.NamespacedName{Name: objectMeta.Name, Namespace: objectMeta.Namespace}, resource); err != nil {
+		if k8serrors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+
+	if !metav1.IsControlledBy(resource, s) {
+		return nil
+	}
+
 	log.Info(
-		"Reconciling resource",
-		"Kind", resource.GetObjectKind(),
+		"Deleting resource for Synapse",
+		"Kind", reflect.TypeOf(resource).Elem().Name(),
 		"Name", objectMeta.Name,
 		"Namespace", objectMeta.Namespace,
 	)
+
+	if err := r.Client.Delete(ctx, resource); err != nil && !k8serrors.IsNotFound(err) {
+		log.Error(
+			err,
+			"Failed to delete resource for Synapse",
+			"Name", objectMeta.Name,
+			"Namespace", objectMeta.Namespace,
+		)
+		return err
+	}
+
 	return nil
 }
+
+// diffResources returns a human readable diff between an existing resource
+// and the result of an apply, or an empty string if they are identical.
+// Fields maintained by the API server, and the values of Secret data, are
+// not part of the returned diff.
+func diffResources(existing client.Object, applied client.Object) string {
+	existing = existing.DeepCopyObject().(client.Object)
+	applied = applied.DeepCopyObject().(client.Object)
+
+	for _, obj := range []client.Object{existing, applied} {
+		obj.GetObjectKind().SetGroupVersionKind(schema.GroupVersionKind{})
+		obj.SetManagedFields(nil)
+		obj.SetResourceVersion("")
+		obj.SetGeneration(0)
+	}
+
+	if equality.Semantic.DeepEqual(existing, applied) {
+		return ""
+	}
+
+	for _, obj := range []client.Object{existing, applied} {
+		if secret, ok := obj.(*corev1.Secret); ok {
+			redactSecretData(secret)
+		}
+	}
+
+	return diff.ObjectReflectDiff(existing, applied)
+}
+
+// redactSecretData replaces the values of a Secret data with their checksum,
+// so that changed keys can be logged without disclosing their values.
+func redactSecretData(secret *corev1.Secret) {
+	for key, value := range secret.Data {
+		secret.Data[key] = []byte(fmt.Sprintf("sha256:%x", sha256.Sum256(value)))
+	}
+	for key, value := range secret.StringData {
+		secret.StringData[key] = fmt.Sprintf("sha256:%x", sha256.Sum256([]byte(value)))
+	}
+}
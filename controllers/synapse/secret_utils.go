@@ -0,0 +1,175 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package synapse
+
+/* This file puts together generic functions for Secret manipulation */
+import (
+	"context"
+	"errors"
+
+	synapsev1alpha1 "github.com/opdev/synapse-operator/apis/synapse/v1alpha1"
+	"gopkg.in/yaml.v2"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// withSecretUpdates returns a function of type createResourceFunc, to be
+// passed as an argument in a call to reconcileResource.
+//
+// The returned function generates a Secret with the given createResourceFunc,
+// then calls each of the given updateDataFunc on the file 'filename' of the
+// Secret. The complete content of the Secret is therefore computed before
+// being applied.
+func (r *SynapseReconciler) withSecretUpdates(
+	createResource createResourceFunc,
+	filename string,
+	updates ...updateDataFunc,
+) createResourceFunc {
+	return func(s *synapsev1alpha1.Synapse, objectMeta metav1.ObjectMeta) (client.Object, error) {
+		resource, err := createResource(s, objectMeta)
+		if err != nil {
+			return &corev1.Secret{}, err
+		}
+
+		secret, ok := resource.(*corev1.Secret)
+		if !ok {
+			return &corev1.Secret{}, errors.New("generated resource is not a Secret")
+		}
+
+		for _, updateData := range updates {
+			if err := r.updateSecretData(secret, *s, updateData, filename); err != nil {
+				return &corev1.Secret{}, err
+			}
+		}
+
+		return secret, nil
+	}
+}
+
+// getExistingSecret returns the Secret described by objectMeta, or an empty
+// Secret if it doesn't exist yet. It is used to keep generated values stable
+// across reconciliations.
+func (r *SynapseReconciler) getExistingSecret(ctx context.Context, objectMeta metav1.ObjectMeta) (corev1.Secret, error) {
+	existingSecret := corev1.Secret{}
+
+	if err := r.Get(
+		ctx,
+		types.NamespacedName{Name: objectMeta.Name, Namespace: objectMeta.Namespace},
+		&existingSecret,
+	); err != nil {
+		if k8serrors.IsNotFound(err) {
+			return corev1.Secret{}, nil
+		}
+		return corev1.Secret{}, err
+	}
+
+	return existingSecret, nil
+}
+
+func (r *SynapseReconciler) updateSecretData(
+	secret *corev1.Secret,
+	s synapsev1alpha1.Synapse,
+	updateData updateDataFunc,
+	filename string,
+) error {
+	// Load file to update from Secret
+	data, err := r.loadYAMLFileFromSecretData(*secret, filename)
+	if err != nil {
+		return err
+	}
+
+	// Update the content of the file
+	if err := updateData(s, data); err != nil {
+		return err
+	}
+
+	// Write new content into Secret data
+	if err := r.writeYAMLFileToSecretData(secret, filename, data); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *SynapseReconciler) loadYAMLFileFromSecretData(
+	secret corev1.Secret,
+	filename string,
+) (map[string]interface{}, error) {
+	yamlContent := map[string]interface{}{}
+
+	content, ok := secret.Data[filename]
+	if !ok {
+		err := errors.New("missing " + filename + " in Secret " + secret.Name)
+		return yamlContent, err
+	}
+	if err := yaml.Unmarshal(content, yamlContent); err != nil {
+		return yamlContent, err
+	}
+
+	return yamlContent, nil
+}
+
+func (r *SynapseReconciler) writeYAMLFileToSecretData(
+	secret *corev1.Secret,
+	filename string,
+	yamlContent map[string]interface{},
+) error {
+	bytesContent, err := yaml.Marshal(yamlContent)
+	if err != nil {
+		return err
+	}
+
+	if secret.Data == nil {
+		secret.Data = map[string][]byte{}
+	}
+	secret.Data[filename] = bytesContent
+	return nil
+}
+
+// inputSecretsForSynapse returns the user-provided Secrets referenced by a
+// Synapse instance. Unlike ConfigMaps, Secrets are always read from the
+// Synapse namespace.
+func (r *SynapseReconciler) inputSecretsForSynapse(s synapsev1alpha1.Synapse) []types.NamespacedName {
+	var secretNames []string
+
+	if s.Spec.Homeserver.Secret != nil {
+		secretNames = append(secretNames, s.Spec.Homeserver.Secret.Name)
+	}
+
+	if s.Spec.Homeserver.SigningKeySecret != nil {
+		secretNames = append(secretNames, s.Spec.Homeserver.SigningKeySecret.Name)
+	}
+
+	for _, override := range s.Spec.Homeserver.Overrides {
+		if override.Secret != nil {
+			secretNames = append(secretNames, override.Secret.Name)
+		}
+	}
+
+	if s.Spec.Redis != nil && s.Spec.Redis.External != nil && s.Spec.Redis.External.PasswordSecretKeyRef != nil {
+		secretNames = append(secretNames, s.Spec.Redis.External.PasswordSecretKeyRef.Name)
+	}
+
+	var inputSecrets []types.NamespacedName
+	for _, name := range secretNames {
+		inputSecrets = append(inputSecrets, types.NamespacedName{Name: name, Namespace: s.Namespace})
+	}
+	return inputSecrets
+}
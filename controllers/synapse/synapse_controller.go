@@ -19,20 +19,32 @@ package synapse
 import (
 	"context"
 	"errors"
+	"fmt"
 	"reflect"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
 	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
 	rbacv1 "k8s.io/api/rbac/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/types"
 
 	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	pgov1beta1 "github.com/crunchydata/postgres-operator/pkg/apis/postgres-operator.crunchydata.com/v1beta1"
 	synapsev1alpha1 "github.com/opdev/synapse-operator/apis/synapse/v1alpha1"
@@ -42,6 +54,17 @@ import (
 type SynapseReconciler struct {
 	client.Client
 	Scheme *runtime.Scheme
+
+	// Records the Events reported on Synapse instances
+	Recorder record.EventRecorder
+
+	// DNS domain of the cluster, used to address Services. Defaults to
+	// cluster.local.
+	ClusterDomain string
+
+	// Results of the last probes of the external Redis instances, keyed by
+	// Synapse instance. See probeExternalRedis.
+	redisProbes sync.Map
 }
 
 type HomeserverPgsqlDatabase struct {
@@ -58,6 +81,33 @@ type HomeserverPgsqlDatabase struct {
 	}
 }
 
+// Reasons of the conditions reported in the Synapse Status
+const (
+	reasonConfigurationValid           = "ConfigurationValid"
+	reasonConfigMapNotFound            = "ConfigMapNotFound"
+	reasonInvalidConfiguration         = "InvalidConfiguration"
+	reasonSecretNotFound               = "SecretNotFound"
+	reasonInvalidSecret                = "InvalidSecret"
+	reasonSigningKeyNotMigrated        = "SigningKeyNotMigrated"
+	reasonPostgresOperatorNotInstalled = "PostgresOperatorNotInstalled"
+	reasonDatabaseNotReady             = "DatabaseNotReady"
+	reasonDatabaseReady                = "DatabaseReady"
+	reasonDeploymentNotAvailable       = "DeploymentNotAvailable"
+	reasonDeploymentAvailable          = "DeploymentAvailable"
+	reasonReconciled                   = "Reconciled"
+	reasonOverrideNotFound             = "OverrideNotFound"
+	reasonOverridesApplied             = "OverridesApplied"
+	reasonOverrideConflict             = "OverrideConflict"
+	reasonRouteAPINotAvailable         = "RouteAPINotAvailable"
+	reasonRouteNotAdmitted             = "RouteNotAdmitted"
+	reasonRouteAdmitted                = "RouteAdmitted"
+	reasonRedisNotReachable            = "RedisNotReachable"
+	reasonRedisReachable               = "RedisReachable"
+	reasonInvalidPodTemplate           = "InvalidPodTemplate"
+	reasonSnapshotNotReady             = "SnapshotNotReady"
+	reasonSnapshotFailed               = "SnapshotFailed"
+)
+
 //+kubebuilder:rbac:groups=synapse.opdev.io,resources=synapses,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=synapse.opdev.io,resources=synapses/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=synapse.opdev.io,resources=synapses/finalizers,verbs=update
@@ -78,6 +128,7 @@ func (r *SynapseReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ct
 			// we'll ignore not-found errors, since they can't be fixed by an immediate
 			// requeue (we'll need to wait for a new notification), and we can get them
 			// on deleted requests.
+			r.forgetExternalRedisProbe(req.NamespacedName)
 			log.Error(
 				err,
 				"Cannot find Synapse - has it been deleted ?",
@@ -95,15 +146,206 @@ func (r *SynapseReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ct
 		return ctrl.Result{}, client.IgnoreNotFound(err)
 	}
 
+	// The Synapse instance is being deleted. Its DeletionPolicy is enforced
+	// before the child resources are garbage collected.
+	if !synapse.DeletionTimestamp.IsZero() {
+		return r.finalizeSynapse(ctx, &synapse)
+	}
+
+	if err := r.ensureSynapseFinalizer(ctx, &synapse); err != nil {
+		log.Error(err, "Error adding the finalizer to Synapse")
+		return ctrl.Result{}, err
+	}
+
+	// Default values are normally set by the defaulting webhook. They are
+	// applied again here, in memory only, in case the webhooks are not
+	// deployed.
+	synapse.Default()
+
+	// Synapse instances created by older versions of the Synapse Operator may
+	// still hold the database password in their Status.
+	if err := r.migrateSynapseStatusDatabasePassword(ctx, &synapse); err != nil {
+		log.Error(err, "Error migrating the database password out of the Synapse Status")
+		return ctrl.Result{}, err
+	}
+
 	objectMetaForSynapse := setObjectMeta(synapse.Name, synapse.Namespace, map[string]string{})
 
+	// The pod templates merged into the Synapse and Heisenbridge
+	// Deployments can't change the volumes they require
+	if err := r.checkPodTemplates(synapse); err != nil {
+		if err := r.setFailedState(
+			ctx,
+			&synapse,
+			synapsev1alpha1.ConditionTypeConfigurationValid,
+			reasonInvalidPodTemplate,
+			err.Error(),
+		); err != nil {
+			log.Error(err, "Error updating Synapse State")
+		}
+
+		log.Error(err, "Invalid pod template")
+		return ctrl.Result{RequeueAfter: 30 * time.Second}, err
+	}
+
+	// The Secret holding the ed25519 signing key of Synapse. It's either a
+	// user-provided Secret, if defined in Spec.Homeserver.SigningKeySecret,
+	// or a new Secret containing a generated signing key. It must be known
+	// before rendering homeserver.yaml.
+	var signingKeySecret corev1.Secret
+	if synapse.Spec.Homeserver.SigningKeySecret != nil {
+		secretName := synapse.Spec.Homeserver.SigningKeySecret.Name
+		if err := r.Get(
+			ctx,
+			types.NamespacedName{Name: secretName, Namespace: synapse.Namespace},
+			&signingKeySecret,
+		); err != nil {
+			reason := "Secret " + secretName + " does not exist in namespace " + synapse.Namespace
+			if err := r.setFailedState(
+				ctx,
+				&synapse,
+				synapsev1alpha1.ConditionTypeConfigurationValid,
+				reasonSecretNotFound,
+				reason,
+			); err != nil {
+				log.Error(err, "Error updating Synapse State")
+			}
+
+			log.Error(
+				err,
+				"Failed to get Secret",
+				"Secret.Namespace",
+				synapse.Namespace,
+				"Secret.Name",
+				secretName,
+			)
+			return ctrl.Result{RequeueAfter: 30 * time.Second}, err
+		}
+	} else {
+		// Synapse instances deployed by earlier versions of the Synapse
+		// Operator use the signing key generated on their PVC. A new key
+		// would silently change the identity of the server.
+		if err := r.checkSigningKeyMigration(ctx, synapse); err != nil {
+			reason := reasonSigningKeyNotMigrated
+			if !errors.Is(err, errSigningKeyNotMigrated) {
+				reason = reasonInvalidSecret
+			}
+			if err := r.setFailedState(
+				ctx,
+				&synapse,
+				synapsev1alpha1.ConditionTypeConfigurationValid,
+				reason,
+				err.Error(),
+			); err != nil {
+				log.Error(err, "Error updating Synapse State")
+			}
+
+			log.Error(err, "Cannot generate a signing key for Synapse")
+			return ctrl.Result{RequeueAfter: 30 * time.Second}, err
+		}
+
+		objectMetaForSigningKeySecret := setObjectMeta(signingKeySecretName(synapse), synapse.Namespace, map[string]string{})
+		if err := r.reconcileResource(
+			ctx,
+			r.secretForSigningKey(ctx),
+			&synapse,
+			&signingKeySecret,
+			objectMetaForSigningKeySecret,
+		); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	signingKeyID, err := r.checkSigningKeySecret(signingKeySecret)
+	if err != nil {
+		if err := r.setFailedState(
+			ctx,
+			&synapse,
+			synapsev1alpha1.ConditionTypeConfigurationValid,
+			reasonInvalidSecret,
+			err.Error(),
+		); err != nil {
+			log.Error(err, "Error updating Synapse State")
+		}
+
+		log.Error(err, "Invalid signing key Secret", "Secret.Name", signingKeySecret.Name)
+		return ctrl.Result{RequeueAfter: 30 * time.Second}, err
+	}
+	synapse.Status.HomeserverConfiguration.SigningKeyID = signingKeyID
+
 	// The ConfigMap for Synapse, containing the homeserver.yaml config file.
 	// It's either a copy of a user-provided ConfigMap, if defined in
 	// Spec.Homeserver.ConfigMap, or a new ConfigMap containing a default
 	// homeserver.yaml.
 	var createdConfigMap corev1.ConfigMap
 
+	// The homeserver.yaml fragments defined in Spec.Homeserver.Overrides
+	overrides, err := r.fetchHomeserverOverrides(ctx, synapse)
+	if err != nil {
+		reason := reasonInvalidConfiguration
+		if k8serrors.IsNotFound(err) {
+			reason = reasonOverrideNotFound
+		}
+		if err := r.setFailedState(
+			ctx,
+			&synapse,
+			synapsev1alpha1.ConditionTypeConfigurationValid,
+			reason,
+			err.Error(),
+		); err != nil {
+			log.Error(err, "Error updating Synapse State")
+		}
+
+		log.Error(err, "Failed to load the homeserver.yaml overrides")
+		return ctrl.Result{RequeueAfter: 30 * time.Second}, err
+	}
+
+	// Overrides are merged first, so that sensitive sections they define are
+	// moved to homeserver-secrets.yaml. Overridden keys which are managed by
+	// the Synapse Operator are collected in overrideConflicts.
+	overrideConflicts := map[string]bool{}
+
+	// Sensitive sections are moved from homeserver.yaml to
+	// homeserver-secrets.yaml. They are collected in sensitiveConfig while
+	// generating the ConfigMap. This includes the sections set by overrides
+	// read from a Secret, which are merged in homeserver-secrets.yaml only.
+	sensitiveConfig := map[string]interface{}{}
+	homeserverUpdates := []updateDataFunc{
+		r.updateHomeserverWithOverrides(overrides.config, overrideConflicts),
+		r.extractSensitiveConfig(sensitiveConfig, overrides.secretKeys(r.homeserverManagedKeys(synapse))...),
+		r.updateHomeserverWithSigningKey(signingKeySecret),
+	}
+	if synapse.Spec.Bridges.Heisenbridge.Enabled {
+		// Enable Heisenbridge in homeserver.yaml
+		homeserverUpdates = append(homeserverUpdates, r.updateHomeserverWithHeisenbridgeInfos)
+	}
+	if isLogConfigManaged(synapse) {
+		// Use the log configuration rendered from Spec.Logging
+		homeserverUpdates = append(homeserverUpdates, r.updateHomeserverWithLogConfig)
+	}
+	if synapse.Spec.Ingress != nil {
+		// Derive public_baseurl from the Ingress host
+		homeserverUpdates = append(homeserverUpdates, r.updateHomeserverWithIngress)
+	}
+	if isRouteReencrypt(synapse) {
+		// Serve the Synapse APIs over TLS for the Route
+		homeserverUpdates = append(homeserverUpdates, r.updateHomeserverWithRouteTLS)
+	}
+	if synapse.Spec.Federation != nil {
+		// Configure the federation listener and options
+		homeserverUpdates = append(homeserverUpdates, r.updateHomeserverWithFederation)
+	}
+	if isWellKnownServedBySynapse(synapse) {
+		// Serve the .well-known documents from Synapse
+		homeserverUpdates = append(homeserverUpdates, r.updateHomeserverWithWellKnown)
+	}
+	if len(synapse.Spec.Workers) != 0 {
+		// Enable the replication and delegate tasks to the workers
+		homeserverUpdates = append(homeserverUpdates, r.updateHomeserverWithWorkers)
+	}
+
 	// Synapse should either have a Spec.Homeserver.ConfigMap or Spec.Homeserver.Values
+	var configMapForSynapse createResourceFunc
 	if synapse.Spec.Homeserver.ConfigMap != nil {
 		// If the user provided a ConfigMap for the Homeserver config file:
 		// * We ensure that it exists and is a valid yaml file
@@ -120,7 +362,13 @@ func (r *SynapseReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ct
 			&inputConfigMap,
 		); err != nil {
 			reason := "ConfigMap " + ConfigMapName + " does not exist in namespace " + ConfigMapNamespace
-			if err := r.setFailedState(ctx, &synapse, reason); err != nil {
+			if err := r.setFailedState(
+				ctx,
+				&synapse,
+				synapsev1alpha1.ConditionTypeConfigurationValid,
+				reasonConfigMapNotFound,
+				reason,
+			); err != nil {
 				log.Error(err, "Error updating Synapse State")
 			}
 
@@ -135,97 +383,572 @@ func (r *SynapseReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ct
 			return ctrl.Result{RequeueAfter: time.Duration(30)}, err
 		}
 
-		if err := r.ParseHomeserverConfigMap(ctx, &synapse, inputConfigMap); err != nil {
-			return ctrl.Result{RequeueAfter: time.Duration(30)}, err
+		if err := r.ParseHomeserverConfigMap(ctx, &synapse, inputConfigMap); err != nil {
+			if err := r.setFailedState(
+				ctx,
+				&synapse,
+				synapsev1alpha1.ConditionTypeConfigurationValid,
+				reasonInvalidConfiguration,
+				err.Error(),
+			); err != nil {
+				log.Error(err, "Error updating Synapse State")
+			}
+			return ctrl.Result{RequeueAfter: time.Duration(30)}, err
+		}
+
+		// Create a copy of the inputConfigMap defined in Spec.Homeserver.ConfigMap
+		// Here we use the configMapForSynapseCopy function as createResourceFunc
+		configMapForSynapse = r.configMapForSynapseCopy
+	} else {
+		// If the user hasn't provided a ConfigMap with a custom
+		// homeserver.yaml, we create a new ConfigMap. The default
+		// homeserver.yaml is configured with values defined in
+		// Spec.Homeserver.Values
+		synapse.Status.HomeserverConfiguration.ServerName = synapse.Spec.Homeserver.Values.ServerName
+		synapse.Status.HomeserverConfiguration.ReportStats = synapse.Spec.Homeserver.Values.ReportStats
+
+		// Create a new ConfigMap for Synapse
+		// Here we use the configMapForSynapse function as createResourceFunc
+		configMapForSynapse = r.configMapForSynapse
+	}
+
+	// The Synapse ConfigMap is only rendered here. It's applied once the
+	// complete configuration of Synapse has been validated. The updates of
+	// homeserver.yaml may fail, e.g. on invalid overrides.
+	desiredConfigMap, err := r.withLogConfig(
+		r.withConfigMapUpdates(configMapForSynapse, "homeserver.yaml", homeserverUpdates...),
+	)(&synapse, objectMetaForSynapse)
+	if err != nil {
+		if err := r.setFailedState(
+			ctx,
+			&synapse,
+			synapsev1alpha1.ConditionTypeConfigurationValid,
+			reasonInvalidConfiguration,
+			"Cannot render the Synapse ConfigMap: "+err.Error(),
+		); err != nil {
+			log.Error(err, "Error updating Synapse State")
+		}
+
+		log.Error(err, "Failed to render the Synapse ConfigMap")
+		return ctrl.Result{}, err
+	}
+
+	// The Secret holding the registration_shared_secret, macaroon_secret_key
+	// and form_secret values. It's either a user-provided Secret, if defined
+	// in Spec.Homeserver.Secret, or a new Secret containing random values.
+	var homeserverSecret corev1.Secret
+	if synapse.Spec.Homeserver.Secret != nil {
+		secretName := synapse.Spec.Homeserver.Secret.Name
+		if err := r.Get(
+			ctx,
+			types.NamespacedName{Name: secretName, Namespace: synapse.Namespace},
+			&homeserverSecret,
+		); err != nil {
+			reason := "Secret " + secretName + " does not exist in namespace " + synapse.Namespace
+			if err := r.setFailedState(
+				ctx,
+				&synapse,
+				synapsev1alpha1.ConditionTypeConfigurationValid,
+				reasonSecretNotFound,
+				reason,
+			); err != nil {
+				log.Error(err, "Error updating Synapse State")
+			}
+
+			log.Error(
+				err,
+				"Failed to get Secret",
+				"Secret.Namespace",
+				synapse.Namespace,
+				"Secret.Name",
+				secretName,
+			)
+			return ctrl.Result{RequeueAfter: 30 * time.Second}, err
+		}
+
+		if err := r.checkHomeserverSecret(homeserverSecret); err != nil {
+			if err := r.setFailedState(
+				ctx,
+				&synapse,
+				synapsev1alpha1.ConditionTypeConfigurationValid,
+				reasonInvalidSecret,
+				err.Error(),
+			); err != nil {
+				log.Error(err, "Error updating Synapse State")
+			}
+
+			log.Error(err, "Invalid Secret", "Secret.Name", secretName)
+			return ctrl.Result{RequeueAfter: 30 * time.Second}, err
+		}
+	} else {
+		// Values are generated only once, when the Secret is created, and
+		// are therefore stable across reconciliations.
+		objectMetaForSynapseSecret := setObjectMeta(synapse.Name+"-secrets", synapse.Namespace, map[string]string{})
+		if err := r.reconcileResource(
+			ctx,
+			r.secretForSynapse(ctx),
+			&synapse,
+			&homeserverSecret,
+			objectMetaForSynapseSecret,
+		); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	// If the user provided a custom Heisenbridge configuration via a
+	// ConfigMap, we need to validate that the ConfigMap exists
+	inputConfigMapName := synapse.Spec.Bridges.Heisenbridge.ConfigMap.Name
+	if synapse.Spec.Bridges.Heisenbridge.Enabled && inputConfigMapName != "" {
+		setConfigMapNamespace := synapse.Spec.Bridges.Heisenbridge.ConfigMap.Namespace
+		inputConfigMapNamespace := r.getConfigMapNamespace(synapse, setConfigMapNamespace)
+
+		// Get and check the input ConfigMap for Heisenbridge
+		var inputHeisenbridgeConfigMap = &corev1.ConfigMap{}
+		keyForConfigMap := types.NamespacedName{
+			Name:      inputConfigMapName,
+			Namespace: inputConfigMapNamespace,
+		}
+
+		if err := r.Get(ctx, keyForConfigMap, inputHeisenbridgeConfigMap); err != nil {
+			reason := "ConfigMap " + inputConfigMapName + " does not exist in namespace " + inputConfigMapNamespace
+			if err := r.setFailedState(
+				ctx,
+				&synapse,
+				synapsev1alpha1.ConditionTypeConfigurationValid,
+				reasonConfigMapNotFound,
+				reason,
+			); err != nil {
+				log.Error(err, "Error updating Synapse State")
+			}
+
+			log.Error(
+				err,
+				"Failed to get ConfigMap",
+				"ConfigMap.Namespace",
+				inputConfigMapNamespace,
+				"ConfigMap.Name",
+				inputConfigMapName,
+			)
+			return ctrl.Result{RequeueAfter: 30 * time.Second}, err
+		}
+	}
+
+	if synapse.Spec.CreateNewPostgreSQL {
+		if !r.isPostgresOperatorInstalled(ctx) {
+			reason := "Cannot create PostgreSQL instance for synapse. Postgres-operator is not installed."
+			if err := r.setFailedState(
+				ctx,
+				&synapse,
+				synapsev1alpha1.ConditionTypeDatabaseReady,
+				reasonPostgresOperatorNotInstalled,
+				reason,
+			); err != nil {
+				log.Error(err, "Error updating Synapse State")
+			}
+
+			err := errors.New("cannot create PostgreSQL instance for synapse. Potsres-operator is not installed")
+			log.Error(err, "Cannot create PostgreSQL instance for synapse. Potsres-operator is not installed.")
+			return ctrl.Result{}, nil
+		}
+		if result, err := r.createPostgresClusterForSynapse(ctx, &synapse); err != nil {
+			return result, err
+		}
+	} else {
+		meta.RemoveStatusCondition(&synapse.Status.Conditions, synapsev1alpha1.ConditionTypeDatabaseReady)
+	}
+
+	// The Secret for Synapse, containing the homeserver-secrets.yaml config
+	// file. Sensitive sections of the Synapse configuration are rendered in
+	// this file instead of the ConfigMap. It shares the same name as the
+	// Synapse ConfigMap.
+	configSecretUpdates := []updateDataFunc{
+		r.mergeSensitiveConfig(sensitiveConfig),
+		r.updateHomeserverWithOverrides(overrides.secrets, overrideConflicts),
+		r.updateHomeserverWithSecrets(homeserverSecret),
+	}
+	if synapse.Spec.CreateNewPostgreSQL {
+		// As it contains the database password, the 'database' section is
+		// never written in the Synapse ConfigMap.
+		configSecretUpdates = append(configSecretUpdates, r.updateHomeserverWithPostgreSQLInfos(ctx))
+	}
+
+	// The Redis instance used by the workers to communicate with the main
+	// Synapse process. It's either an external Redis instance, if defined in
+	// Spec.Redis.External, or a Redis instance deployed by the Synapse
+	// Operator. The latter is composed of a Deployment, a Service and an
+	// optional Secret holding its password, appended with "-redis".
+	objectMetaRedis := setObjectMeta(redisResourceName(synapse), synapse.Namespace, map[string]string{})
+	redisResources := []client.Object{&corev1.Service{}, &appsv1.Deployment{}, &corev1.Secret{}}
+	if isRedisDeployed(synapse) {
+		var redisPassword string
+		if isRedisPasswordGenerated(synapse) {
+			createdRedisSecret := &corev1.Secret{}
+			if err := r.reconcileResource(
+				ctx,
+				r.secretForRedis(ctx),
+				&synapse,
+				createdRedisSecret,
+				objectMetaRedis,
+			); err != nil {
+				return ctrl.Result{}, err
+			}
+			redisPassword = string(createdRedisSecret.Data[redisPasswordKey])
+		} else {
+			if err := r.deleteResource(ctx, &synapse, &corev1.Secret{}, objectMetaRedis); err != nil {
+				return ctrl.Result{}, err
+			}
+		}
+
+		configSecretUpdates = append(configSecretUpdates, r.updateHomeserverWithRedis(redisPassword))
+	} else {
+		for _, resource := range redisResources {
+			if err := r.deleteResource(ctx, &synapse, resource, objectMetaRedis); err != nil {
+				return ctrl.Result{}, err
+			}
+		}
+
+		if len(synapse.Spec.Workers) != 0 {
+			redisPassword, err := r.fetchExternalRedisPassword(ctx, synapse)
+			if err != nil {
+				reason := reasonInvalidSecret
+				if k8serrors.IsNotFound(err) {
+					reason = reasonSecretNotFound
+				}
+				if err := r.setFailedState(
+					ctx,
+					&synapse,
+					synapsev1alpha1.ConditionTypeConfigurationValid,
+					reason,
+					"Cannot read the password of the external Redis instance: "+err.Error(),
+				); err != nil {
+					log.Error(err, "Error updating Synapse State")
+				}
+
+				log.Error(err, "Failed to read the password of the external Redis instance")
+				return ctrl.Result{RequeueAfter: 30 * time.Second}, err
+			}
+
+			r.setExternalRedisCondition(&synapse, redisPassword)
+			configSecretUpdates = append(configSecretUpdates, r.updateHomeserverWithRedis(redisPassword))
+		} else {
+			r.forgetExternalRedisProbe(req.NamespacedName)
+			meta.RemoveStatusCondition(&synapse.Status.Conditions, synapsev1alpha1.ConditionTypeRedisReady)
+		}
+	}
+
+	desiredConfigSecret, err := r.withSecretUpdates(
+		r.configSecretForSynapse,
+		"homeserver-secrets.yaml",
+		configSecretUpdates...,
+	)(&synapse, objectMetaForSynapse)
+	if err != nil {
+		log.Error(err, "Failed to render the Synapse Secret")
+		return ctrl.Result{}, err
+	}
+
+	if len(synapse.Spec.Homeserver.Overrides) != 0 {
+		r.setOverridesCondition(&synapse, overrideConflicts)
+	} else {
+		meta.RemoveStatusCondition(&synapse.Status.Conditions, synapsev1alpha1.ConditionTypeOverridesApplied)
+	}
+
+	// The complete configuration of Synapse, including the overrides and
+	// the updates of the Synapse Operator, is validated before the Synapse
+	// ConfigMap and Secret are applied. An invalid configuration never
+	// reaches the volumes of the running pods.
+	warnings, err := r.validateRenderedHomeserver(
+		*desiredConfigMap.(*corev1.ConfigMap),
+		*desiredConfigSecret.(*corev1.Secret),
+	)
+	if err != nil {
+		if err := r.setFailedState(
+			ctx,
+			&synapse,
+			synapsev1alpha1.ConditionTypeConfigurationValid,
+			reasonInvalidConfiguration,
+			err.Error(),
+		); err != nil {
+			log.Error(err, "Error updating Synapse State")
+		}
+
+		log.Error(err, "Invalid Synapse configuration")
+		return ctrl.Result{RequeueAfter: 30 * time.Second}, err
+	}
+	synapse.Status.HomeserverConfiguration.Warnings = warnings
+
+	if err := r.reconcileResource(
+		ctx,
+		renderedResource(desiredConfigMap),
+		&synapse,
+		&createdConfigMap,
+		objectMetaForSynapse,
+	); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	var createdConfigSecret corev1.Secret
+	if err := r.reconcileResource(
+		ctx,
+		renderedResource(desiredConfigSecret),
+		&synapse,
+		&createdConfigSecret,
+		objectMetaForSynapse,
+	); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	configurationMessage := "The Synapse configuration is valid"
+	if warnings := synapse.Status.HomeserverConfiguration.Warnings; len(warnings) != 0 {
+		configurationMessage += fmt.Sprintf(
+			", with %d warning(s) reported in status.homeserverConfiguration.warnings",
+			len(warnings),
+		)
+	}
+	r.setStatusCondition(
+		&synapse,
+		synapsev1alpha1.ConditionTypeConfigurationValid,
+		metav1.ConditionTrue,
+		reasonConfigurationValid,
+		configurationMessage,
+	)
+
+	if err := r.updateSynapseStatus(ctx, &synapse); err != nil {
+		log.Error(err, "Error updating Synapse Status")
+		return ctrl.Result{}, err
+	}
+
+	// The Redis instance deployed by the Synapse Operator
+	if isRedisDeployed(synapse) {
+		createdRedisDeployment := &appsv1.Deployment{}
+		if err := r.reconcileResource(
+			ctx,
+			r.deploymentForRedis,
+			&synapse,
+			createdRedisDeployment,
+			objectMetaRedis,
+		); err != nil {
+			return ctrl.Result{}, err
+		}
+
+		if err := r.reconcileResource(
+			ctx,
+			r.serviceForRedis,
+			&synapse,
+			&corev1.Service{},
+			objectMetaRedis,
+		); err != nil {
+			return ctrl.Result{}, err
+		}
+
+		r.setDeploymentCondition(&synapse, synapsev1alpha1.ConditionTypeRedisReady, *createdRedisDeployment)
+	}
+
+	// The Synapse Service. Its DNS name is used by the bridges to reach
+	// Synapse.
+	createdService := &corev1.Service{}
+	if err := r.reconcileResource(
+		ctx,
+		r.serviceForSynapse,
+		&synapse,
+		createdService,
+		objectMetaForSynapse,
+	); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	// The Service exposing the main Synapse process to the reverse proxy
+	// and to the workers, in worker mode
+	objectMetaMain := setObjectMeta(mainServiceName(synapse), synapse.Namespace, map[string]string{})
+	if isProxyDeployed(synapse) {
+		if err := r.reconcileResource(
+			ctx,
+			r.serviceForMainProcess,
+			&synapse,
+			&corev1.Service{},
+			objectMetaMain,
+		); err != nil {
+			return ctrl.Result{}, err
+		}
+	} else {
+		if err := r.deleteResource(ctx, &synapse, &corev1.Service{}, objectMetaMain); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	// The Service exposing the federation listener, if enabled in
+	// Spec.Federation
+	objectMetaFederation := setObjectMeta(synapse.Name+"-federation", synapse.Namespace, map[string]string{})
+	if isFederationListenerEnabled(synapse) {
+		if err := r.reconcileResource(
+			ctx,
+			r.serviceForFederation,
+			&synapse,
+			&corev1.Service{},
+			objectMetaFederation,
+		); err != nil {
+			return ctrl.Result{}, err
+		}
+	} else {
+		if err := r.deleteResource(ctx, &synapse, &corev1.Service{}, objectMetaFederation); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	// The Synapse IP is only reported for information
+	synapse.Status.IP = createdService.Spec.ClusterIP
+
+	// The Ingress exposing the Synapse Service, if defined in Spec.Ingress
+	if synapse.Spec.Ingress != nil {
+		if err := r.reconcileResource(
+			ctx,
+			r.ingressForSynapse,
+			&synapse,
+			&networkingv1.Ingress{},
+			objectMetaForSynapse,
+		); err != nil {
+			return ctrl.Result{}, err
+		}
+	} else {
+		if err := r.deleteResource(ctx, &synapse, &networkingv1.Ingress{}, objectMetaForSynapse); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	// The OpenShift Route exposing the Synapse Service, if defined in
+	// Spec.Route. The route.openshift.io API is looked up via discovery.
+	createdRoute := &unstructured.Unstructured{}
+	createdRoute.SetGroupVersionKind(routeGVK)
+	if synapse.Spec.Route != nil {
+		if !isRouteAPIAvailable(r.Client.RESTMapper()) {
+			reason := "Cannot create a Route for Synapse. The route.openshift.io API is not available."
+			if err := r.setFailedState(
+				ctx,
+				&synapse,
+				synapsev1alpha1.ConditionTypeRouteAdmitted,
+				reasonRouteAPINotAvailable,
+				reason,
+			); err != nil {
+				log.Error(err, "Error updating Synapse State")
+			}
+
+			err := errors.New("cannot create a Route for Synapse. The route.openshift.io API is not available")
+			log.Error(err, "Cannot create a Route for Synapse. The route.openshift.io API is not available.")
+			return ctrl.Result{}, nil
+		}
+
+		if err := r.reconcileResource(
+			ctx,
+			r.routeForSynapse,
+			&synapse,
+			createdRoute,
+			objectMetaForSynapse,
+		); err != nil {
+			return ctrl.Result{}, err
+		}
+
+		r.setRouteCondition(&synapse, *createdRoute)
+	} else if meta.FindStatusCondition(synapse.Status.Conditions, synapsev1alpha1.ConditionTypeRouteAdmitted) != nil {
+		// A Route was previously created for Synapse
+		if isRouteAPIAvailable(r.Client.RESTMapper()) {
+			if err := r.deleteResource(ctx, &synapse, createdRoute, objectMetaForSynapse); err != nil {
+				return ctrl.Result{}, err
+			}
+		}
+
+		meta.RemoveStatusCondition(&synapse.Status.Conditions, synapsev1alpha1.ConditionTypeRouteAdmitted)
+		synapse.Status.Route = synapsev1alpha1.SynapseStatusRoute{}
+	}
+
+	// The web server publishing the .well-known documents on the
+	// server_name domain, if requested in Spec.WellKnown. It's composed of a
+	// ConfigMap, a Deployment, a Service and an Ingress. Resources are
+	// appended with "-well-known".
+	objectMetaWellKnown := setObjectMeta(synapse.Name+"-well-known", synapse.Namespace, map[string]string{})
+	if isWellKnownDeployed(synapse) {
+		// The published addresses are derived from the Ingress or Route of
+		// Synapse, unless set in Spec.WellKnown
+		if _, err := wellKnownDocuments(synapse); err != nil {
+			if err := r.setFailedState(
+				ctx,
+				&synapse,
+				synapsev1alpha1.ConditionTypeConfigurationValid,
+				reasonInvalidConfiguration,
+				err.Error(),
+			); err != nil {
+				log.Error(err, "Error updating Synapse State")
+			}
+
+			log.Error(err, "Failed to render the .well-known documents")
+			return ctrl.Result{RequeueAfter: 30 * time.Second}, err
 		}
 
-		// Create a copy of the inputConfigMap defined in Spec.Homeserver.ConfigMap
-		// Here we use the configMapForSynapseCopy function as createResourceFunc
+		createdWellKnownConfigMap := &corev1.ConfigMap{}
 		if err := r.reconcileResource(
 			ctx,
-			r.configMapForSynapseCopy,
+			r.configMapForWellKnown,
 			&synapse,
-			&createdConfigMap,
-			objectMetaForSynapse,
+			createdWellKnownConfigMap,
+			objectMetaWellKnown,
 		); err != nil {
-			return ctrl.Result{}, nil
+			return ctrl.Result{}, err
 		}
-	} else {
-		// If the user hasn't provided a ConfigMap with a custom
-		// homeserver.yaml, we create a new ConfigMap. The default
-		// homeserver.yaml is configured with values defined in
-		// Spec.Homeserver.Values
-		synapse.Status.HomeserverConfiguration.ServerName = synapse.Spec.Homeserver.Values.ServerName
-		synapse.Status.HomeserverConfiguration.ReportStats = synapse.Spec.Homeserver.Values.ReportStats
 
-		// Create a new ConfigMap for Synapse
-		// Here we use the configMapForSynapse function as createResourceFunc
 		if err := r.reconcileResource(
 			ctx,
-			r.configMapForSynapse,
+			r.withConfigChecksum(r.deploymentForWellKnown, createdWellKnownConfigMap),
 			&synapse,
-			&createdConfigMap,
-			objectMetaForSynapse,
+			&appsv1.Deployment{},
+			objectMetaWellKnown,
 		); err != nil {
-			return ctrl.Result{}, nil
+			return ctrl.Result{}, err
 		}
-	}
-
-	if err := r.updateSynapseStatus(ctx, &synapse); err != nil {
-		log.Error(err, "Error updating Synapse Status")
-		return ctrl.Result{}, err
-	}
 
-	if synapse.Spec.CreateNewPostgreSQL {
-		if !r.isPostgresOperatorInstalled(ctx) {
-			reason := "Cannot create PostgreSQL instance for synapse. Postgres-operator is not installed."
-			if err := r.setFailedState(ctx, &synapse, reason); err != nil {
-				log.Error(err, "Error updating Synapse State")
-			}
+		if err := r.reconcileResource(
+			ctx,
+			r.serviceForWellKnown,
+			&synapse,
+			&corev1.Service{},
+			objectMetaWellKnown,
+		); err != nil {
+			return ctrl.Result{}, err
+		}
 
-			err := errors.New("cannot create PostgreSQL instance for synapse. Potsres-operator is not installed")
-			log.Error(err, "Cannot create PostgreSQL instance for synapse. Potsres-operator is not installed.")
-			return ctrl.Result{}, nil
+		if err := r.reconcileResource(
+			ctx,
+			r.ingressForWellKnown,
+			&synapse,
+			&networkingv1.Ingress{},
+			objectMetaWellKnown,
+		); err != nil {
+			return ctrl.Result{}, err
 		}
-		if result, err := r.createPostgresClusterForSynapse(ctx, synapse, createdConfigMap); err != nil {
-			return result, err
+	} else {
+		for _, resource := range []client.Object{
+			&networkingv1.Ingress{},
+			&corev1.Service{},
+			&appsv1.Deployment{},
+			&corev1.ConfigMap{},
+		} {
+			if err := r.deleteResource(ctx, &synapse, resource, objectMetaWellKnown); err != nil {
+				return ctrl.Result{}, err
+			}
 		}
 	}
 
-	// We first need to create the Synapse Service as its IP address is potentially
-	// needed by the Bridges
-	synapseKey := types.NamespacedName{Name: synapse.Name, Namespace: synapse.Namespace}
-	createdService := &corev1.Service{}
-	if err := r.reconcileResource(
-		ctx,
-		r.serviceForSynapse,
-		&synapse,
-		createdService,
-		objectMetaForSynapse,
-	); err != nil {
-		return ctrl.Result{}, err
-	}
-
-	// Fetch Synapse IP and update the resource status
-	synapseIP, err := r.getServiceIP(ctx, synapseKey, createdService)
-	if err != nil {
-		return ctrl.Result{}, err
-	}
-	synapse.Status.IP = synapseIP
-	if err := r.updateSynapseStatus(ctx, &synapse); err != nil {
-		return ctrl.Result{}, err
-	}
+	// The configuration files and Secrets mounted in the Synapse pods. The
+	// Synapse Deployment is restarted when any of them changes.
+	synapseConfigObjects := []client.Object{&createdConfigMap, &createdConfigSecret, &signingKeySecret}
 
 	if synapse.Spec.Bridges.Heisenbridge.Enabled {
 		log.Info("Heisenbridge is enabled - deploying Heisenbridge")
-		// Heisenbridge is composed of a ConfigMap, a Service and a Deployment.
+		// Heisenbridge is composed of a ConfigMap, a Secret, a Service and a
+		// Deployment.
 		// Resources associated to the Heisenbridge are append with "-heisenbridge"
 		createdHeisenbridgeService := &corev1.Service{}
 		objectMetaHeisenbridge := setObjectMeta(synapse.Name+"-heisenbridge", synapse.Namespace, map[string]string{})
-		heisenbergKey := types.NamespacedName{Name: synapse.Name + "-heisenbridge", Namespace: synapse.Namespace}
 
-		// First create the service as we need its IP address for the
-		// heisenbridge.yaml configuration file
+		// The Heisenbridge Service. Its DNS name is used by Synapse to reach
+		// the bridge.
 		if err := r.reconcileResource(
 			ctx,
 			r.serviceForHeisenbridge,
@@ -236,78 +959,38 @@ func (r *SynapseReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ct
 			return ctrl.Result{}, err
 		}
 
-		// Get Service IP and update the Synapse status
-		heisenbridgeIP, err := r.getServiceIP(ctx, heisenbergKey, createdHeisenbridgeService)
-		if err != nil {
-			return ctrl.Result{}, err
-		}
-		synapse.Status.BridgesConfiguration.Heisenbridge.IP = heisenbridgeIP
-		if err := r.updateSynapseStatus(ctx, &synapse); err != nil {
-			return ctrl.Result{}, err
+		// The Heisenbridge IP is only reported for information
+		synapse.Status.BridgesConfiguration.Heisenbridge.IP = createdHeisenbridgeService.Spec.ClusterIP
+
+		// Configure the correct URL in heisenbridge.yaml. The tokens are
+		// only written in the Heisenbridge Secret.
+		heisenbridgeUpdates := []updateDataFunc{
+			r.updateHeisenbridgeWithURL,
+			r.updateHeisenbridgeWithoutTokens,
 		}
 
 		// The ConfigMap for Heisenbridge, containing the heisenbridge.yaml
-		// config file. It's either a copy of a user-provided ConfigMap, if
-		// defined in Spec.Bridges.Heisenbridge.ConfigMap, or a new ConfigMap
-		// containing a default heisenbridge.yaml.
+		// config file without the tokens. It's either a copy of a
+		// user-provided ConfigMap, if defined in
+		// Spec.Bridges.Heisenbridge.ConfigMap, or a new ConfigMap containing
+		// a default heisenbridge.yaml.
 		createdHeisenbridgeConfigMap := &corev1.ConfigMap{}
 
 		// The user may specify a ConfigMap, containing the heisenbridge.yaml
-		// config file, under Spec.Bridges.Heisenbridge.ConfigMap
-		inputConfigMapName := synapse.Spec.Bridges.Heisenbridge.ConfigMap.Name
-		setConfigMapNamespace := synapse.Spec.Bridges.Heisenbridge.ConfigMap.Namespace
-		inputConfigMapNamespace := r.getConfigMapNamespace(synapse, setConfigMapNamespace)
-		if inputConfigMapName != "" {
-			// If the user provided a custom Heisenbridge configuration via a
-			// ConfigMap, we need to validate that the ConfigMap exists, and
-			// create a copy in createdHeisenbridgeConfigMap
-
-			// Get and check the input ConfigMap for Heisenbridge
-			var inputHeisenbridgeConfigMap = &corev1.ConfigMap{}
-			keyForConfigMap := types.NamespacedName{
-				Name:      inputConfigMapName,
-				Namespace: inputConfigMapNamespace,
-			}
-
-			if err := r.Get(ctx, keyForConfigMap, inputHeisenbridgeConfigMap); err != nil {
-				reason := "ConfigMap " + inputConfigMapName + " does not exist in namespace " + inputConfigMapNamespace
-				if err := r.setFailedState(ctx, &synapse, reason); err != nil {
-					log.Error(err, "Error updating Synapse State")
-				}
-
-				log.Error(
-					err,
-					"Failed to get ConfigMap",
-					"ConfigMap.Namespace",
-					inputConfigMapNamespace,
-					"ConfigMap.Name",
-					inputConfigMapName,
-				)
-				return ctrl.Result{RequeueAfter: time.Duration(30)}, err
-			}
-
-			// Create a copy of the inputHeisenbridgeConfigMap defined in Spec.Bridges.Heisenbridge.ConfigMap
+		// config file, under Spec.Bridges.Heisenbridge.ConfigMap. Its
+		// existence has already been validated.
+		if synapse.Spec.Bridges.Heisenbridge.ConfigMap.Name != "" {
+			// Create a copy of the ConfigMap defined in Spec.Bridges.Heisenbridge.ConfigMap
 			// Here we use the configMapForHeisenbridgeCopy function as createResourceFunc
 			if err := r.reconcileResource(
 				ctx,
-				r.configMapForHeisenbridgeCopy,
+				r.withConfigMapUpdates(r.configMapForHeisenbridgeCopy, "heisenbridge.yaml", heisenbridgeUpdates...),
 				&synapse,
 				createdHeisenbridgeConfigMap,
 				objectMetaHeisenbridge,
 			); err != nil {
 				return ctrl.Result{}, err
 			}
-
-			// Configure correct URL in Heisenbridge ConfigMap
-			if err := r.updateConfigMap(
-				ctx,
-				createdHeisenbridgeConfigMap,
-				synapse,
-				r.updateHeisenbridgeWithURL,
-				"heisenbridge.yaml",
-			); err != nil {
-				return ctrl.Result{}, err
-			}
 		} else {
 			// If the user hasn't provided a ConfigMap with a custom
 			// config.yaml, we create a new ConfigMap with a default
@@ -316,36 +999,51 @@ func (r *SynapseReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ct
 			// Here we use configMapForHeisenbridge as createResourceFunc
 			if err := r.reconcileResource(
 				ctx,
-				r.configMapForHeisenbridge,
+				r.withConfigMapUpdates(r.configMapForHeisenbridge, "heisenbridge.yaml", heisenbridgeUpdates...),
 				&synapse,
-				&corev1.ConfigMap{},
+				createdHeisenbridgeConfigMap,
 				objectMetaHeisenbridge,
 			); err != nil {
 				return ctrl.Result{}, err
 			}
 		}
 
-		// Create Deployment for Heisenbridge
+		// The Secret for Heisenbridge, containing the as_token and hs_token
+		// used by Synapse and Heisenbridge to authenticate each other, and
+		// the heisenbridge.yaml mounted in the Synapse and Heisenbridge pods.
+		createdHeisenbridgeSecret := &corev1.Secret{}
 		if err := r.reconcileResource(
 			ctx,
-			r.deploymentForHeisenbridge,
+			r.secretForHeisenbridge(ctx, *createdHeisenbridgeConfigMap),
 			&synapse,
-			&appsv1.Deployment{},
+			createdHeisenbridgeSecret,
 			objectMetaHeisenbridge,
 		); err != nil {
 			return ctrl.Result{}, err
 		}
 
-		// Update the Synapse ConfigMap to enable Heisenbridge
-		if err := r.updateConfigMap(
+		// Create Deployment for Heisenbridge. Its pods are restarted when
+		// heisenbridge.yaml changes.
+		createdHeisenbridgeDeployment := &appsv1.Deployment{}
+		if err := r.reconcileResource(
 			ctx,
-			&createdConfigMap,
-			synapse,
-			r.updateHomeserverWithHeisenbridgeInfos,
-			"homeserver.yaml",
+			r.withConfigChecksum(
+				r.withPodTemplate(r.deploymentForHeisenbridge, synapse.Spec.Bridges.Heisenbridge.PodTemplate),
+				createdHeisenbridgeSecret,
+			),
+			&synapse,
+			createdHeisenbridgeDeployment,
+			objectMetaHeisenbridge,
 		); err != nil {
 			return ctrl.Result{}, err
 		}
+
+		r.setDeploymentCondition(&synapse, synapsev1alpha1.ConditionTypeHeisenbridgeReady, *createdHeisenbridgeDeployment)
+
+		// heisenbridge.yaml is also mounted in the Synapse pods
+		synapseConfigObjects = append(synapseConfigObjects, createdHeisenbridgeSecret)
+	} else {
+		meta.RemoveStatusCondition(&synapse.Status.Conditions, synapsev1alpha1.ConditionTypeHeisenbridgeReady)
 	}
 
 	// Reconcile Synapse resources: PVC, Deployment and Service
@@ -379,25 +1077,156 @@ func (r *SynapseReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ct
 		return ctrl.Result{}, err
 	}
 
+	createdDeployment := &appsv1.Deployment{}
 	if err := r.reconcileResource(
 		ctx,
-		r.deploymentForSynapse,
+		r.withConfigChecksum(r.withPodTemplate(r.deploymentForSynapse, synapse.Spec.PodTemplate), synapseConfigObjects...),
 		&synapse,
-		&appsv1.Deployment{},
+		createdDeployment,
 		objectMetaForSynapse,
 	); err != nil {
 		return ctrl.Result{}, err
 	}
-	// TODO: If a deployment is found, check that its Spec are correct.
 
-	// Update the Synapse status if needed
-	if synapse.Status.State != "RUNNING" {
-		synapse.Status.State = "RUNNING"
-		synapse.Status.Reason = ""
-		if err := r.Status().Update(ctx, &synapse); err != nil {
-			log.Error(err, "Failed to update Synapse status")
+	r.setDeploymentCondition(&synapse, synapsev1alpha1.ConditionTypeDeploymentAvailable, *createdDeployment)
+
+	// The workers of Spec.Workers. Each pool of workers is composed of a
+	// ConfigMap, a Deployment, a Service and, if autoscaled, a
+	// HorizontalPodAutoscaler, appended with "-worker-<pool name>". The
+	// autoscaled federation_sender pools use a StatefulSet instead of a
+	// Deployment.
+	federationSenderInstances, err := r.federationSenderInstances(ctx, synapse)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	for _, pool := range synapse.Spec.Workers {
+		objectMetaWorker := setObjectMeta(
+			workerPoolResourceName(synapse, pool),
+			synapse.Namespace,
+			labelsForWorkerPool(synapse.Name, pool.Name),
+		)
+
+		createdWorkerConfigMap := &corev1.ConfigMap{}
+		if err := r.reconcileResource(
+			ctx,
+			r.configMapForWorkerPool(pool, federationSenderInstances),
+			&synapse,
+			createdWorkerConfigMap,
+			objectMetaWorker,
+		); err != nil {
+			return ctrl.Result{}, err
+		}
+
+		// The workers are restarted when the configuration they share
+		// with the main Synapse process changes
+		workerConfigObjects := append([]client.Object{createdWorkerConfigMap}, synapseConfigObjects...)
+		createWorkers := r.withConfigChecksum(r.deploymentForWorkerPool(pool), workerConfigObjects...)
+		var workers, staleWorkers client.Object = &appsv1.Deployment{}, &appsv1.StatefulSet{}
+		if isWorkerPoolStatefulSet(pool) {
+			createWorkers = r.asStatefulSet(createWorkers)
+			workers, staleWorkers = &appsv1.StatefulSet{}, &appsv1.Deployment{}
+		}
+		if pool.Autoscaling != nil {
+			if err := r.handOverWorkerPoolReplicas(ctx, workers, objectMetaWorker); err != nil {
+				return ctrl.Result{}, err
+			}
+		}
+		if err := r.reconcileResource(
+			ctx,
+			createWorkers,
+			&synapse,
+			workers,
+			objectMetaWorker,
+		); err != nil {
+			return ctrl.Result{}, err
+		}
+		if err := r.deleteResource(ctx, &synapse, staleWorkers, objectMetaWorker); err != nil {
+			return ctrl.Result{}, err
+		}
+
+		if err := r.reconcileResource(
+			ctx,
+			r.serviceForWorkerPool(pool),
+			&synapse,
+			&corev1.Service{},
+			objectMetaWorker,
+		); err != nil {
+			return ctrl.Result{}, err
+		}
+
+		if pool.Autoscaling != nil {
+			if err := r.reconcileResource(
+				ctx,
+				r.horizontalPodAutoscalerForWorkerPool(pool),
+				&synapse,
+				&autoscalingv2.HorizontalPodAutoscaler{},
+				objectMetaWorker,
+			); err != nil {
+				return ctrl.Result{}, err
+			}
+		} else {
+			if err := r.deleteResource(ctx, &synapse, &autoscalingv2.HorizontalPodAutoscaler{}, objectMetaWorker); err != nil {
+				return ctrl.Result{}, err
+			}
+		}
+	}
+
+	// Pools removed from Spec.Workers
+	if err := r.deleteStaleWorkerPools(ctx, &synapse); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	// The reverse proxy routing the requests to the workers, selected by
+	// the Services exposing Synapse in worker mode. It's composed of a
+	// ConfigMap and a Deployment, appended with "-proxy". Its configuration
+	// references the worker Services, which must exist when it starts.
+	objectMetaProxy := setObjectMeta(proxyResourceName(synapse), synapse.Namespace, map[string]string{})
+	if isProxyDeployed(synapse) {
+		createdProxyConfigMap := &corev1.ConfigMap{}
+		if err := r.reconcileResource(
+			ctx,
+			r.configMapForProxy,
+			&synapse,
+			createdProxyConfigMap,
+			objectMetaProxy,
+		); err != nil {
+			return ctrl.Result{}, err
+		}
+
+		createdProxyDeployment := &appsv1.Deployment{}
+		if err := r.reconcileResource(
+			ctx,
+			r.withConfigChecksum(r.deploymentForProxy, createdProxyConfigMap),
+			&synapse,
+			createdProxyDeployment,
+			objectMetaProxy,
+		); err != nil {
 			return ctrl.Result{}, err
 		}
+
+		r.setDeploymentCondition(&synapse, synapsev1alpha1.ConditionTypeProxyReady, *createdProxyDeployment)
+	} else {
+		for _, resource := range []client.Object{&appsv1.Deployment{}, &corev1.ConfigMap{}} {
+			if err := r.deleteResource(ctx, &synapse, resource, objectMetaProxy); err != nil {
+				return ctrl.Result{}, err
+			}
+		}
+		meta.RemoveStatusCondition(&synapse.Status.Conditions, synapsev1alpha1.ConditionTypeProxyReady)
+	}
+
+	// Update the Synapse status
+	r.setReadyCondition(&synapse)
+	synapse.Status.State = "RUNNING"
+	synapse.Status.Reason = ""
+	synapse.Status.ObservedGeneration = synapse.Generation
+	if err := r.updateSynapseStatus(ctx, &synapse); err != nil {
+		log.Error(err, "Failed to update Synapse status")
+		return ctrl.Result{}, err
+	}
+
+	// The external Redis instance isn't watched, and is checked again later
+	if len(synapse.Spec.Workers) != 0 && !isRedisDeployed(synapse) {
+		return ctrl.Result{RequeueAfter: redisProbeInterval}, nil
 	}
 	return ctrl.Result{}, nil
 }
@@ -421,14 +1250,108 @@ func (r *SynapseReconciler) getConfigMapNamespace(
 	return synapse.Namespace
 }
 
-func (r *SynapseReconciler) setFailedState(ctx context.Context, synapse *synapsev1alpha1.Synapse, reason string) error {
+// setFailedState sets the Synapse State to FAILED, and reports the failure in
+// the given condition type and in the Ready condition. The reason is a
+// machine-readable CamelCase string, while the message is human readable.
+func (r *SynapseReconciler) setFailedState(
+	ctx context.Context,
+	synapse *synapsev1alpha1.Synapse,
+	conditionType string,
+	reason string,
+	message string,
+) error {
 
 	synapse.Status.State = "FAILED"
-	synapse.Status.Reason = reason
+	synapse.Status.Reason = message
+
+	r.setStatusCondition(synapse, conditionType, metav1.ConditionFalse, reason, message)
+	r.setStatusCondition(synapse, synapsev1alpha1.ConditionTypeReady, metav1.ConditionFalse, reason, message)
 
 	return r.updateSynapseStatus(ctx, synapse)
 }
 
+// setStatusCondition sets a condition in the Synapse Status. The status
+// is not persisted, see updateSynapseStatus.
+func (r *SynapseReconciler) setStatusCondition(
+	synapse *synapsev1alpha1.Synapse,
+	conditionType string,
+	status metav1.ConditionStatus,
+	reason string,
+	message string,
+) {
+	meta.SetStatusCondition(&synapse.Status.Conditions, metav1.Condition{
+		Type:               conditionType,
+		Status:             status,
+		ObservedGeneration: synapse.Generation,
+		Reason:             reason,
+		Message:            message,
+	})
+}
+
+// setDeploymentCondition sets the given condition type according to the
+// availability of a Deployment.
+func (r *SynapseReconciler) setDeploymentCondition(
+	synapse *synapsev1alpha1.Synapse,
+	conditionType string,
+	deployment appsv1.Deployment,
+) {
+	for _, condition := range deployment.Status.Conditions {
+		if condition.Type == appsv1.DeploymentAvailable && condition.Status == corev1.ConditionTrue {
+			r.setStatusCondition(
+				synapse,
+				conditionType,
+				metav1.ConditionTrue,
+				reasonDeploymentAvailable,
+				"Deployment "+deployment.Name+" is available",
+			)
+			return
+		}
+	}
+
+	r.setStatusCondition(
+		synapse,
+		conditionType,
+		metav1.ConditionFalse,
+		reasonDeploymentNotAvailable,
+		"Deployment "+deployment.Name+" is not available yet",
+	)
+}
+
+// setReadyCondition sets the Ready condition to true if all other
+// conditions are true. Otherwise, the Ready condition reports the first
+// condition which is not true.
+func (r *SynapseReconciler) setReadyCondition(synapse *synapsev1alpha1.Synapse) {
+	for _, conditionType := range []string{
+		synapsev1alpha1.ConditionTypeConfigurationValid,
+		synapsev1alpha1.ConditionTypeDatabaseReady,
+		synapsev1alpha1.ConditionTypeHeisenbridgeReady,
+		synapsev1alpha1.ConditionTypeDeploymentAvailable,
+		synapsev1alpha1.ConditionTypeRouteAdmitted,
+		synapsev1alpha1.ConditionTypeRedisReady,
+		synapsev1alpha1.ConditionTypeProxyReady,
+	} {
+		condition := meta.FindStatusCondition(synapse.Status.Conditions, conditionType)
+		if condition != nil && condition.Status != metav1.ConditionTrue {
+			r.setStatusCondition(
+				synapse,
+				synapsev1alpha1.ConditionTypeReady,
+				metav1.ConditionFalse,
+				condition.Reason,
+				condition.Message,
+			)
+			return
+		}
+	}
+
+	r.setStatusCondition(
+		synapse,
+		synapsev1alpha1.ConditionTypeReady,
+		metav1.ConditionTrue,
+		reasonReconciled,
+		"Synapse is ready",
+	)
+}
+
 func (r *SynapseReconciler) updateSynapseStatus(ctx context.Context, synapse *synapsev1alpha1.Synapse) error {
 	current := &synapsev1alpha1.Synapse{}
 	if err := r.Get(
@@ -455,20 +1378,19 @@ func (r *SynapseReconciler) isPostgresOperatorInstalled(ctx context.Context) boo
 
 func (r *SynapseReconciler) createPostgresClusterForSynapse(
 	ctx context.Context,
-	synapse synapsev1alpha1.Synapse,
-	cm corev1.ConfigMap,
+	synapse *synapsev1alpha1.Synapse,
 ) (ctrl.Result, error) {
 	var objectMeta metav1.ObjectMeta
 	createdPostgresCluster := pgov1beta1.PostgresCluster{}
 
 	// Create ConfigMap for PostgresCluster
 	objectMeta = setObjectMeta(synapse.Name+"-pgsql", synapse.Namespace, map[string]string{})
-	if err := r.reconcileResource(ctx, r.configMapForPostgresCluster, &synapse, &corev1.ConfigMap{}, objectMeta); err != nil {
+	if err := r.reconcileResource(ctx, r.configMapForPostgresCluster, synapse, &corev1.ConfigMap{}, objectMeta); err != nil {
 		return ctrl.Result{}, err
 	}
 
 	// Create PostgresCluster for Synapse
-	if err := r.reconcileResource(ctx, r.postgresClusterForSynapse, &synapse, &createdPostgresCluster, objectMeta); err != nil {
+	if err := r.reconcileResource(ctx, r.postgresClusterForSynapse, synapse, &createdPostgresCluster, objectMeta); err != nil {
 		return ctrl.Result{}, err
 	}
 
@@ -477,24 +1399,23 @@ func (r *SynapseReconciler) createPostgresClusterForSynapse(
 		return ctrl.Result{}, err
 	}
 	if !r.isPostgresClusterReady(createdPostgresCluster) {
-		r.updateSynapseStatusDatabaseState(ctx, &synapse, "NOT READY")
+		message := "PostgresCluster " + createdPostgresCluster.Name + " is not ready yet"
+		r.setStatusCondition(synapse, synapsev1alpha1.ConditionTypeDatabaseReady, metav1.ConditionFalse, reasonDatabaseNotReady, message)
+		r.setStatusCondition(synapse, synapsev1alpha1.ConditionTypeReady, metav1.ConditionFalse, reasonDatabaseNotReady, message)
+		r.updateSynapseStatusDatabaseState(ctx, synapse, "NOT READY")
 		err := errors.New("postgreSQL Database not ready yet")
 		return ctrl.Result{RequeueAfter: time.Duration(5)}, err
 	}
 
 	// Update Synapse Status with PostgreSQL DB information
-	if err := r.updateSynapseStatusWithPostgreSQLInfos(ctx, &synapse, createdPostgresCluster); err != nil {
-		return ctrl.Result{}, err
-	}
-
-	// Update configMap data with PostgreSQL DB information
-	if err := r.updateConfigMap(
-		ctx,
-		&cm,
+	r.setStatusCondition(
 		synapse,
-		r.updateHomeserverWithPostgreSQLInfos,
-		"homeserver.yaml",
-	); err != nil {
+		synapsev1alpha1.ConditionTypeDatabaseReady,
+		metav1.ConditionTrue,
+		reasonDatabaseReady,
+		"PostgresCluster "+createdPostgresCluster.Name+" is ready",
+	)
+	if err := r.updateSynapseStatusWithPostgreSQLInfos(ctx, synapse, createdPostgresCluster); err != nil {
 		return ctrl.Result{}, err
 	}
 
@@ -598,8 +1519,7 @@ func (r *SynapseReconciler) updateSynapseStatusDatabase(
 		return err
 	}
 
-	password, ok := postgresSecretData["password"]
-	if !ok {
+	if _, ok := postgresSecretData["password"]; !ok {
 		err := errors.New("missing password in PostgreSQL Secret")
 		// log.Error(err, "Missing password in PostgreSQL Secret")
 		return err
@@ -609,36 +1529,220 @@ func (r *SynapseReconciler) updateSynapseStatusDatabase(
 	// s.Status.DatabaseConnectionInfo.DatabaseName = string(databaseName) // See https://github.com/opdev/synapse-operator/issues/12
 	s.Status.DatabaseConnectionInfo.DatabaseName = "synapse"
 	s.Status.DatabaseConnectionInfo.User = string(user)
-	s.Status.DatabaseConnectionInfo.Password = string(base64encode(string(password)))
+	s.Status.DatabaseConnectionInfo.PasswordSecretKeyRef = synapsev1alpha1.SynapseStatusSecretKeyRef{
+		Name: postgresSecret.Name,
+		Key:  "password",
+	}
+	s.Status.DatabaseConnectionInfo.Password = ""
 	s.Status.DatabaseConnectionInfo.State = "READY"
 
 	return nil
 }
 
-func (r *SynapseReconciler) getServiceIP(
-	ctx context.Context,
-	synapseKey types.NamespacedName,
-	service *corev1.Service,
-) (string, error) {
-	if err := r.Get(ctx, synapseKey, service); err != nil {
-		return "", err
+// migrateSynapseStatusDatabasePassword removes the deprecated
+// DatabaseConnectionInfo.Password from the Synapse Status, and replaces it
+// with a reference to the Secret created by the postgres-operator for the
+// synapse user.
+func (r *SynapseReconciler) migrateSynapseStatusDatabasePassword(ctx context.Context, s *synapsev1alpha1.Synapse) error {
+	if s.Status.DatabaseConnectionInfo.Password == "" {
+		return nil
+	}
+
+	if s.Status.DatabaseConnectionInfo.PasswordSecretKeyRef.Name == "" {
+		s.Status.DatabaseConnectionInfo.PasswordSecretKeyRef = synapsev1alpha1.SynapseStatusSecretKeyRef{
+			Name: s.Name + "-pgsql-pguser-synapse",
+			Key:  "password",
+		}
+	}
+	s.Status.DatabaseConnectionInfo.Password = ""
+
+	return r.updateSynapseStatus(ctx, s)
+}
+
+// serviceHost returns the cluster DNS name of a Service of the Synapse
+// namespace. It's used rather than the ClusterIP of the Service, which
+// changes if the Service is recreated.
+func (r *SynapseReconciler) serviceHost(s synapsev1alpha1.Synapse, serviceName string) string {
+	clusterDomain := r.ClusterDomain
+	if clusterDomain == "" {
+		clusterDomain = "cluster.local"
+	}
+	return serviceName + "." + s.Namespace + ".svc." + clusterDomain
+}
+
+// serviceURL returns the URL of a Service of the Synapse namespace, on the
+// given port.
+func (r *SynapseReconciler) serviceURL(s synapsev1alpha1.Synapse, serviceName string, port int) string {
+	return "http://" + r.serviceHost(s, serviceName) + ":" + strconv.Itoa(port)
+}
+
+// inputConfigMapsIndex is the name of the field index listing, for each
+// Synapse instance, the user-provided ConfigMaps it references, in the
+// "namespace/name" format.
+const inputConfigMapsIndex = "synapse.opdev.io/input-configmaps"
+
+// indexInputConfigMaps is the indexer function of the inputConfigMapsIndex.
+func (r *SynapseReconciler) indexInputConfigMaps(obj client.Object) []string {
+	synapse, ok := obj.(*synapsev1alpha1.Synapse)
+	if !ok {
+		return nil
+	}
+
+	var inputConfigMaps []string
+	for _, key := range r.inputConfigMapsForSynapse(*synapse) {
+		inputConfigMaps = append(inputConfigMaps, key.String())
+	}
+	return inputConfigMaps
+}
+
+// inputSecretsIndex is the name of the field index listing, for each
+// Synapse instance, the user-provided Secrets it references, in the
+// "namespace/name" format.
+const inputSecretsIndex = "synapse.opdev.io/input-secrets"
+
+// indexInputSecrets is the indexer function of the inputSecretsIndex.
+func (r *SynapseReconciler) indexInputSecrets(obj client.Object) []string {
+	synapse, ok := obj.(*synapsev1alpha1.Synapse)
+	if !ok {
+		return nil
+	}
+
+	var inputSecrets []string
+	for _, key := range r.inputSecretsForSynapse(*synapse) {
+		inputSecrets = append(inputSecrets, key.String())
+	}
+	return inputSecrets
+}
+
+// databaseSecretIndex is the name of the field index listing, for each
+// Synapse instance, the Secret holding the password of its database user,
+// as referenced in Status.DatabaseConnectionInfo, in the "namespace/name"
+// format.
+const databaseSecretIndex = "synapse.opdev.io/database-secret"
+
+// indexDatabaseSecret is the indexer function of the databaseSecretIndex.
+// The Secret is owned by the PostgresCluster, and its password may be
+// rotated by the postgres-operator.
+func (r *SynapseReconciler) indexDatabaseSecret(obj client.Object) []string {
+	synapse, ok := obj.(*synapsev1alpha1.Synapse)
+	if !ok {
+		return nil
+	}
+
+	secretName := synapse.Status.DatabaseConnectionInfo.PasswordSecretKeyRef.Name
+	if secretName == "" {
+		return nil
 	}
+	return []string{types.NamespacedName{Name: secretName, Namespace: synapse.Namespace}.String()}
+}
+
+// synapsesForConfigMap returns a reconcile request for each Synapse instance
+// referencing the given ConfigMap as a user-provided ConfigMap. ConfigMaps
+// may be referenced by Synapse instances living in other namespaces.
+func (r *SynapseReconciler) synapsesForConfigMap(obj client.Object) []reconcile.Request {
+	return r.synapsesForInput(inputConfigMapsIndex, "ConfigMap", obj)
+}
 
-	serviceIP := service.Spec.ClusterIP
-	if serviceIP == "" {
-		err := errors.New("service IP not set")
-		return "", err
+// synapsesForSecret returns a reconcile request for each Synapse instance
+// referencing the given Secret as a user-provided Secret.
+func (r *SynapseReconciler) synapsesForSecret(obj client.Object) []reconcile.Request {
+	return r.synapsesForInput(inputSecretsIndex, "Secret", obj)
+}
+
+// synapsesForDatabaseSecret returns a reconcile request for each Synapse
+// instance reading the password of its database user from the given Secret.
+func (r *SynapseReconciler) synapsesForDatabaseSecret(obj client.Object) []reconcile.Request {
+	return r.synapsesForInput(databaseSecretIndex, "Secret", obj)
+}
+
+// synapsesForInput returns a reconcile request for each Synapse instance
+// listing the given object in the given field index.
+func (r *SynapseReconciler) synapsesForInput(index string, kind string, obj client.Object) []reconcile.Request {
+	log := ctrllog.Log.WithName("synapse-" + strings.ToLower(kind) + "-watch")
+
+	var synapses synapsev1alpha1.SynapseList
+	if err := r.List(
+		context.TODO(),
+		&synapses,
+		client.MatchingFields{index: client.ObjectKeyFromObject(obj).String()},
+	); err != nil {
+		log.Error(
+			err,
+			"Error listing Synapse instances referencing "+kind,
+			kind+".Name", obj.GetName(),
+			kind+".Namespace", obj.GetNamespace(),
+		)
+		return nil
 	}
 
-	return serviceIP, nil
+	requests := make([]reconcile.Request, 0, len(synapses.Items))
+	for _, synapse := range synapses.Items {
+		requests = append(requests, reconcile.Request{
+			NamespacedName: types.NamespacedName{Name: synapse.Name, Namespace: synapse.Namespace},
+		})
+	}
+	return requests
 }
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *SynapseReconciler) SetupWithManager(mgr ctrl.Manager) error {
-	return ctrl.NewControllerManagedBy(mgr).
+	if err := mgr.GetFieldIndexer().IndexField(
+		context.Background(),
+		&synapsev1alpha1.Synapse{},
+		inputConfigMapsIndex,
+		r.indexInputConfigMaps,
+	); err != nil {
+		return err
+	}
+	if err := mgr.GetFieldIndexer().IndexField(
+		context.Background(),
+		&synapsev1alpha1.Synapse{},
+		inputSecretsIndex,
+		r.indexInputSecrets,
+	); err != nil {
+		return err
+	}
+	if err := mgr.GetFieldIndexer().IndexField(
+		context.Background(),
+		&synapsev1alpha1.Synapse{},
+		databaseSecretIndex,
+		r.indexDatabaseSecret,
+	); err != nil {
+		return err
+	}
+
+	builder := ctrl.NewControllerManagedBy(mgr).
 		For(&synapsev1alpha1.Synapse{}).
+		Owns(&corev1.ConfigMap{}).
+		Owns(&corev1.Secret{}).
 		Owns(&corev1.Service{}).
+		Owns(&corev1.ServiceAccount{}).
+		Owns(&rbacv1.RoleBinding{}).
 		Owns(&appsv1.Deployment{}).
+		Owns(&appsv1.StatefulSet{}).
+		Owns(&autoscalingv2.HorizontalPodAutoscaler{}).
 		Owns(&corev1.PersistentVolumeClaim{}).
-		Complete(r)
+		Owns(&networkingv1.Ingress{}).
+		Watches(
+			&source.Kind{Type: &corev1.ConfigMap{}},
+			handler.EnqueueRequestsFromMapFunc(r.synapsesForConfigMap),
+		).
+		Watches(
+			&source.Kind{Type: &corev1.Secret{}},
+			handler.EnqueueRequestsFromMapFunc(r.synapsesForSecret),
+		).
+		Watches(
+			&source.Kind{Type: &corev1.Secret{}},
+			handler.EnqueueRequestsFromMapFunc(r.synapsesForDatabaseSecret),
+		)
+
+	// Routes can only be watched on clusters serving the route.openshift.io
+	// API
+	if isRouteAPIAvailable(mgr.GetRESTMapper()) {
+		route := &unstructured.Unstructured{}
+		route.SetGroupVersionKind(routeGVK)
+		builder = builder.Owns(route)
+	}
+
+	return builder.Complete(r)
 }
@@ -16,6 +16,7 @@ import (
 	corev1 "k8s.io/api/core/v1"
 	rbacv1 "k8s.io/api/rbac/v1"
 	v1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/types"
@@ -92,8 +93,9 @@ var _ = Describe("Integration tests for the Synapse controller", Ordered, Label(
 		Expect(err).ToNot(HaveOccurred())
 
 		err = (&SynapseReconciler{
-			Client: k8sManager.GetClient(),
-			Scheme: k8sManager.GetScheme(),
+			Client:   k8sManager.GetClient(),
+			Scheme:   k8sManager.GetScheme(),
+			Recorder: k8sManager.GetEventRecorderFor("synapse-controller"),
 		}).SetupWithManager(k8sManager)
 		Expect(err).ToNot(HaveOccurred())
 
@@ -105,7 +107,7 @@ var _ = Describe("Integration tests for the Synapse controller", Ordered, Label(
 
 	// Verify the absence of Synapse sub-resources
 	// This function common to multiple tests
-	var checkSubresourceAbsence = func(expectedReason string) {
+	var checkSubresourceAbsence = func(expectedReason string, expectedConditionType string, expectedConditionReason string) {
 		s := &synapsev1alpha1.Synapse{}
 		synapseLookupKey := types.NamespacedName{Name: SynapseName, Namespace: SynapseNamespace}
 		expectedState := "FAILED"
@@ -122,6 +124,14 @@ var _ = Describe("Integration tests for the Synapse controller", Ordered, Label(
 			g.Expect(k8sClient.Get(ctx, synapseLookupKey, s)).Should(Succeed())
 			g.Expect(s.Status.State).To(Equal(expectedState))
 			g.Expect(s.Status.Reason).To(Equal(expectedReason))
+
+			for _, conditionType := range []string{expectedConditionType, synapsev1alpha1.ConditionTypeReady} {
+				condition := meta.FindStatusCondition(s.Status.Conditions, conditionType)
+				g.Expect(condition).ShouldNot(BeNil())
+				g.Expect(condition.Status).To(Equal(metav1.ConditionFalse))
+				g.Expect(condition.Reason).To(Equal(expectedConditionReason))
+				g.Expect(condition.Message).To(Equal(expectedReason))
+			}
 		}, timeout, interval).Should(Succeed())
 
 		By("Checking that synapse sub-resources have not been created")
@@ -430,7 +440,12 @@ var _ = Describe("Integration tests for the Synapse controller", Ordered, Label(
 			var createdService *corev1.Service
 			var createdServiceAccount *corev1.ServiceAccount
 			var createdRoleBinding *rbacv1.RoleBinding
+			var createdSecret *corev1.Secret
+			var createdConfigSecret *corev1.Secret
+			var createdSigningKeySecret *corev1.Secret
 			var synapseLookupKey types.NamespacedName
+			var secretLookupKey types.NamespacedName
+			var signingKeySecretLookupKey types.NamespacedName
 			var expectedOwnerReference metav1.OwnerReference
 			var synapseSpec synapsev1alpha1.SynapseSpec
 
@@ -443,6 +458,11 @@ var _ = Describe("Integration tests for the Synapse controller", Ordered, Label(
 				createdService = &corev1.Service{}
 				createdServiceAccount = &corev1.ServiceAccount{}
 				createdRoleBinding = &rbacv1.RoleBinding{}
+				createdSecret = &corev1.Secret{}
+				createdConfigSecret = &corev1.Secret{}
+				createdSigningKeySecret = &corev1.Secret{}
+				secretLookupKey = types.NamespacedName{Name: SynapseName + "-secrets", Namespace: SynapseNamespace}
+				signingKeySecretLookupKey = types.NamespacedName{Name: SynapseName + "-signing-key", Namespace: SynapseNamespace}
 				// The OwnerReference UID must be set after the Synapse instance has been
 				// created. See the JustBeforeEach node.
 				expectedOwnerReference = metav1.OwnerReference{
@@ -476,7 +496,9 @@ var _ = Describe("Integration tests for the Synapse controller", Ordered, Label(
 
 			var cleanupSynapseResources = func() {
 				By("Cleaning up Synapse CR")
-				Expect(k8sClient.Delete(ctx, synapse)).Should(Succeed())
+				// The Synapse Operator removes its finalizer before the
+				// Synapse is actually deleted
+				deleteResource(synapse, synapseLookupKey, false)
 
 				// Child resources must be manually deleted as the controllers responsible of
 				// their lifecycle are not running.
@@ -497,6 +519,11 @@ var _ = Describe("Integration tests for the Synapse controller", Ordered, Label(
 
 				By("Cleaning up Synapse ServiceAccount")
 				deleteResource(createdServiceAccount, synapseLookupKey, false)
+
+				By("Cleaning up Synapse Secrets")
+				deleteResource(createdSecret, secretLookupKey, false)
+				deleteResource(createdConfigSecret, synapseLookupKey, false)
+				deleteResource(createdSigningKeySecret, signingKeySecretLookupKey, false)
 			}
 
 			When("Specifying the Synapse configuration via Values", func() {
@@ -543,7 +570,13 @@ var _ = Describe("Integration tests for the Synapse controller", Ordered, Label(
 					// Status may need some time to be updated
 					Eventually(func() synapsev1alpha1.SynapseStatus {
 						_ = k8sClient.Get(ctx, synapseLookupKey, synapse)
-						return synapse.Status
+						// Conditions are checked separately
+						status := synapse.Status
+						status.Conditions = nil
+						status.ObservedGeneration = 0
+						// The signing key ID is random
+						status.HomeserverConfiguration.SigningKeyID = ""
+						return status
 					}, timeout, interval).Should(Equal(expectedStatus))
 				})
 
@@ -574,6 +607,62 @@ var _ = Describe("Integration tests for the Synapse controller", Ordered, Label(
 					checkResourcePresence(createdService, synapseLookupKey, expectedOwnerReference)
 				})
 
+				It("Should create a Secret holding the signing key", func() {
+					checkResourcePresence(createdSigningKeySecret, signingKeySecretLookupKey, expectedOwnerReference)
+
+					Eventually(func(g Gomega) {
+						g.Expect(k8sClient.Get(ctx, synapseLookupKey, synapse)).Should(Succeed())
+						g.Expect(k8sClient.Get(ctx, signingKeySecretLookupKey, createdSigningKeySecret)).Should(Succeed())
+
+						keyID, _, err := parseSigningKey(string(createdSigningKeySecret.Data["signing.key"]))
+						g.Expect(err).ShouldNot(HaveOccurred())
+						g.Expect(synapse.Status.HomeserverConfiguration.SigningKeyID).Should(Equal(keyID))
+					}, timeout, interval).Should(Succeed())
+				})
+
+				It("Should report the Synapse conditions in the Status", func() {
+					Eventually(func(g Gomega) {
+						g.Expect(k8sClient.Get(ctx, synapseLookupKey, synapse)).Should(Succeed())
+						g.Expect(synapse.Status.ObservedGeneration).Should(Equal(synapse.Generation))
+						g.Expect(meta.IsStatusConditionTrue(
+							synapse.Status.Conditions,
+							synapsev1alpha1.ConditionTypeConfigurationValid,
+						)).Should(BeTrue())
+
+						// There is no controller managing Pods in envtest, the
+						// Synapse Deployment is therefore never available.
+						condition := meta.FindStatusCondition(
+							synapse.Status.Conditions,
+							synapsev1alpha1.ConditionTypeDeploymentAvailable,
+						)
+						g.Expect(condition).ShouldNot(BeNil())
+						g.Expect(condition.Status).Should(Equal(metav1.ConditionFalse))
+						g.Expect(condition.Reason).Should(Equal("DeploymentNotAvailable"))
+						g.Expect(meta.IsStatusConditionFalse(
+							synapse.Status.Conditions,
+							synapsev1alpha1.ConditionTypeReady,
+						)).Should(BeTrue())
+					}, timeout, interval).Should(Succeed())
+				})
+
+				It("Should revert manual changes to the Synapse Deployment", func() {
+					var expectedImage string
+
+					By("Modifying the image of the Synapse container")
+					Eventually(func(g Gomega) {
+						g.Expect(k8sClient.Get(ctx, synapseLookupKey, createdDeployment)).Should(Succeed())
+						expectedImage = createdDeployment.Spec.Template.Spec.Containers[0].Image
+						createdDeployment.Spec.Template.Spec.Containers[0].Image = "example.com/not-synapse:latest"
+						g.Expect(k8sClient.Update(ctx, createdDeployment)).Should(Succeed())
+					}, timeout, interval).Should(Succeed())
+
+					By("Checking that the image is restored by the controller")
+					Eventually(func(g Gomega) {
+						g.Expect(k8sClient.Get(ctx, synapseLookupKey, createdDeployment)).Should(Succeed())
+						g.Expect(createdDeployment.Spec.Template.Spec.Containers[0].Image).Should(Equal(expectedImage))
+					}, timeout, interval).Should(Succeed())
+				})
+
 				It("Should create a Synapse ServiceAccount", func() {
 					checkResourcePresence(createdServiceAccount, synapseLookupKey, expectedOwnerReference)
 				})
@@ -581,6 +670,30 @@ var _ = Describe("Integration tests for the Synapse controller", Ordered, Label(
 				It("Should create a Synapse RoleBinding", func() {
 					checkResourcePresence(createdRoleBinding, synapseLookupKey, expectedOwnerReference)
 				})
+
+				It("Should create a Synapse Secret", func() {
+					checkResourcePresence(createdSecret, secretLookupKey, expectedOwnerReference)
+				})
+
+				It("Should inject the generated secret values in homeserver-secrets.yaml", func() {
+					Eventually(func(g Gomega) {
+						g.Expect(k8sClient.Get(ctx, secretLookupKey, createdSecret)).Should(Succeed())
+						g.Expect(k8sClient.Get(ctx, synapseLookupKey, createdConfigMap)).Should(Succeed())
+						g.Expect(k8sClient.Get(ctx, synapseLookupKey, createdConfigSecret)).Should(Succeed())
+
+						homeserver := make(map[string]interface{})
+						g.Expect(yaml.Unmarshal([]byte(createdConfigMap.Data["homeserver.yaml"]), homeserver)).Should(Succeed())
+
+						homeserverSecrets := make(map[string]interface{})
+						g.Expect(yaml.Unmarshal(createdConfigSecret.Data["homeserver-secrets.yaml"], homeserverSecrets)).Should(Succeed())
+
+						for _, key := range homeserverSecretKeys {
+							g.Expect(homeserver).ShouldNot(HaveKey(key))
+							g.Expect(homeserverSecrets[key]).Should(Equal(string(createdSecret.Data[key])))
+						}
+						g.Expect(homeserver).ShouldNot(HaveKey("database"))
+					}, timeout, interval).Should(Succeed())
+				})
 			})
 
 			When("Specifying the Synapse configuration via a ConfigMap", func() {
@@ -655,7 +768,13 @@ var _ = Describe("Integration tests for the Synapse controller", Ordered, Label(
 						// Status may need some time to be updated
 						Eventually(func() synapsev1alpha1.SynapseStatus {
 							_ = k8sClient.Get(ctx, synapseLookupKey, synapse)
-							return synapse.Status
+							// Conditions are checked separately
+							status := synapse.Status
+							status.Conditions = nil
+							status.ObservedGeneration = 0
+							// The signing key ID is random
+							status.HomeserverConfiguration.SigningKeyID = ""
+							return status
 						}, timeout, interval).Should(Equal(expectedStatus))
 					})
 
@@ -693,6 +812,31 @@ var _ = Describe("Integration tests for the Synapse controller", Ordered, Label(
 					It("Should create a Synapse RoleBinding", func() {
 						checkResourcePresence(createdRoleBinding, synapseLookupKey, expectedOwnerReference)
 					})
+
+					It("Should react to changes in the input ConfigMap", func() {
+						inputConfigMapLookupKey := types.NamespacedName{Name: InputConfigMapName, Namespace: SynapseNamespace}
+
+						Expect(k8sClient.Get(ctx, synapseLookupKey, createdDeployment)).Should(Succeed())
+						configChecksum := createdDeployment.Spec.Template.Annotations[configChecksumAnnotation]
+						Expect(configChecksum).ShouldNot(BeEmpty())
+
+						By("Editing the input ConfigMap")
+						Expect(k8sClient.Get(ctx, inputConfigMapLookupKey, inputConfigMap)).Should(Succeed())
+						inputConfigMap.Data["homeserver.yaml"] += "\nmax_upload_size: 100M"
+						Expect(k8sClient.Update(ctx, inputConfigMap)).Should(Succeed())
+
+						By("Checking that the Synapse ConfigMap and Deployment are updated")
+						Eventually(func(g Gomega) {
+							g.Expect(k8sClient.Get(ctx, synapseLookupKey, createdConfigMap)).Should(Succeed())
+							homeserver := make(map[string]interface{})
+							g.Expect(yaml.Unmarshal([]byte(createdConfigMap.Data["homeserver.yaml"]), homeserver)).Should(Succeed())
+							g.Expect(homeserver).Should(HaveKeyWithValue("max_upload_size", "100M"))
+
+							g.Expect(k8sClient.Get(ctx, synapseLookupKey, createdDeployment)).Should(Succeed())
+							g.Expect(createdDeployment.Spec.Template.Annotations).Should(HaveKey(configChecksumAnnotation))
+							g.Expect(createdDeployment.Spec.Template.Annotations[configChecksumAnnotation]).ShouldNot(Equal(configChecksum))
+						}, timeout, interval).Should(Succeed())
+					})
 				})
 
 				When("Requesting a new PostgreSQL instance to be created for Synapse", func() {
@@ -791,24 +935,26 @@ var _ = Describe("Integration tests for the Synapse controller", Ordered, Label(
 							g.Expect(synapse.Status.DatabaseConnectionInfo.ConnectionURL).Should(Equal("hostname.postgresql.url:5432"))
 							g.Expect(synapse.Status.DatabaseConnectionInfo.DatabaseName).Should(Equal("synapse"))
 							g.Expect(synapse.Status.DatabaseConnectionInfo.User).Should(Equal("synapse"))
-							g.Expect(synapse.Status.DatabaseConnectionInfo.Password).Should(Equal(string(base64encode("VerySecureSyn@psePassword!"))))
+							g.Expect(synapse.Status.DatabaseConnectionInfo.PasswordSecretKeyRef.Name).Should(Equal(SynapseName + "-pgsql-pguser-synapse"))
+							g.Expect(synapse.Status.DatabaseConnectionInfo.PasswordSecretKeyRef.Key).Should(Equal("password"))
+							g.Expect(synapse.Status.DatabaseConnectionInfo.Password).Should(BeEmpty())
 							g.Expect(synapse.Status.DatabaseConnectionInfo.State).Should(Equal("READY"))
 						}, timeout, interval).Should(Succeed())
 					})
 
-					It("Should update the ConfigMap Data", func() {
+					It("Should update the Secret Data", func() {
 						Eventually(func(g Gomega) {
-							// Fetching database section of the homeserver.yaml configuration file
+							// Fetching database section of the homeserver-secrets.yaml configuration file
 							g.Expect(k8sClient.Get(ctx,
 								types.NamespacedName{Name: SynapseName, Namespace: SynapseNamespace},
-								createdConfigMap,
+								createdConfigSecret,
 							)).Should(Succeed())
 
-							cm_data, ok := createdConfigMap.Data["homeserver.yaml"]
+							secret_data, ok := createdConfigSecret.Data["homeserver-secrets.yaml"]
 							g.Expect(ok).Should(BeTrue())
 
 							homeserver := make(map[string]interface{})
-							g.Expect(yaml.Unmarshal([]byte(cm_data), homeserver)).Should(Succeed())
+							g.Expect(yaml.Unmarshal(secret_data, homeserver)).Should(Succeed())
 
 							_, ok = homeserver["database"]
 							g.Expect(ok).Should(BeTrue())
@@ -842,6 +988,7 @@ var _ = Describe("Integration tests for the Synapse controller", Ordered, Label(
 					var createdHeisenbridgeDeployment *appsv1.Deployment
 					var createdHeisenbridgeService *corev1.Service
 					var createdHeisenbridgeConfigMap *corev1.ConfigMap
+					var createdHeisenbridgeSecret *corev1.Secret
 					var heisenbridgeLookupKey types.NamespacedName
 
 					var initHeisenbridgeVariables = func() {
@@ -849,6 +996,7 @@ var _ = Describe("Integration tests for the Synapse controller", Ordered, Label(
 						createdHeisenbridgeDeployment = &appsv1.Deployment{}
 						createdHeisenbridgeService = &corev1.Service{}
 						createdHeisenbridgeConfigMap = &corev1.ConfigMap{}
+						createdHeisenbridgeSecret = &corev1.Secret{}
 
 						heisenbridgeLookupKey = types.NamespacedName{Name: SynapseName + "-heisenbridge", Namespace: SynapseNamespace}
 					}
@@ -862,6 +1010,9 @@ var _ = Describe("Integration tests for the Synapse controller", Ordered, Label(
 
 						By("Cleaning up the Heisenbridge ConfigMap")
 						deleteResource(createdHeisenbridgeConfigMap, heisenbridgeLookupKey, false)
+
+						By("Cleaning up the Heisenbridge Secret")
+						deleteResource(createdHeisenbridgeSecret, heisenbridgeLookupKey, false)
 					}
 
 					When("Using the default configuration", func() {
@@ -902,6 +1053,60 @@ var _ = Describe("Integration tests for the Synapse controller", Ordered, Label(
 							checkResourcePresence(createdHeisenbridgeConfigMap, heisenbridgeLookupKey, expectedOwnerReference)
 						})
 
+						It("Should create a Secret for Heisenbridge", func() {
+							checkResourcePresence(createdHeisenbridgeSecret, heisenbridgeLookupKey, expectedOwnerReference)
+						})
+
+						It("Should configure the generated tokens in the heisenbridge.yaml of the Secret only", func() {
+							Eventually(func(g Gomega) {
+								g.Expect(k8sClient.Get(ctx, heisenbridgeLookupKey, createdHeisenbridgeSecret)).Should(Succeed())
+								g.Expect(k8sClient.Get(ctx, heisenbridgeLookupKey, createdHeisenbridgeConfigMap)).Should(Succeed())
+
+								secret_data, ok := createdHeisenbridgeSecret.Data["heisenbridge.yaml"]
+								g.Expect(ok).Should(BeTrue())
+
+								heisenbridge := make(map[string]interface{})
+								g.Expect(yaml.Unmarshal(secret_data, heisenbridge)).Should(Succeed())
+
+								g.Expect(heisenbridge["as_token"]).Should(Equal(string(createdHeisenbridgeSecret.Data["as_token"])))
+								g.Expect(heisenbridge["hs_token"]).Should(Equal(string(createdHeisenbridgeSecret.Data["hs_token"])))
+
+								cm_data, ok := createdHeisenbridgeConfigMap.Data["heisenbridge.yaml"]
+								g.Expect(ok).Should(BeTrue())
+
+								heisenbridge = make(map[string]interface{})
+								g.Expect(yaml.Unmarshal([]byte(cm_data), heisenbridge)).Should(Succeed())
+
+								g.Expect(heisenbridge).ShouldNot(HaveKey("as_token"))
+								g.Expect(heisenbridge).ShouldNot(HaveKey("hs_token"))
+							}, timeout, interval).Should(Succeed())
+						})
+
+						It("Should rotate the tokens when requested", func() {
+							var previousToken []byte
+							Expect(k8sClient.Get(ctx, heisenbridgeLookupKey, createdHeisenbridgeSecret)).Should(Succeed())
+							previousToken = createdHeisenbridgeSecret.Data["as_token"]
+
+							By("Setting the rotation annotation on the Synapse instance")
+							Expect(k8sClient.Get(ctx, synapseLookupKey, synapse)).Should(Succeed())
+							synapse.Annotations = map[string]string{rotateHeisenbridgeTokensAnnotation: "1"}
+							Expect(k8sClient.Update(ctx, synapse)).Should(Succeed())
+
+							By("Checking that the tokens have been regenerated")
+							Eventually(func(g Gomega) {
+								g.Expect(k8sClient.Get(ctx, heisenbridgeLookupKey, createdHeisenbridgeSecret)).Should(Succeed())
+								g.Expect(createdHeisenbridgeSecret.Data["as_token"]).ShouldNot(Equal(previousToken))
+							}, timeout, interval).Should(Succeed())
+
+							By("Checking that the Heisenbridge Deployment is restarted")
+							Eventually(func(g Gomega) {
+								g.Expect(k8sClient.Get(ctx, heisenbridgeLookupKey, createdHeisenbridgeDeployment)).Should(Succeed())
+								g.Expect(createdHeisenbridgeDeployment.Spec.Template.Annotations).Should(
+									HaveKeyWithValue(rotateHeisenbridgeTokensAnnotation, "1"),
+								)
+							}, timeout, interval).Should(Succeed())
+						})
+
 						It("Should create a Deployment for Heisenbridge", func() {
 							By("Checking that a Synapse Deployment exists and is correctly configured")
 							checkResourcePresence(createdHeisenbridgeDeployment, heisenbridgeLookupKey, expectedOwnerReference)
@@ -1059,7 +1264,7 @@ var _ = Describe("Integration tests for the Synapse controller", Ordered, Label(
 								_, ok = heisenbridge["url"]
 								g.Expect(ok).Should(BeTrue())
 
-								g.Expect(heisenbridge["url"]).To(Equal("http://" + heisenbridgeIP + ":9898"))
+								g.Expect(heisenbridge["url"]).To(Equal("http://" + SynapseName + "-heisenbridge." + SynapseNamespace + ".svc.cluster.local:9898"))
 							}, timeout, interval).Should(Succeed())
 						})
 					})
@@ -1090,12 +1295,12 @@ var _ = Describe("Integration tests for the Synapse controller", Ordered, Label(
 
 			AfterEach(func() {
 				By("Cleaning up Synapse CR")
-				Expect(k8sClient.Delete(ctx, synapse)).Should(Succeed())
+				deleteResource(synapse, types.NamespacedName{Name: SynapseName, Namespace: SynapseNamespace}, false)
 			})
 
 			It("Should get in a failed state and not create child objects", func() {
 				reason := "ConfigMap " + InputConfigMapName + " does not exist in namespace " + SynapseNamespace
-				checkSubresourceAbsence(reason)
+				checkSubresourceAbsence(reason, synapsev1alpha1.ConditionTypeConfigurationValid, "ConfigMapNotFound")
 			})
 		})
 	})
@@ -1164,12 +1369,12 @@ var _ = Describe("Integration tests for the Synapse controller", Ordered, Label(
 				Expect(k8sClient.Delete(ctx, configMap)).Should(Succeed())
 
 				By("Cleaning up Synapse CR")
-				Expect(k8sClient.Delete(ctx, synapse)).Should(Succeed())
+				deleteResource(synapse, types.NamespacedName{Name: SynapseName, Namespace: SynapseNamespace}, false)
 			})
 
 			It("Should not create Synapse sub-resources", func() {
 				reason := "Cannot create PostgreSQL instance for synapse. Postgres-operator is not installed."
-				checkSubresourceAbsence(reason)
+				checkSubresourceAbsence(reason, synapsev1alpha1.ConditionTypeDatabaseReady, "PostgresOperatorNotInstalled")
 			})
 		})
 	})
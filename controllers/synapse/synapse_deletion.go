@@ -0,0 +1,285 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package synapse
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
+
+	pgov1beta1 "github.com/crunchydata/postgres-operator/pkg/apis/postgres-operator.crunchydata.com/v1beta1"
+	synapsev1alpha1 "github.com/opdev/synapse-operator/apis/synapse/v1alpha1"
+)
+
+// synapseFinalizer is set on every Synapse instance, so that the
+// DeletionPolicy can be enforced before the child resources are garbage
+// collected.
+const synapseFinalizer = "synapse.opdev.io/finalizer"
+
+// volumeSnapshotGVK is the GroupVersionKind of the VolumeSnapshot created
+// with the Snapshot DeletionPolicy. VolumeSnapshots are handled as
+// unstructured objects, as the snapshot API is not part of the core
+// Kubernetes API.
+var volumeSnapshotGVK = schema.GroupVersionKind{
+	Group:   "snapshot.storage.k8s.io",
+	Version: "v1",
+	Kind:    "VolumeSnapshot",
+}
+
+// snapshotTimeout is the time after which a VolumeSnapshot which is still
+// not ready to use is considered as failed.
+const snapshotTimeout = 30 * time.Minute
+
+// errSnapshotFailed is returned when the Synapse PVC can't be snapshotted,
+// e.g. when the VolumeSnapshot API is not available. The Synapse data is
+// then retained instead of being deleted without a snapshot.
+var errSnapshotFailed = errors.New("cannot snapshot the Synapse PVC")
+
+// ensureSynapseFinalizer adds the synapseFinalizer to the Synapse instance,
+// if not already present.
+func (r *SynapseReconciler) ensureSynapseFinalizer(ctx context.Context, s *synapsev1alpha1.Synapse) error {
+	if controllerutil.ContainsFinalizer(s, synapseFinalizer) {
+		return nil
+	}
+
+	controllerutil.AddFinalizer(s, synapseFinalizer)
+	return r.Update(ctx, s)
+}
+
+// finalizeSynapse enforces the DeletionPolicy of a Synapse instance being
+// deleted, then removes the synapseFinalizer. The child resources which are
+// still owned by the Synapse instance are then garbage collected.
+func (r *SynapseReconciler) finalizeSynapse(ctx context.Context, s *synapsev1alpha1.Synapse) (ctrl.Result, error) {
+	log := ctrllog.FromContext(ctx)
+
+	if !controllerutil.ContainsFinalizer(s, synapseFinalizer) {
+		return ctrl.Result{}, nil
+	}
+
+	switch s.Spec.DeletionPolicy {
+	case synapsev1alpha1.DeletionPolicyRetain:
+		log.Info("Retaining Synapse data", "Synapse Name", s.Name, "Synapse Namespace", s.Namespace)
+		if err := r.orphanSynapseData(ctx, s); err != nil {
+			log.Error(err, "Error orphaning Synapse data")
+			return ctrl.Result{}, err
+		}
+	case synapsev1alpha1.DeletionPolicySnapshot:
+		snapshotReady, err := r.snapshotSynapsePVC(ctx, s)
+		if errors.Is(err, errSnapshotFailed) {
+			// Deleting the Synapse data without a snapshot would lose it
+			log.Error(err, "Retaining Synapse data", "Synapse Name", s.Name, "Synapse Namespace", s.Namespace)
+			r.Recorder.Event(s, corev1.EventTypeWarning, reasonSnapshotFailed, err.Error()+", retaining the Synapse data instead")
+			if err := r.orphanSynapseData(ctx, s); err != nil {
+				log.Error(err, "Error orphaning Synapse data")
+				return ctrl.Result{}, err
+			}
+		} else if err != nil {
+			log.Error(err, "Error creating a VolumeSnapshot of the Synapse PVC")
+			return ctrl.Result{}, err
+		} else if !snapshotReady {
+			log.Info("Waiting for the VolumeSnapshot of the Synapse PVC to be ready")
+			r.setStatusCondition(
+				s,
+				synapsev1alpha1.ConditionTypeSnapshotReady,
+				metav1.ConditionFalse,
+				reasonSnapshotNotReady,
+				"VolumeSnapshot "+snapshotName(*s)+" is not ready to use yet",
+			)
+			if err := r.updateSynapseStatus(ctx, s); err != nil {
+				log.Error(err, "Error updating Synapse Status")
+				return ctrl.Result{}, err
+			}
+			return ctrl.Result{RequeueAfter: 5 * time.Second}, nil
+		}
+	}
+
+	controllerutil.RemoveFinalizer(s, synapseFinalizer)
+	if err := r.Update(ctx, s); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	return ctrl.Result{}, nil
+}
+
+// retainedResourcesForSynapse returns the resources holding the Synapse
+// data, which are kept with the Retain DeletionPolicy. The Secret created by
+// the postgres-operator for the synapse user is owned by the PostgresCluster,
+// and is therefore kept along with it.
+func (r *SynapseReconciler) retainedResourcesForSynapse(s synapsev1alpha1.Synapse) map[types.NamespacedName]client.Object {
+	resources := map[types.NamespacedName]client.Object{
+		{Name: s.Name, Namespace: s.Namespace}:            &corev1.PersistentVolumeClaim{},
+		{Name: s.Name + "-pgsql", Namespace: s.Namespace}: &pgov1beta1.PostgresCluster{},
+	}
+
+	// Generated secrets can't be recovered once lost
+	if s.Spec.Homeserver.Secret == nil {
+		resources[types.NamespacedName{Name: s.Name + "-secrets", Namespace: s.Namespace}] = &corev1.Secret{}
+	}
+	if s.Spec.Homeserver.SigningKeySecret == nil {
+		resources[types.NamespacedName{Name: signingKeySecretName(s), Namespace: s.Namespace}] = &corev1.Secret{}
+	}
+
+	return resources
+}
+
+// orphanSynapseData removes the owner reference to the Synapse instance from
+// the resources holding the Synapse data, so that they are not garbage
+// collected. As child resources are applied with a fixed name, a Synapse
+// instance created later with the same name adopts them again.
+func (r *SynapseReconciler) orphanSynapseData(ctx context.Context, s *synapsev1alpha1.Synapse) error {
+	for key, resource := range r.retainedResourcesForSynapse(*s) {
+		if err := r.Get(ctx, key, resource); err != nil {
+			if k8serrors.IsNotFound(err) || meta.IsNoMatchError(err) {
+				continue
+			}
+			return err
+		}
+
+		if err := r.removeOwnerReference(ctx, s, resource); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// removeOwnerReference removes the owner reference to the Synapse instance
+// from the given resource.
+func (r *SynapseReconciler) removeOwnerReference(ctx context.Context, s *synapsev1alpha1.Synapse, resource client.Object) error {
+	patch := client.MergeFrom(resource.DeepCopyObject().(client.Object))
+
+	ownerReferences := resource.GetOwnerReferences()
+	filteredOwnerReferences := ownerReferences[:0]
+	for _, ownerReference := range ownerReferences {
+		if ownerReference.UID != s.UID {
+			filteredOwnerReferences = append(filteredOwnerReferences, ownerReference)
+		}
+	}
+
+	if len(filteredOwnerReferences) == len(ownerReferences) {
+		return nil
+	}
+
+	resource.SetOwnerReferences(filteredOwnerReferences)
+	return r.Patch(ctx, resource, patch)
+}
+
+// snapshotName returns the name of the VolumeSnapshot of the Synapse PVC.
+// It's unique to the Synapse instance, so that snapshots of previous
+// instances with the same name are not overwritten.
+func snapshotName(s synapsev1alpha1.Synapse) string {
+	return s.Name + "-" + string(s.UID)
+}
+
+// snapshotSynapsePVC creates a VolumeSnapshot of the Synapse PVC, and
+// returns whether the VolumeSnapshot is ready to use. The VolumeSnapshot is
+// not owned by the Synapse instance, and is therefore kept after its
+// deletion. Nothing is done if the Synapse PVC doesn't exist.
+//
+// An error wrapping errSnapshotFailed is returned if the VolumeSnapshot API
+// is not available, if the VolumeSnapshot reports an error, or if it is
+// not ready to use after snapshotTimeout.
+func (r *SynapseReconciler) snapshotSynapsePVC(ctx context.Context, s *synapsev1alpha1.Synapse) (bool, error) {
+	log := ctrllog.FromContext(ctx)
+
+	pvc := &corev1.PersistentVolumeClaim{}
+	if err := r.Get(ctx, types.NamespacedName{Name: s.Name, Namespace: s.Namespace}, pvc); err != nil {
+		if k8serrors.IsNotFound(err) {
+			return true, nil
+		}
+		return false, err
+	}
+
+	snapshot := &unstructured.Unstructured{}
+	snapshot.SetGroupVersionKind(volumeSnapshotGVK)
+	snapshotKey := types.NamespacedName{Name: snapshotName(*s), Namespace: s.Namespace}
+
+	if err := r.Get(ctx, snapshotKey, snapshot); err != nil {
+		if meta.IsNoMatchError(err) {
+			return false, fmt.Errorf("%w: the VolumeSnapshot API is not available", errSnapshotFailed)
+		}
+		if !k8serrors.IsNotFound(err) {
+			return false, err
+		}
+
+		snapshot.SetName(snapshotKey.Name)
+		snapshot.SetNamespace(snapshotKey.Namespace)
+		snapshot.SetLabels(labelsForSynapse(s.Name))
+		if err := unstructured.SetNestedField(
+			snapshot.Object,
+			pvc.Name,
+			"spec", "source", "persistentVolumeClaimName",
+		); err != nil {
+			return false, err
+		}
+
+		log.Info(
+			"Creating a VolumeSnapshot of the Synapse PVC",
+			"VolumeSnapshot.Name", snapshotKey.Name,
+			"VolumeSnapshot.Namespace", snapshotKey.Namespace,
+		)
+		if err := r.Create(ctx, snapshot); err != nil {
+			if meta.IsNoMatchError(err) {
+				return false, fmt.Errorf("%w: the VolumeSnapshot API is not available", errSnapshotFailed)
+			}
+			return false, err
+		}
+	}
+
+	readyToUse, _, err := unstructured.NestedBool(snapshot.Object, "status", "readyToUse")
+	if err != nil {
+		return false, err
+	}
+	if readyToUse {
+		return true, nil
+	}
+
+	// Errors reported by the snapshot controller may be transient, but
+	// nothing guarantees that they are retried
+	errorMessage, _, err := unstructured.NestedString(snapshot.Object, "status", "error", "message")
+	if err != nil {
+		return false, err
+	}
+	if errorMessage != "" {
+		return false, fmt.Errorf("%w: VolumeSnapshot %s failed: %s", errSnapshotFailed, snapshotKey.Name, errorMessage)
+	}
+
+	creationTimestamp := snapshot.GetCreationTimestamp()
+	if !creationTimestamp.IsZero() && time.Since(creationTimestamp.Time) > snapshotTimeout {
+		return false, fmt.Errorf(
+			"%w: VolumeSnapshot %s is not ready to use after %s",
+			errSnapshotFailed,
+			snapshotKey.Name,
+			snapshotTimeout,
+		)
+	}
+
+	return false, nil
+}
@@ -33,7 +33,8 @@ func (r *SynapseReconciler) deploymentForSynapse(s *synapsev1alpha1.Synapse, obj
 
 	server_name := s.Status.HomeserverConfiguration.ServerName
 	report_stats := s.Status.HomeserverConfiguration.ReportStats
-	// The created Synapse ConfigMap shares the same name as the Synapse deployment
+	// The created Synapse ConfigMap and Secret share the same name as the
+	// Synapse deployment
 	synapseConfigMapName := objectMeta.Name
 
 	dep := &appsv1.Deployment{
@@ -49,7 +50,7 @@ func (r *SynapseReconciler) deploymentForSynapse(s *synapsev1alpha1.Synapse, obj
 				},
 				Spec: corev1.PodSpec{
 					InitContainers: []corev1.Container{{
-						Image: "matrixdotorg/synapse:v1.60.0",
+						Image: s.Spec.Image,
 						Name:  "synapse-generate",
 						Args:  []string{"generate"},
 						Env: []corev1.EnvVar{{
@@ -65,14 +66,25 @@ func (r *SynapseReconciler) deploymentForSynapse(s *synapsev1alpha1.Synapse, obj
 						VolumeMounts: []corev1.VolumeMount{{
 							Name:      "homeserver",
 							MountPath: "/data-homeserver",
+						}, {
+							Name:      "signing-key",
+							MountPath: signingKeyMountPath,
 						}, {
 							Name:      "data-pv",
 							MountPath: "/data",
 						}},
 					}},
 					Containers: []corev1.Container{{
-						Image: "matrixdotorg/synapse:v1.60.0",
+						Image: s.Spec.Image,
 						Name:  "synapse",
+						// Synapse merges all configuration files given with
+						// --config-path. Sensitive sections are stored in
+						// homeserver-secrets.yaml.
+						Args: []string{
+							"run",
+							"--config-path", "/data-homeserver/homeserver.yaml",
+							"--config-path", "/data-homeserver-secrets/homeserver-secrets.yaml",
+						},
 						Env: []corev1.EnvVar{{
 							Name:  "SYNAPSE_CONFIG_PATH",
 							Value: "/data-homeserver/homeserver.yaml",
@@ -80,6 +92,12 @@ func (r *SynapseReconciler) deploymentForSynapse(s *synapsev1alpha1.Synapse, obj
 						VolumeMounts: []corev1.VolumeMount{{
 							Name:      "homeserver",
 							MountPath: "/data-homeserver",
+						}, {
+							Name:      "homeserver-secrets",
+							MountPath: "/data-homeserver-secrets",
+						}, {
+							Name:      "signing-key",
+							MountPath: signingKeyMountPath,
 						}, {
 							Name:      "data-pv",
 							MountPath: "/data",
@@ -98,6 +116,20 @@ func (r *SynapseReconciler) deploymentForSynapse(s *synapsev1alpha1.Synapse, obj
 								},
 							},
 						},
+					}, {
+						Name: "homeserver-secrets",
+						VolumeSource: corev1.VolumeSource{
+							Secret: &corev1.SecretVolumeSource{
+								SecretName: synapseConfigMapName,
+							},
+						},
+					}, {
+						Name: "signing-key",
+						VolumeSource: corev1.VolumeSource{
+							Secret: &corev1.SecretVolumeSource{
+								SecretName: signingKeySecretName(*s),
+							},
+						},
 					}, {
 						Name: "data-pv",
 						VolumeSource: corev1.VolumeSource{
@@ -112,7 +144,9 @@ func (r *SynapseReconciler) deploymentForSynapse(s *synapsev1alpha1.Synapse, obj
 	}
 
 	if s.Spec.Bridges.Heisenbridge.Enabled {
-		heisenbridgeConfigMapName := objectMeta.Name + "-heisenbridge"
+		// heisenbridge.yaml contains the appservice tokens, and is mounted
+		// from the Heisenbridge Secret
+		heisenbridgeSecretName := objectMeta.Name + "-heisenbridge"
 
 		dep.Spec.Template.Spec.Containers[0].VolumeMounts = append(
 			dep.Spec.Template.Spec.Containers[0].VolumeMounts,
@@ -127,12 +161,90 @@ func (r *SynapseReconciler) deploymentForSynapse(s *synapsev1alpha1.Synapse, obj
 			corev1.Volume{
 				Name: "data-heisenbridge",
 				VolumeSource: corev1.VolumeSource{
-					ConfigMap: &corev1.ConfigMapVolumeSource{
-						LocalObjectReference: corev1.LocalObjectReference{
-							Name: heisenbridgeConfigMapName,
+					Secret: &corev1.SecretVolumeSource{
+						SecretName: heisenbridgeSecretName,
+					},
+				},
+			},
+		)
+
+		// Record the Heisenbridge tokens currently in use
+		setAnnotation(
+			&dep.Spec.Template.ObjectMeta,
+			rotateHeisenbridgeTokensAnnotation,
+			s.Annotations[rotateHeisenbridgeTokensAnnotation],
+		)
+	}
+
+	// Mount the certificate served on the TLS listener of the reencrypt
+	// Route
+	if isRouteReencrypt(*s) {
+		dep.Spec.Template.Spec.Containers[0].VolumeMounts = append(
+			dep.Spec.Template.Spec.Containers[0].VolumeMounts,
+			corev1.VolumeMount{
+				Name:      "tls",
+				MountPath: routeTLSMountPath,
+			},
+		)
+
+		dep.Spec.Template.Spec.Containers[0].Ports = append(
+			dep.Spec.Template.Spec.Containers[0].Ports,
+			corev1.ContainerPort{
+				ContainerPort: routeTLSPort,
+			},
+		)
+
+		dep.Spec.Template.Spec.Volumes = append(
+			dep.Spec.Template.Spec.Volumes,
+			corev1.Volume{
+				Name: "tls",
+				VolumeSource: corev1.VolumeSource{
+					Secret: &corev1.SecretVolumeSource{
+						SecretName: servingCertSecretName(*s),
+					},
+				},
+			},
+		)
+	}
+
+	// Expose the federation listener, and mount its TLS certificate
+	if isFederationListenerEnabled(*s) {
+		dep.Spec.Template.Spec.Containers[0].Ports = append(
+			dep.Spec.Template.Spec.Containers[0].Ports,
+			corev1.ContainerPort{
+				ContainerPort: s.Spec.Federation.Port,
+			},
+		)
+
+		if s.Spec.Federation.TLSSecretName != "" {
+			dep.Spec.Template.Spec.Containers[0].VolumeMounts = append(
+				dep.Spec.Template.Spec.Containers[0].VolumeMounts,
+				corev1.VolumeMount{
+					Name:      "federation-tls",
+					MountPath: federationTLSMountPath,
+				},
+			)
+
+			dep.Spec.Template.Spec.Volumes = append(
+				dep.Spec.Template.Spec.Volumes,
+				corev1.Volume{
+					Name: "federation-tls",
+					VolumeSource: corev1.VolumeSource{
+						Secret: &corev1.SecretVolumeSource{
+							SecretName: s.Spec.Federation.TLSSecretName,
 						},
 					},
 				},
+			)
+		}
+	}
+
+	// The workers connect to the replication listener
+	if len(s.Spec.Workers) != 0 {
+		dep.Spec.Template.Spec.Containers[0].Ports = append(
+			dep.Spec.Template.Spec.Containers[0].Ports,
+			corev1.ContainerPort{
+				ContainerPort: replicationPort,
 			},
 		)
 	}
@@ -0,0 +1,156 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package synapse
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	synapsev1alpha1 "github.com/opdev/synapse-operator/apis/synapse/v1alpha1"
+)
+
+// The TLS certificate of the federation listener is mounted from the Secret
+// given in Spec.Federation.TLSSecretName.
+const federationTLSMountPath = "/data-federation-tls"
+
+// isFederationEnabled returns true if Synapse federates with other
+// homeservers, according to Spec.Federation.
+func isFederationEnabled(s synapsev1alpha1.Synapse) bool {
+	return s.Spec.Federation == nil || s.Spec.Federation.Enabled == nil || *s.Spec.Federation.Enabled
+}
+
+// isFederationListenerEnabled returns true if Synapse serves the federation
+// API on a dedicated listener, exposed by the federation Service.
+func isFederationListenerEnabled(s synapsev1alpha1.Synapse) bool {
+	return s.Spec.Federation != nil && isFederationEnabled(s)
+}
+
+// serviceForFederation returns a Service exposing the federation listener of
+// Synapse. In worker mode, it selects the reverse proxy routing the requests
+// to the workers.
+func (r *SynapseReconciler) serviceForFederation(s *synapsev1alpha1.Synapse, objectMeta metav1.ObjectMeta) (client.Object, error) {
+	service := &corev1.Service{
+		ObjectMeta: objectMeta,
+		Spec: corev1.ServiceSpec{
+			Ports: []corev1.ServicePort{{
+				Name:       "federation",
+				Protocol:   corev1.ProtocolTCP,
+				Port:       s.Spec.Federation.Port,
+				TargetPort: intstr.FromInt(int(s.Spec.Federation.Port)),
+			}},
+			Selector: labelsForSynapseService(*s),
+			Type:     s.Spec.Federation.ServiceType,
+		},
+	}
+
+	// Set Synapse instance as the owner and controller
+	if err := ctrl.SetControllerReference(s, service, r.Scheme); err != nil {
+		return &corev1.Service{}, err
+	}
+	return service, nil
+}
+
+// updateHomeserverWithFederation is a function of type updateDataFunc, to be
+// passed as an argument in a call to withConfigMapUpdates.
+//
+// It configures the federation options of Spec.Federation. When federation
+// is enabled, a listener serving the federation API is added on the
+// federation port. Otherwise, the federation API is removed from all
+// listeners, and federation is refused with all domains.
+func (r *SynapseReconciler) updateHomeserverWithFederation(s synapsev1alpha1.Synapse, homeserver map[string]interface{}) error {
+	federation := s.Spec.Federation
+
+	if len(federation.IPRangeBlacklist) != 0 {
+		homeserver["ip_range_blacklist"] = federation.IPRangeBlacklist
+	}
+
+	listeners, _ := homeserver["listeners"].([]interface{})
+
+	if !isFederationEnabled(s) {
+		homeserver["federation_domain_whitelist"] = []string{}
+		for _, listener := range listeners {
+			removeListenerResource(listener, "federation")
+		}
+		return nil
+	}
+
+	if len(federation.DomainWhitelist) != 0 {
+		homeserver["federation_domain_whitelist"] = federation.DomainWhitelist
+	} else {
+		delete(homeserver, "federation_domain_whitelist")
+	}
+
+	tls := federation.TLSSecretName != ""
+	if tls {
+		homeserver["tls_certificate_path"] = federationTLSMountPath + "/tls.crt"
+		homeserver["tls_private_key_path"] = federationTLSMountPath + "/tls.key"
+	}
+
+	federationListener := HomeserverListener{
+		Port: federation.Port,
+		Type: "http",
+		TLS:  tls,
+		// Without TLS, the listener is expected to be behind a TLS
+		// terminating proxy or load balancer
+		XForwarded: !tls,
+		Resources: []HomeserverListenerResource{{
+			Names:    []string{"federation"},
+			Compress: false,
+		}},
+	}
+
+	// Replace any listener previously defined on the federation port
+	var updatedListeners []interface{}
+	for _, listener := range listeners {
+		if listener, ok := listener.(map[interface{}]interface{}); ok && listener["port"] == int(federation.Port) {
+			continue
+		}
+		updatedListeners = append(updatedListeners, listener)
+	}
+	homeserver["listeners"] = append(updatedListeners, federationListener)
+
+	return nil
+}
+
+// removeListenerResource removes the resource of the given name from a
+// listener of homeserver.yaml.
+func removeListenerResource(listener interface{}, name string) {
+	listenerMap, ok := listener.(map[interface{}]interface{})
+	if !ok {
+		return
+	}
+
+	resources, _ := listenerMap["resources"].([]interface{})
+	for _, resource := range resources {
+		resourceMap, ok := resource.(map[interface{}]interface{})
+		if !ok {
+			continue
+		}
+
+		names, _ := resourceMap["names"].([]interface{})
+		var updatedNames []interface{}
+		for _, n := range names {
+			if n != name {
+				updatedNames = append(updatedNames, n)
+			}
+		}
+		resourceMap["names"] = updatedNames
+	}
+}
@@ -29,9 +29,7 @@ import (
 func (r *SynapseReconciler) configMapForHeisenbridge(s *synapsev1alpha1.Synapse, objectMeta metav1.ObjectMeta) (client.Object, error) {
 	heisenbridgeYaml := `
 id: heisenbridge
-url: http://` + s.Status.BridgesConfiguration.Heisenbridge.IP + `:9898
-as_token: EUFqSPQusV4mXkPKbwdHyIhthELQ1Xf9S5lSEzTrrlb0uz0ZJRHhwEljT71ByObe
-hs_token: If6r2GGlsNN4MnoW3djToADNdq0JuIJ1WNM4rKHO73WuG5QvVubj1Q4JHrmQBcS6
+url: ` + r.serviceURL(*s, s.Name+"-heisenbridge", 9898) + `
 rate_limited: false
 sender_localpart: heisenbridge
 namespaces:
@@ -83,7 +81,7 @@ func (r *SynapseReconciler) configMapForHeisenbridgeCopy(
 }
 
 // updateHeisenbridgeWithURL is a function of type updateDataFunc function to
-// be passed as an argument in a call to updateConfigMap.
+// be passed as an argument in a call to withConfigMapUpdates.
 //
 // It configures the correct Heisenbridge URL, needed for Synapse to reach the
 // bridge.
@@ -91,6 +89,6 @@ func (r *SynapseReconciler) updateHeisenbridgeWithURL(
 	s synapsev1alpha1.Synapse,
 	heisenbridge map[string]interface{},
 ) error {
-	heisenbridge["url"] = "http://" + s.Status.BridgesConfiguration.Heisenbridge.IP + ":9898"
+	heisenbridge["url"] = r.serviceURL(s, s.Name+"-heisenbridge", 9898)
 	return nil
 }
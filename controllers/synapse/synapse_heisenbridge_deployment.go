@@ -38,9 +38,9 @@ func (r *SynapseReconciler) deploymentForHeisenbridge(s *synapsev1alpha1.Synapse
 	replicas := int32(1)
 
 	command := r.craftHeisenbridgeCommad(*s)
-	// The created Heisenbridge ConfigMap Name share the same name as the
-	// Heisenbridge Deployment
-	heisenbridgeConfigMapName := objectMeta.Name
+	// The Heisenbridge Secret, containing heisenbridge.yaml and its tokens,
+	// shares the same name as the Heisenbridge Deployment
+	heisenbridgeSecretName := objectMeta.Name
 
 	dep := &appsv1.Deployment{
 		ObjectMeta: objectMeta,
@@ -55,7 +55,7 @@ func (r *SynapseReconciler) deploymentForHeisenbridge(s *synapsev1alpha1.Synapse
 				},
 				Spec: corev1.PodSpec{
 					Containers: []corev1.Container{{
-						Image: "hif1/heisenbridge:1.13",
+						Image: s.Spec.Bridges.Heisenbridge.Image,
 						Name:  "heisenbridge",
 						VolumeMounts: []corev1.VolumeMount{{
 							Name:      "data-heisenbridge",
@@ -69,10 +69,8 @@ func (r *SynapseReconciler) deploymentForHeisenbridge(s *synapsev1alpha1.Synapse
 					Volumes: []corev1.Volume{{
 						Name: "data-heisenbridge",
 						VolumeSource: corev1.VolumeSource{
-							ConfigMap: &corev1.ConfigMapVolumeSource{
-								LocalObjectReference: corev1.LocalObjectReference{
-									Name: heisenbridgeConfigMapName,
-								},
+							Secret: &corev1.SecretVolumeSource{
+								SecretName: heisenbridgeSecretName,
 							},
 						},
 					}},
@@ -80,6 +78,14 @@ func (r *SynapseReconciler) deploymentForHeisenbridge(s *synapsev1alpha1.Synapse
 			},
 		},
 	}
+
+	// Record the Heisenbridge tokens currently in use
+	setAnnotation(
+		&dep.Spec.Template.ObjectMeta,
+		rotateHeisenbridgeTokensAnnotation,
+		s.Annotations[rotateHeisenbridgeTokensAnnotation],
+	)
+
 	// Set Synapse instance as the owner and controller
 	if err := ctrl.SetControllerReference(s, dep, r.Scheme); err != nil {
 		return &appsv1.Deployment{}, err
@@ -108,7 +114,7 @@ func (r *SynapseReconciler) craftHeisenbridgeCommad(s synapsev1alpha1.Synapse) [
 		"/data-heisenbridge/heisenbridge.yaml",
 		"-l",
 		"0.0.0.0",
-		"http://"+s.Status.IP+":8008",
+		r.serviceURL(s, s.Name, 8008),
 	)
 
 	return command
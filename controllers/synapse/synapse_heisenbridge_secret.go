@@ -0,0 +1,137 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package synapse
+
+import (
+	"context"
+	"errors"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	synapsev1alpha1 "github.com/opdev/synapse-operator/apis/synapse/v1alpha1"
+)
+
+// rotateHeisenbridgeTokensAnnotation is the Synapse annotation used to
+// request the rotation of the Heisenbridge appservice tokens. The tokens are
+// regenerated every time the value of the annotation changes. The last value
+// handled by the Synapse Operator is recorded on the Heisenbridge Secret and
+// on the pod template of the Synapse and Heisenbridge Deployments, so that
+// both are restarted with the new tokens.
+const rotateHeisenbridgeTokensAnnotation = "synapse.opdev.io/rotate-heisenbridge-tokens"
+
+// heisenbridgeTokenKeys lists the heisenbridge.yaml keys holding the
+// appservice tokens. They are stored in the Heisenbridge Secret.
+var heisenbridgeTokenKeys = []string{
+	"as_token",
+	"hs_token",
+}
+
+// secretForHeisenbridge returns a function of type createResourceFunc, to be
+// passed as an argument in a call to reconcileResource.
+//
+// The returned function generates a Secret containing a random value for
+// each of the heisenbridgeTokenKeys, and the heisenbridge.yaml of the given
+// ConfigMap completed with these tokens. The Secret is mounted in the
+// Synapse and Heisenbridge pods, so that the tokens never appear in a
+// ConfigMap. The tokens of the existing Secret are kept, unless a rotation
+// has been requested via the rotateHeisenbridgeTokensAnnotation.
+func (r *SynapseReconciler) secretForHeisenbridge(ctx context.Context, configMap corev1.ConfigMap) createResourceFunc {
+	return func(s *synapsev1alpha1.Synapse, objectMeta metav1.ObjectMeta) (client.Object, error) {
+		existingSecret, err := r.getExistingSecret(ctx, objectMeta)
+		if err != nil {
+			return &corev1.Secret{}, err
+		}
+
+		rotation := s.Annotations[rotateHeisenbridgeTokensAnnotation]
+		keepTokens := existingSecret.Annotations[rotateHeisenbridgeTokensAnnotation] == rotation
+
+		data := map[string][]byte{}
+		for _, key := range heisenbridgeTokenKeys {
+			if value := existingSecret.Data[key]; len(value) != 0 && keepTokens {
+				data[key] = value
+				continue
+			}
+
+			value, err := generateRandomString(64)
+			if err != nil {
+				return &corev1.Secret{}, err
+			}
+			data[key] = []byte(value)
+		}
+
+		heisenbridgeYaml, err := r.loadFileFromConfigMapData(configMap, "heisenbridge.yaml")
+		if err != nil {
+			return &corev1.Secret{}, err
+		}
+		data["heisenbridge.yaml"] = []byte(heisenbridgeYaml)
+
+		secret := &corev1.Secret{
+			ObjectMeta: objectMeta,
+			Type:       corev1.SecretTypeOpaque,
+			Data:       data,
+		}
+
+		// Configure the tokens in heisenbridge.yaml
+		if err := r.updateSecretData(secret, *s, r.updateHeisenbridgeWithTokens(*secret), "heisenbridge.yaml"); err != nil {
+			return &corev1.Secret{}, err
+		}
+
+		// Record the rotation the tokens correspond to
+		setAnnotation(&secret.ObjectMeta, rotateHeisenbridgeTokensAnnotation, rotation)
+
+		// Set Synapse instance as the owner and controller
+		if err := ctrl.SetControllerReference(s, secret, r.Scheme); err != nil {
+			return &corev1.Secret{}, err
+		}
+
+		return secret, nil
+	}
+}
+
+// updateHeisenbridgeWithTokens returns a function of type updateDataFunc, to
+// be passed as an argument in a call to withSecretUpdates.
+//
+// The returned function writes the tokens found in the given Secret in
+// heisenbridge.yaml. Tokens defined in a user-provided heisenbridge.yaml are
+// overwritten, so that each Heisenbridge uses its own tokens.
+func (r *SynapseReconciler) updateHeisenbridgeWithTokens(secret corev1.Secret) updateDataFunc {
+	return func(s synapsev1alpha1.Synapse, heisenbridge map[string]interface{}) error {
+		for _, key := range heisenbridgeTokenKeys {
+			if len(secret.Data[key]) == 0 {
+				return errors.New("missing " + key + " in Secret " + secret.Name)
+			}
+			heisenbridge[key] = string(secret.Data[key])
+		}
+		return nil
+	}
+}
+
+// updateHeisenbridgeWithoutTokens is a function of type updateDataFunc, to be
+// passed as an argument in a call to withConfigMapUpdates.
+//
+// It removes the tokens from heisenbridge.yaml. The tokens of a
+// user-provided heisenbridge.yaml are replaced by generated tokens, which are
+// only written in the Heisenbridge Secret.
+func (r *SynapseReconciler) updateHeisenbridgeWithoutTokens(s synapsev1alpha1.Synapse, heisenbridge map[string]interface{}) error {
+	for _, key := range heisenbridgeTokenKeys {
+		delete(heisenbridge, key)
+	}
+	return nil
+}
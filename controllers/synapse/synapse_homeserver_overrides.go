@@ -0,0 +1,278 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package synapse
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+
+	synapsev1alpha1 "github.com/opdev/synapse-operator/apis/synapse/v1alpha1"
+)
+
+// homeserverOverrides holds the homeserver.yaml fragments defined in
+// Spec.Homeserver.Overrides. Fragments read from a Secret are kept apart, as
+// they are rendered in homeserver-secrets.yaml instead of the Synapse
+// ConfigMap.
+type homeserverOverrides struct {
+	config  []map[string]interface{}
+	secrets []map[string]interface{}
+}
+
+// secretKeys returns the top-level keys set by the fragments read from a
+// Secret, except for the given managed keys which can't be overridden.
+func (o homeserverOverrides) secretKeys(managedKeys []string) []string {
+	var keys []string
+	for _, fragment := range o.secrets {
+		for key := range fragment {
+			if !containsString(managedKeys, key) && !containsString(keys, key) {
+				keys = append(keys, key)
+			}
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// fetchHomeserverOverrides loads the homeserver.yaml fragments defined in
+// Spec.Homeserver.Overrides, in order. An error is returned if a referenced
+// ConfigMap or Secret doesn't exist, or if a fragment is not a valid YAML
+// document or doesn't match the schema of homeserver.yaml.
+func (r *SynapseReconciler) fetchHomeserverOverrides(
+	ctx context.Context,
+	s synapsev1alpha1.Synapse,
+) (homeserverOverrides, error) {
+	var overrides homeserverOverrides
+
+	for i, override := range s.Spec.Homeserver.Overrides {
+		var fragment map[string]interface{}
+		var err error
+
+		switch {
+		case override.ConfigMap != nil:
+			var cm corev1.ConfigMap
+			key := types.NamespacedName{
+				Name:      override.ConfigMap.Name,
+				Namespace: r.getConfigMapNamespace(s, override.ConfigMap.Namespace),
+			}
+			if err := r.Get(ctx, key, &cm); err != nil {
+				return homeserverOverrides{}, err
+			}
+			fragment, err = r.loadYAMLFileFromConfigMapData(cm, override.ConfigMap.Key)
+		case override.Secret != nil:
+			var secret corev1.Secret
+			key := types.NamespacedName{Name: override.Secret.Name, Namespace: s.Namespace}
+			if err := r.Get(ctx, key, &secret); err != nil {
+				return homeserverOverrides{}, err
+			}
+			fragment, err = r.loadYAMLFileFromSecretData(secret, override.Secret.Key)
+		default:
+			fragment = map[string]interface{}{}
+			err = yaml.Unmarshal([]byte(override.Inline), fragment)
+		}
+
+		if err != nil {
+			return homeserverOverrides{}, fmt.Errorf("invalid homeserver.yaml override %d: %w", i, err)
+		}
+
+		// Overrides are meant to set options which are unknown to the
+		// Synapse Operator, so only errors are reported.
+		path := field.NewPath("spec", "homeserver", "overrides").Index(i)
+		if errs, _ := validateHomeserverFragment(fragment, path); len(errs) != 0 {
+			return homeserverOverrides{}, fmt.Errorf("invalid homeserver.yaml override %d: %w", i, errs.ToAggregate())
+		}
+
+		if override.Secret != nil {
+			overrides.secrets = append(overrides.secrets, fragment)
+		} else {
+			overrides.config = append(overrides.config, fragment)
+		}
+	}
+
+	return overrides, nil
+}
+
+// homeserverManagedKeys returns the top-level homeserver.yaml keys managed
+// by the Synapse Operator, which can't be overridden.
+func (r *SynapseReconciler) homeserverManagedKeys(s synapsev1alpha1.Synapse) []string {
+	// The server name and report stats values are used to configure the
+	// Synapse Deployment, which also mounts the signing key
+	managedKeys := []string{"server_name", "report_stats", "signing_key_path"}
+
+	// Old signing keys are recorded in the generated signing key Secret
+	if s.Spec.Homeserver.SigningKeySecret == nil {
+		managedKeys = append(managedKeys, "old_signing_keys")
+	}
+
+	if s.Spec.CreateNewPostgreSQL {
+		managedKeys = append(managedKeys, "database")
+	}
+
+	if s.Spec.Bridges.Heisenbridge.Enabled {
+		managedKeys = append(managedKeys, "app_service_config_files")
+	}
+
+	if isLogConfigManaged(s) {
+		managedKeys = append(managedKeys, "log_config")
+	}
+
+	if s.Spec.Ingress != nil {
+		managedKeys = append(managedKeys, "public_baseurl")
+	}
+
+	if isRouteReencrypt(s) {
+		managedKeys = append(managedKeys, "tls_certificate_path", "tls_private_key_path")
+	}
+
+	if s.Spec.Federation != nil {
+		managedKeys = append(managedKeys, "federation_domain_whitelist")
+		if len(s.Spec.Federation.IPRangeBlacklist) != 0 {
+			managedKeys = append(managedKeys, "ip_range_blacklist")
+		}
+		if isFederationListenerEnabled(s) && s.Spec.Federation.TLSSecretName != "" {
+			managedKeys = append(managedKeys, "tls_certificate_path", "tls_private_key_path")
+		}
+	}
+
+	if isWellKnownServedBySynapse(s) {
+		managedKeys = append(managedKeys, "serve_server_wellknown")
+		if s.Spec.WellKnown.IdentityServerBaseURL != "" {
+			managedKeys = append(managedKeys, "default_identity_server")
+		}
+	}
+
+	if len(s.Spec.Workers) != 0 {
+		managedKeys = append(managedKeys, workerManagedKeys(s)...)
+	}
+
+	// See updateHomeserverWithSecrets
+	if s.Spec.Homeserver.ConfigMap == nil || s.Spec.Homeserver.Secret != nil {
+		managedKeys = append(managedKeys, homeserverSecretKeys...)
+	}
+
+	return managedKeys
+}
+
+// updateHomeserverWithOverrides returns a function of type updateDataFunc,
+// to be passed as an argument in a call to withConfigMapUpdates or
+// withSecretUpdates.
+//
+// The returned function deep-merges the given overrides, in order, in
+// homeserver.yaml or homeserver-secrets.yaml. Keys managed by the Synapse
+// Operator are left untouched, and saved in the given conflicts map.
+func (r *SynapseReconciler) updateHomeserverWithOverrides(
+	overrides []map[string]interface{},
+	conflicts map[string]bool,
+) updateDataFunc {
+	return func(s synapsev1alpha1.Synapse, homeserver map[string]interface{}) error {
+		managedKeys := r.homeserverManagedKeys(s)
+
+		for _, override := range overrides {
+			for key, value := range override {
+				if containsString(managedKeys, key) {
+					conflicts[key] = true
+					continue
+				}
+				homeserver[key] = mergeYAMLValues(homeserver[key], value)
+			}
+		}
+		return nil
+	}
+}
+
+// setOverridesCondition reports the keys of the overrides conflicting with
+// the keys managed by the Synapse Operator in the OverridesApplied condition.
+func (r *SynapseReconciler) setOverridesCondition(synapse *synapsev1alpha1.Synapse, conflicts map[string]bool) {
+	if len(conflicts) == 0 {
+		r.setStatusCondition(
+			synapse,
+			synapsev1alpha1.ConditionTypeOverridesApplied,
+			metav1.ConditionTrue,
+			reasonOverridesApplied,
+			"All homeserver.yaml overrides are applied",
+		)
+		return
+	}
+
+	var conflictingKeys []string
+	for key := range conflicts {
+		conflictingKeys = append(conflictingKeys, key)
+	}
+	sort.Strings(conflictingKeys)
+
+	r.setStatusCondition(
+		synapse,
+		synapsev1alpha1.ConditionTypeOverridesApplied,
+		metav1.ConditionFalse,
+		reasonOverrideConflict,
+		"Keys managed by the Synapse Operator can't be overridden: "+strings.Join(conflictingKeys, ", "),
+	)
+}
+
+// mergeYAMLValues deep-merges the override value into the base value. Maps
+// are merged key by key, while any other value, including lists, is
+// replaced by the override.
+func mergeYAMLValues(base interface{}, override interface{}) interface{} {
+	baseMap, baseIsMap := toYAMLMap(base)
+	overrideMap, overrideIsMap := toYAMLMap(override)
+	if !baseIsMap || !overrideIsMap {
+		return override
+	}
+
+	merged := map[interface{}]interface{}{}
+	for key, value := range baseMap {
+		merged[key] = value
+	}
+	for key, value := range overrideMap {
+		merged[key] = mergeYAMLValues(merged[key], value)
+	}
+	return merged
+}
+
+// toYAMLMap converts the maps produced by yaml.Unmarshal, which are either
+// map[string]interface{} at the top level or map[interface{}]interface{}
+// when nested, to a map[interface{}]interface{}.
+func toYAMLMap(value interface{}) (map[interface{}]interface{}, bool) {
+	switch m := value.(type) {
+	case map[interface{}]interface{}:
+		return m, true
+	case map[string]interface{}:
+		converted := map[interface{}]interface{}{}
+		for key, value := range m {
+			converted[key] = value
+		}
+		return converted, true
+	default:
+		return nil, false
+	}
+}
+
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
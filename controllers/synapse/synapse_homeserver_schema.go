@@ -0,0 +1,520 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package synapse
+
+import (
+	"fmt"
+	"sort"
+
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// homeserverOptionType is the expected type of a homeserver.yaml option.
+type homeserverOptionType int
+
+const (
+	// Any value is accepted
+	optionAny homeserverOptionType = iota
+	optionString
+	optionBool
+	optionInt
+	// Integer or floating point number
+	optionNumber
+	// Size or duration, either given as an integer or as a string with a
+	// unit (e.g. "50M" or "1d")
+	optionStringOrInt
+	optionList
+	optionMap
+)
+
+func (t homeserverOptionType) String() string {
+	return [...]string{"any", "string", "boolean", "integer", "number", "string or integer", "list", "mapping"}[t]
+}
+
+// homeserverOption describes a homeserver.yaml option. Null values are
+// always accepted, as Synapse then uses its default value.
+type homeserverOption struct {
+	Type homeserverOptionType
+
+	// Accepted values, for options of type optionString
+	Enum []string
+
+	// Schema of the list items, for options of type optionList
+	Items *homeserverOption
+
+	// Known keys, for options of type optionMap. Keys of a map with no
+	// Fields are not checked.
+	Fields map[string]homeserverOption
+}
+
+var (
+	anyOption         = homeserverOption{Type: optionAny}
+	stringOption      = homeserverOption{Type: optionString}
+	boolOption        = homeserverOption{Type: optionBool}
+	intOption         = homeserverOption{Type: optionInt}
+	stringOrIntOption = homeserverOption{Type: optionStringOrInt}
+	mapOption         = homeserverOption{Type: optionMap}
+	stringListOption  = homeserverOption{Type: optionList, Items: &stringOption}
+
+	rateLimitOption = homeserverOption{Type: optionMap, Fields: map[string]homeserverOption{
+		"per_second":  {Type: optionNumber},
+		"burst_count": {Type: optionNumber},
+	}}
+)
+
+// homeserverSchema lists the options known by Synapse in homeserver.yaml.
+// Sections which are not detailed here are only checked for their type.
+var homeserverSchema = homeserverOption{Type: optionMap, Fields: map[string]homeserverOption{
+	// Server
+	"server_name":            stringOption,
+	"public_baseurl":         stringOption,
+	"serve_server_wellknown": boolOption,
+	"pid_file":               stringOption,
+	"web_client_location":    stringOption,
+	"soft_file_limit":        intOption,
+	"presence": {Type: optionMap, Fields: map[string]homeserverOption{
+		"enabled":         anyOption,
+		"presence_router": mapOption,
+	}},
+	"require_auth_for_profile_requests":               boolOption,
+	"limit_profile_requests_to_users_who_share_rooms": boolOption,
+	"include_profile_data_on_invite":                  boolOption,
+	"allow_public_rooms_without_auth":                 boolOption,
+	"allow_public_rooms_over_federation":              boolOption,
+	"default_room_version":                            stringOption,
+	"gc_thresholds":                                   {Type: optionList, Items: &intOption},
+	"gc_min_interval":                                 {Type: optionList, Items: &stringOrIntOption},
+	"filter_timeline_limit":                           intOption,
+	"block_non_admin_invites":                         boolOption,
+	"enable_search":                                   boolOption,
+	"ip_range_blacklist":                              stringListOption,
+	"ip_range_whitelist":                              stringListOption,
+	"listeners":                                       {Type: optionList, Items: &listenerOption},
+	"manhole_settings":                                mapOption,
+	"dummy_events_threshold":                          intOption,
+	"limit_remote_rooms":                              mapOption,
+	"require_membership_for_aliases":                  boolOption,
+	"allow_per_room_profiles":                         boolOption,
+	"max_avatar_size":                                 stringOrIntOption,
+	"allowed_avatar_mimetypes":                        stringListOption,
+	"redaction_retention_period":                      stringOrIntOption,
+	"user_ips_max_age":                                stringOrIntOption,
+	"request_token_inhibit_3pid_errors":               boolOption,
+	"next_link_domain_whitelist":                      stringListOption,
+	"templates":                                       mapOption,
+	"retention":                                       retentionOption,
+	"forget_rooms_on_leave":                           boolOption,
+	"exclude_rooms_from_sync":                         stringListOption,
+	"delete_stale_devices_after":                      stringOrIntOption,
+	"admin_contact":                                   stringOption,
+	"hs_disabled":                                     boolOption,
+	"hs_disabled_message":                             stringOption,
+	"limit_usage_by_mau":                              boolOption,
+	"max_mau_value":                                   intOption,
+	"mau_trial_days":                                  intOption,
+	"mau_limit_alerting":                              boolOption,
+	"mau_stats_only":                                  boolOption,
+	"mau_limit_reserved_threepids":                    {Type: optionList},
+	"server_context":                                  stringOption,
+	"user_consent":                                    mapOption,
+	"room_prejoin_state":                              mapOption,
+	"experimental_features":                           mapOption,
+	"modules":                                         {Type: optionList},
+	"acme":                                            mapOption,
+	"account_validity":                                mapOption,
+
+	// TLS
+	"tls_certificate_path":                          stringOption,
+	"tls_private_key_path":                          stringOption,
+	"federation_certificate_verification_whitelist": stringListOption,
+	"federation_custom_ca_list":                     stringListOption,
+	"federation_verify_certificates":                boolOption,
+	"federation_client_minimum_tls_version":         anyOption,
+
+	// Federation
+	"federation_domain_whitelist":              stringListOption,
+	"federation_ip_range_blacklist":            stringListOption,
+	"federation_metrics_domains":               stringListOption,
+	"allow_profile_lookup_over_federation":     boolOption,
+	"allow_device_name_lookup_over_federation": boolOption,
+	"federation": mapOption,
+
+	// Caching
+	"caches":           mapOption,
+	"event_cache_size": stringOrIntOption,
+
+	// Database
+	"database": {Type: optionMap, Fields: map[string]homeserverOption{
+		"name":                {Type: optionString, Enum: []string{"sqlite3", "psycopg2"}},
+		"args":                mapOption,
+		"allow_unsafe_locale": boolOption,
+		"txn_limit":           intOption,
+	}},
+	"databases": mapOption,
+
+	// Logging
+	"log_config": stringOption,
+
+	// Rate limits
+	"rc_message":                     rateLimitOption,
+	"rc_registration":                rateLimitOption,
+	"rc_registration_token_validity": rateLimitOption,
+	"rc_login":                       {Type: optionMap, Fields: map[string]homeserverOption{"address": rateLimitOption, "account": rateLimitOption, "failed_attempts": rateLimitOption}},
+	"rc_admin_redaction":             rateLimitOption,
+	"rc_joins":                       {Type: optionMap, Fields: map[string]homeserverOption{"local": rateLimitOption, "remote": rateLimitOption}},
+	"rc_joins_per_room":              rateLimitOption,
+	"rc_3pid_validation":             rateLimitOption,
+	"rc_invites":                     {Type: optionMap, Fields: map[string]homeserverOption{"per_room": rateLimitOption, "per_user": rateLimitOption, "per_issuer": rateLimitOption}},
+	"rc_third_party_invite":          rateLimitOption,
+	"rc_federation":                  mapOption,
+	"federation_rr_transactions_per_room_per_second": intOption,
+
+	// Media
+	"enable_media_repo":              boolOption,
+	"media_store_path":               stringOption,
+	"media_storage_providers":        {Type: optionList},
+	"max_upload_size":                stringOrIntOption,
+	"max_image_pixels":               stringOrIntOption,
+	"dynamic_thumbnails":             boolOption,
+	"thumbnail_sizes":                {Type: optionList},
+	"media_retention":                mapOption,
+	"url_preview_enabled":            boolOption,
+	"url_preview_ip_range_blacklist": stringListOption,
+	"url_preview_ip_range_whitelist": stringListOption,
+	"url_preview_url_blacklist":      {Type: optionList},
+	"max_spider_size":                stringOrIntOption,
+	"url_preview_accept_language":    stringListOption,
+	"oembed":                         mapOption,
+
+	// Captcha and TURN
+	"recaptcha_public_key":        stringOption,
+	"recaptcha_private_key":       stringOption,
+	"enable_registration_captcha": boolOption,
+	"recaptcha_siteverify_api":    stringOption,
+	"turn_uris":                   stringListOption,
+	"turn_shared_secret":          stringOption,
+	"turn_username":               stringOption,
+	"turn_password":               stringOption,
+	"turn_user_lifetime":          stringOrIntOption,
+	"turn_allow_guests":           boolOption,
+
+	// Registration
+	"enable_registration":                      boolOption,
+	"enable_registration_without_verification": boolOption,
+	"registrations_require_3pid":               stringListOption,
+	"disable_msisdn_registration":              boolOption,
+	"allowed_local_3pids":                      {Type: optionList},
+	"enable_3pid_lookup":                       boolOption,
+	"registration_requires_token":              boolOption,
+	"registration_shared_secret":               stringOption,
+	"bcrypt_rounds":                            intOption,
+	"allow_guest_access":                       boolOption,
+	"default_identity_server":                  stringOption,
+	"account_threepid_delegates":               mapOption,
+	"enable_set_displayname":                   boolOption,
+	"enable_set_avatar_url":                    boolOption,
+	"enable_3pid_changes":                      boolOption,
+	"auto_join_rooms":                          stringListOption,
+	"autocreate_auto_join_rooms":               boolOption,
+	"auto_join_rooms_for_guests":               boolOption,
+	"inhibit_user_in_use_error":                boolOption,
+	"session_lifetime":                         stringOrIntOption,
+	"ui_auth":                                  mapOption,
+
+	// Metrics
+	"enable_metrics":        boolOption,
+	"metrics_flags":         mapOption,
+	"report_stats":          boolOption,
+	"report_stats_endpoint": stringOption,
+
+	// Application services
+	"app_service_config_files":  stringListOption,
+	"track_appservice_user_ips": boolOption,
+
+	// Signing keys
+	"macaroon_secret_key":          stringOption,
+	"form_secret":                  stringOption,
+	"signing_key_path":             stringOption,
+	"old_signing_keys":             mapOption,
+	"key_refresh_interval":         stringOrIntOption,
+	"trusted_key_servers":          {Type: optionList, Items: &trustedKeyServerOption},
+	"suppress_key_server_warning":  boolOption,
+	"key_server_signing_keys_path": stringOption,
+
+	// Single sign-on and authentication
+	"saml2_config":       mapOption,
+	"oidc_providers":     {Type: optionList},
+	"cas_config":         mapOption,
+	"sso":                mapOption,
+	"jwt_config":         mapOption,
+	"password_config":    mapOption,
+	"password_providers": {Type: optionList},
+	"spam_checker":       anyOption,
+
+	// Push, rooms and user directory
+	"push": mapOption,
+	"encryption_enabled_by_default_for_room_type": {Type: optionString, Enum: []string{"all", "invite", "off"}},
+	"user_directory":              mapOption,
+	"stats":                       mapOption,
+	"server_notices":              mapOption,
+	"enable_room_list_search":     boolOption,
+	"alias_creation_rules":        {Type: optionList},
+	"room_list_publication_rules": {Type: optionList},
+	"opentracing":                 mapOption,
+
+	// Workers
+	"worker_app":                             stringOption,
+	"send_federation":                        boolOption,
+	"federation_sender_instances":            stringListOption,
+	"instance_map":                           mapOption,
+	"stream_writers":                         mapOption,
+	"run_background_tasks_on":                stringOption,
+	"update_user_directory_from_worker":      stringOption,
+	"notify_appservices_from_worker":         stringOption,
+	"media_instance_running_background_jobs": stringOption,
+	"start_pushers":                          boolOption,
+	"pusher_instances":                       stringListOption,
+	"worker_replication_secret":              stringOption,
+	"redis": {Type: optionMap, Fields: map[string]homeserverOption{
+		"enabled":  boolOption,
+		"host":     stringOption,
+		"port":     intOption,
+		"password": stringOption,
+		"dbid":     intOption,
+	}},
+	"background_updates": mapOption,
+
+	// Email
+	"email": mapOption,
+}}
+
+var listenerOption = homeserverOption{Type: optionMap, Fields: map[string]homeserverOption{
+	"port":              intOption,
+	"bind_addresses":    stringListOption,
+	"type":              {Type: optionString, Enum: []string{"http", "manhole", "metrics", "replication"}},
+	"tls":               boolOption,
+	"x_forwarded":       boolOption,
+	"tag":               stringOption,
+	"request_id_header": stringOption,
+	"path":              stringOption,
+	"mode":              anyOption,
+	"resources": {Type: optionList, Items: &homeserverOption{Type: optionMap, Fields: map[string]homeserverOption{
+		"names": {Type: optionList, Items: &homeserverOption{Type: optionString, Enum: []string{
+			"client", "consent", "federation", "health", "keys", "media", "metrics", "openid", "replication", "static",
+		}}},
+		"compress": boolOption,
+	}}},
+	"additional_resources": mapOption,
+}}
+
+var trustedKeyServerOption = homeserverOption{Type: optionMap, Fields: map[string]homeserverOption{
+	"server_name":            stringOption,
+	"verify_keys":            mapOption,
+	"accept_keys_insecurely": boolOption,
+}}
+
+var retentionOption = homeserverOption{Type: optionMap, Fields: map[string]homeserverOption{
+	"enabled": boolOption,
+	"default_policy": {Type: optionMap, Fields: map[string]homeserverOption{
+		"min_lifetime": stringOrIntOption,
+		"max_lifetime": stringOrIntOption,
+	}},
+	"allowed_lifetime_min": stringOrIntOption,
+	"allowed_lifetime_max": stringOrIntOption,
+	"purge_jobs":           {Type: optionList},
+}}
+
+// validateHomeserverConfig checks the content of a complete homeserver.yaml
+// against the schema of the options known by Synapse, and against the
+// combinations of options required by Synapse to start.
+//
+// Errors are reported with the YAML path of the faulty option. Unknown
+// options are not considered as errors, but returned as warnings.
+func validateHomeserverConfig(homeserver map[string]interface{}) (field.ErrorList, []string) {
+	errs, warnings := validateHomeserverFragment(homeserver, nil)
+	errs = append(errs, validateHomeserverRequirements(homeserver)...)
+	return errs, warnings
+}
+
+// validateHomeserverFragment checks a homeserver.yaml fragment against the
+// schema of the options known by Synapse. Paths are reported relative to
+// the given root path, or to the root of homeserver.yaml if nil.
+func validateHomeserverFragment(fragment map[string]interface{}, root *field.Path) (field.ErrorList, []string) {
+	values := map[interface{}]interface{}{}
+	for key, value := range fragment {
+		values[key] = value
+	}
+
+	var warnings []string
+	errs := validateHomeserverOption(homeserverSchema, values, root, &warnings)
+	return errs, warnings
+}
+
+func validateHomeserverOption(
+	option homeserverOption,
+	value interface{},
+	path *field.Path,
+	warnings *[]string,
+) field.ErrorList {
+	var errs field.ErrorList
+
+	if value == nil {
+		return errs
+	}
+
+	switch option.Type {
+	case optionString:
+		s, ok := value.(string)
+		if !ok {
+			return append(errs, field.Invalid(path, value, "must be a "+option.Type.String()))
+		}
+		if len(option.Enum) != 0 && !containsString(option.Enum, s) {
+			errs = append(errs, field.NotSupported(path, s, option.Enum))
+		}
+	case optionBool:
+		if _, ok := value.(bool); !ok {
+			errs = append(errs, field.Invalid(path, value, "must be a "+option.Type.String()))
+		}
+	case optionInt:
+		if _, ok := value.(int); !ok {
+			errs = append(errs, field.Invalid(path, value, "must be an "+option.Type.String()))
+		}
+	case optionNumber:
+		switch value.(type) {
+		case int, float64:
+		default:
+			errs = append(errs, field.Invalid(path, value, "must be a "+option.Type.String()))
+		}
+	case optionStringOrInt:
+		switch value.(type) {
+		case int, string:
+		default:
+			errs = append(errs, field.Invalid(path, value, "must be a "+option.Type.String()))
+		}
+	case optionList:
+		items, ok := value.([]interface{})
+		if !ok {
+			return append(errs, field.Invalid(path, value, "must be a "+option.Type.String()))
+		}
+		if option.Items != nil {
+			for i, item := range items {
+				errs = append(errs, validateHomeserverOption(*option.Items, item, path.Index(i), warnings)...)
+			}
+		}
+	case optionMap:
+		values, ok := toYAMLMap(value)
+		if !ok {
+			return append(errs, field.Invalid(path, value, "must be a "+option.Type.String()))
+		}
+		if option.Fields == nil {
+			return errs
+		}
+
+		// Keys are sorted so that errors and warnings are reported in a
+		// stable order
+		var keys []string
+		for key := range values {
+			keys = append(keys, fmt.Sprint(key))
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			childPath := field.NewPath(key)
+			if path != nil {
+				childPath = path.Child(key)
+			}
+
+			fieldOption, known := option.Fields[key]
+			if !known {
+				*warnings = append(*warnings, childPath.String()+": unknown option")
+				continue
+			}
+			errs = append(errs, validateHomeserverOption(fieldOption, values[key], childPath, warnings)...)
+		}
+	}
+
+	return errs
+}
+
+// validateHomeserverRequirements checks the combinations of options which
+// Synapse requires to start. Options of the wrong type are already reported
+// by validateHomeserverFragment and are ignored here.
+func validateHomeserverRequirements(homeserver map[string]interface{}) field.ErrorList {
+	var errs field.ErrorList
+
+	if listeners, ok := homeserver["listeners"].([]interface{}); ok {
+		for i, item := range listeners {
+			listener, ok := toYAMLMap(item)
+			if !ok {
+				continue
+			}
+			path := field.NewPath("listeners").Index(i)
+
+			if listener["port"] == nil && listener["path"] == nil {
+				errs = append(errs, field.Required(path.Child("port"), "a listener must define a port"))
+			}
+
+			if tls, _ := listener["tls"].(bool); tls {
+				for _, key := range []string{"tls_certificate_path", "tls_private_key_path"} {
+					if homeserver[key] == nil {
+						errs = append(errs, field.Required(field.NewPath(key), "required when "+path.Child("tls").String()+" is true"))
+					}
+				}
+			}
+		}
+	}
+
+	if database, ok := toYAMLMap(homeserver["database"]); ok {
+		if database["name"] == "psycopg2" && database["args"] == nil {
+			errs = append(errs, field.Required(field.NewPath("database", "args"), "required when database.name is psycopg2"))
+		}
+	}
+
+	if enabled, _ := homeserver["enable_registration"].(bool); enabled {
+		withoutVerification, _ := homeserver["enable_registration_without_verification"].(bool)
+		requiresToken, _ := homeserver["registration_requires_token"].(bool)
+		captcha, _ := homeserver["enable_registration_captcha"].(bool)
+		requires3pid, _ := homeserver["registrations_require_3pid"].([]interface{})
+
+		if !withoutVerification && !requiresToken && !captcha && len(requires3pid) == 0 {
+			errs = append(errs, field.Forbidden(
+				field.NewPath("enable_registration"),
+				"open registration requires either enable_registration_without_verification, "+
+					"registration_requires_token, enable_registration_captcha or registrations_require_3pid",
+			))
+		}
+	}
+
+	if captcha, _ := homeserver["enable_registration_captcha"].(bool); captcha {
+		for _, key := range []string{"recaptcha_public_key", "recaptcha_private_key"} {
+			if homeserver[key] == nil {
+				errs = append(errs, field.Required(field.NewPath(key), "required when enable_registration_captcha is true"))
+			}
+		}
+	}
+
+	if enabled, _ := homeserver["url_preview_enabled"].(bool); enabled {
+		if homeserver["url_preview_ip_range_blacklist"] == nil {
+			errs = append(errs, field.Required(
+				field.NewPath("url_preview_ip_range_blacklist"),
+				"required when url_preview_enabled is true",
+			))
+		}
+	}
+
+	return errs
+}
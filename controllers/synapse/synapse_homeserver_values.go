@@ -0,0 +1,296 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package synapse
+
+import (
+	"strconv"
+
+	synapsev1alpha1 "github.com/opdev/synapse-operator/apis/synapse/v1alpha1"
+)
+
+// HomeserverConfig holds the content of the homeserver.yaml rendered from
+// Spec.Homeserver.Values. Options which are not set are omitted, in which
+// case Synapse falls back to its own default values.
+type HomeserverConfig struct {
+	ServerName     string                       `yaml:"server_name"`
+	PublicBaseURL  string                       `yaml:"public_baseurl,omitempty"`
+	PidFile        string                       `yaml:"pid_file"`
+	Listeners      []HomeserverListener         `yaml:"listeners"`
+	Database       HomeserverSqliteDatabase     `yaml:"database"`
+	LogConfig      string                       `yaml:"log_config"`
+	MediaStorePath string                       `yaml:"media_store_path"`
+	ReportStats    bool                         `yaml:"report_stats"`
+	SigningKeyPath string                       `yaml:"signing_key_path"`
+	KeyServers     []HomeserverTrustedKeyServer `yaml:"trusted_key_servers"`
+
+	// Registration
+	EnableRegistration                    *bool    `yaml:"enable_registration,omitempty"`
+	EnableRegistrationWithoutVerification *bool    `yaml:"enable_registration_without_verification,omitempty"`
+	RegistrationRequiresToken             *bool    `yaml:"registration_requires_token,omitempty"`
+	AllowGuestAccess                      *bool    `yaml:"allow_guest_access,omitempty"`
+	AutoJoinRooms                         []string `yaml:"auto_join_rooms,omitempty"`
+
+	// Federation. An empty federation_domain_whitelist disables federation.
+	FederationDomainWhitelist      *[]string `yaml:"federation_domain_whitelist,omitempty"`
+	AllowPublicRoomsOverFederation *bool     `yaml:"allow_public_rooms_over_federation,omitempty"`
+
+	// Rate limits
+	RcMessage      *HomeserverRateLimit      `yaml:"rc_message,omitempty"`
+	RcRegistration *HomeserverRateLimit      `yaml:"rc_registration,omitempty"`
+	RcLogin        *HomeserverLoginRateLimit `yaml:"rc_login,omitempty"`
+
+	// Media
+	MaxUploadSize     string `yaml:"max_upload_size,omitempty"`
+	MaxImagePixels    string `yaml:"max_image_pixels,omitempty"`
+	DynamicThumbnails *bool  `yaml:"dynamic_thumbnails,omitempty"`
+
+	// URL previews
+	URLPreviewEnabled          *bool    `yaml:"url_preview_enabled,omitempty"`
+	URLPreviewIPRangeBlacklist []string `yaml:"url_preview_ip_range_blacklist,omitempty"`
+	MaxSpiderSize              string   `yaml:"max_spider_size,omitempty"`
+
+	Presence  *HomeserverPresence  `yaml:"presence,omitempty"`
+	Retention *HomeserverRetention `yaml:"retention,omitempty"`
+}
+
+type HomeserverListener struct {
+	Port          int32                        `yaml:"port"`
+	BindAddresses []string                     `yaml:"bind_addresses,omitempty"`
+	Type          string                       `yaml:"type"`
+	TLS           bool                         `yaml:"tls"`
+	XForwarded    bool                         `yaml:"x_forwarded"`
+	Resources     []HomeserverListenerResource `yaml:"resources,omitempty"`
+}
+
+type HomeserverListenerResource struct {
+	Names    []string `yaml:"names"`
+	Compress bool     `yaml:"compress"`
+}
+
+type HomeserverSqliteDatabase struct {
+	Name string `yaml:"name"`
+	Args struct {
+		Database string `yaml:"database"`
+	} `yaml:"args"`
+}
+
+type HomeserverTrustedKeyServer struct {
+	ServerName string `yaml:"server_name"`
+}
+
+type HomeserverRateLimit struct {
+	PerSecond  float64 `yaml:"per_second"`
+	BurstCount int32   `yaml:"burst_count"`
+}
+
+type HomeserverLoginRateLimit struct {
+	Address *HomeserverRateLimit `yaml:"address"`
+	Account *HomeserverRateLimit `yaml:"account"`
+}
+
+type HomeserverPresence struct {
+	Enabled bool `yaml:"enabled"`
+}
+
+type HomeserverRetention struct {
+	Enabled            bool                       `yaml:"enabled"`
+	DefaultPolicy      *HomeserverRetentionPolicy `yaml:"default_policy,omitempty"`
+	AllowedLifetimeMin string                     `yaml:"allowed_lifetime_min,omitempty"`
+	AllowedLifetimeMax string                     `yaml:"allowed_lifetime_max,omitempty"`
+}
+
+type HomeserverRetentionPolicy struct {
+	MinLifetime string `yaml:"min_lifetime,omitempty"`
+	MaxLifetime string `yaml:"max_lifetime,omitempty"`
+}
+
+// defaultURLPreviewIPRangeBlacklist is the list of IP ranges recommended
+// by Synapse for url_preview_ip_range_blacklist. Synapse refuses to start
+// with URL previews enabled and no blacklist.
+var defaultURLPreviewIPRangeBlacklist = []string{
+	"127.0.0.0/8",
+	"10.0.0.0/8",
+	"172.16.0.0/12",
+	"192.168.0.0/16",
+	"100.64.0.0/10",
+	"192.0.0.0/24",
+	"169.254.0.0/16",
+	"192.88.99.0/24",
+	"198.18.0.0/15",
+	"192.0.2.0/24",
+	"198.51.100.0/24",
+	"203.0.113.0/24",
+	"224.0.0.0/4",
+	"::1/128",
+	"fe80::/10",
+	"fc00::/7",
+	"2001:db8::/32",
+	"ff00::/8",
+	"fec0::/10",
+}
+
+// homeserverConfigForValues returns the homeserver.yaml configuration
+// corresponding to the given Spec.Homeserver.Values.
+func (r *SynapseReconciler) homeserverConfigForValues(values synapsev1alpha1.SynapseHomeserverValues) (HomeserverConfig, error) {
+	config := HomeserverConfig{
+		ServerName:     values.ServerName,
+		PublicBaseURL:  values.PublicBaseURL,
+		PidFile:        "/homeserver.pid",
+		Listeners:      homeserverListenersForValues(values.Listeners),
+		LogConfig:      logConfigPath,
+		MediaStorePath: "/data/media_store",
+		ReportStats:    values.ReportStats,
+		SigningKeyPath: signingKeyMountPath + "/" + signingKeyFile,
+		KeyServers:     []HomeserverTrustedKeyServer{{ServerName: "matrix.org"}},
+	}
+	config.Database.Name = "sqlite3"
+	config.Database.Args.Database = "/data/homeserver.db"
+
+	if registration := values.Registration; registration != nil {
+		config.EnableRegistration = &registration.Enabled
+		config.EnableRegistrationWithoutVerification = &registration.EnabledWithoutVerification
+		config.RegistrationRequiresToken = &registration.RequiresToken
+		config.AllowGuestAccess = &registration.AllowGuestAccess
+		config.AutoJoinRooms = registration.AutoJoinRooms
+	}
+
+	if federation := values.Federation; federation != nil {
+		if federation.Enabled != nil && !*federation.Enabled {
+			config.FederationDomainWhitelist = &[]string{}
+		} else if len(federation.DomainWhitelist) != 0 {
+			config.FederationDomainWhitelist = &federation.DomainWhitelist
+		}
+		config.AllowPublicRoomsOverFederation = &federation.AllowPublicRoomsOverFederation
+
+		if len(federation.TrustedKeyServers) != 0 {
+			config.KeyServers = []HomeserverTrustedKeyServer{}
+			for _, keyServer := range federation.TrustedKeyServers {
+				config.KeyServers = append(config.KeyServers, HomeserverTrustedKeyServer{ServerName: keyServer})
+			}
+		}
+	}
+
+	if rateLimits := values.RateLimits; rateLimits != nil {
+		var err error
+		if config.RcMessage, err = homeserverRateLimit(rateLimits.Message); err != nil {
+			return config, err
+		}
+		if config.RcRegistration, err = homeserverRateLimit(rateLimits.Registration); err != nil {
+			return config, err
+		}
+		login, err := homeserverRateLimit(rateLimits.Login)
+		if err != nil {
+			return config, err
+		}
+		if login != nil {
+			config.RcLogin = &HomeserverLoginRateLimit{Address: login, Account: login}
+		}
+	}
+
+	if media := values.Media; media != nil {
+		config.MaxUploadSize = media.MaxUploadSize
+		config.MaxImagePixels = media.MaxImagePixels
+		config.DynamicThumbnails = &media.DynamicThumbnails
+	}
+
+	if urlPreviews := values.URLPreviews; urlPreviews != nil {
+		config.URLPreviewEnabled = &urlPreviews.Enabled
+		config.MaxSpiderSize = urlPreviews.MaxSpiderSize
+		if urlPreviews.Enabled {
+			config.URLPreviewIPRangeBlacklist = urlPreviews.IPRangeBlacklist
+			if len(config.URLPreviewIPRangeBlacklist) == 0 {
+				config.URLPreviewIPRangeBlacklist = defaultURLPreviewIPRangeBlacklist
+			}
+		}
+	}
+
+	if presence := values.Presence; presence != nil && presence.Enabled != nil {
+		config.Presence = &HomeserverPresence{Enabled: *presence.Enabled}
+	}
+
+	if retention := values.Retention; retention != nil {
+		config.Retention = &HomeserverRetention{
+			Enabled:            retention.Enabled,
+			AllowedLifetimeMin: retention.AllowedLifetimeMin,
+			AllowedLifetimeMax: retention.AllowedLifetimeMax,
+		}
+		if policy := retention.DefaultPolicy; policy != nil {
+			config.Retention.DefaultPolicy = &HomeserverRetentionPolicy{
+				MinLifetime: policy.MinLifetime,
+				MaxLifetime: policy.MaxLifetime,
+			}
+		}
+	}
+
+	return config, nil
+}
+
+// homeserverListenersForValues returns the listeners section of
+// homeserver.yaml. By default, Synapse serves the client and federation APIs
+// on port 8008.
+func homeserverListenersForValues(listeners []synapsev1alpha1.SynapseListener) []HomeserverListener {
+	if len(listeners) == 0 {
+		return []HomeserverListener{{
+			Port:       8008,
+			Type:       "http",
+			TLS:        false,
+			XForwarded: true,
+			Resources: []HomeserverListenerResource{{
+				Names:    []string{"client", "federation"},
+				Compress: false,
+			}},
+		}}
+	}
+
+	var homeserverListeners []HomeserverListener
+	for _, listener := range listeners {
+		homeserverListener := HomeserverListener{
+			Port:          listener.Port,
+			BindAddresses: listener.BindAddresses,
+			Type:          listener.Type,
+			TLS:           listener.TLS,
+			XForwarded:    listener.XForwarded,
+		}
+		if homeserverListener.Type == "" {
+			homeserverListener.Type = "http"
+		}
+		for _, resource := range listener.Resources {
+			homeserverListener.Resources = append(homeserverListener.Resources, HomeserverListenerResource{
+				Names:    resource.Names,
+				Compress: resource.Compress,
+			})
+		}
+		homeserverListeners = append(homeserverListeners, homeserverListener)
+	}
+
+	return homeserverListeners
+}
+
+// homeserverRateLimit converts a rate limit of the Synapse Spec into its
+// homeserver.yaml representation.
+func homeserverRateLimit(rateLimit *synapsev1alpha1.SynapseRateLimit) (*HomeserverRateLimit, error) {
+	if rateLimit == nil {
+		return nil, nil
+	}
+
+	perSecond, err := strconv.ParseFloat(rateLimit.PerSecond, 64)
+	if err != nil {
+		return nil, err
+	}
+
+	return &HomeserverRateLimit{PerSecond: perSecond, BurstCount: rateLimit.BurstCount}, nil
+}
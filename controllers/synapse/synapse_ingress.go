@@ -0,0 +1,150 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package synapse
+
+import (
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	synapsev1alpha1 "github.com/opdev/synapse-operator/apis/synapse/v1alpha1"
+)
+
+// ingressPaths are the path prefixes of the Synapse HTTP APIs exposed by the
+// Ingress: the client and federation APIs under /_matrix, and the Synapse
+// specific client endpoints, like the password reset pages, under
+// /_synapse/client. The admin API is not exposed.
+var ingressPaths = []string{"/_matrix", "/_synapse/client"}
+
+// ingressTLSSecretName returns the name of the Secret holding the TLS
+// certificate of the Ingress.
+func ingressTLSSecretName(s synapsev1alpha1.Synapse) string {
+	if s.Spec.Ingress.TLS.SecretName != "" {
+		return s.Spec.Ingress.TLS.SecretName
+	}
+	return s.Name + "-tls"
+}
+
+// publicBaseURLForIngress returns the public base URL of Synapse, as served by
+// the Ingress.
+func publicBaseURLForIngress(s synapsev1alpha1.Synapse) string {
+	if s.Spec.Ingress.TLS != nil {
+		return "https://" + s.Spec.Ingress.Host + "/"
+	}
+	return "http://" + s.Spec.Ingress.Host + "/"
+}
+
+// ingressForSynapse returns an Ingress routing the Synapse HTTP APIs to the
+// Synapse Service. If a cert-manager issuer is configured, the corresponding
+// annotation is set so that cert-manager provisions the TLS Secret.
+func (r *SynapseReconciler) ingressForSynapse(s *synapsev1alpha1.Synapse, objectMeta metav1.ObjectMeta) (client.Object, error) {
+	pathType := networkingv1.PathTypePrefix
+	var paths []networkingv1.HTTPIngressPath
+	for _, path := range ingressPaths {
+		paths = append(paths, networkingv1.HTTPIngressPath{
+			Path:     path,
+			PathType: &pathType,
+			Backend: networkingv1.IngressBackend{
+				Service: &networkingv1.IngressServiceBackend{
+					Name: s.Name,
+					Port: networkingv1.ServiceBackendPort{Number: 8008},
+				},
+			},
+		})
+	}
+
+	ingress := &networkingv1.Ingress{
+		ObjectMeta: objectMeta,
+		Spec: networkingv1.IngressSpec{
+			IngressClassName: s.Spec.Ingress.IngressClassName,
+			Rules: []networkingv1.IngressRule{{
+				Host: s.Spec.Ingress.Host,
+				IngressRuleValue: networkingv1.IngressRuleValue{
+					HTTP: &networkingv1.HTTPIngressRuleValue{Paths: paths},
+				},
+			}},
+		},
+	}
+	hosts := []string{s.Spec.Ingress.Host}
+
+	// The .well-known documents served by Synapse must be reachable on the
+	// server_name domain
+	if isWellKnownServedBySynapse(*s) {
+		wellKnownIngressPath := networkingv1.HTTPIngressPath{
+			Path:     wellKnownPath,
+			PathType: &pathType,
+			Backend:  paths[0].Backend,
+		}
+
+		if serverHost := serverNameHost(*s); serverHost != s.Spec.Ingress.Host {
+			ingress.Spec.Rules = append(ingress.Spec.Rules, networkingv1.IngressRule{
+				Host: serverHost,
+				IngressRuleValue: networkingv1.IngressRuleValue{
+					HTTP: &networkingv1.HTTPIngressRuleValue{
+						Paths: []networkingv1.HTTPIngressPath{wellKnownIngressPath},
+					},
+				},
+			})
+			hosts = append(hosts, serverHost)
+		} else {
+			rule := ingress.Spec.Rules[0].HTTP
+			rule.Paths = append(rule.Paths, wellKnownIngressPath)
+		}
+	}
+
+	for key, value := range s.Spec.Ingress.Annotations {
+		setAnnotation(&ingress.ObjectMeta, key, value)
+	}
+
+	if tls := s.Spec.Ingress.TLS; tls != nil {
+		ingress.Spec.TLS = []networkingv1.IngressTLS{{
+			Hosts:      hosts,
+			SecretName: ingressTLSSecretName(*s),
+		}}
+
+		setIssuerAnnotation(&ingress.ObjectMeta, tls.Issuer)
+	}
+
+	// Set Synapse instance as the owner and controller
+	if err := ctrl.SetControllerReference(s, ingress, r.Scheme); err != nil {
+		return &networkingv1.Ingress{}, err
+	}
+	return ingress, nil
+}
+
+// setIssuerAnnotation sets the annotation requesting a certificate from the
+// given cert-manager issuer, if any.
+func setIssuerAnnotation(objectMeta *metav1.ObjectMeta, issuer *synapsev1alpha1.SynapseIngressIssuer) {
+	if issuer == nil {
+		return
+	}
+	if issuer.Kind == "ClusterIssuer" {
+		setAnnotation(objectMeta, "cert-manager.io/cluster-issuer", issuer.Name)
+	} else {
+		setAnnotation(objectMeta, "cert-manager.io/issuer", issuer.Name)
+	}
+}
+
+// updateHomeserverWithIngress is a function of type updateDataFunc, to be
+// passed as an argument in a call to withConfigMapUpdates.
+//
+// It sets 'public_baseurl' to the URL served by the Ingress.
+func (r *SynapseReconciler) updateHomeserverWithIngress(s synapsev1alpha1.Synapse, homeserver map[string]interface{}) error {
+	homeserver["public_baseurl"] = publicBaseURLForIngress(s)
+	return nil
+}
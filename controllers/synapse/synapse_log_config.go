@@ -0,0 +1,192 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package synapse
+
+import (
+	"errors"
+
+	"gopkg.in/yaml.v2"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	synapsev1alpha1 "github.com/opdev/synapse-operator/apis/synapse/v1alpha1"
+)
+
+// The log configuration is rendered in the Synapse ConfigMap, next to
+// homeserver.yaml, and is therefore mounted in /data-homeserver.
+const (
+	logConfigFilename = "log.config"
+	logConfigPath     = "/data-homeserver/" + logConfigFilename
+	logFilePath       = "/data/homeserver.log"
+)
+
+// LogConfig holds the content of the Python logging configuration used by
+// Synapse. See https://docs.python.org/3/library/logging.config.html
+type LogConfig struct {
+	Version                int                           `yaml:"version"`
+	Formatters             map[string]LogConfigFormatter `yaml:"formatters"`
+	Filters                map[string]map[string]string  `yaml:"filters"`
+	Handlers               map[string]LogConfigHandler   `yaml:"handlers"`
+	Loggers                map[string]LogConfigLogger    `yaml:"loggers,omitempty"`
+	Root                   LogConfigLogger               `yaml:"root"`
+	DisableExistingLoggers bool                          `yaml:"disable_existing_loggers"`
+}
+
+type LogConfigFormatter struct {
+	Class  string `yaml:"class,omitempty"`
+	Format string `yaml:"format,omitempty"`
+}
+
+type LogConfigHandler struct {
+	Class       string   `yaml:"class"`
+	Formatter   string   `yaml:"formatter"`
+	Filters     []string `yaml:"filters"`
+	Filename    string   `yaml:"filename,omitempty"`
+	When        string   `yaml:"when,omitempty"`
+	BackupCount int      `yaml:"backupCount,omitempty"`
+	Encoding    string   `yaml:"encoding,omitempty"`
+}
+
+type LogConfigLogger struct {
+	Level    string   `yaml:"level"`
+	Handlers []string `yaml:"handlers,omitempty"`
+}
+
+// isLogConfigManaged returns true if the Synapse Operator renders the log
+// configuration of Synapse. This is always the case for a homeserver.yaml
+// rendered from Spec.Homeserver.Values.
+func isLogConfigManaged(s synapsev1alpha1.Synapse) bool {
+	return s.Spec.Logging != nil || s.Spec.Homeserver.ConfigMap == nil
+}
+
+// logConfigForSynapse returns the Python logging configuration corresponding
+// to Spec.Logging. Default values are used if Spec.Logging is not set.
+func logConfigForSynapse(s synapsev1alpha1.Synapse) LogConfig {
+	logging := synapsev1alpha1.SynapseLogging{}
+	if s.Spec.Logging != nil {
+		logging = *s.Spec.Logging
+	}
+
+	formatter := "precise"
+	if logging.Format == "json" {
+		formatter = "structured"
+	}
+
+	handler := LogConfigHandler{
+		Class:     "logging.StreamHandler",
+		Formatter: formatter,
+		Filters:   []string{"context"},
+	}
+	if logging.Output == "file" {
+		handler = LogConfigHandler{
+			Class:       "logging.handlers.TimedRotatingFileHandler",
+			Formatter:   formatter,
+			Filters:     []string{"context"},
+			Filename:    logFilePath,
+			When:        "midnight",
+			BackupCount: 3,
+			Encoding:    "utf8",
+		}
+	}
+
+	level := string(logging.Level)
+	if level == "" {
+		level = "INFO"
+	}
+
+	logConfig := LogConfig{
+		Version: 1,
+		Formatters: map[string]LogConfigFormatter{
+			"precise": {
+				Format: "%(asctime)s - %(name)s - %(lineno)d - %(levelname)s - %(request)s - %(message)s",
+			},
+			"structured": {
+				Class: "synapse.logging.TerseJsonFormatter",
+			},
+		},
+		// The context filter adds the request ID to the log records
+		Filters: map[string]map[string]string{
+			"context": {
+				"()":      "synapse.logging.context.LoggingContextFilter",
+				"request": "",
+			},
+		},
+		Handlers: map[string]LogConfigHandler{
+			"synapse": handler,
+		},
+		Root: LogConfigLogger{
+			Level:    level,
+			Handlers: []string{"synapse"},
+		},
+		DisableExistingLoggers: false,
+	}
+
+	for name, loggerLevel := range logging.Loggers {
+		if logConfig.Loggers == nil {
+			logConfig.Loggers = map[string]LogConfigLogger{}
+		}
+		logConfig.Loggers[name] = LogConfigLogger{Level: string(loggerLevel)}
+	}
+
+	return logConfig
+}
+
+// withLogConfig returns a function of type createResourceFunc, to be passed
+// as an argument in a call to reconcileResource.
+//
+// The returned function generates the Synapse ConfigMap with the given
+// createResourceFunc, and adds the log configuration rendered from
+// Spec.Logging, if managed by the Synapse Operator.
+func (r *SynapseReconciler) withLogConfig(createResource createResourceFunc) createResourceFunc {
+	return func(s *synapsev1alpha1.Synapse, objectMeta metav1.ObjectMeta) (client.Object, error) {
+		resource, err := createResource(s, objectMeta)
+		if err != nil {
+			return &corev1.ConfigMap{}, err
+		}
+
+		cm, ok := resource.(*corev1.ConfigMap)
+		if !ok {
+			return &corev1.ConfigMap{}, errors.New("generated resource is not a ConfigMap")
+		}
+
+		if !isLogConfigManaged(*s) {
+			return cm, nil
+		}
+
+		logConfig, err := yaml.Marshal(logConfigForSynapse(*s))
+		if err != nil {
+			return &corev1.ConfigMap{}, err
+		}
+
+		if cm.Data == nil {
+			cm.Data = map[string]string{}
+		}
+		cm.Data[logConfigFilename] = string(logConfig)
+
+		return cm, nil
+	}
+}
+
+// updateHomeserverWithLogConfig is a function of type updateDataFunc, to be
+// passed as an argument in a call to withConfigMapUpdates.
+//
+// It points 'log_config' to the log configuration rendered by withLogConfig.
+func (r *SynapseReconciler) updateHomeserverWithLogConfig(s synapsev1alpha1.Synapse, homeserver map[string]interface{}) error {
+	homeserver["log_config"] = logConfigPath
+	return nil
+}
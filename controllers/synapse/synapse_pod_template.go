@@ -0,0 +1,190 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package synapse
+
+import (
+	"encoding/json"
+	"errors"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	synapsev1alpha1 "github.com/opdev/synapse-operator/apis/synapse/v1alpha1"
+)
+
+// withPodTemplate returns a function of type createResourceFunc, to be
+// passed as an argument in a call to reconcileResource.
+//
+// The returned function generates a Deployment with the given
+// createResourceFunc, and merges the given pod template into its pod
+// template, with the semantics of a strategic merge patch. The labels,
+// volumes and volume mounts of the generated pod template can't be changed
+// by the given pod template.
+func (r *SynapseReconciler) withPodTemplate(createResource createResourceFunc, podTemplate *corev1.PodTemplateSpec) createResourceFunc {
+	return func(s *synapsev1alpha1.Synapse, objectMeta metav1.ObjectMeta) (client.Object, error) {
+		resource, err := createResource(s, objectMeta)
+		if err != nil {
+			return &appsv1.Deployment{}, err
+		}
+
+		dep, ok := resource.(*appsv1.Deployment)
+		if !ok {
+			return &appsv1.Deployment{}, errors.New("generated resource is not a Deployment")
+		}
+
+		if podTemplate == nil {
+			return dep, nil
+		}
+
+		mergedTemplate, err := mergePodTemplate(dep.Spec.Template, *podTemplate)
+		if err != nil {
+			return &appsv1.Deployment{}, err
+		}
+		if err := checkRequiredPodTemplate(dep.Spec.Template, mergedTemplate); err != nil {
+			return &appsv1.Deployment{}, err
+		}
+		dep.Spec.Template = mergedTemplate
+
+		return dep, nil
+	}
+}
+
+// checkPodTemplates ensures that the pod templates of Spec.PodTemplate and
+// Spec.Bridges.Heisenbridge.PodTemplate can be merged into the pod templates
+// generated for Synapse and Heisenbridge.
+func (r *SynapseReconciler) checkPodTemplates(s synapsev1alpha1.Synapse) error {
+	objectMetaForSynapse := setObjectMeta(s.Name, s.Namespace, map[string]string{})
+	if _, err := r.withPodTemplate(r.deploymentForSynapse, s.Spec.PodTemplate)(&s, objectMetaForSynapse); err != nil {
+		return errors.New("invalid spec.podTemplate: " + err.Error())
+	}
+
+	heisenbridge := s.Spec.Bridges.Heisenbridge
+	if heisenbridge.Enabled {
+		objectMetaHeisenbridge := setObjectMeta(s.Name+"-heisenbridge", s.Namespace, map[string]string{})
+		if _, err := r.withPodTemplate(r.deploymentForHeisenbridge, heisenbridge.PodTemplate)(&s, objectMetaHeisenbridge); err != nil {
+			return errors.New("invalid spec.bridges.heisenbridge.podTemplate: " + err.Error())
+		}
+	}
+
+	return nil
+}
+
+// mergePodTemplate merges a pod template into a generated pod template, with
+// the semantics of a strategic merge patch. As the pod template can't express
+// the deletion of a field, its null fields are ignored.
+func mergePodTemplate(generated corev1.PodTemplateSpec, podTemplate corev1.PodTemplateSpec) (corev1.PodTemplateSpec, error) {
+	original, err := json.Marshal(generated)
+	if err != nil {
+		return corev1.PodTemplateSpec{}, err
+	}
+
+	patchContent, err := json.Marshal(podTemplate)
+	if err != nil {
+		return corev1.PodTemplateSpec{}, err
+	}
+	var patch map[string]interface{}
+	if err := json.Unmarshal(patchContent, &patch); err != nil {
+		return corev1.PodTemplateSpec{}, err
+	}
+	patchContent, err = json.Marshal(removeNullFields(patch))
+	if err != nil {
+		return corev1.PodTemplateSpec{}, err
+	}
+
+	merged, err := strategicpatch.StrategicMergePatch(original, patchContent, corev1.PodTemplateSpec{})
+	if err != nil {
+		return corev1.PodTemplateSpec{}, err
+	}
+
+	var mergedTemplate corev1.PodTemplateSpec
+	if err := json.Unmarshal(merged, &mergedTemplate); err != nil {
+		return corev1.PodTemplateSpec{}, err
+	}
+	return mergedTemplate, nil
+}
+
+// removeNullFields recursively removes the null fields of a JSON object.
+// Null fields are interpreted as deletions by a strategic merge patch.
+func removeNullFields(value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		for key, field := range value {
+			if field == nil {
+				delete(value, key)
+				continue
+			}
+			value[key] = removeNullFields(field)
+		}
+	case []interface{}:
+		for i, item := range value {
+			value[i] = removeNullFields(item)
+		}
+	}
+	return value
+}
+
+// checkRequiredPodTemplate ensures that the labels, volumes and volume
+// mounts of a generated pod template are left unchanged in the merged pod
+// template. They are required by the Deployment selector and by the
+// configuration of the containers.
+func checkRequiredPodTemplate(generated corev1.PodTemplateSpec, merged corev1.PodTemplateSpec) error {
+	for key, value := range generated.Labels {
+		if merged.Labels[key] != value {
+			return errors.New("label " + key + " is required and can't be changed")
+		}
+	}
+
+	mergedVolumes := map[string]corev1.Volume{}
+	for _, volume := range merged.Spec.Volumes {
+		mergedVolumes[volume.Name] = volume
+	}
+	for _, volume := range generated.Spec.Volumes {
+		if !equality.Semantic.DeepEqual(mergedVolumes[volume.Name], volume) {
+			return errors.New("volume " + volume.Name + " is required and can't be changed")
+		}
+	}
+
+	mergedContainers := map[string]corev1.Container{}
+	for _, containers := range [][]corev1.Container{merged.Spec.InitContainers, merged.Spec.Containers} {
+		for _, container := range containers {
+			mergedContainers[container.Name] = container
+		}
+	}
+	var generatedContainers []corev1.Container
+	generatedContainers = append(generatedContainers, generated.Spec.InitContainers...)
+	generatedContainers = append(generatedContainers, generated.Spec.Containers...)
+	for _, container := range generatedContainers {
+		mergedVolumeMounts := map[string]corev1.VolumeMount{}
+		for _, volumeMount := range mergedContainers[container.Name].VolumeMounts {
+			mergedVolumeMounts[volumeMount.MountPath] = volumeMount
+		}
+		for _, volumeMount := range container.VolumeMounts {
+			if !equality.Semantic.DeepEqual(mergedVolumeMounts[volumeMount.MountPath], volumeMount) {
+				return errors.New(
+					"volume mount " + volumeMount.MountPath + " of container " + container.Name +
+						" is required and can't be changed",
+				)
+			}
+		}
+	}
+
+	return nil
+}
@@ -17,8 +17,6 @@ limitations under the License.
 package synapse
 
 import (
-	b64 "encoding/base64"
-
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -96,12 +94,3 @@ func (r *SynapseReconciler) configMapForPostgresCluster(s *synapsev1alpha1.Synap
 
 	return configMap, nil
 }
-
-func base64encode(to_encode string) []byte {
-	return []byte(b64.StdEncoding.EncodeToString([]byte(to_encode)))
-}
-
-func base64decode(to_decode []byte) string {
-	decoded_bytes, _ := b64.StdEncoding.DecodeString(string(to_decode))
-	return string(decoded_bytes)
-}
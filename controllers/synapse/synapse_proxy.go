@@ -0,0 +1,376 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package synapse
+
+import (
+	"fmt"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	synapsev1alpha1 "github.com/opdev/synapse-operator/apis/synapse/v1alpha1"
+)
+
+// In worker mode, the Services exposing Synapse select the nginx reverse
+// proxy instead of the main Synapse process. The proxy listens on the same
+// ports as Synapse, and routes the requests to the workers or to the main
+// Synapse process, reached through the main Service.
+const (
+	proxyHTTPPort = 8008
+	// Matches the default max_upload_size of Synapse
+	proxyMaxBodySize = "50M"
+)
+
+// The requests served by the workers, as documented in
+// https://matrix-org.github.io/synapse/v1.60/workers.html
+var (
+	genericWorkerClientPaths = []string{
+		// Sync requests
+		"^/_matrix/client/(r0|v3)/sync$",
+		"^/_matrix/client/(api/v1|r0|v3)/events$",
+		"^/_matrix/client/(api/v1|r0|v3)/initialSync$",
+		"^/_matrix/client/(api/v1|r0|v3)/rooms/[^/]+/initialSync$",
+		// Client API requests
+		"^/_matrix/client/(api/v1|r0|v3|unstable)/createRoom$",
+		"^/_matrix/client/(api/v1|r0|v3|unstable)/publicRooms$",
+		"^/_matrix/client/(api/v1|r0|v3|unstable)/rooms/.*/joined_members$",
+		"^/_matrix/client/(api/v1|r0|v3|unstable)/rooms/.*/context/.*$",
+		"^/_matrix/client/(api/v1|r0|v3|unstable)/rooms/.*/members$",
+		"^/_matrix/client/(api/v1|r0|v3|unstable)/rooms/.*/state$",
+		"^/_matrix/client/v1/rooms/.*/hierarchy$",
+		"^/_matrix/client/unstable/org.matrix.msc2716/rooms/.*/batch_send$",
+		"^/_matrix/client/unstable/im.nheko.summary/rooms/.*/summary$",
+		"^/_matrix/client/(r0|v3|unstable)/account/3pid$",
+		"^/_matrix/client/(r0|v3|unstable)/account/whoami$",
+		"^/_matrix/client/(r0|v3|unstable)/devices$",
+		"^/_matrix/client/versions$",
+		"^/_matrix/client/(api/v1|r0|v3|unstable)/voip/turnServer$",
+		"^/_matrix/client/(api/v1|r0|v3|unstable)/rooms/.*/event/",
+		"^/_matrix/client/(api/v1|r0|v3|unstable)/joined_rooms$",
+		"^/_matrix/client/(api/v1|r0|v3|unstable)/search$",
+		// Encryption requests
+		"^/_matrix/client/(r0|v3|unstable)/keys/query$",
+		"^/_matrix/client/(r0|v3|unstable)/keys/changes$",
+		"^/_matrix/client/(r0|v3|unstable)/keys/claim$",
+		"^/_matrix/client/(r0|v3|unstable)/room_keys/",
+		// Registration/login requests
+		"^/_matrix/client/(api/v1|r0|v3|unstable)/login$",
+		"^/_matrix/client/(r0|v3|unstable)/register$",
+		"^/_matrix/client/v1/register/m.login.registration_token/validity$",
+		// Event sending requests
+		"^/_matrix/client/(api/v1|r0|v3|unstable)/rooms/.*/redact",
+		"^/_matrix/client/(api/v1|r0|v3|unstable)/rooms/.*/send",
+		"^/_matrix/client/(api/v1|r0|v3|unstable)/rooms/.*/state/",
+		"^/_matrix/client/(api/v1|r0|v3|unstable)/rooms/.*/(join|invite|leave|ban|unban|kick)$",
+		"^/_matrix/client/(api/v1|r0|v3|unstable)/join/",
+		"^/_matrix/client/(api/v1|r0|v3|unstable)/profile/",
+	}
+
+	genericWorkerFederationPaths = []string{
+		"^/_matrix/federation/v1/event/",
+		"^/_matrix/federation/v1/state/",
+		"^/_matrix/federation/v1/state_ids/",
+		"^/_matrix/federation/v1/backfill/",
+		"^/_matrix/federation/v1/get_missing_events/",
+		"^/_matrix/federation/v1/publicRooms",
+		"^/_matrix/federation/v1/query/",
+		"^/_matrix/federation/v1/make_join/",
+		"^/_matrix/federation/v1/make_leave/",
+		"^/_matrix/federation/(v1|v2)/send_join/",
+		"^/_matrix/federation/(v1|v2)/send_leave/",
+		"^/_matrix/federation/(v1|v2)/invite/",
+		"^/_matrix/federation/v1/event_auth/",
+		"^/_matrix/federation/v1/exchange_third_party_invite/",
+		"^/_matrix/federation/v1/user/devices/",
+		"^/_matrix/federation/v1/get_groups_publicised$",
+		"^/_matrix/key/v2/query",
+		"^/_matrix/federation/v1/hierarchy/",
+		// Inbound federation transaction requests
+		"^/_matrix/federation/v1/send/",
+	}
+
+	mediaRepositoryPaths = []string{
+		"^/_matrix/media/",
+		"^/_synapse/admin/v1/purge_media_cache$",
+		"^/_synapse/admin/v1/room/.*/media.*$",
+		"^/_synapse/admin/v1/user/.*/media.*$",
+		"^/_synapse/admin/v1/media/.*$",
+		"^/_synapse/admin/v1/quarantine_media/.*$",
+		"^/_synapse/admin/v1/users/.*/media$",
+	}
+)
+
+// labelsForProxy returns the labels for selecting the resources of the
+// reverse proxy of the given synapse CR name.
+func labelsForProxy(name string) map[string]string {
+	return map[string]string{"app": "synapse-proxy", "synapse_cr": name}
+}
+
+// proxyResourceName returns the name of the ConfigMap and Deployment of the
+// reverse proxy.
+func proxyResourceName(s synapsev1alpha1.Synapse) string {
+	return s.Name + "-proxy"
+}
+
+// mainServiceName returns the name of the Service exposing the main Synapse
+// process to the reverse proxy and to the workers.
+func mainServiceName(s synapsev1alpha1.Synapse) string {
+	return s.Name + "-main"
+}
+
+// isProxyDeployed returns true if the requests sent to Synapse are routed by
+// the reverse proxy, which is the case in worker mode.
+func isProxyDeployed(s synapsev1alpha1.Synapse) bool {
+	return len(s.Spec.Workers) != 0
+}
+
+// labelsForSynapseService returns the labels selecting the pods behind the
+// Services exposing Synapse.
+func labelsForSynapseService(s synapsev1alpha1.Synapse) map[string]string {
+	if isProxyDeployed(s) {
+		return labelsForProxy(s.Name)
+	}
+	return labelsForSynapse(s.Name)
+}
+
+// nginxConfForProxy returns the nginx configuration of the reverse proxy.
+// Each pool of workers is an upstream server of the upstream of its worker
+// type, and the requests served by a worker type are routed to its
+// upstream. All other requests are sent to the main Synapse process.
+func (r *SynapseReconciler) nginxConfForProxy(s synapsev1alpha1.Synapse) string {
+	var conf strings.Builder
+
+	fmt.Fprintf(&conf, "upstream synapse_main {\n    server %s:%d;\n}\n", r.serviceHost(s, mainServiceName(s)), proxyHTTPPort)
+
+	// Locations are matched in order, the media repository paths being
+	// more specific than the generic worker paths
+	var locations strings.Builder
+	for _, workerType := range []synapsev1alpha1.SynapseWorkerType{
+		synapsev1alpha1.WorkerTypeMediaRepository,
+		synapsev1alpha1.WorkerTypeGenericWorker,
+	} {
+		var servers []string
+		for _, pool := range s.Spec.Workers {
+			if pool.Type == workerType {
+				servers = append(servers, r.serviceHost(s, workerPoolResourceName(s, pool)))
+			}
+		}
+		if len(servers) == 0 {
+			continue
+		}
+
+		upstream := "synapse_" + string(workerType)
+		fmt.Fprintf(&conf, "\nupstream %s {\n", upstream)
+		for _, server := range servers {
+			fmt.Fprintf(&conf, "    server %s:%d;\n", server, workerHTTPPort)
+		}
+		conf.WriteString("}\n")
+
+		var paths []string
+		switch workerType {
+		case synapsev1alpha1.WorkerTypeMediaRepository:
+			paths = mediaRepositoryPaths
+		case synapsev1alpha1.WorkerTypeGenericWorker:
+			paths = append(paths, genericWorkerClientPaths...)
+			if isFederationEnabled(s) {
+				paths = append(paths, genericWorkerFederationPaths...)
+			}
+		}
+		for _, path := range paths {
+			fmt.Fprintf(&locations, "\n    location ~ %s {\n        proxy_pass http://%s;\n    }\n", path, upstream)
+		}
+	}
+	locations.WriteString("\n    location / {\n        proxy_pass http://synapse_main;\n    }\n")
+
+	// The TLS listeners of Synapse are served by the reverse proxy, with
+	// the same certificates
+	servers := []string{fmt.Sprintf("listen %d;", proxyHTTPPort)}
+	if isRouteReencrypt(s) {
+		servers = append(servers, fmt.Sprintf(
+			"listen %d ssl;\n    ssl_certificate %s/tls.crt;\n    ssl_certificate_key %s/tls.key;",
+			routeTLSPort, routeTLSMountPath, routeTLSMountPath,
+		))
+	}
+	if isFederationListenerEnabled(s) {
+		if s.Spec.Federation.TLSSecretName != "" {
+			servers = append(servers, fmt.Sprintf(
+				"listen %d ssl;\n    ssl_certificate %s/tls.crt;\n    ssl_certificate_key %s/tls.key;",
+				s.Spec.Federation.Port, federationTLSMountPath, federationTLSMountPath,
+			))
+		} else {
+			servers = append(servers, fmt.Sprintf("listen %d;", s.Spec.Federation.Port))
+		}
+	}
+
+	for _, server := range servers {
+		fmt.Fprintf(&conf, "\nserver {\n    %s\n", server)
+		fmt.Fprintf(&conf, "    client_max_body_size %s;\n", proxyMaxBodySize)
+		conf.WriteString("    proxy_http_version 1.1;\n")
+		conf.WriteString("    proxy_set_header Host $host;\n")
+		conf.WriteString("    proxy_set_header X-Forwarded-For $proxy_add_x_forwarded_for;\n")
+		conf.WriteString(locations.String())
+		conf.WriteString("}\n")
+	}
+
+	return conf.String()
+}
+
+// configMapForProxy returns a ConfigMap holding the nginx configuration of
+// the reverse proxy.
+func (r *SynapseReconciler) configMapForProxy(s *synapsev1alpha1.Synapse, objectMeta metav1.ObjectMeta) (client.Object, error) {
+	cm := &corev1.ConfigMap{
+		ObjectMeta: objectMeta,
+		Data:       map[string]string{"default.conf": r.nginxConfForProxy(*s)},
+	}
+
+	// Set Synapse instance as the owner and controller
+	if err := ctrl.SetControllerReference(s, cm, r.Scheme); err != nil {
+		return &corev1.ConfigMap{}, err
+	}
+	return cm, nil
+}
+
+// deploymentForProxy returns a Deployment running the reverse proxy.
+func (r *SynapseReconciler) deploymentForProxy(s *synapsev1alpha1.Synapse, objectMeta metav1.ObjectMeta) (client.Object, error) {
+	ls := labelsForProxy(s.Name)
+
+	dep := &appsv1.Deployment{
+		ObjectMeta: objectMeta,
+		Spec: appsv1.DeploymentSpec{
+			Replicas: s.Spec.Proxy.Replicas,
+			Selector: &metav1.LabelSelector{
+				MatchLabels: ls,
+			},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: ls,
+				},
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{{
+						Image: s.Spec.Proxy.Image,
+						Name:  "proxy",
+						VolumeMounts: []corev1.VolumeMount{{
+							Name:      "nginx-conf",
+							MountPath: "/etc/nginx/conf.d",
+						}},
+						Ports: []corev1.ContainerPort{{
+							ContainerPort: proxyHTTPPort,
+						}},
+						ReadinessProbe: &corev1.Probe{
+							ProbeHandler: corev1.ProbeHandler{
+								TCPSocket: &corev1.TCPSocketAction{
+									Port: intstr.FromInt(proxyHTTPPort),
+								},
+							},
+						},
+					}},
+					Volumes: []corev1.Volume{{
+						Name: "nginx-conf",
+						VolumeSource: corev1.VolumeSource{
+							ConfigMap: &corev1.ConfigMapVolumeSource{
+								LocalObjectReference: corev1.LocalObjectReference{
+									Name: objectMeta.Name,
+								},
+							},
+						},
+					}},
+				},
+			},
+		},
+	}
+
+	podSpec := &dep.Spec.Template.Spec
+	container := &podSpec.Containers[0]
+
+	if isRouteReencrypt(*s) {
+		container.Ports = append(container.Ports, corev1.ContainerPort{
+			ContainerPort: routeTLSPort,
+		})
+		container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+			Name:      "tls",
+			MountPath: routeTLSMountPath,
+		})
+		podSpec.Volumes = append(podSpec.Volumes, corev1.Volume{
+			Name: "tls",
+			VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{
+					SecretName: servingCertSecretName(*s),
+				},
+			},
+		})
+	}
+
+	if isFederationListenerEnabled(*s) {
+		container.Ports = append(container.Ports, corev1.ContainerPort{
+			ContainerPort: s.Spec.Federation.Port,
+		})
+		if s.Spec.Federation.TLSSecretName != "" {
+			container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+				Name:      "federation-tls",
+				MountPath: federationTLSMountPath,
+			})
+			podSpec.Volumes = append(podSpec.Volumes, corev1.Volume{
+				Name: "federation-tls",
+				VolumeSource: corev1.VolumeSource{
+					Secret: &corev1.SecretVolumeSource{
+						SecretName: s.Spec.Federation.TLSSecretName,
+					},
+				},
+			})
+		}
+	}
+
+	// Set Synapse instance as the owner and controller
+	if err := ctrl.SetControllerReference(s, dep, r.Scheme); err != nil {
+		return &appsv1.Deployment{}, err
+	}
+	return dep, nil
+}
+
+// serviceForMainProcess returns a Service exposing the main Synapse process
+// to the reverse proxy, and its replication listener to the workers.
+func (r *SynapseReconciler) serviceForMainProcess(s *synapsev1alpha1.Synapse, objectMeta metav1.ObjectMeta) (client.Object, error) {
+	service := &corev1.Service{
+		ObjectMeta: objectMeta,
+		Spec: corev1.ServiceSpec{
+			Ports: []corev1.ServicePort{{
+				Name:       "http",
+				Protocol:   corev1.ProtocolTCP,
+				Port:       8008,
+				TargetPort: intstr.FromInt(8008),
+			}, {
+				Name:       "replication",
+				Protocol:   corev1.ProtocolTCP,
+				Port:       replicationPort,
+				TargetPort: intstr.FromInt(replicationPort),
+			}},
+			Selector: labelsForSynapse(s.Name),
+			Type:     corev1.ServiceTypeClusterIP,
+		},
+	}
+
+	// Set Synapse instance as the owner and controller
+	if err := ctrl.SetControllerReference(s, service, r.Scheme); err != nil {
+		return &corev1.Service{}, err
+	}
+	return service, nil
+}
@@ -18,7 +18,6 @@ package synapse
 
 import (
 	corev1 "k8s.io/api/core/v1"
-	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
@@ -37,7 +36,7 @@ func (r *SynapseReconciler) persistentVolumeClaimForSynapse(s *synapsev1alpha1.S
 			VolumeMode:  &pvcmode,
 			Resources: corev1.ResourceRequirements{
 				Requests: corev1.ResourceList{
-					"storage": *resource.NewQuantity(5*1024*1024*1024, resource.BinarySI),
+					"storage": *s.Spec.Storage.Size,
 				},
 			},
 		},
@@ -0,0 +1,372 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package synapse
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	synapsev1alpha1 "github.com/opdev/synapse-operator/apis/synapse/v1alpha1"
+)
+
+// Redis is only used by Synapse for the replication between its main
+// process and its workers. The Redis instance deployed by the Synapse
+// Operator doesn't persist any data. Its optional password is stored in the
+// redisPasswordKey of the Redis Secret.
+const (
+	redisPort        = 6379
+	redisPasswordKey = "password"
+	redisPingTimeout = 5 * time.Second
+)
+
+// An external Redis instance is probed at most once per redisProbeInterval,
+// and the Synapse instances using it are reconciled again after this
+// interval, so that the RedisReady condition follows its availability.
+const redisProbeInterval = 30 * time.Second
+
+// redisProbe is the result of the last probe of the external Redis instance
+// of a Synapse instance. The probe is run again when the address or the
+// password of the Redis instance changes, identified by their checksum.
+type redisProbe struct {
+	checksum string
+	probedAt time.Time
+	err      error
+}
+
+// labelsForRedis returns the labels for selecting the Redis resources of
+// the given synapse CR name.
+func labelsForRedis(name string) map[string]string {
+	return map[string]string{"app": "synapse-redis", "synapse_cr": name}
+}
+
+// redisResourceName returns the name of the Redis Deployment, Service and
+// Secret.
+func redisResourceName(s synapsev1alpha1.Synapse) string {
+	return s.Name + "-redis"
+}
+
+// isRedisDeployed returns true if the Redis instance used by the workers is
+// deployed by the Synapse Operator.
+func isRedisDeployed(s synapsev1alpha1.Synapse) bool {
+	return len(s.Spec.Workers) != 0 && (s.Spec.Redis == nil || s.Spec.Redis.External == nil)
+}
+
+// isRedisPasswordGenerated returns true if the Redis instance deployed by
+// the Synapse Operator is protected by a generated password.
+func isRedisPasswordGenerated(s synapsev1alpha1.Synapse) bool {
+	return isRedisDeployed(s) && s.Spec.Redis != nil && s.Spec.Redis.GeneratePassword
+}
+
+// redisAddress returns the host and port of the Redis instance used by the
+// workers.
+func (r *SynapseReconciler) redisAddress(s synapsev1alpha1.Synapse) (string, int) {
+	if !isRedisDeployed(s) {
+		return s.Spec.Redis.External.Host, int(s.Spec.Redis.External.Port)
+	}
+	return r.serviceHost(s, redisResourceName(s)), redisPort
+}
+
+// secretForRedis returns a function of type createResourceFunc, to be passed
+// as an argument in a call to reconcileResouce.
+//
+// The returned function returns a Secret containing the random password of the
+// Redis instance deployed by the Synapse Operator. The password is generated
+// only once, and kept from the existing Secret afterwards.
+func (r *SynapseReconciler) secretForRedis(ctx context.Context) createResourceFunc {
+	return func(s *synapsev1alpha1.Synapse, objectMeta metav1.ObjectMeta) (client.Object, error) {
+		existingSecret, err := r.getExistingSecret(ctx, objectMeta)
+		if err != nil {
+			return &corev1.Secret{}, err
+		}
+
+		password := existingSecret.Data[redisPasswordKey]
+		if len(password) == 0 {
+			value, err := generateRandomString(32)
+			if err != nil {
+				return &corev1.Secret{}, err
+			}
+			password = []byte(value)
+		}
+
+		secret := &corev1.Secret{
+			ObjectMeta: objectMeta,
+			Type:       corev1.SecretTypeOpaque,
+			Data:       map[string][]byte{redisPasswordKey: password},
+		}
+
+		// Set Synapse instance as the owner and controller
+		if err := ctrl.SetControllerReference(s, secret, r.Scheme); err != nil {
+			return &corev1.Secret{}, err
+		}
+		return secret, nil
+	}
+}
+
+// deploymentForRedis returns a Deployment running the Redis instance used
+// by Synapse workers.
+func (r *SynapseReconciler) deploymentForRedis(s *synapsev1alpha1.Synapse, objectMeta metav1.ObjectMeta) (client.Object, error) {
+	ls := labelsForRedis(s.Name)
+	replicas := int32(1)
+
+	dep := &appsv1.Deployment{
+		ObjectMeta: objectMeta,
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &replicas,
+			Selector: &metav1.LabelSelector{
+				MatchLabels: ls,
+			},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: ls,
+				},
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{{
+						Image: s.Spec.Redis.Image,
+						Name:  "redis",
+						// Disable persistence
+						Args: []string{"--save", "", "--appendonly", "no"},
+						Ports: []corev1.ContainerPort{{
+							ContainerPort: redisPort,
+						}},
+						ReadinessProbe: &corev1.Probe{
+							ProbeHandler: corev1.ProbeHandler{
+								TCPSocket: &corev1.TCPSocketAction{
+									Port: intstr.FromInt(redisPort),
+								},
+							},
+						},
+					}},
+				},
+			},
+		},
+	}
+
+	// The password is read from the Redis Secret, and expanded by the
+	// kubelet in the arguments of Redis
+	if isRedisPasswordGenerated(*s) {
+		container := &dep.Spec.Template.Spec.Containers[0]
+		container.Env = []corev1.EnvVar{{
+			Name: "REDIS_PASSWORD",
+			ValueFrom: &corev1.EnvVarSource{
+				SecretKeyRef: &corev1.SecretKeySelector{
+					LocalObjectReference: corev1.LocalObjectReference{Name: objectMeta.Name},
+					Key:                  redisPasswordKey,
+				},
+			},
+		}}
+		container.Args = append(container.Args, "--requirepass", "$(REDIS_PASSWORD)")
+	}
+
+	// Set Synapse instance as the owner and controller
+	if err := ctrl.SetControllerReference(s, dep, r.Scheme); err != nil {
+		return &appsv1.Deployment{}, err
+	}
+	return dep, nil
+}
+
+// serviceForRedis returns a Service for the Redis instance used by Synapse
+// workers.
+func (r *SynapseReconciler) serviceForRedis(s *synapsev1alpha1.Synapse, objectMeta metav1.ObjectMeta) (client.Object, error) {
+	service := &corev1.Service{
+		ObjectMeta: objectMeta,
+		Spec: corev1.ServiceSpec{
+			Ports: []corev1.ServicePort{{
+				Name:       "redis",
+				Protocol:   corev1.ProtocolTCP,
+				Port:       redisPort,
+				TargetPort: intstr.FromInt(redisPort),
+			}},
+			Selector: labelsForRedis(s.Name),
+			Type:     corev1.ServiceTypeClusterIP,
+		},
+	}
+
+	// Set Synapse instance as the owner and controller
+	if err := ctrl.SetControllerReference(s, service, r.Scheme); err != nil {
+		return &corev1.Service{}, err
+	}
+	return service, nil
+}
+
+// fetchExternalRedisPassword returns the password of the external Redis
+// instance, read from the Secret referenced in
+// Spec.Redis.External.PasswordSecretKeyRef, if any.
+func (r *SynapseReconciler) fetchExternalRedisPassword(ctx context.Context, s synapsev1alpha1.Synapse) (string, error) {
+	secretKeyRef := s.Spec.Redis.External.PasswordSecretKeyRef
+	if secretKeyRef == nil {
+		return "", nil
+	}
+
+	var secret corev1.Secret
+	if err := r.Get(
+		ctx,
+		types.NamespacedName{Name: secretKeyRef.Name, Namespace: s.Namespace},
+		&secret,
+	); err != nil {
+		return "", err
+	}
+
+	password, ok := secret.Data[secretKeyRef.Key]
+	if !ok || len(password) == 0 {
+		return "", errors.New("missing " + secretKeyRef.Key + " in Secret " + secretKeyRef.Name)
+	}
+
+	return string(password), nil
+}
+
+// pingRedis checks that the Redis instance at the given address accepts
+// connections with the given password, if any.
+func pingRedis(address string, password string) error {
+	conn, err := net.DialTimeout("tcp", address, redisPingTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if err := conn.SetDeadline(time.Now().Add(redisPingTimeout)); err != nil {
+		return err
+	}
+	reader := bufio.NewReader(conn)
+
+	// Commands are sent as arrays of bulk strings, as described in
+	// https://redis.io/docs/reference/protocol-spec/
+	command := func(args ...string) (string, error) {
+		request := fmt.Sprintf("*%d\r\n", len(args))
+		for _, arg := range args {
+			request += fmt.Sprintf("$%d\r\n%s\r\n", len(arg), arg)
+		}
+		if _, err := conn.Write([]byte(request)); err != nil {
+			return "", err
+		}
+
+		reply, err := reader.ReadString('\n')
+		if err != nil {
+			return "", err
+		}
+		reply = strings.TrimSpace(reply)
+		if strings.HasPrefix(reply, "-") {
+			return "", errors.New(strings.TrimPrefix(reply, "-"))
+		}
+		return reply, nil
+	}
+
+	if password != "" {
+		if _, err := command("AUTH", password); err != nil {
+			return err
+		}
+	}
+
+	reply, err := command("PING")
+	if err != nil {
+		return err
+	}
+	if reply != "+PONG" {
+		return errors.New("unexpected reply to PING: " + reply)
+	}
+
+	return nil
+}
+
+// setExternalRedisCondition reports whether the external Redis instance
+// accepts connections in the RedisReady condition.
+func (r *SynapseReconciler) setExternalRedisCondition(synapse *synapsev1alpha1.Synapse, password string) {
+	host, port := r.redisAddress(*synapse)
+	address := net.JoinHostPort(host, strconv.Itoa(port))
+
+	if err := r.probeExternalRedis(*synapse, address, password); err != nil {
+		r.setStatusCondition(
+			synapse,
+			synapsev1alpha1.ConditionTypeRedisReady,
+			metav1.ConditionFalse,
+			reasonRedisNotReachable,
+			"Redis instance "+address+" is not reachable: "+err.Error(),
+		)
+		return
+	}
+
+	r.setStatusCondition(
+		synapse,
+		synapsev1alpha1.ConditionTypeRedisReady,
+		metav1.ConditionTrue,
+		reasonRedisReachable,
+		"Redis instance "+address+" is reachable",
+	)
+}
+
+// probeExternalRedis pings the external Redis instance of a Synapse
+// instance, unless it was already probed within redisProbeInterval. The
+// result of the last probe is returned in that case, so that frequent
+// reconciliations aren't slowed down by the probe.
+func (r *SynapseReconciler) probeExternalRedis(s synapsev1alpha1.Synapse, address string, password string) error {
+	key := types.NamespacedName{Name: s.Name, Namespace: s.Namespace}
+	checksum := fmt.Sprintf("%x", sha256.Sum256([]byte(address+"\n"+password)))
+
+	if value, ok := r.redisProbes.Load(key); ok {
+		probe := value.(redisProbe)
+		if probe.checksum == checksum && time.Since(probe.probedAt) < redisProbeInterval {
+			return probe.err
+		}
+	}
+
+	err := pingRedis(address, password)
+	r.redisProbes.Store(key, redisProbe{checksum: checksum, probedAt: time.Now(), err: err})
+	return err
+}
+
+// forgetExternalRedisProbe drops the result of the last probe of the
+// external Redis instance of the Synapse instance with the given key.
+func (r *SynapseReconciler) forgetExternalRedisProbe(key types.NamespacedName) {
+	r.redisProbes.Delete(key)
+}
+
+// updateHomeserverWithRedis returns a function of type updateDataFunc, to be
+// passed as an argument in a call to withSecretUpdates.
+//
+// The returned function writes the 'redis' section of homeserver.yaml,
+// connecting Synapse to the Redis instance used by the workers. As it may
+// contain the Redis password, this section is never written in the Synapse
+// ConfigMap.
+func (r *SynapseReconciler) updateHomeserverWithRedis(password string) updateDataFunc {
+	return func(s synapsev1alpha1.Synapse, homeserver map[string]interface{}) error {
+		host, port := r.redisAddress(s)
+		redis := map[string]interface{}{
+			"enabled": true,
+			"host":    host,
+			"port":    port,
+		}
+		if password != "" {
+			redis["password"] = password
+		}
+		homeserver["redis"] = redis
+		return nil
+	}
+}
@@ -0,0 +1,192 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package synapse
+
+import (
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	synapsev1alpha1 "github.com/opdev/synapse-operator/apis/synapse/v1alpha1"
+)
+
+// routeGVK is the GroupVersionKind of the OpenShift Route exposing Synapse.
+// Routes are handled as unstructured objects, as the OpenShift API is not
+// part of the core Kubernetes API.
+var routeGVK = schema.GroupVersionKind{
+	Group:   "route.openshift.io",
+	Version: "v1",
+	Kind:    "Route",
+}
+
+// With the reencrypt termination, Synapse serves its HTTP APIs over TLS on
+// routeTLSPort, with the certificate issued by the OpenShift service CA in
+// the Secret annotated on the Synapse Service.
+const (
+	routeTLSPort                = 8443
+	routeTLSMountPath           = "/data-tls"
+	servingCertSecretAnnotation = "service.beta.openshift.io/serving-cert-secret-name"
+)
+
+// isRouteAPIAvailable returns true if the route.openshift.io API is served
+// by the cluster, according to the discovery information of the given
+// RESTMapper.
+func isRouteAPIAvailable(mapper meta.RESTMapper) bool {
+	_, err := mapper.RESTMapping(routeGVK.GroupKind(), routeGVK.Version)
+	return err == nil
+}
+
+// isRouteReencrypt returns true if Synapse is exposed with a Route using the
+// reencrypt termination.
+func isRouteReencrypt(s synapsev1alpha1.Synapse) bool {
+	return s.Spec.Route != nil && s.Spec.Route.Termination == "reencrypt"
+}
+
+// servingCertSecretName returns the name of the Secret holding the
+// certificate issued by the OpenShift service CA for the Synapse Service.
+func servingCertSecretName(s synapsev1alpha1.Synapse) string {
+	return s.Name + "-serving-cert"
+}
+
+// routeForSynapse returns an OpenShift Route for the Synapse Service. HTTP
+// requests are redirected to HTTPS.
+func (r *SynapseReconciler) routeForSynapse(s *synapsev1alpha1.Synapse, objectMeta metav1.ObjectMeta) (client.Object, error) {
+	termination := "edge"
+	targetPort := "http"
+	if isRouteReencrypt(*s) {
+		termination = "reencrypt"
+		targetPort = "https"
+	}
+
+	route := &unstructured.Unstructured{}
+	route.SetGroupVersionKind(routeGVK)
+	route.SetName(objectMeta.Name)
+	route.SetNamespace(objectMeta.Namespace)
+	route.SetLabels(objectMeta.Labels)
+
+	spec := map[string]interface{}{
+		"to": map[string]interface{}{
+			"kind":   "Service",
+			"name":   s.Name,
+			"weight": int64(100),
+		},
+		"port": map[string]interface{}{
+			"targetPort": targetPort,
+		},
+		"tls": map[string]interface{}{
+			"termination":                   termination,
+			"insecureEdgeTerminationPolicy": "Redirect",
+		},
+	}
+	if s.Spec.Route.Host != "" {
+		spec["host"] = s.Spec.Route.Host
+	}
+	if err := unstructured.SetNestedMap(route.Object, spec, "spec"); err != nil {
+		return &unstructured.Unstructured{}, err
+	}
+
+	// Set Synapse instance as the owner and controller
+	if err := ctrl.SetControllerReference(s, route, r.Scheme); err != nil {
+		return &unstructured.Unstructured{}, err
+	}
+	return route, nil
+}
+
+// routeAdmittedHost returns the host name of the Route admitted by the
+// OpenShift router, if any.
+func routeAdmittedHost(route unstructured.Unstructured) (string, bool) {
+	ingresses, _, _ := unstructured.NestedSlice(route.Object, "status", "ingress")
+	for _, ingress := range ingresses {
+		ingress, ok := ingress.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		conditions, _, _ := unstructured.NestedSlice(ingress, "conditions")
+		for _, condition := range conditions {
+			condition, ok := condition.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			if condition["type"] == "Admitted" && condition["status"] == "True" {
+				host, _, _ := unstructured.NestedString(ingress, "host")
+				return host, true
+			}
+		}
+	}
+
+	return "", false
+}
+
+// updateHomeserverWithRouteTLS is a function of type updateDataFunc, to be
+// passed as an argument in a call to withConfigMapUpdates.
+//
+// It configures the certificate issued by the OpenShift service CA, and adds
+// a TLS listener on routeTLSPort serving the client and federation APIs, if
+// not already defined.
+func (r *SynapseReconciler) updateHomeserverWithRouteTLS(s synapsev1alpha1.Synapse, homeserver map[string]interface{}) error {
+	homeserver["tls_certificate_path"] = routeTLSMountPath + "/tls.crt"
+	homeserver["tls_private_key_path"] = routeTLSMountPath + "/tls.key"
+
+	listeners, _ := homeserver["listeners"].([]interface{})
+	for _, listener := range listeners {
+		if listener, ok := listener.(map[interface{}]interface{}); ok && listener["port"] == routeTLSPort {
+			return nil
+		}
+	}
+
+	homeserver["listeners"] = append(listeners, HomeserverListener{
+		Port:       routeTLSPort,
+		Type:       "http",
+		TLS:        true,
+		XForwarded: true,
+		Resources: []HomeserverListenerResource{{
+			Names:    []string{"client", "federation"},
+			Compress: false,
+		}},
+	})
+	return nil
+}
+
+// setRouteCondition sets the RouteAdmitted condition and the admitted host
+// name in the Synapse Status, depending on the status of the given Route.
+func (r *SynapseReconciler) setRouteCondition(synapse *synapsev1alpha1.Synapse, route unstructured.Unstructured) {
+	host, admitted := routeAdmittedHost(route)
+	synapse.Status.Route.Host = host
+
+	if !admitted {
+		r.setStatusCondition(
+			synapse,
+			synapsev1alpha1.ConditionTypeRouteAdmitted,
+			metav1.ConditionFalse,
+			reasonRouteNotAdmitted,
+			"Route "+route.GetName()+" is not admitted yet",
+		)
+		return
+	}
+
+	r.setStatusCondition(
+		synapse,
+		synapsev1alpha1.ConditionTypeRouteAdmitted,
+		metav1.ConditionTrue,
+		reasonRouteAdmitted,
+		"Route "+route.GetName()+" is admitted with host "+host,
+	)
+}
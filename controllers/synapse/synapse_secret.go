@@ -0,0 +1,188 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package synapse
+
+import (
+	"context"
+	"errors"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	synapsev1alpha1 "github.com/opdev/synapse-operator/apis/synapse/v1alpha1"
+)
+
+// homeserverSecretKeys lists the homeserver.yaml keys holding secret values.
+// They are expected to be found in the Secret used by Synapse.
+var homeserverSecretKeys = []string{
+	"registration_shared_secret",
+	"macaroon_secret_key",
+	"form_secret",
+}
+
+// homeserverSensitiveKeys lists the top-level homeserver.yaml sections which
+// may contain sensitive values. Those sections are rendered in a separate
+// configuration file stored in a Secret, rather than in the Synapse ConfigMap.
+// Synapse merges its configuration files section by section, therefore
+// sections are always moved as a whole.
+var homeserverSensitiveKeys = append([]string{
+	"database",
+	"email",
+	"jwt_config",
+	"oidc_providers",
+	"recaptcha_private_key",
+	"redis",
+	"turn_shared_secret",
+	"worker_replication_secret",
+}, homeserverSecretKeys...)
+
+// secretForSynapse returns a function of type createResourceFunc, to be passed
+// as an argument in a call to reconcileResouce.
+//
+// The returned function returns a Secret containing a random value for each of the
+// homeserverSecretKeys. Values are generated only once, and are kept from the
+// existing Secret afterwards.
+func (r *SynapseReconciler) secretForSynapse(ctx context.Context) createResourceFunc {
+	return func(s *synapsev1alpha1.Synapse, objectMeta metav1.ObjectMeta) (client.Object, error) {
+		existingSecret, err := r.getExistingSecret(ctx, objectMeta)
+		if err != nil {
+			return &corev1.Secret{}, err
+		}
+
+		data := map[string][]byte{}
+		for _, key := range homeserverSecretKeys {
+			if value := existingSecret.Data[key]; len(value) != 0 {
+				data[key] = value
+				continue
+			}
+
+			value, err := generateRandomString(50)
+			if err != nil {
+				return &corev1.Secret{}, err
+			}
+			data[key] = []byte(value)
+		}
+
+		secret := &corev1.Secret{
+			ObjectMeta: objectMeta,
+			Type:       corev1.SecretTypeOpaque,
+			Data:       data,
+		}
+
+		// Set Synapse instance as the owner and controller
+		if err := ctrl.SetControllerReference(s, secret, r.Scheme); err != nil {
+			return &corev1.Secret{}, err
+		}
+
+		return secret, nil
+	}
+}
+
+// checkHomeserverSecret ensures that a Secret contains all the
+// homeserverSecretKeys.
+func (r *SynapseReconciler) checkHomeserverSecret(secret corev1.Secret) error {
+	for _, key := range homeserverSecretKeys {
+		if len(secret.Data[key]) == 0 {
+			return errors.New("missing " + key + " in Secret " + secret.Name)
+		}
+	}
+
+	return nil
+}
+
+// configSecretForSynapse is a function of type createResourceFunc, to be
+// passed as an argument in a call to reconcileResouce.
+//
+// It returns a Secret containing an empty homeserver-secrets.yaml
+// configuration file. This file is later populated with the sensitive
+// sections of the Synapse configuration, and passed to Synapse as an
+// additional configuration file.
+func (r *SynapseReconciler) configSecretForSynapse(s *synapsev1alpha1.Synapse, objectMeta metav1.ObjectMeta) (client.Object, error) {
+	secret := &corev1.Secret{
+		ObjectMeta: objectMeta,
+		Type:       corev1.SecretTypeOpaque,
+		Data:       map[string][]byte{"homeserver-secrets.yaml": []byte("{}\n")},
+	}
+
+	// Set Synapse instance as the owner and controller
+	if err := ctrl.SetControllerReference(s, secret, r.Scheme); err != nil {
+		return &corev1.Secret{}, err
+	}
+
+	return secret, nil
+}
+
+// extractSensitiveConfig returns a function of type updateDataFunc, to be
+// passed as an argument in a call to withConfigMapUpdates.
+//
+// The returned function removes the homeserverSensitiveKeys, as well as the
+// given additional keys, from homeserver.yaml, and saves them in the given
+// sensitiveConfig map.
+func (r *SynapseReconciler) extractSensitiveConfig(sensitiveConfig map[string]interface{}, keys ...string) updateDataFunc {
+	return func(s synapsev1alpha1.Synapse, homeserver map[string]interface{}) error {
+		for _, sensitiveKeys := range [][]string{homeserverSensitiveKeys, keys} {
+			for _, key := range sensitiveKeys {
+				if value, ok := homeserver[key]; ok {
+					sensitiveConfig[key] = value
+					delete(homeserver, key)
+				}
+			}
+		}
+		return nil
+	}
+}
+
+// mergeSensitiveConfig returns a function of type updateDataFunc, to be
+// passed as an argument in a call to withSecretUpdates.
+//
+// The returned function writes the content of the given sensitiveConfig map
+// in homeserver-secrets.yaml, overriding any existing value.
+func (r *SynapseReconciler) mergeSensitiveConfig(sensitiveConfig map[string]interface{}) updateDataFunc {
+	return func(s synapsev1alpha1.Synapse, homeserverSecrets map[string]interface{}) error {
+		for key, value := range sensitiveConfig {
+			homeserverSecrets[key] = value
+		}
+		return nil
+	}
+}
+
+// updateHomeserverWithSecrets returns a function of type updateDataFunc, to
+// be passed as an argument in a call to withSecretUpdates.
+//
+// The returned function writes the values found in the given Secret in
+// homeserver-secrets.yaml. If the homeserver.yaml was provided by the user
+// via a ConfigMap, values already defined by the user are kept, unless a
+// Secret was explicitly referenced in Spec.Homeserver.Secret.
+func (r *SynapseReconciler) updateHomeserverWithSecrets(secret corev1.Secret) updateDataFunc {
+	return func(s synapsev1alpha1.Synapse, homeserverSecrets map[string]interface{}) error {
+		if err := r.checkHomeserverSecret(secret); err != nil {
+			return err
+		}
+
+		keepUserValues := s.Spec.Homeserver.ConfigMap != nil && s.Spec.Homeserver.Secret == nil
+		for _, key := range homeserverSecretKeys {
+			if _, ok := homeserverSecrets[key]; ok && keepUserValues {
+				continue
+			}
+			homeserverSecrets[key] = string(secret.Data[key])
+		}
+
+		return nil
+	}
+}
@@ -26,7 +26,8 @@ import (
 	synapsev1alpha1 "github.com/opdev/synapse-operator/apis/synapse/v1alpha1"
 )
 
-// serviceForSynapse returns a synapse Service object
+// serviceForSynapse returns a synapse Service object. In worker mode, it
+// selects the reverse proxy routing the requests to the workers.
 func (r *SynapseReconciler) serviceForSynapse(s *synapsev1alpha1.Synapse, objectMeta metav1.ObjectMeta) (client.Object, error) {
 	service := &corev1.Service{
 		ObjectMeta: objectMeta,
@@ -37,10 +38,23 @@ func (r *SynapseReconciler) serviceForSynapse(s *synapsev1alpha1.Synapse, object
 				Port:       8008,
 				TargetPort: intstr.FromInt(8008),
 			}},
-			Selector: labelsForSynapse(s.Name),
+			Selector: labelsForSynapseService(*s),
 			Type:     corev1.ServiceTypeClusterIP,
 		},
 	}
+
+	// With the reencrypt Route termination, Synapse also serves its APIs
+	// over TLS, with a certificate issued by the OpenShift service CA
+	if isRouteReencrypt(*s) {
+		service.Spec.Ports = append(service.Spec.Ports, corev1.ServicePort{
+			Name:       "https",
+			Protocol:   corev1.ProtocolTCP,
+			Port:       routeTLSPort,
+			TargetPort: intstr.FromInt(routeTLSPort),
+		})
+		setAnnotation(&service.ObjectMeta, servingCertSecretAnnotation, servingCertSecretName(*s))
+	}
+
 	// Set Synapse instance as the owner and controller
 	if err := ctrl.SetControllerReference(s, service, r.Scheme); err != nil {
 		return &corev1.Service{}, err
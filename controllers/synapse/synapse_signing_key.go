@@ -0,0 +1,258 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package synapse
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	synapsev1alpha1 "github.com/opdev/synapse-operator/apis/synapse/v1alpha1"
+)
+
+// rotateSigningKeyAnnotation is the Synapse annotation used to request the
+// rotation of a signing key generated by the Synapse Operator. A new key is
+// generated every time the value of the annotation changes, and the previous
+// key is moved to old_signing_keys. The last value handled by the Synapse
+// Operator is recorded on the signing key Secret.
+const rotateSigningKeyAnnotation = "synapse.opdev.io/rotate-signing-key"
+
+// The signing key Secret is mounted in signingKeyMountPath. signingKeyFile
+// holds the current key, in the format used by Synapse. oldSigningKeysFile
+// holds the verify keys of the rotated signing keys, in the format of the
+// old_signing_keys section of homeserver.yaml.
+const (
+	signingKeyFile      = "signing.key"
+	oldSigningKeysFile  = "old_signing_keys.yaml"
+	signingKeyMountPath = "/data-signing-key"
+)
+
+// oldSigningKey is an entry of the old_signing_keys section of
+// homeserver.yaml.
+type oldSigningKey struct {
+	Key       string `yaml:"key"`
+	ExpiredTs int64  `yaml:"expired_ts"`
+}
+
+// signingKeySecretName returns the name of the Secret holding the signing key
+// used by Synapse.
+func signingKeySecretName(s synapsev1alpha1.Synapse) string {
+	if s.Spec.Homeserver.SigningKeySecret != nil {
+		return s.Spec.Homeserver.SigningKeySecret.Name
+	}
+	return s.Name + "-signing-key"
+}
+
+// generateSigningKey returns a new ed25519 signing key, in the format used by
+// Synapse: 'ed25519 <version> <unpadded base64 seed>'.
+func generateSigningKey() (string, error) {
+	version, err := generateRandomString(4)
+	if err != nil {
+		return "", err
+	}
+
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return "", err
+	}
+
+	return "ed25519 a_" + version + " " + base64.RawStdEncoding.EncodeToString(privateKey.Seed()), nil
+}
+
+// parseSigningKey returns the key ID (e.g. 'ed25519:a_AbCd') and the unpadded
+// base64 verify key of a signing key in the format used by Synapse. Only the
+// first key of the file is considered, as done by Synapse.
+func parseSigningKey(content string) (string, string, error) {
+	fields := strings.Fields(strings.SplitN(strings.TrimSpace(content), "\n", 2)[0])
+	if len(fields) != 3 {
+		return "", "", errors.New("signing key must be in the format 'ed25519 <version> <base64 seed>'")
+	}
+
+	if fields[0] != "ed25519" {
+		return "", "", errors.New("unsupported signing key algorithm " + fields[0])
+	}
+
+	seed, err := base64.RawStdEncoding.DecodeString(strings.TrimRight(fields[2], "="))
+	if err != nil {
+		return "", "", errors.New("signing key is not valid base64: " + err.Error())
+	}
+	if len(seed) != ed25519.SeedSize {
+		return "", "", errors.New("signing key seed must be 32 bytes long")
+	}
+
+	verifyKey := ed25519.NewKeyFromSeed(seed).Public().(ed25519.PublicKey)
+	return fields[0] + ":" + fields[1], base64.RawStdEncoding.EncodeToString(verifyKey), nil
+}
+
+// secretForSigningKey returns a function of type createResourceFunc, to be
+// passed as an argument in a call to reconcileResouce.
+//
+// The returned function returns a Secret containing a signing key for Synapse. The key of the
+// existing Secret is kept, unless a rotation has been requested via the
+// rotateSigningKeyAnnotation. In that case, the verify key of the previous
+// signing key is added to the old signing keys, expiring immediately.
+func (r *SynapseReconciler) secretForSigningKey(ctx context.Context) createResourceFunc {
+	return func(s *synapsev1alpha1.Synapse, objectMeta metav1.ObjectMeta) (client.Object, error) {
+		existingSecret, err := r.getExistingSecret(ctx, objectMeta)
+		if err != nil {
+			return &corev1.Secret{}, err
+		}
+
+		oldSigningKeys := map[string]oldSigningKey{}
+		if content := existingSecret.Data[oldSigningKeysFile]; len(content) != 0 {
+			if err := yaml.Unmarshal(content, oldSigningKeys); err != nil {
+				return &corev1.Secret{}, err
+			}
+		}
+
+		rotation := s.Annotations[rotateSigningKeyAnnotation]
+		signingKey := string(existingSecret.Data[signingKeyFile])
+
+		if signingKey != "" && existingSecret.Annotations[rotateSigningKeyAnnotation] != rotation {
+			keyID, verifyKey, err := parseSigningKey(signingKey)
+			if err != nil {
+				return &corev1.Secret{}, err
+			}
+			oldSigningKeys[keyID] = oldSigningKey{
+				Key:       verifyKey,
+				ExpiredTs: time.Now().UnixNano() / int64(time.Millisecond),
+			}
+			signingKey = ""
+		}
+
+		if signingKey == "" {
+			if signingKey, err = generateSigningKey(); err != nil {
+				return &corev1.Secret{}, err
+			}
+		}
+
+		oldSigningKeysContent, err := yaml.Marshal(oldSigningKeys)
+		if err != nil {
+			return &corev1.Secret{}, err
+		}
+
+		secret := &corev1.Secret{
+			ObjectMeta: objectMeta,
+			Type:       corev1.SecretTypeOpaque,
+			Data: map[string][]byte{
+				signingKeyFile:     []byte(signingKey),
+				oldSigningKeysFile: oldSigningKeysContent,
+			},
+		}
+
+		// Record the rotation the signing key corresponds to
+		setAnnotation(&secret.ObjectMeta, rotateSigningKeyAnnotation, rotation)
+
+		// Set Synapse instance as the owner and controller
+		if err := ctrl.SetControllerReference(s, secret, r.Scheme); err != nil {
+			return &corev1.Secret{}, err
+		}
+
+		return secret, nil
+	}
+}
+
+// errSigningKeyNotMigrated is returned by checkSigningKeyMigration when the
+// signing key of Synapse is only stored on its PVC.
+var errSigningKeyNotMigrated = errors.New("the signing key of Synapse must be migrated from its PVC")
+
+// checkSigningKeyMigration ensures that a signing key can be generated for a
+// Synapse instance. Earlier versions of the Synapse Operator let Synapse
+// generate its signing key on the PVC, in '/data/<server_name>.signing.key'.
+// If the PVC exists while the generated signing key Secret doesn't, the key
+// must first be migrated to a Secret referenced in
+// Spec.Homeserver.SigningKeySecret.
+func (r *SynapseReconciler) checkSigningKeyMigration(ctx context.Context, s synapsev1alpha1.Synapse) error {
+	var secret corev1.Secret
+	err := r.Get(ctx, types.NamespacedName{Name: signingKeySecretName(s), Namespace: s.Namespace}, &secret)
+	if err == nil || !k8serrors.IsNotFound(err) {
+		return err
+	}
+
+	var pvc corev1.PersistentVolumeClaim
+	err = r.Get(ctx, types.NamespacedName{Name: s.Name, Namespace: s.Namespace}, &pvc)
+	if k8serrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	return fmt.Errorf(
+		"%w: the PVC %s exists without the %s Secret, create a Secret from the "+
+			"<server_name>.signing.key file of the PVC and reference it in spec.homeserver.signingKeySecret",
+		errSigningKeyNotMigrated,
+		pvc.Name,
+		signingKeySecretName(s),
+	)
+}
+
+// checkSigningKeySecret ensures that a Secret contains a valid signing key,
+// and returns its key ID.
+func (r *SynapseReconciler) checkSigningKeySecret(secret corev1.Secret) (string, error) {
+	if len(secret.Data[signingKeyFile]) == 0 {
+		return "", errors.New("missing " + signingKeyFile + " in Secret " + secret.Name)
+	}
+
+	keyID, _, err := parseSigningKey(string(secret.Data[signingKeyFile]))
+	if err != nil {
+		return "", errors.New("invalid " + signingKeyFile + " in Secret " + secret.Name + ": " + err.Error())
+	}
+
+	return keyID, nil
+}
+
+// updateHomeserverWithSigningKey returns a function of type updateDataFunc,
+// to be passed as an argument in a call to withConfigMapUpdates.
+//
+// The returned function points 'signing_key_path' to the signing key mounted
+// from the given Secret. If the Secret holds old signing keys, they are
+// written in the 'old_signing_keys' section.
+func (r *SynapseReconciler) updateHomeserverWithSigningKey(secret corev1.Secret) updateDataFunc {
+	return func(s synapsev1alpha1.Synapse, homeserver map[string]interface{}) error {
+		homeserver["signing_key_path"] = signingKeyMountPath + "/" + signingKeyFile
+
+		content, ok := secret.Data[oldSigningKeysFile]
+		if !ok {
+			return nil
+		}
+
+		oldSigningKeys := map[string]oldSigningKey{}
+		if err := yaml.Unmarshal(content, oldSigningKeys); err != nil {
+			return err
+		}
+		if len(oldSigningKeys) == 0 {
+			delete(homeserver, "old_signing_keys")
+			return nil
+		}
+		homeserver["old_signing_keys"] = oldSigningKeys
+		return nil
+	}
+}
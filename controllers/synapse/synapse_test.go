@@ -5,7 +5,12 @@
 package synapse
 
 import (
+	"bufio"
 	"context"
+	"net"
+	"os"
+	"strconv"
+	"strings"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
@@ -13,10 +18,78 @@ import (
 
 	pgov1beta1 "github.com/crunchydata/postgres-operator/pkg/apis/postgres-operator.crunchydata.com/v1beta1"
 	synapsev1alpha1 "github.com/opdev/synapse-operator/apis/synapse/v1alpha1"
+	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
 	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 )
 
 var _ = Describe("Unit tests for Synapse package", Label("unit"), func() {
+	// newTestReconciler returns a SynapseReconciler backed by a fake client
+	// holding the given objects. The scheme registers all the resources
+	// managed by the Synapse Operator.
+	var newTestReconciler = func(objects ...client.Object) SynapseReconciler {
+		scheme := runtime.NewScheme()
+		Expect(synapsev1alpha1.AddToScheme(scheme)).Should(Succeed())
+		Expect(corev1.AddToScheme(scheme)).Should(Succeed())
+		Expect(appsv1.AddToScheme(scheme)).Should(Succeed())
+		Expect(networkingv1.AddToScheme(scheme)).Should(Succeed())
+		Expect(autoscalingv2.AddToScheme(scheme)).Should(Succeed())
+		Expect(pgov1beta1.AddToScheme(scheme)).Should(Succeed())
+
+		return SynapseReconciler{
+			Client:   fake.NewClientBuilder().WithScheme(scheme).WithObjects(objects...).Build(),
+			Scheme:   scheme,
+			Recorder: record.NewFakeRecorder(10),
+		}
+	}
+
+	// newTestSynapse returns a Synapse instance named "test" in the "default"
+	// namespace, with the given Spec.
+	var newTestSynapse = func(spec synapsev1alpha1.SynapseSpec) synapsev1alpha1.Synapse {
+		return synapsev1alpha1.Synapse{
+			ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"},
+			Spec:       spec,
+		}
+	}
+
+	// renderResource calls a createResourceFunc and expects it to succeed.
+	var renderResource = func(
+		createResource createResourceFunc,
+		s synapsev1alpha1.Synapse,
+		objectMeta metav1.ObjectMeta,
+	) client.Object {
+		resource, err := createResource(&s, objectMeta)
+		Expect(err).ShouldNot(HaveOccurred())
+		return resource
+	}
+
+	// renderHomeserver applies an updateDataFunc to the homeserver.yaml of a
+	// ConfigMap, and returns the updated homeserver.yaml.
+	var renderHomeserver = func(
+		r *SynapseReconciler,
+		s synapsev1alpha1.Synapse,
+		cm *corev1.ConfigMap,
+		update updateDataFunc,
+	) map[string]interface{} {
+		Expect(r.updateConfigMapData(cm, s, update, "homeserver.yaml")).Should(Succeed())
+
+		homeserver := map[string]interface{}{}
+		Expect(yaml.Unmarshal([]byte(cm.Data["homeserver.yaml"]), homeserver)).Should(Succeed())
+		return homeserver
+	}
+
 	// Testing ParseHomeserverConfigMap
 	Context("When parsing the homeserver ConfigMap", func() {
 		var r SynapseReconciler
@@ -67,6 +140,24 @@ var _ = Describe("Unit tests for Synapse package", Label("unit"), func() {
 				})
 			})
 
+			When("when server name differs from the server name of the deployed Synapse", func() {
+				BeforeEach(func() {
+					data = map[string]string{
+						"homeserver.yaml": "server_name: my-new-server-name\nreport_stats: false",
+					}
+					s.Status.HomeserverConfiguration.ServerName = "my-server-name"
+					s.Status.HomeserverConfiguration.ReportStats = true
+				})
+
+				It("should refuse the new server name and leave the Synapse Status unchanged", func() {
+					err := r.ParseHomeserverConfigMap(ctx, &s, cm)
+					Expect(err).Should(HaveOccurred())
+					Expect(err.Error()).Should(ContainSubstring("can't be changed from my-server-name"))
+					Expect(s.Status.HomeserverConfiguration.ServerName).Should(Equal("my-server-name"))
+					Expect(s.Status.HomeserverConfiguration.ReportStats).Should(BeTrue())
+				})
+			})
+
 			When("when 'homeserver.yaml' is not present in the ConfigMap data", func() {
 				BeforeEach(func() {
 					data = map[string]string{
@@ -274,7 +365,9 @@ var _ = Describe("Unit tests for Synapse package", Label("unit"), func() {
 			Expect(s.Status.DatabaseConnectionInfo.ConnectionURL).Should(Equal("unittestdb-primary.unittest-postgres.svc:5432"))
 			Expect(s.Status.DatabaseConnectionInfo.DatabaseName).Should(Equal("synapse"))
 			Expect(s.Status.DatabaseConnectionInfo.User).Should(Equal("synapse"))
-			Expect(s.Status.DatabaseConnectionInfo.Password).Should(Equal(string(base64encode("iOycqrF;EbyqUo7Z2oma}.<L"))))
+			Expect(s.Status.DatabaseConnectionInfo.PasswordSecretKeyRef.Name).Should(Equal("unittestdb-pguser-synapse"))
+			Expect(s.Status.DatabaseConnectionInfo.PasswordSecretKeyRef.Key).Should(Equal("password"))
+			Expect(s.Status.DatabaseConnectionInfo.Password).Should(BeEmpty())
 			//Expect(s.Status.DatabaseConnectionInfo.State).Should(Equal("RUNNING"))
 		}
 
@@ -282,7 +375,9 @@ var _ = Describe("Unit tests for Synapse package", Label("unit"), func() {
 			// Init variables
 			r = SynapseReconciler{}
 			s = synapsev1alpha1.Synapse{}
-			postgresSecret = corev1.Secret{}
+			postgresSecret = corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "unittestdb-pguser-synapse"},
+			}
 
 			// Init default value the Synapse Status state and for the Secret given as
 			// input. These values are intended to be overwritten in the different tests
@@ -343,11 +438,12 @@ var _ = Describe("Unit tests for Synapse package", Label("unit"), func() {
 		var homeserver_out map[interface{}]interface{}
 		var s synapsev1alpha1.Synapse
 		var synapseDatabaseInfo synapsev1alpha1.SynapseStatusDatabaseConnectionInfo
+		var ctx context.Context
 
 		// Re-usable test for checking different happy paths
 		check_happy_path := func() {
 			By("Updating the ConfigMap Data")
-			Expect(r.updateConfigMapData(&cm, s, r.updateHomeserverWithPostgreSQLInfos, "homeserver.yaml")).Should(Succeed())
+			Expect(r.updateConfigMapData(&cm, s, r.updateHomeserverWithPostgreSQLInfos(ctx), "homeserver.yaml")).Should(Succeed())
 
 			By("Parsing the ConfigMap Data and checking Database information are correct")
 			configMapData, ok := cm.Data["homeserver.yaml"]
@@ -403,9 +499,17 @@ var _ = Describe("Unit tests for Synapse package", Label("unit"), func() {
 
 		BeforeEach(func() {
 			// Init variables
-			r = SynapseReconciler{}
+			ctx = context.Background()
+			r = SynapseReconciler{
+				Client: fake.NewClientBuilder().WithObjects(&corev1.Secret{
+					ObjectMeta: metav1.ObjectMeta{Name: "unittestdb-pguser-synapse", Namespace: "default"},
+					Data:       map[string][]byte{"password": []byte("iOycqrF;EbyqUo7Z2oma}.<L+")},
+				}).Build(),
+			}
 			cm = corev1.ConfigMap{}
-			s = synapsev1alpha1.Synapse{}
+			s = synapsev1alpha1.Synapse{
+				ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"},
+			}
 			homeserver_out = make(map[interface{}]interface{})
 
 			// Init default value for pre-existing homeserver.yaml, and for Synapse
@@ -426,8 +530,11 @@ var _ = Describe("Unit tests for Synapse package", Label("unit"), func() {
 				ConnectionURL: "unittestdb-primary.unittest-postgres.svc:5432",
 				DatabaseName:  "synapse",
 				User:          "synapse",
-				Password:      string(base64encode("iOycqrF;EbyqUo7Z2oma}.<L+")),
-				State:         "RUNNING",
+				PasswordSecretKeyRef: synapsev1alpha1.SynapseStatusSecretKeyRef{
+					Name: "unittestdb-pguser-synapse",
+					Key:  "password",
+				},
+				State: "RUNNING",
 			}
 
 		})
@@ -489,7 +596,7 @@ var _ = Describe("Unit tests for Synapse package", Label("unit"), func() {
 			})
 
 			It("Should fail to update the ConfigMap data", func() {
-				Expect(r.updateConfigMapData(&cm, s, r.updateHomeserverWithPostgreSQLInfos, "homeserver.yaml")).ShouldNot(Succeed())
+				Expect(r.updateConfigMapData(&cm, s, r.updateHomeserverWithPostgreSQLInfos(ctx), "homeserver.yaml")).ShouldNot(Succeed())
 			})
 		})
 
@@ -499,7 +606,7 @@ var _ = Describe("Unit tests for Synapse package", Label("unit"), func() {
 			})
 
 			It("Should fail to update the ConfigMap data", func() {
-				Expect(r.updateConfigMapData(&cm, s, r.updateHomeserverWithPostgreSQLInfos, "homeserver.yaml")).ShouldNot(Succeed())
+				Expect(r.updateConfigMapData(&cm, s, r.updateHomeserverWithPostgreSQLInfos(ctx), "homeserver.yaml")).ShouldNot(Succeed())
 			})
 		})
 
@@ -509,7 +616,7 @@ var _ = Describe("Unit tests for Synapse package", Label("unit"), func() {
 			})
 
 			It("Should fail to update the ConfigMap data", func() {
-				Expect(r.updateConfigMapData(&cm, s, r.updateHomeserverWithPostgreSQLInfos, "homeserver.yaml")).ShouldNot(Succeed())
+				Expect(r.updateConfigMapData(&cm, s, r.updateHomeserverWithPostgreSQLInfos(ctx), "homeserver.yaml")).ShouldNot(Succeed())
 			})
 		})
 
@@ -519,7 +626,7 @@ var _ = Describe("Unit tests for Synapse package", Label("unit"), func() {
 			})
 
 			It("Should fail to update the ConfigMap data", func() {
-				Expect(r.updateConfigMapData(&cm, s, r.updateHomeserverWithPostgreSQLInfos, "homeserver.yaml")).ShouldNot(Succeed())
+				Expect(r.updateConfigMapData(&cm, s, r.updateHomeserverWithPostgreSQLInfos(ctx), "homeserver.yaml")).ShouldNot(Succeed())
 			})
 		})
 
@@ -529,18 +636,2527 @@ var _ = Describe("Unit tests for Synapse package", Label("unit"), func() {
 			})
 
 			It("Should fail to update the ConfigMap data", func() {
-				Expect(r.updateConfigMapData(&cm, s, r.updateHomeserverWithPostgreSQLInfos, "homeserver.yaml")).ShouldNot(Succeed())
+				Expect(r.updateConfigMapData(&cm, s, r.updateHomeserverWithPostgreSQLInfos(ctx), "homeserver.yaml")).ShouldNot(Succeed())
+			})
+		})
+
+		When("when Synapse Status database connection information is missing the password Secret reference", func() {
+			BeforeEach(func() {
+				synapseDatabaseInfo.PasswordSecretKeyRef = synapsev1alpha1.SynapseStatusSecretKeyRef{}
+			})
+
+			It("Should fail to update the ConfigMap data", func() {
+				Expect(r.updateConfigMapData(&cm, s, r.updateHomeserverWithPostgreSQLInfos(ctx), "homeserver.yaml")).ShouldNot(Succeed())
+			})
+		})
+
+		When("when the referenced password Secret doesn't exist", func() {
+			BeforeEach(func() {
+				synapseDatabaseInfo.PasswordSecretKeyRef.Name = "does-not-exist"
+			})
+
+			It("Should fail to update the ConfigMap data", func() {
+				Expect(r.updateConfigMapData(&cm, s, r.updateHomeserverWithPostgreSQLInfos(ctx), "homeserver.yaml")).ShouldNot(Succeed())
 			})
 		})
 
-		When("when Synapse Status database connection information is missing the password", func() {
+		When("when the referenced password Secret is missing the password key", func() {
 			BeforeEach(func() {
-				synapseDatabaseInfo.Password = ""
+				synapseDatabaseInfo.PasswordSecretKeyRef.Key = "missing-key"
 			})
 
 			It("Should fail to update the ConfigMap data", func() {
-				Expect(r.updateConfigMapData(&cm, s, r.updateHomeserverWithPostgreSQLInfos, "homeserver.yaml")).ShouldNot(Succeed())
+				Expect(r.updateConfigMapData(&cm, s, r.updateHomeserverWithPostgreSQLInfos(ctx), "homeserver.yaml")).ShouldNot(Succeed())
 			})
 		})
 	})
-})
+	Context("When migrating the database password out of the Synapse Status", func() {
+		var r SynapseReconciler
+		var ctx context.Context
+		var s synapsev1alpha1.Synapse
+
+		BeforeEach(func() {
+			ctx = context.Background()
+			s = newTestSynapse(synapsev1alpha1.SynapseSpec{})
+			s.Status.DatabaseConnectionInfo = synapsev1alpha1.SynapseStatusDatabaseConnectionInfo{
+				ConnectionURL: "unittestdb-primary.unittest-postgres.svc:5432",
+				DatabaseName:  "synapse",
+				User:          "synapse",
+				Password:      "aU95Y3FyRjtFYnlxVW83WjJvbWF9LjxM",
+				State:         "READY",
+			}
+			r = newTestReconciler(s.DeepCopy())
+			// Fetch the Synapse from the client, as done at the start of a reconciliation
+			Expect(r.Get(ctx, types.NamespacedName{Name: "test", Namespace: "default"}, &s)).Should(Succeed())
+		})
+
+		It("Should replace the password with a reference to the PostgreSQL Secret", func() {
+			Expect(r.migrateSynapseStatusDatabasePassword(ctx, &s)).Should(Succeed())
+
+			var updated synapsev1alpha1.Synapse
+			Expect(r.Get(ctx, types.NamespacedName{Name: "test", Namespace: "default"}, &updated)).Should(Succeed())
+			Expect(updated.Status.DatabaseConnectionInfo.Password).Should(BeEmpty())
+			Expect(updated.Status.DatabaseConnectionInfo.PasswordSecretKeyRef.Name).Should(Equal("test-pgsql-pguser-synapse"))
+			Expect(updated.Status.DatabaseConnectionInfo.PasswordSecretKeyRef.Key).Should(Equal("password"))
+			Expect(updated.Status.DatabaseConnectionInfo.User).Should(Equal("synapse"))
+		})
+
+		It("Should keep an existing reference to the password Secret", func() {
+			s.Status.DatabaseConnectionInfo.PasswordSecretKeyRef = synapsev1alpha1.SynapseStatusSecretKeyRef{
+				Name: "custom-secret",
+				Key:  "custom-key",
+			}
+			Expect(r.migrateSynapseStatusDatabasePassword(ctx, &s)).Should(Succeed())
+			Expect(s.Status.DatabaseConnectionInfo.Password).Should(BeEmpty())
+			Expect(s.Status.DatabaseConnectionInfo.PasswordSecretKeyRef.Name).Should(Equal("custom-secret"))
+		})
+	})
+
+	Context("When enforcing the DeletionPolicy of a Synapse instance", func() {
+		var r SynapseReconciler
+		var ctx context.Context
+		var s synapsev1alpha1.Synapse
+		var ownerReference metav1.OwnerReference
+		var otherOwnerReference metav1.OwnerReference
+
+		var buildReconciler = func(deletionPolicy synapsev1alpha1.SynapseDeletionPolicy) {
+			s = newTestSynapse(synapsev1alpha1.SynapseSpec{DeletionPolicy: deletionPolicy})
+			s.UID = "synapse-uid"
+			s.Finalizers = []string{synapseFinalizer}
+			ownerReference = metav1.OwnerReference{
+				APIVersion: "synapse.opdev.io/v1alpha1",
+				Kind:       "Synapse",
+				Name:       "test",
+				UID:        "synapse-uid",
+				Controller: BoolAddr(true),
+			}
+			otherOwnerReference = metav1.OwnerReference{
+				APIVersion: "v1",
+				Kind:       "ConfigMap",
+				Name:       "other",
+				UID:        "other-uid",
+			}
+
+			pvc := &corev1.PersistentVolumeClaim{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:            "test",
+					Namespace:       "default",
+					OwnerReferences: []metav1.OwnerReference{ownerReference, otherOwnerReference},
+				},
+			}
+			secret := &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:            "test-secrets",
+					Namespace:       "default",
+					OwnerReferences: []metav1.OwnerReference{ownerReference},
+				},
+			}
+
+			r = newTestReconciler(s.DeepCopy(), pvc, secret)
+			// Fetch the Synapse from the client, as done at the start of a reconciliation
+			Expect(r.Get(ctx, types.NamespacedName{Name: "test", Namespace: "default"}, &s)).Should(Succeed())
+		}
+
+		var getOwnerReferences = func(name string, resource client.Object) []metav1.OwnerReference {
+			Expect(r.Get(ctx, types.NamespacedName{Name: name, Namespace: "default"}, resource)).Should(Succeed())
+			return resource.GetOwnerReferences()
+		}
+
+		BeforeEach(func() {
+			ctx = context.Background()
+		})
+
+		It("Should add the finalizer to the Synapse instance", func() {
+			buildReconciler(synapsev1alpha1.DeletionPolicyDelete)
+			s.Finalizers = nil
+			Expect(r.Update(ctx, &s)).Should(Succeed())
+
+			Expect(r.ensureSynapseFinalizer(ctx, &s)).Should(Succeed())
+
+			var updated synapsev1alpha1.Synapse
+			Expect(r.Get(ctx, types.NamespacedName{Name: "test", Namespace: "default"}, &updated)).Should(Succeed())
+			Expect(updated.Finalizers).Should(ConsistOf(synapseFinalizer))
+		})
+
+		It("Should keep the owner references with the Delete policy", func() {
+			buildReconciler(synapsev1alpha1.DeletionPolicyDelete)
+
+			_, err := r.finalizeSynapse(ctx, &s)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(s.Finalizers).Should(BeEmpty())
+
+			Expect(getOwnerReferences("test", &corev1.PersistentVolumeClaim{})).Should(ContainElement(ownerReference))
+			Expect(getOwnerReferences("test-secrets", &corev1.Secret{})).Should(ContainElement(ownerReference))
+		})
+
+		It("Should orphan the Synapse data with the Retain policy", func() {
+			buildReconciler(synapsev1alpha1.DeletionPolicyRetain)
+
+			_, err := r.finalizeSynapse(ctx, &s)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(s.Finalizers).Should(BeEmpty())
+
+			Expect(getOwnerReferences("test", &corev1.PersistentVolumeClaim{})).Should(ConsistOf(otherOwnerReference))
+			Expect(getOwnerReferences("test-secrets", &corev1.Secret{})).Should(BeEmpty())
+		})
+
+		It("Should keep a user-provided Secret untouched with the Retain policy", func() {
+			buildReconciler(synapsev1alpha1.DeletionPolicyRetain)
+			s.Spec.Homeserver.Secret = &synapsev1alpha1.SynapseHomeserverSecret{Name: "user-secret"}
+
+			_, err := r.finalizeSynapse(ctx, &s)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			Expect(getOwnerReferences("test-secrets", &corev1.Secret{})).Should(ContainElement(ownerReference))
+		})
+
+		It("Should wait for a VolumeSnapshot of the PVC with the Snapshot policy", func() {
+			buildReconciler(synapsev1alpha1.DeletionPolicySnapshot)
+
+			result, err := r.finalizeSynapse(ctx, &s)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(result.RequeueAfter).ShouldNot(BeZero())
+			Expect(s.Finalizers).Should(ConsistOf(synapseFinalizer))
+
+			snapshot := &unstructured.Unstructured{}
+			snapshot.SetGroupVersionKind(volumeSnapshotGVK)
+			Expect(r.Get(ctx, types.NamespacedName{Name: "test-synapse-uid", Namespace: "default"}, snapshot)).Should(Succeed())
+			Expect(snapshot.GetOwnerReferences()).Should(BeEmpty())
+			source, _, err := unstructured.NestedString(snapshot.Object, "spec", "source", "persistentVolumeClaimName")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(source).Should(Equal("test"))
+
+			By("Marking the VolumeSnapshot as ready to use")
+			Expect(unstructured.SetNestedField(snapshot.Object, true, "status", "readyToUse")).Should(Succeed())
+			Expect(r.Update(ctx, snapshot)).Should(Succeed())
+
+			result, err = r.finalizeSynapse(ctx, &s)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(result.RequeueAfter).Should(BeZero())
+			Expect(s.Finalizers).Should(BeEmpty())
+		})
+
+		It("Should report a pending VolumeSnapshot in the Synapse Status", func() {
+			buildReconciler(synapsev1alpha1.DeletionPolicySnapshot)
+
+			_, err := r.finalizeSynapse(ctx, &s)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			var updated synapsev1alpha1.Synapse
+			Expect(r.Get(ctx, types.NamespacedName{Name: "test", Namespace: "default"}, &updated)).Should(Succeed())
+			condition := meta.FindStatusCondition(updated.Status.Conditions, synapsev1alpha1.ConditionTypeSnapshotReady)
+			Expect(condition).ShouldNot(BeNil())
+			Expect(condition.Status).Should(Equal(metav1.ConditionFalse))
+			Expect(condition.Reason).Should(Equal(reasonSnapshotNotReady))
+		})
+
+		It("Should retain the Synapse data if the VolumeSnapshot fails", func() {
+			buildReconciler(synapsev1alpha1.DeletionPolicySnapshot)
+
+			_, err := r.finalizeSynapse(ctx, &s)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			By("Reporting an error in the VolumeSnapshot")
+			snapshot := &unstructured.Unstructured{}
+			snapshot.SetGroupVersionKind(volumeSnapshotGVK)
+			Expect(r.Get(ctx, types.NamespacedName{Name: "test-synapse-uid", Namespace: "default"}, snapshot)).Should(Succeed())
+			Expect(unstructured.SetNestedField(snapshot.Object, "no VolumeSnapshotClass", "status", "error", "message")).Should(Succeed())
+			Expect(r.Update(ctx, snapshot)).Should(Succeed())
+
+			result, err := r.finalizeSynapse(ctx, &s)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(result.RequeueAfter).Should(BeZero())
+			Expect(s.Finalizers).Should(BeEmpty())
+
+			Expect(getOwnerReferences("test", &corev1.PersistentVolumeClaim{})).Should(ConsistOf(otherOwnerReference))
+			Expect(r.Recorder.(*record.FakeRecorder).Events).Should(Receive(SatisfyAll(
+				ContainSubstring(reasonSnapshotFailed),
+				ContainSubstring("no VolumeSnapshotClass"),
+			)))
+		})
+
+		It("Should retain the Synapse data if the VolumeSnapshot API is not available", func() {
+			buildReconciler(synapsev1alpha1.DeletionPolicySnapshot)
+			r.Client = noVolumeSnapshotAPIClient{r.Client}
+
+			result, err := r.finalizeSynapse(ctx, &s)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(result.RequeueAfter).Should(BeZero())
+			Expect(s.Finalizers).Should(BeEmpty())
+
+			Expect(getOwnerReferences("test", &corev1.PersistentVolumeClaim{})).Should(ConsistOf(otherOwnerReference))
+			Expect(r.Recorder.(*record.FakeRecorder).Events).Should(Receive(ContainSubstring("API is not available")))
+		})
+	})
+
+	Context("When watching the user-provided ConfigMaps", func() {
+		var r SynapseReconciler
+		var s synapsev1alpha1.Synapse
+
+		BeforeEach(func() {
+			s = synapsev1alpha1.Synapse{
+				ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"},
+				Spec: synapsev1alpha1.SynapseSpec{
+					Homeserver: synapsev1alpha1.SynapseHomeserver{
+						ConfigMap: &synapsev1alpha1.SynapseHomeserverConfigMap{Name: "my-homeserver"},
+					},
+					Bridges: synapsev1alpha1.SynapseBridges{
+						Heisenbridge: synapsev1alpha1.SynapseHeisenbridge{
+							Enabled: true,
+							ConfigMap: synapsev1alpha1.SynapseHeisenbridgeConfigMap{
+								Name:      "my-heisenbridge",
+								Namespace: "bridges",
+							},
+						},
+					},
+				},
+			}
+			r = SynapseReconciler{}
+		})
+
+		It("Should index the referenced ConfigMaps, including those of other namespaces", func() {
+			Expect(r.indexInputConfigMaps(&s)).Should(ConsistOf("default/my-homeserver", "bridges/my-heisenbridge"))
+		})
+
+		It("Should not index the Heisenbridge ConfigMap if Heisenbridge is disabled", func() {
+			s.Spec.Bridges.Heisenbridge.Enabled = false
+			Expect(r.indexInputConfigMaps(&s)).Should(ConsistOf("default/my-homeserver"))
+		})
+	})
+
+	Context("When watching the Secret of the database password", func() {
+		var r SynapseReconciler
+		var s synapsev1alpha1.Synapse
+
+		BeforeEach(func() {
+			r = SynapseReconciler{}
+			s = newTestSynapse(synapsev1alpha1.SynapseSpec{CreateNewPostgreSQL: true})
+		})
+
+		It("Should not index any Secret before the PostgresCluster is ready", func() {
+			Expect(r.indexDatabaseSecret(&s)).Should(BeEmpty())
+		})
+
+		It("Should index the Secret referenced in the Synapse Status", func() {
+			s.Status.DatabaseConnectionInfo.PasswordSecretKeyRef = synapsev1alpha1.SynapseStatusSecretKeyRef{
+				Name: "test-pguser-synapse",
+				Key:  "password",
+			}
+			Expect(r.indexDatabaseSecret(&s)).Should(ConsistOf("default/test-pguser-synapse"))
+		})
+	})
+
+	Context("When computing the configuration checksum of a Deployment", func() {
+		var r SynapseReconciler
+		var s synapsev1alpha1.Synapse
+		var configMap *corev1.ConfigMap
+		var secret *corev1.Secret
+
+		var createDeployment = func(s *synapsev1alpha1.Synapse, objectMeta metav1.ObjectMeta) (client.Object, error) {
+			return &appsv1.Deployment{ObjectMeta: objectMeta}, nil
+		}
+
+		var checksumOf = func(configObjects ...client.Object) string {
+			resource, err := r.withConfigChecksum(createDeployment, configObjects...)(&s, metav1.ObjectMeta{Name: "test"})
+			Expect(err).ShouldNot(HaveOccurred())
+			dep, ok := resource.(*appsv1.Deployment)
+			Expect(ok).Should(BeTrue())
+			Expect(dep.Spec.Template.Annotations).Should(HaveKey(configChecksumAnnotation))
+			return dep.Spec.Template.Annotations[configChecksumAnnotation]
+		}
+
+		BeforeEach(func() {
+			r = SynapseReconciler{}
+			s = synapsev1alpha1.Synapse{ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"}}
+			configMap = &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Name: "test"},
+				Data:       map[string]string{"homeserver.yaml": "server_name: example.com", "log.yaml": "version: 1"},
+			}
+			secret = &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "test"},
+				Data:       map[string][]byte{"homeserver-secrets.yaml": []byte("form_secret: secret")},
+			}
+		})
+
+		It("Should be stable for the same configuration", func() {
+			Expect(checksumOf(configMap, secret)).Should(Equal(checksumOf(configMap.DeepCopy(), secret.DeepCopy())))
+		})
+
+		It("Should change when a configuration file changes", func() {
+			checksum := checksumOf(configMap, secret)
+			configMap.Data["homeserver.yaml"] = "server_name: example.org"
+			Expect(checksumOf(configMap, secret)).ShouldNot(Equal(checksum))
+		})
+
+		It("Should change when a Secret changes", func() {
+			checksum := checksumOf(configMap, secret)
+			secret.Data["homeserver-secrets.yaml"] = []byte("form_secret: rotated")
+			Expect(checksumOf(configMap, secret)).ShouldNot(Equal(checksum))
+		})
+
+		It("Should fail for resources other than ConfigMaps and Secrets", func() {
+			_, err := r.withConfigChecksum(createDeployment, &corev1.Service{})(&s, metav1.ObjectMeta{Name: "test"})
+			Expect(err).Should(HaveOccurred())
+		})
+	})
+
+	Context("When rendering homeserver.yaml from Spec.Homeserver.Values", func() {
+		var r SynapseReconciler
+		var values synapsev1alpha1.SynapseHomeserverValues
+
+		var render = func() map[string]interface{} {
+			config, err := r.homeserverConfigForValues(values)
+			Expect(err).ShouldNot(HaveOccurred())
+			content, err := yaml.Marshal(config)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			homeserver := map[string]interface{}{}
+			Expect(yaml.Unmarshal(content, homeserver)).Should(Succeed())
+			return homeserver
+		}
+
+		BeforeEach(func() {
+			r = SynapseReconciler{}
+			values = synapsev1alpha1.SynapseHomeserverValues{
+				ServerName:  "example.com",
+				ReportStats: true,
+			}
+		})
+
+		It("Should only render the required options by default", func() {
+			homeserver := render()
+			Expect(homeserver).Should(HaveKeyWithValue("server_name", "example.com"))
+			Expect(homeserver).Should(HaveKeyWithValue("report_stats", true))
+			Expect(homeserver).Should(HaveKey("listeners"))
+			Expect(homeserver).Should(HaveKey("database"))
+			Expect(homeserver).ShouldNot(HaveKey("public_baseurl"))
+			Expect(homeserver).ShouldNot(HaveKey("enable_registration"))
+			Expect(homeserver).ShouldNot(HaveKey("federation_domain_whitelist"))
+			Expect(homeserver).ShouldNot(HaveKey("presence"))
+			Expect(homeserver).ShouldNot(HaveKey("retention"))
+
+			listeners := homeserver["listeners"].([]interface{})
+			Expect(listeners).Should(HaveLen(1))
+			Expect(listeners[0]).Should(HaveKeyWithValue("port", 8008))
+			Expect(listeners[0]).Should(HaveKeyWithValue("x_forwarded", true))
+		})
+
+		It("Should render the given options", func() {
+			presence := false
+			values.PublicBaseURL = "https://matrix.example.com/"
+			values.Listeners = []synapsev1alpha1.SynapseListener{{
+				Port:       8448,
+				XForwarded: true,
+				Resources: []synapsev1alpha1.SynapseListenerResource{{
+					Names:    []string{"federation"},
+					Compress: true,
+				}},
+			}}
+			values.Registration = &synapsev1alpha1.SynapseRegistration{
+				Enabled:       true,
+				RequiresToken: true,
+				AutoJoinRooms: []string{"#welcome:example.com"},
+			}
+			values.RateLimits = &synapsev1alpha1.SynapseRateLimits{
+				Message: &synapsev1alpha1.SynapseRateLimit{PerSecond: "0.2", BurstCount: 10},
+				Login:   &synapsev1alpha1.SynapseRateLimit{PerSecond: "1", BurstCount: 5},
+			}
+			values.Media = &synapsev1alpha1.SynapseMedia{MaxUploadSize: "100M"}
+			values.Presence = &synapsev1alpha1.SynapsePresence{Enabled: &presence}
+			values.Retention = &synapsev1alpha1.SynapseRetention{
+				Enabled:       true,
+				DefaultPolicy: &synapsev1alpha1.SynapseRetentionPolicy{MaxLifetime: "1y"},
+			}
+
+			homeserver := render()
+			Expect(homeserver).Should(HaveKeyWithValue("public_baseurl", "https://matrix.example.com/"))
+			Expect(homeserver).Should(HaveKeyWithValue("enable_registration", true))
+			Expect(homeserver).Should(HaveKeyWithValue("registration_requires_token", true))
+			Expect(homeserver).Should(HaveKeyWithValue("auto_join_rooms", ConsistOf("#welcome:example.com")))
+			Expect(homeserver).Should(HaveKeyWithValue("max_upload_size", "100M"))
+			Expect(homeserver).Should(HaveKeyWithValue("rc_message", HaveKeyWithValue("per_second", 0.2)))
+			Expect(homeserver).Should(HaveKeyWithValue("rc_login", HaveKey("address")))
+			Expect(homeserver).Should(HaveKeyWithValue("presence", HaveKeyWithValue("enabled", false)))
+			Expect(homeserver).Should(HaveKeyWithValue("retention", HaveKeyWithValue("enabled", true)))
+
+			listeners := homeserver["listeners"].([]interface{})
+			Expect(listeners).Should(HaveLen(1))
+			Expect(listeners[0]).Should(HaveKeyWithValue("port", 8448))
+			Expect(listeners[0]).Should(HaveKeyWithValue("type", "http"))
+		})
+
+		It("Should disable federation with an empty domain whitelist", func() {
+			federation := false
+			values.Federation = &synapsev1alpha1.SynapseFederation{Enabled: &federation}
+
+			homeserver := render()
+			Expect(homeserver).Should(HaveKeyWithValue("federation_domain_whitelist", BeEmpty()))
+		})
+
+		It("Should use the recommended IP range blacklist for URL previews", func() {
+			values.URLPreviews = &synapsev1alpha1.SynapseURLPreviews{Enabled: true}
+
+			homeserver := render()
+			Expect(homeserver).Should(HaveKeyWithValue("url_preview_enabled", true))
+			Expect(homeserver).Should(HaveKeyWithValue("url_preview_ip_range_blacklist", ContainElement("10.0.0.0/8")))
+		})
+
+		It("Should fail on an invalid rate limit", func() {
+			values.RateLimits = &synapsev1alpha1.SynapseRateLimits{
+				Message: &synapsev1alpha1.SynapseRateLimit{PerSecond: "fast", BurstCount: 10},
+			}
+
+			_, err := r.homeserverConfigForValues(values)
+			Expect(err).Should(HaveOccurred())
+		})
+	})
+
+	Context("When merging overrides on top of the generated homeserver.yaml", func() {
+		var r SynapseReconciler
+		var ctx context.Context
+		var s synapsev1alpha1.Synapse
+		var cm corev1.ConfigMap
+
+		BeforeEach(func() {
+			overrideConfigMap := &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Name: "overrides", Namespace: "default"},
+				Data: map[string]string{
+					"homeserver.yaml": "max_upload_size: 100M\nretention:\n  allowed_lifetime_max: 1y\n",
+				},
+			}
+			overrideSecret := &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "overrides", Namespace: "default"},
+				Data: map[string][]byte{
+					"homeserver.yaml": []byte("oidc_config:\n  client_secret: secret\n"),
+				},
+			}
+
+			ctx = context.Background()
+			r = newTestReconciler(overrideConfigMap, overrideSecret)
+			s = newTestSynapse(synapsev1alpha1.SynapseSpec{
+				Homeserver: synapsev1alpha1.SynapseHomeserver{
+					Overrides: []synapsev1alpha1.SynapseHomeserverOverride{
+						{ConfigMap: &synapsev1alpha1.SynapseHomeserverOverrideConfigMap{Name: "overrides", Key: "homeserver.yaml"}},
+						{Secret: &synapsev1alpha1.SynapseHomeserverOverrideSecret{Name: "overrides", Key: "homeserver.yaml"}},
+						{Inline: "retention:\n  enabled: true\nurl_preview_ip_range_blacklist:\n- 10.0.0.0/8\n"},
+					},
+				},
+			})
+			cm = corev1.ConfigMap{
+				Data: map[string]string{
+					"homeserver.yaml": "server_name: example.com\n" +
+						"report_stats: true\n" +
+						"retention:\n  enabled: false\n  allowed_lifetime_min: 1d\n" +
+						"url_preview_ip_range_blacklist:\n- 127.0.0.0/8\n- 192.168.0.0/16\n" +
+						"oidc_config:\n  enabled: true\n",
+				},
+			}
+		})
+
+		It("Should deep-merge the overrides in order", func() {
+			overrides, err := r.fetchHomeserverOverrides(ctx, s)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(overrides.config).Should(HaveLen(2))
+			Expect(overrides.secrets).Should(HaveLen(1))
+
+			conflicts := map[string]bool{}
+			Expect(r.updateConfigMapData(&cm, s, r.updateHomeserverWithOverrides(overrides.config, conflicts), "homeserver.yaml")).Should(Succeed())
+			Expect(conflicts).Should(BeEmpty())
+
+			homeserver, err := r.loadYAMLFileFromConfigMapData(cm, "homeserver.yaml")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(homeserver["max_upload_size"]).Should(Equal("100M"))
+			Expect(homeserver["retention"]).Should(Equal(map[interface{}]interface{}{
+				"enabled":              true,
+				"allowed_lifetime_min": "1d",
+				"allowed_lifetime_max": "1y",
+			}))
+			Expect(homeserver["url_preview_ip_range_blacklist"]).Should(Equal([]interface{}{"10.0.0.0/8"}))
+		})
+
+		It("Should merge the overrides read from a Secret in homeserver-secrets.yaml only", func() {
+			overrides, err := r.fetchHomeserverOverrides(ctx, s)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(overrides.secretKeys(r.homeserverManagedKeys(s))).Should(Equal([]string{"oidc_config"}))
+
+			conflicts := map[string]bool{}
+			sensitiveConfig := map[string]interface{}{}
+			extractSensitiveConfig := r.extractSensitiveConfig(sensitiveConfig, overrides.secretKeys(r.homeserverManagedKeys(s))...)
+			Expect(r.updateConfigMapData(&cm, s, extractSensitiveConfig, "homeserver.yaml")).Should(Succeed())
+
+			secret := corev1.Secret{Data: map[string][]byte{"homeserver-secrets.yaml": []byte("{}\n")}}
+			Expect(r.updateSecretData(&secret, s, r.mergeSensitiveConfig(sensitiveConfig), "homeserver-secrets.yaml")).Should(Succeed())
+			Expect(r.updateSecretData(&secret, s, r.updateHomeserverWithOverrides(overrides.secrets, conflicts), "homeserver-secrets.yaml")).Should(Succeed())
+			Expect(conflicts).Should(BeEmpty())
+
+			homeserver, err := r.loadYAMLFileFromConfigMapData(cm, "homeserver.yaml")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(homeserver).ShouldNot(HaveKey("oidc_config"))
+
+			homeserverSecrets, err := r.loadYAMLFileFromSecretData(secret, "homeserver-secrets.yaml")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(homeserverSecrets["oidc_config"]).Should(Equal(map[interface{}]interface{}{
+				"enabled":       true,
+				"client_secret": "secret",
+			}))
+		})
+
+		It("Should not override the keys managed by the Synapse Operator from a Secret", func() {
+			s.Spec.Homeserver.Overrides = []synapsev1alpha1.SynapseHomeserverOverride{
+				{Secret: &synapsev1alpha1.SynapseHomeserverOverrideSecret{Name: "overrides", Key: "homeserver.yaml"}},
+			}
+			s.Spec.CreateNewPostgreSQL = true
+			secretOverride := &corev1.Secret{}
+			Expect(r.Get(ctx, types.NamespacedName{Name: "overrides", Namespace: "default"}, secretOverride)).Should(Succeed())
+			secretOverride.Data["homeserver.yaml"] = []byte("server_name: other.com\ndatabase:\n  name: psycopg2\n")
+			Expect(r.Update(ctx, secretOverride)).Should(Succeed())
+
+			overrides, err := r.fetchHomeserverOverrides(ctx, s)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(overrides.secretKeys(r.homeserverManagedKeys(s))).Should(BeEmpty())
+
+			conflicts := map[string]bool{}
+			secret := corev1.Secret{Data: map[string][]byte{"homeserver-secrets.yaml": []byte("{}\n")}}
+			Expect(r.updateSecretData(&secret, s, r.updateHomeserverWithOverrides(overrides.secrets, conflicts), "homeserver-secrets.yaml")).Should(Succeed())
+			Expect(conflicts).Should(Equal(map[string]bool{"server_name": true, "database": true}))
+
+			homeserverSecrets, err := r.loadYAMLFileFromSecretData(secret, "homeserver-secrets.yaml")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(homeserverSecrets).Should(BeEmpty())
+		})
+
+		It("Should not override the keys managed by the Synapse Operator", func() {
+			s.Spec.Homeserver.Overrides = []synapsev1alpha1.SynapseHomeserverOverride{
+				{Inline: "server_name: other.com\nmacaroon_secret_key: macaroon\nenable_registration: true\n"},
+			}
+			overrides, err := r.fetchHomeserverOverrides(ctx, s)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			conflicts := map[string]bool{}
+			Expect(r.updateConfigMapData(&cm, s, r.updateHomeserverWithOverrides(overrides.config, conflicts), "homeserver.yaml")).Should(Succeed())
+			Expect(conflicts).Should(Equal(map[string]bool{"server_name": true, "macaroon_secret_key": true}))
+
+			homeserver, err := r.loadYAMLFileFromConfigMapData(cm, "homeserver.yaml")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(homeserver["server_name"]).Should(Equal("example.com"))
+			Expect(homeserver).ShouldNot(HaveKey("macaroon_secret_key"))
+			Expect(homeserver["enable_registration"]).Should(BeTrue())
+
+			r.setOverridesCondition(&s, conflicts)
+			condition := meta.FindStatusCondition(s.Status.Conditions, synapsev1alpha1.ConditionTypeOverridesApplied)
+			Expect(condition).ShouldNot(BeNil())
+			Expect(condition.Status).Should(Equal(metav1.ConditionFalse))
+			Expect(condition.Reason).Should(Equal(reasonOverrideConflict))
+			Expect(condition.Message).Should(HaveSuffix("macaroon_secret_key, server_name"))
+		})
+
+		It("Should fail if a referenced ConfigMap doesn't exist", func() {
+			s.Spec.Homeserver.Overrides[0].ConfigMap.Name = "missing"
+			_, err := r.fetchHomeserverOverrides(ctx, s)
+			Expect(k8serrors.IsNotFound(err)).Should(BeTrue())
+		})
+
+		It("Should fail if an override is not a valid YAML mapping", func() {
+			s.Spec.Homeserver.Overrides = []synapsev1alpha1.SynapseHomeserverOverride{{Inline: "- not a mapping"}}
+			_, err := r.fetchHomeserverOverrides(ctx, s)
+			Expect(err).Should(HaveOccurred())
+		})
+
+		It("Should watch the ConfigMaps referenced by the overrides", func() {
+			Expect(r.indexInputConfigMaps(&s)).Should(ConsistOf("default/overrides"))
+		})
+
+		It("Should watch the Secrets referenced by the overrides", func() {
+			Expect(r.indexInputSecrets(&s)).Should(ConsistOf("default/overrides"))
+		})
+	})
+
+	Context("When validating homeserver.yaml against the schema of Synapse options", func() {
+		var r SynapseReconciler
+		var homeserver map[string]interface{}
+
+		var loadHomeserver = func(content string) {
+			homeserver = map[string]interface{}{}
+			Expect(yaml.Unmarshal([]byte(content), homeserver)).Should(Succeed())
+		}
+
+		It("Should accept a valid homeserver.yaml", func() {
+			loadHomeserver("server_name: example.com\n" +
+				"report_stats: true\n" +
+				"presence:\n" +
+				"listeners:\n" +
+				"- port: 8008\n  type: http\n  x_forwarded: true\n  resources:\n  - names: [client, federation]\n    compress: false\n" +
+				"database:\n  name: psycopg2\n  args:\n    user: synapse\n" +
+				"max_upload_size: 50M\n" +
+				"rc_message:\n  per_second: 0.2\n  burst_count: 10\n")
+
+			errs, warnings := validateHomeserverConfig(homeserver)
+			Expect(errs).Should(BeEmpty())
+			Expect(warnings).Should(BeEmpty())
+		})
+
+		It("Should accept the homeserver.yaml of the examples", func() {
+			content, err := os.ReadFile("../../examples/02-using-existing-configmap/homeserver.yaml")
+			Expect(err).ShouldNot(HaveOccurred())
+			loadHomeserver(string(content))
+
+			errs, warnings := validateHomeserverConfig(homeserver)
+			Expect(errs).Should(BeEmpty())
+			Expect(warnings).Should(BeEmpty())
+		})
+
+		It("Should report type and enum errors with their YAML path", func() {
+			loadHomeserver("server_name: example.com\n" +
+				"report_stats: true\n" +
+				"listeners:\n- port: \"8008\"\n  resources:\n  - names: [client, federaton]\n" +
+				"database:\n  name: postgres\n")
+
+			errs, _ := validateHomeserverConfig(homeserver)
+			var paths []string
+			for _, err := range errs {
+				paths = append(paths, err.Field)
+			}
+			Expect(paths).Should(ConsistOf(
+				"listeners[0].port",
+				"listeners[0].resources[0].names[1]",
+				"database.name",
+			))
+		})
+
+		It("Should report unknown options as warnings", func() {
+			loadHomeserver("server_name: example.com\n" +
+				"report_stats: true\n" +
+				"listners: []\n" +
+				"listeners:\n- port: 8008\n  x_forwarder: true\n")
+
+			errs, warnings := validateHomeserverConfig(homeserver)
+			Expect(errs).Should(BeEmpty())
+			Expect(warnings).Should(ConsistOf(
+				"listeners[0].x_forwarder: unknown option",
+				"listners: unknown option",
+			))
+		})
+
+		It("Should report missing combinations of options", func() {
+			loadHomeserver("server_name: example.com\n" +
+				"report_stats: true\n" +
+				"enable_registration: true\n" +
+				"url_preview_enabled: true\n" +
+				"listeners:\n- tls: true\n")
+
+			errs, _ := validateHomeserverConfig(homeserver)
+			var paths []string
+			for _, err := range errs {
+				paths = append(paths, err.Field)
+			}
+			Expect(paths).Should(ConsistOf(
+				"listeners[0].port",
+				"tls_certificate_path",
+				"tls_private_key_path",
+				"enable_registration",
+				"url_preview_ip_range_blacklist",
+			))
+		})
+
+		It("Should reject an invalid homeserver.yaml ConfigMap and record warnings otherwise", func() {
+			s := synapsev1alpha1.Synapse{}
+			cm := corev1.ConfigMap{Data: map[string]string{
+				"homeserver.yaml": "server_name: example.com\nreport_stats: true\nlisteners:\n- port: not-a-port\n",
+			}}
+			err := r.ParseHomeserverConfigMap(context.Background(), &s, cm)
+			Expect(err).Should(MatchError(ContainSubstring("listeners[0].port")))
+
+			cm.Data["homeserver.yaml"] = "server_name: example.com\nreport_stats: true\nunknown_option: true\n"
+			Expect(r.ParseHomeserverConfigMap(context.Background(), &s, cm)).Should(Succeed())
+			Expect(s.Status.HomeserverConfiguration.Warnings).Should(Equal([]string{"unknown_option: unknown option"}))
+		})
+
+		It("Should report errors in overrides relative to Spec.Homeserver.Overrides", func() {
+			s := synapsev1alpha1.Synapse{
+				Spec: synapsev1alpha1.SynapseSpec{
+					Homeserver: synapsev1alpha1.SynapseHomeserver{
+						Overrides: []synapsev1alpha1.SynapseHomeserverOverride{
+							{Inline: "unknown_option: true\n"},
+							{Inline: "redis:\n  enabled: \"yes\"\n"},
+						},
+					},
+				},
+			}
+			_, err := r.fetchHomeserverOverrides(context.Background(), s)
+			Expect(err).Should(MatchError(ContainSubstring("spec.homeserver.overrides[1].redis.enabled")))
+		})
+	})
+
+	Context("When validating the rendered Synapse configuration", func() {
+		var r SynapseReconciler
+		var s synapsev1alpha1.Synapse
+		var configSecret corev1.Secret
+
+		var renderConfigMap = func() corev1.ConfigMap {
+			overrides, err := r.fetchHomeserverOverrides(context.Background(), s)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			createConfigMap := r.withConfigMapUpdates(
+				r.configMapForSynapse,
+				"homeserver.yaml",
+				r.updateHomeserverWithOverrides(overrides.config, map[string]bool{}),
+			)
+			return *renderResource(createConfigMap, s, metav1.ObjectMeta{Name: "test", Namespace: "default"}).(*corev1.ConfigMap)
+		}
+
+		BeforeEach(func() {
+			r = newTestReconciler()
+			s = newTestSynapse(synapsev1alpha1.SynapseSpec{
+				Homeserver: synapsev1alpha1.SynapseHomeserver{
+					Values: &synapsev1alpha1.SynapseHomeserverValues{ServerName: "example.com", ReportStats: true},
+				},
+			})
+			configSecret = corev1.Secret{Data: map[string][]byte{"homeserver-secrets.yaml": []byte("{}\n")}}
+		})
+
+		It("Should accept the generated homeserver.yaml without warnings", func() {
+			warnings, err := r.validateRenderedHomeserver(renderConfigMap(), configSecret)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(warnings).Should(BeEmpty())
+		})
+
+		It("Should check the requirements of Synapse once the overrides are merged", func() {
+			s.Spec.Homeserver.Overrides = []synapsev1alpha1.SynapseHomeserverOverride{
+				{Inline: "enable_registration: true\n"},
+			}
+			_, err := r.validateRenderedHomeserver(renderConfigMap(), configSecret)
+			Expect(err).Should(MatchError(ContainSubstring("enable_registration")))
+		})
+
+		It("Should check the sections rendered in homeserver-secrets.yaml", func() {
+			configSecret.Data["homeserver-secrets.yaml"] = []byte("database:\n  name: psycopg2\n")
+			_, err := r.validateRenderedHomeserver(renderConfigMap(), configSecret)
+			Expect(err).Should(MatchError(ContainSubstring("database.args")))
+		})
+
+		It("Should report the unknown options set by the overrides as warnings", func() {
+			s.Spec.Homeserver.Overrides = []synapsev1alpha1.SynapseHomeserverOverride{
+				{Inline: "unknown_option: true\n"},
+			}
+			warnings, err := r.validateRenderedHomeserver(renderConfigMap(), configSecret)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(warnings).Should(Equal([]string{"unknown_option: unknown option"}))
+		})
+
+		It("Should apply the validated ConfigMap as it was rendered", func() {
+			cm := renderConfigMap()
+			_, err := r.validateRenderedHomeserver(cm, configSecret)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			// The input ConfigMaps may change between the validation and the apply
+			s.Spec.Homeserver.Overrides = []synapsev1alpha1.SynapseHomeserverOverride{
+				{Inline: "enable_registration: true\n"},
+			}
+			applied := renderResource(renderedResource(&cm), s, metav1.ObjectMeta{Name: "test", Namespace: "default"})
+			Expect(applied).Should(Equal(&cm))
+			Expect(applied).ShouldNot(BeIdenticalTo(&cm))
+		})
+	})
+
+	Context("When rendering the Synapse log configuration", func() {
+		var r SynapseReconciler
+		var s synapsev1alpha1.Synapse
+
+		var renderConfigMap = func() *corev1.ConfigMap {
+			createConfigMap := func(s *synapsev1alpha1.Synapse, objectMeta metav1.ObjectMeta) (client.Object, error) {
+				return &corev1.ConfigMap{
+					ObjectMeta: objectMeta,
+					Data:       map[string]string{"homeserver.yaml": "server_name: example.com\nreport_stats: true\n"},
+				}, nil
+			}
+			resource, err := r.withLogConfig(r.withConfigMapUpdates(
+				createConfigMap,
+				"homeserver.yaml",
+				r.updateHomeserverWithLogConfig,
+			))(&s, metav1.ObjectMeta{Name: "test", Namespace: "default"})
+			Expect(err).ShouldNot(HaveOccurred())
+			return resource.(*corev1.ConfigMap)
+		}
+
+		BeforeEach(func() {
+			r = SynapseReconciler{}
+			s = synapsev1alpha1.Synapse{
+				Spec: synapsev1alpha1.SynapseSpec{
+					Homeserver: synapsev1alpha1.SynapseHomeserver{
+						Values: &synapsev1alpha1.SynapseHomeserverValues{ServerName: "example.com"},
+					},
+				},
+			}
+		})
+
+		It("Should log to the console at INFO level by default", func() {
+			cm := renderConfigMap()
+
+			logConfig := LogConfig{}
+			Expect(yaml.Unmarshal([]byte(cm.Data["log.config"]), &logConfig)).Should(Succeed())
+			Expect(logConfig.Root.Level).Should(Equal("INFO"))
+			Expect(logConfig.Handlers["synapse"].Class).Should(Equal("logging.StreamHandler"))
+			Expect(logConfig.Handlers["synapse"].Formatter).Should(Equal("precise"))
+			Expect(logConfig.Loggers).Should(BeEmpty())
+
+			homeserver, err := r.loadYAMLFileFromConfigMapData(*cm, "homeserver.yaml")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(homeserver["log_config"]).Should(Equal("/data-homeserver/log.config"))
+		})
+
+		It("Should render the level, format and output defined in Spec.Logging", func() {
+			s.Spec.Logging = &synapsev1alpha1.SynapseLogging{
+				Level:   "WARNING",
+				Loggers: map[string]synapsev1alpha1.SynapseLogLevel{"synapse.storage.SQL": "DEBUG"},
+				Format:  "json",
+				Output:  "file",
+			}
+			cm := renderConfigMap()
+
+			logConfig := LogConfig{}
+			Expect(yaml.Unmarshal([]byte(cm.Data["log.config"]), &logConfig)).Should(Succeed())
+			Expect(logConfig.Root.Level).Should(Equal("WARNING"))
+			Expect(logConfig.Loggers).Should(Equal(map[string]LogConfigLogger{"synapse.storage.SQL": {Level: "DEBUG"}}))
+			Expect(logConfig.Handlers["synapse"].Class).Should(Equal("logging.handlers.TimedRotatingFileHandler"))
+			Expect(logConfig.Handlers["synapse"].Filename).Should(Equal("/data/homeserver.log"))
+			Expect(logConfig.Handlers["synapse"].Formatter).Should(Equal("structured"))
+			Expect(logConfig.Formatters["structured"].Class).Should(Equal("synapse.logging.TerseJsonFormatter"))
+		})
+
+		It("Should keep the log configuration of an existing homeserver.yaml if Spec.Logging is not set", func() {
+			s.Spec.Homeserver = synapsev1alpha1.SynapseHomeserver{
+				ConfigMap: &synapsev1alpha1.SynapseHomeserverConfigMap{Name: "my-homeserver"},
+			}
+			Expect(isLogConfigManaged(s)).Should(BeFalse())
+			Expect(r.homeserverManagedKeys(s)).ShouldNot(ContainElement("log_config"))
+
+			s.Spec.Logging = &synapsev1alpha1.SynapseLogging{Level: "DEBUG"}
+			Expect(isLogConfigManaged(s)).Should(BeTrue())
+			Expect(r.homeserverManagedKeys(s)).Should(ContainElement("log_config"))
+		})
+	})
+
+	Context("When managing the signing key of Synapse", func() {
+		var r SynapseReconciler
+		var s synapsev1alpha1.Synapse
+		var objectMeta metav1.ObjectMeta
+
+		var renderSecret = func() *corev1.Secret {
+			return renderResource(r.secretForSigningKey(context.Background()), s, objectMeta).(*corev1.Secret)
+		}
+
+		BeforeEach(func() {
+			r = newTestReconciler()
+			s = newTestSynapse(synapsev1alpha1.SynapseSpec{})
+			objectMeta = setObjectMeta(signingKeySecretName(s), s.Namespace, map[string]string{})
+		})
+
+		It("Should generate a valid ed25519 signing key", func() {
+			secret := renderSecret()
+			Expect(secret.Name).Should(Equal("test-signing-key"))
+
+			keyID, err := r.checkSigningKeySecret(*secret)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(keyID).Should(MatchRegexp(`^ed25519:a_[a-zA-Z0-9]{4}$`))
+
+			oldSigningKeys := map[string]interface{}{}
+			Expect(yaml.Unmarshal(secret.Data["old_signing_keys.yaml"], oldSigningKeys)).Should(Succeed())
+			Expect(oldSigningKeys).Should(BeEmpty())
+		})
+
+		It("Should refuse to generate a signing key for an existing PVC", func() {
+			Expect(r.checkSigningKeyMigration(context.Background(), s)).Should(Succeed())
+
+			pvc := &corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"}}
+			Expect(r.Create(context.Background(), pvc)).Should(Succeed())
+			Expect(r.checkSigningKeyMigration(context.Background(), s)).Should(MatchError(errSigningKeyNotMigrated))
+
+			Expect(r.Create(context.Background(), renderSecret())).Should(Succeed())
+			Expect(r.checkSigningKeyMigration(context.Background(), s)).Should(Succeed())
+		})
+
+		It("Should keep the signing key unless a rotation is requested", func() {
+			secret := renderSecret()
+			Expect(r.Create(context.Background(), secret)).Should(Succeed())
+			Expect(renderSecret().Data["signing.key"]).Should(Equal(secret.Data["signing.key"]))
+
+			previousKeyID, previousVerifyKey, err := parseSigningKey(string(secret.Data["signing.key"]))
+			Expect(err).ShouldNot(HaveOccurred())
+
+			s.Annotations = map[string]string{rotateSigningKeyAnnotation: "1"}
+			rotatedSecret := renderSecret()
+			Expect(rotatedSecret.Data["signing.key"]).ShouldNot(Equal(secret.Data["signing.key"]))
+			Expect(rotatedSecret.Annotations).Should(HaveKeyWithValue(rotateSigningKeyAnnotation, "1"))
+
+			oldSigningKeys := map[string]oldSigningKey{}
+			Expect(yaml.Unmarshal(rotatedSecret.Data["old_signing_keys.yaml"], oldSigningKeys)).Should(Succeed())
+			Expect(oldSigningKeys).Should(HaveKey(previousKeyID))
+			Expect(oldSigningKeys[previousKeyID].Key).Should(Equal(previousVerifyKey))
+			Expect(oldSigningKeys[previousKeyID].ExpiredTs).Should(BeNumerically(">", 0))
+		})
+
+		It("Should reject invalid signing keys", func() {
+			for _, signingKey := range []string{
+				"",
+				"ed25519 a_AbCd",
+				"rsa a_AbCd AAAA",
+				"ed25519 a_AbCd not-base64!",
+				"ed25519 a_AbCd AAAA",
+			} {
+				secret := corev1.Secret{Data: map[string][]byte{"signing.key": []byte(signingKey)}}
+				_, err := r.checkSigningKeySecret(secret)
+				Expect(err).Should(HaveOccurred(), signingKey)
+			}
+		})
+
+		It("Should point homeserver.yaml to the mounted signing key", func() {
+			secret := corev1.Secret{Data: map[string][]byte{
+				"signing.key":           []byte("ed25519 a_AbCd AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA"),
+				"old_signing_keys.yaml": []byte("ed25519:a_Old:\n  key: verifykey\n  expired_ts: 1000\n"),
+			}}
+			homeserver := map[string]interface{}{"signing_key_path": "/data/example.com.signing.key"}
+			Expect(r.updateHomeserverWithSigningKey(secret)(s, homeserver)).Should(Succeed())
+			Expect(homeserver["signing_key_path"]).Should(Equal("/data-signing-key/signing.key"))
+			Expect(homeserver["old_signing_keys"]).Should(Equal(map[string]oldSigningKey{
+				"ed25519:a_Old": {Key: "verifykey", ExpiredTs: 1000},
+			}))
+		})
+	})
+
+	Context("When exposing Synapse with an Ingress", func() {
+		var r SynapseReconciler
+		var s synapsev1alpha1.Synapse
+		var objectMeta metav1.ObjectMeta
+
+		var renderIngress = func() *networkingv1.Ingress {
+			return renderResource(r.ingressForSynapse, s, objectMeta).(*networkingv1.Ingress)
+		}
+
+		BeforeEach(func() {
+			r = newTestReconciler()
+			s = newTestSynapse(synapsev1alpha1.SynapseSpec{
+				Ingress: &synapsev1alpha1.SynapseIngress{
+					Host: "matrix.example.com",
+				},
+			})
+			objectMeta = setObjectMeta(s.Name, s.Namespace, map[string]string{})
+		})
+
+		It("Should route the client and federation APIs to the Synapse Service", func() {
+			ingress := renderIngress()
+			Expect(ingress.Spec.TLS).Should(BeEmpty())
+			Expect(ingress.Spec.Rules).Should(HaveLen(1))
+			Expect(ingress.Spec.Rules[0].Host).Should(Equal("matrix.example.com"))
+
+			var paths []string
+			for _, path := range ingress.Spec.Rules[0].HTTP.Paths {
+				paths = append(paths, path.Path)
+				Expect(*path.PathType).Should(Equal(networkingv1.PathTypePrefix))
+				Expect(path.Backend.Service.Name).Should(Equal("test"))
+				Expect(path.Backend.Service.Port.Number).Should(Equal(int32(8008)))
+			}
+			Expect(paths).Should(Equal([]string{"/_matrix", "/_synapse/client"}))
+
+			homeserver := map[string]interface{}{}
+			Expect(r.updateHomeserverWithIngress(s, homeserver)).Should(Succeed())
+			Expect(homeserver).Should(HaveKeyWithValue("public_baseurl", "http://matrix.example.com/"))
+		})
+
+		It("Should set the IngressClass and the annotations", func() {
+			ingressClassName := "nginx"
+			s.Spec.Ingress.IngressClassName = &ingressClassName
+			s.Spec.Ingress.Annotations = map[string]string{
+				"nginx.ingress.kubernetes.io/proxy-body-size": "100m",
+			}
+
+			ingress := renderIngress()
+			Expect(*ingress.Spec.IngressClassName).Should(Equal("nginx"))
+			Expect(ingress.Annotations).Should(HaveKeyWithValue("nginx.ingress.kubernetes.io/proxy-body-size", "100m"))
+		})
+
+		It("Should request a certificate from the cert-manager issuer", func() {
+			s.Spec.Ingress.TLS = &synapsev1alpha1.SynapseIngressTLS{
+				Issuer: &synapsev1alpha1.SynapseIngressIssuer{
+					Name: "letsencrypt",
+					Kind: "ClusterIssuer",
+				},
+			}
+
+			ingress := renderIngress()
+			Expect(ingress.Annotations).Should(HaveKeyWithValue("cert-manager.io/cluster-issuer", "letsencrypt"))
+			Expect(ingress.Annotations).ShouldNot(HaveKey("cert-manager.io/issuer"))
+			Expect(ingress.Spec.TLS).Should(Equal([]networkingv1.IngressTLS{{
+				Hosts:      []string{"matrix.example.com"},
+				SecretName: "test-tls",
+			}}))
+
+			homeserver := map[string]interface{}{}
+			Expect(r.updateHomeserverWithIngress(s, homeserver)).Should(Succeed())
+			Expect(homeserver).Should(HaveKeyWithValue("public_baseurl", "https://matrix.example.com/"))
+		})
+
+		It("Should use the TLS Secret provided by the user", func() {
+			s.Spec.Ingress.TLS = &synapsev1alpha1.SynapseIngressTLS{
+				SecretName: "my-certificate",
+			}
+
+			ingress := renderIngress()
+			Expect(ingress.Annotations).ShouldNot(HaveKey("cert-manager.io/issuer"))
+			Expect(ingress.Spec.TLS[0].SecretName).Should(Equal("my-certificate"))
+		})
+
+		It("Should delete the Ingress once Spec.Ingress is removed", func() {
+			ingress := renderIngress()
+			Expect(r.Client.Create(context.Background(), ingress)).Should(Succeed())
+
+			s.Spec.Ingress = nil
+			Expect(r.deleteResource(context.Background(), &s, &networkingv1.Ingress{}, objectMeta)).Should(Succeed())
+
+			err := r.Client.Get(context.Background(), types.NamespacedName{Name: "test", Namespace: "default"}, &networkingv1.Ingress{})
+			Expect(k8serrors.IsNotFound(err)).Should(BeTrue())
+		})
+	})
+
+	Context("When exposing Synapse with an OpenShift Route", func() {
+		var r SynapseReconciler
+		var s synapsev1alpha1.Synapse
+		var objectMeta metav1.ObjectMeta
+
+		var renderRoute = func() *unstructured.Unstructured {
+			return renderResource(r.routeForSynapse, s, objectMeta).(*unstructured.Unstructured)
+		}
+
+		BeforeEach(func() {
+			r = newTestReconciler()
+			s = newTestSynapse(synapsev1alpha1.SynapseSpec{
+				Route: &synapsev1alpha1.SynapseRoute{
+					Termination: "edge",
+				},
+			})
+			objectMeta = setObjectMeta(s.Name, s.Namespace, map[string]string{})
+		})
+
+		It("Should detect the route.openshift.io API", func() {
+			mapper := meta.NewDefaultRESTMapper([]schema.GroupVersion{})
+			Expect(isRouteAPIAvailable(mapper)).Should(BeFalse())
+
+			mapper.Add(routeGVK, meta.RESTScopeNamespace)
+			Expect(isRouteAPIAvailable(mapper)).Should(BeTrue())
+		})
+
+		It("Should create an edge-terminated Route to the Synapse Service", func() {
+			route := renderRoute()
+			Expect(route.GroupVersionKind()).Should(Equal(routeGVK))
+			Expect(route.GetOwnerReferences()).Should(HaveLen(1))
+
+			spec, _, err := unstructured.NestedMap(route.Object, "spec")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(spec).ShouldNot(HaveKey("host"))
+			Expect(spec).Should(HaveKeyWithValue("to", HaveKeyWithValue("name", "test")))
+			Expect(spec).Should(HaveKeyWithValue("port", HaveKeyWithValue("targetPort", "http")))
+			Expect(spec).Should(HaveKeyWithValue("tls", HaveKeyWithValue("termination", "edge")))
+		})
+
+		It("Should serve Synapse over TLS with a reencrypt-terminated Route", func() {
+			s.Spec.Route.Host = "matrix.apps.example.com"
+			s.Spec.Route.Termination = "reencrypt"
+
+			route := renderRoute()
+			spec, _, err := unstructured.NestedMap(route.Object, "spec")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(spec).Should(HaveKeyWithValue("host", "matrix.apps.example.com"))
+			Expect(spec).Should(HaveKeyWithValue("port", HaveKeyWithValue("targetPort", "https")))
+			Expect(spec).Should(HaveKeyWithValue("tls", HaveKeyWithValue("termination", "reencrypt")))
+
+			resource, err := r.serviceForSynapse(&s, objectMeta)
+			Expect(err).ShouldNot(HaveOccurred())
+			service := resource.(*corev1.Service)
+			Expect(service.Annotations).Should(HaveKeyWithValue(servingCertSecretAnnotation, "test-serving-cert"))
+			Expect(service.Spec.Ports).Should(HaveLen(2))
+			Expect(service.Spec.Ports[1].Name).Should(Equal("https"))
+			Expect(service.Spec.Ports[1].Port).Should(Equal(int32(8443)))
+
+			resource, err = r.deploymentForSynapse(&s, objectMeta)
+			Expect(err).ShouldNot(HaveOccurred())
+			deployment := resource.(*appsv1.Deployment)
+			Expect(deployment.Spec.Template.Spec.Volumes).Should(ContainElement(corev1.Volume{
+				Name: "tls",
+				VolumeSource: corev1.VolumeSource{
+					Secret: &corev1.SecretVolumeSource{SecretName: "test-serving-cert"},
+				},
+			}))
+		})
+
+		It("Should add a TLS listener to homeserver.yaml only once", func() {
+			cm := &corev1.ConfigMap{
+				Data: map[string]string{"homeserver.yaml": "listeners:\n- port: 8008\n  type: http\n"},
+			}
+			for i := 0; i < 2; i++ {
+				Expect(r.updateConfigMapData(cm, s, r.updateHomeserverWithRouteTLS, "homeserver.yaml")).Should(Succeed())
+			}
+
+			homeserver := map[string]interface{}{}
+			Expect(yaml.Unmarshal([]byte(cm.Data["homeserver.yaml"]), homeserver)).Should(Succeed())
+			Expect(homeserver).Should(HaveKeyWithValue("tls_certificate_path", "/data-tls/tls.crt"))
+			Expect(homeserver).Should(HaveKeyWithValue("tls_private_key_path", "/data-tls/tls.key"))
+			Expect(homeserver["listeners"]).Should(HaveLen(2))
+			Expect(homeserver["listeners"]).Should(ContainElement(HaveKeyWithValue("tls", true)))
+		})
+
+		It("Should report the admitted host name in the Synapse Status", func() {
+			route := renderRoute()
+			r.setRouteCondition(&s, *route)
+			condition := meta.FindStatusCondition(s.Status.Conditions, synapsev1alpha1.ConditionTypeRouteAdmitted)
+			Expect(condition).ShouldNot(BeNil())
+			Expect(condition.Status).Should(Equal(metav1.ConditionFalse))
+			Expect(s.Status.Route.Host).Should(BeEmpty())
+
+			Expect(unstructured.SetNestedSlice(route.Object, []interface{}{
+				map[string]interface{}{
+					"host": "test-default.apps.example.com",
+					"conditions": []interface{}{
+						map[string]interface{}{"type": "Admitted", "status": "True"},
+					},
+				},
+			}, "status", "ingress")).Should(Succeed())
+
+			r.setRouteCondition(&s, *route)
+			condition = meta.FindStatusCondition(s.Status.Conditions, synapsev1alpha1.ConditionTypeRouteAdmitted)
+			Expect(condition.Status).Should(Equal(metav1.ConditionTrue))
+			Expect(s.Status.Route.Host).Should(Equal("test-default.apps.example.com"))
+		})
+	})
+
+	Context("When publishing the .well-known Matrix documents", func() {
+		var r SynapseReconciler
+		var s synapsev1alpha1.Synapse
+		var objectMeta metav1.ObjectMeta
+
+		BeforeEach(func() {
+			r = newTestReconciler()
+			s = newTestSynapse(synapsev1alpha1.SynapseSpec{
+				Ingress: &synapsev1alpha1.SynapseIngress{
+					Host: "matrix.example.com",
+					TLS: &synapsev1alpha1.SynapseIngressTLS{
+						Issuer: &synapsev1alpha1.SynapseIngressIssuer{Name: "letsencrypt", Kind: "ClusterIssuer"},
+					},
+				},
+				WellKnown: &synapsev1alpha1.SynapseWellKnown{
+					Mode: "Deployment",
+				},
+			})
+			s.Status.HomeserverConfiguration.ServerName = "example.com"
+			objectMeta = setObjectMeta(s.Name+"-well-known", s.Namespace, map[string]string{})
+		})
+
+		It("Should derive the documents from the Synapse Ingress", func() {
+			documents, err := wellKnownDocuments(s)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(documents["server"]).Should(MatchJSON(`{"m.server": "matrix.example.com:443"}`))
+			Expect(documents["client"]).Should(MatchJSON(`{"m.homeserver": {"base_url": "https://matrix.example.com/"}}`))
+		})
+
+		It("Should publish the addresses set in Spec.WellKnown", func() {
+			s.Spec.Ingress = nil
+			s.Spec.WellKnown.Server = "synapse.example.com:8448"
+			s.Spec.WellKnown.HomeserverBaseURL = "https://synapse.example.com/"
+			s.Spec.WellKnown.IdentityServerBaseURL = "https://vector.im"
+
+			documents, err := wellKnownDocuments(s)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(documents["server"]).Should(MatchJSON(`{"m.server": "synapse.example.com:8448"}`))
+			Expect(documents["client"]).Should(MatchJSON(`{
+				"m.homeserver": {"base_url": "https://synapse.example.com/"},
+				"m.identity_server": {"base_url": "https://vector.im"}
+			}`))
+		})
+
+		It("Should fail if the federation address is unknown", func() {
+			s.Spec.Ingress = nil
+
+			_, err := wellKnownDocuments(s)
+			Expect(err).Should(HaveOccurred())
+		})
+
+		It("Should expose the documents on the server_name domain", func() {
+			ingress := renderResource(r.ingressForWellKnown, s, objectMeta).(*networkingv1.Ingress)
+
+			Expect(ingress.Spec.Rules).Should(HaveLen(1))
+			Expect(ingress.Spec.Rules[0].Host).Should(Equal("example.com"))
+			Expect(ingress.Spec.Rules[0].HTTP.Paths[0].Path).Should(Equal("/.well-known/matrix"))
+			Expect(ingress.Spec.Rules[0].HTTP.Paths[0].Backend.Service.Name).Should(Equal("test-well-known"))
+			Expect(ingress.Annotations).Should(HaveKeyWithValue("cert-manager.io/cluster-issuer", "letsencrypt"))
+			Expect(ingress.Spec.TLS).Should(Equal([]networkingv1.IngressTLS{{
+				Hosts:      []string{"example.com"},
+				SecretName: "test-well-known-tls",
+			}}))
+
+			configMap := renderResource(r.configMapForWellKnown, s, objectMeta).(*corev1.ConfigMap)
+			Expect(configMap.Data).Should(HaveKey("default.conf"))
+		})
+
+		It("Should serve the documents from Synapse in the Synapse mode", func() {
+			s.Spec.WellKnown.Mode = "Synapse"
+			s.Spec.WellKnown.IdentityServerBaseURL = "https://vector.im"
+
+			objectMetaForSynapse := setObjectMeta(s.Name, s.Namespace, map[string]string{})
+			ingress := renderResource(r.ingressForSynapse, s, objectMetaForSynapse).(*networkingv1.Ingress)
+			Expect(ingress.Spec.Rules).Should(HaveLen(2))
+			Expect(ingress.Spec.Rules[1].Host).Should(Equal("example.com"))
+			Expect(ingress.Spec.Rules[1].HTTP.Paths[0].Path).Should(Equal("/.well-known/matrix"))
+			Expect(ingress.Spec.Rules[1].HTTP.Paths[0].Backend.Service.Name).Should(Equal("test"))
+			Expect(ingress.Spec.TLS[0].Hosts).Should(Equal([]string{"matrix.example.com", "example.com"}))
+
+			homeserver := map[string]interface{}{}
+			Expect(r.updateHomeserverWithWellKnown(s, homeserver)).Should(Succeed())
+			Expect(homeserver).Should(HaveKeyWithValue("serve_server_wellknown", true))
+			Expect(homeserver).Should(HaveKeyWithValue("default_identity_server", "https://vector.im"))
+		})
+
+		It("Should serve the documents on the Synapse host if it is the server_name domain", func() {
+			s.Spec.WellKnown.Mode = "Synapse"
+			s.Status.HomeserverConfiguration.ServerName = "matrix.example.com"
+
+			objectMetaForSynapse := setObjectMeta(s.Name, s.Namespace, map[string]string{})
+			ingress := renderResource(r.ingressForSynapse, s, objectMetaForSynapse).(*networkingv1.Ingress)
+			Expect(ingress.Spec.Rules).Should(HaveLen(1))
+			Expect(ingress.Spec.Rules[0].Host).Should(Equal("matrix.example.com"))
+
+			var paths []string
+			for _, path := range ingress.Spec.Rules[0].HTTP.Paths {
+				paths = append(paths, path.Path)
+			}
+			Expect(paths).Should(Equal([]string{"/_matrix", "/_synapse/client", "/.well-known/matrix"}))
+			Expect(ingress.Spec.TLS[0].Hosts).Should(Equal([]string{"matrix.example.com"}))
+		})
+	})
+
+	Context("When configuring the federation of Synapse", func() {
+		var r SynapseReconciler
+		var s synapsev1alpha1.Synapse
+		var cm *corev1.ConfigMap
+
+		BeforeEach(func() {
+			r = newTestReconciler()
+			s = newTestSynapse(synapsev1alpha1.SynapseSpec{
+				Federation: &synapsev1alpha1.SynapseFederationListener{
+					Port:        8448,
+					ServiceType: corev1.ServiceTypeLoadBalancer,
+				},
+			})
+			cm = &corev1.ConfigMap{
+				Data: map[string]string{"homeserver.yaml": `
+listeners:
+- port: 8008
+  type: http
+  x_forwarded: true
+  resources:
+  - names: [client, federation]
+federation_domain_whitelist: [matrix.org]
+`},
+			}
+		})
+
+		It("Should add a federation listener only once", func() {
+			s.Spec.Federation.DomainWhitelist = []string{"example.org"}
+			s.Spec.Federation.IPRangeBlacklist = []string{"10.0.0.0/8"}
+
+			renderHomeserver(&r, s, cm, r.updateHomeserverWithFederation)
+			homeserver := renderHomeserver(&r, s, cm, r.updateHomeserverWithFederation)
+
+			Expect(homeserver).Should(HaveKeyWithValue("federation_domain_whitelist", ConsistOf("example.org")))
+			Expect(homeserver).Should(HaveKeyWithValue("ip_range_blacklist", ConsistOf("10.0.0.0/8")))
+			Expect(homeserver["listeners"]).Should(HaveLen(2))
+			Expect(homeserver["listeners"]).Should(ContainElement(SatisfyAll(
+				HaveKeyWithValue("port", 8448),
+				HaveKeyWithValue("tls", false),
+				HaveKeyWithValue("x_forwarded", true),
+			)))
+			Expect(homeserver).ShouldNot(HaveKey("tls_certificate_path"))
+		})
+
+		It("Should terminate TLS on the federation listener", func() {
+			s.Spec.Federation.TLSSecretName = "federation-tls"
+
+			homeserver := renderHomeserver(&r, s, cm, r.updateHomeserverWithFederation)
+			Expect(homeserver).ShouldNot(HaveKey("federation_domain_whitelist"))
+			Expect(homeserver).Should(HaveKeyWithValue("tls_certificate_path", "/data-federation-tls/tls.crt"))
+			Expect(homeserver["listeners"]).Should(ContainElement(SatisfyAll(
+				HaveKeyWithValue("port", 8448),
+				HaveKeyWithValue("tls", true),
+			)))
+
+			resource, err := r.deploymentForSynapse(&s, setObjectMeta(s.Name, s.Namespace, map[string]string{}))
+			Expect(err).ShouldNot(HaveOccurred())
+			deployment := resource.(*appsv1.Deployment)
+			Expect(deployment.Spec.Template.Spec.Containers[0].Ports).Should(ContainElement(corev1.ContainerPort{ContainerPort: 8448}))
+			Expect(deployment.Spec.Template.Spec.Volumes).Should(ContainElement(corev1.Volume{
+				Name: "federation-tls",
+				VolumeSource: corev1.VolumeSource{
+					Secret: &corev1.SecretVolumeSource{SecretName: "federation-tls"},
+				},
+			}))
+		})
+
+		It("Should stop serving the federation API when disabled", func() {
+			s.Spec.Federation.Enabled = BoolAddr(false)
+			Expect(isFederationListenerEnabled(s)).Should(BeFalse())
+
+			homeserver := renderHomeserver(&r, s, cm, r.updateHomeserverWithFederation)
+			Expect(homeserver).Should(HaveKeyWithValue("federation_domain_whitelist", BeEmpty()))
+			Expect(homeserver["listeners"]).Should(HaveLen(1))
+
+			listener := homeserver["listeners"].([]interface{})[0].(map[interface{}]interface{})
+			resource := listener["resources"].([]interface{})[0].(map[interface{}]interface{})
+			Expect(resource["names"]).Should(ConsistOf("client"))
+		})
+
+		It("Should expose the federation listener with the requested Service type", func() {
+			resource, err := r.serviceForFederation(&s, setObjectMeta(s.Name+"-federation", s.Namespace, map[string]string{}))
+			Expect(err).ShouldNot(HaveOccurred())
+			service := resource.(*corev1.Service)
+			Expect(service.Spec.Type).Should(Equal(corev1.ServiceTypeLoadBalancer))
+			Expect(service.Spec.Ports).Should(HaveLen(1))
+			Expect(service.Spec.Ports[0].Port).Should(Equal(int32(8448)))
+			Expect(service.Spec.Selector).Should(Equal(labelsForSynapse("test")))
+		})
+	})
+
+	Context("When running Synapse in worker mode", func() {
+		var r SynapseReconciler
+		var s synapsev1alpha1.Synapse
+		var cm *corev1.ConfigMap
+
+		BeforeEach(func() {
+			replicas := int32(3)
+			r = newTestReconciler()
+			s = newTestSynapse(synapsev1alpha1.SynapseSpec{
+				Image: "matrixdotorg/synapse:v1.60.0",
+				Workers: []synapsev1alpha1.SynapseWorkerPool{
+					{Name: "generic", Type: synapsev1alpha1.WorkerTypeGenericWorker, Replicas: &replicas},
+					{Name: "sender1", Type: synapsev1alpha1.WorkerTypeFederationSender},
+					{Name: "sender2", Type: synapsev1alpha1.WorkerTypeFederationSender},
+					{Name: "events", Type: synapsev1alpha1.WorkerTypeEventPersister},
+				},
+			})
+			s.UID = "test-uid"
+			cm = &corev1.ConfigMap{
+				Data: map[string]string{"homeserver.yaml": `
+listeners:
+- port: 8008
+  type: http
+  x_forwarded: true
+  resources:
+  - names: [client, federation]
+`},
+			}
+		})
+
+		It("Should enable the replication and delegate tasks to the workers", func() {
+			renderHomeserver(&r, s, cm, r.updateHomeserverWithWorkers)
+			homeserver := renderHomeserver(&r, s, cm, r.updateHomeserverWithWorkers)
+
+			Expect(homeserver["listeners"]).Should(HaveLen(2))
+			Expect(homeserver["listeners"]).Should(ContainElement(HaveKeyWithValue("port", 9093)))
+			Expect(homeserver).Should(HaveKeyWithValue("send_federation", false))
+			Expect(homeserver).ShouldNot(HaveKey("federation_sender_instances"))
+			Expect(homeserver["stream_writers"]).Should(HaveKeyWithValue("events", ConsistOf("events")))
+			Expect(homeserver["instance_map"]).Should(HaveKeyWithValue("events", SatisfyAll(
+				HaveKeyWithValue("host", "test-worker-events.default.svc.cluster.local"),
+				HaveKeyWithValue("port", 9093),
+			)))
+			Expect(homeserver["instance_map"]).ShouldNot(HaveKey("generic"))
+			Expect(homeserver).ShouldNot(HaveKey("start_pushers"))
+			Expect(homeserver).ShouldNot(HaveKey("enable_media_repo"))
+		})
+
+		It("Should name the workers which can't be replicated after their pool", func() {
+			config := r.workerConfigForPool(s, s.Spec.Workers[1])
+			Expect(config.WorkerApp).Should(Equal("synapse.app.generic_worker"))
+			Expect(config.WorkerName).Should(Equal("sender1"))
+			Expect(config.WorkerReplicationHost).Should(Equal("test-main.default.svc.cluster.local"))
+			Expect(config.WorkerListeners).Should(HaveLen(1))
+
+			resource, err := r.deploymentForWorkerPool(s.Spec.Workers[1])(&s, setObjectMeta("test-worker-sender1", s.Namespace, map[string]string{}))
+			Expect(err).ShouldNot(HaveOccurred())
+			deployment := resource.(*appsv1.Deployment)
+			Expect(*deployment.Spec.Replicas).Should(Equal(int32(1)))
+			Expect(deployment.Spec.Template.Spec.InitContainers).Should(BeEmpty())
+		})
+
+		It("Should name the replicated workers after their pod", func() {
+			config := r.workerConfigForPool(s, s.Spec.Workers[0])
+			Expect(config.WorkerName).Should(BeEmpty())
+			Expect(config.WorkerListeners).Should(HaveLen(2))
+
+			resource, err := r.deploymentForWorkerPool(s.Spec.Workers[0])(&s, setObjectMeta("test-worker-generic", s.Namespace, map[string]string{}))
+			Expect(err).ShouldNot(HaveOccurred())
+			deployment := resource.(*appsv1.Deployment)
+			Expect(*deployment.Spec.Replicas).Should(Equal(int32(3)))
+			Expect(deployment.Spec.Template.Spec.InitContainers).Should(HaveLen(1))
+			Expect(deployment.Spec.Template.Spec.Containers[0].Args).Should(ContainElement("/data-worker-name/worker_name.yaml"))
+			Expect(deployment.Spec.Template.Spec.Containers[0].Env).Should(ContainElement(corev1.EnvVar{
+				Name:  "SYNAPSE_WORKER",
+				Value: "synapse.app.generic_worker",
+			}))
+
+			resource, err = r.serviceForWorkerPool(s.Spec.Workers[0])(&s, setObjectMeta("test-worker-generic", s.Namespace, map[string]string{}))
+			Expect(err).ShouldNot(HaveOccurred())
+			service := resource.(*corev1.Service)
+			Expect(service.Spec.Ports).Should(HaveLen(2))
+			Expect(service.Spec.Selector).Should(Equal(labelsForWorkerPool("test", "generic")))
+		})
+
+		It("Should run the media repository workers with the Synapse PVC", func() {
+			pool := synapsev1alpha1.SynapseWorkerPool{Name: "media", Type: synapsev1alpha1.WorkerTypeMediaRepository}
+			s.Spec.Workers = append(s.Spec.Workers, pool)
+
+			Expect(renderHomeserver(&r, s, cm, r.updateHomeserverWithWorkers)).Should(HaveKeyWithValue("enable_media_repo", false))
+			Expect(r.workerConfigForPool(s, pool).WorkerApp).Should(Equal("synapse.app.media_repository"))
+			Expect(r.workerConfigForPool(s, pool).WorkerName).Should(Equal("media"))
+
+			resource, err := r.deploymentForWorkerPool(pool)(&s, setObjectMeta("test-worker-media", s.Namespace, map[string]string{}))
+			Expect(err).ShouldNot(HaveOccurred())
+			deployment := resource.(*appsv1.Deployment)
+			Expect(deployment.Spec.Template.Spec.Containers[0].VolumeMounts).Should(ContainElement(corev1.VolumeMount{
+				Name:      "data-pv",
+				MountPath: "/data",
+			}))
+
+			// The ReadWriteOnce PVC can only be mounted on the node of Synapse
+			affinity := deployment.Spec.Template.Spec.Affinity
+			Expect(affinity).ShouldNot(BeNil())
+			Expect(affinity.PodAffinity.RequiredDuringSchedulingIgnoredDuringExecution).Should(ConsistOf(corev1.PodAffinityTerm{
+				LabelSelector: &metav1.LabelSelector{MatchLabels: labelsForSynapse("test")},
+				TopologyKey:   "kubernetes.io/hostname",
+			}))
+		})
+
+		It("Should scale the autoscaled pools with a HorizontalPodAutoscaler", func() {
+			minReplicas := int32(2)
+			pool := s.Spec.Workers[0]
+			pool.Resources.Requests = corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("500m")}
+			pool.Autoscaling = &synapsev1alpha1.SynapseWorkerPoolAutoscaling{
+				MinReplicas: &minReplicas,
+				MaxReplicas: 10,
+			}
+			s.Spec.Workers[0] = pool
+			s.Default()
+			pool = s.Spec.Workers[0]
+			objectMeta := setObjectMeta("test-worker-generic", s.Namespace, map[string]string{})
+
+			// The number of workers is left to the HorizontalPodAutoscaler
+			resource, err := r.deploymentForWorkerPool(pool)(&s, objectMeta)
+			Expect(err).ShouldNot(HaveOccurred())
+			deployment := resource.(*appsv1.Deployment)
+			Expect(deployment.Spec.Replicas).Should(BeNil())
+			Expect(deployment.Spec.Template.Spec.Containers[0].Resources).Should(Equal(pool.Resources))
+
+			resource, err = r.horizontalPodAutoscalerForWorkerPool(pool)(&s, objectMeta)
+			Expect(err).ShouldNot(HaveOccurred())
+			hpa := resource.(*autoscalingv2.HorizontalPodAutoscaler)
+			Expect(hpa.Spec.ScaleTargetRef).Should(Equal(autoscalingv2.CrossVersionObjectReference{
+				APIVersion: "apps/v1",
+				Kind:       "Deployment",
+				Name:       "test-worker-generic",
+			}))
+			Expect(*hpa.Spec.MinReplicas).Should(Equal(int32(2)))
+			Expect(hpa.Spec.MaxReplicas).Should(Equal(int32(10)))
+			Expect(hpa.Spec.Metrics).Should(HaveLen(1))
+			Expect(hpa.Spec.Metrics[0].Resource.Name).Should(Equal(corev1.ResourceCPU))
+			Expect(*hpa.Spec.Metrics[0].Resource.Target.AverageUtilization).Should(Equal(int32(synapsev1alpha1.DefaultTargetCPU)))
+		})
+
+		It("Should scale the autoscaled pools on custom metrics", func() {
+			target := resource.MustParse("100")
+			pool := s.Spec.Workers[0]
+			pool.Autoscaling = &synapsev1alpha1.SynapseWorkerPoolAutoscaling{
+				MaxReplicas: 5,
+				Metrics: []autoscalingv2.MetricSpec{{
+					Type: autoscalingv2.PodsMetricSourceType,
+					Pods: &autoscalingv2.PodsMetricSource{
+						Metric: autoscalingv2.MetricIdentifier{Name: "synapse_http_server_requests_received"},
+						Target: autoscalingv2.MetricTarget{
+							Type:         autoscalingv2.AverageValueMetricType,
+							AverageValue: &target,
+						},
+					},
+				}},
+			}
+			s.Spec.Workers[0] = pool
+			s.Default()
+
+			resource, err := r.horizontalPodAutoscalerForWorkerPool(s.Spec.Workers[0])(&s, setObjectMeta("test-worker-generic", s.Namespace, map[string]string{}))
+			Expect(err).ShouldNot(HaveOccurred())
+			hpa := resource.(*autoscalingv2.HorizontalPodAutoscaler)
+			Expect(*hpa.Spec.MinReplicas).Should(Equal(int32(1)))
+			Expect(hpa.Spec.Metrics).Should(Equal(pool.Autoscaling.Metrics))
+		})
+
+		It("Should autoscale the federation senders with a StatefulSet", func() {
+			pool := s.Spec.Workers[1]
+			pool.Autoscaling = &synapsev1alpha1.SynapseWorkerPoolAutoscaling{MaxReplicas: 5}
+			s.Spec.Workers[1] = pool
+			s.Default()
+			pool = s.Spec.Workers[1]
+			objectMeta := setObjectMeta("test-worker-sender1", s.Namespace, map[string]string{})
+
+			// The workers are named after the stable names of the pods
+			config := r.workerConfigForPool(s, pool)
+			Expect(config.WorkerName).Should(BeEmpty())
+
+			resource, err := r.asStatefulSet(r.deploymentForWorkerPool(pool))(&s, objectMeta)
+			Expect(err).ShouldNot(HaveOccurred())
+			statefulSet := resource.(*appsv1.StatefulSet)
+			Expect(statefulSet.Spec.Replicas).Should(BeNil())
+			Expect(statefulSet.Spec.ServiceName).Should(Equal("test-worker-sender1"))
+			Expect(statefulSet.Spec.PodManagementPolicy).Should(Equal(appsv1.ParallelPodManagement))
+			Expect(statefulSet.Spec.Template.Spec.InitContainers).Should(HaveLen(1))
+
+			resource, err = r.horizontalPodAutoscalerForWorkerPool(pool)(&s, objectMeta)
+			Expect(err).ShouldNot(HaveOccurred())
+			hpa := resource.(*autoscalingv2.HorizontalPodAutoscaler)
+			Expect(hpa.Spec.ScaleTargetRef.Kind).Should(Equal("StatefulSet"))
+
+			// A new StatefulSet runs a single federation sender
+			instances, err := r.federationSenderInstances(context.Background(), s)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(instances).Should(ConsistOf("test-worker-sender1-0", "sender2"))
+
+			// The federation senders follow the replicas set by the HorizontalPodAutoscaler
+			replicas := int32(3)
+			statefulSet.Spec.Replicas = &replicas
+			r = newTestReconciler(statefulSet)
+			instances, err = r.federationSenderInstances(context.Background(), s)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(instances).Should(ConsistOf(
+				"test-worker-sender1-0",
+				"test-worker-sender1-1",
+				"test-worker-sender1-2",
+				"sender2",
+			))
+
+			// The federation sender instances are only listed in the
+			// configuration of the federation_sender workers
+			for i, pool := range s.Spec.Workers {
+				workerConfigMap := renderResource(r.configMapForWorkerPool(pool, instances), s, objectMeta).(*corev1.ConfigMap)
+				var config WorkerConfig
+				Expect(yaml.Unmarshal([]byte(workerConfigMap.Data[workerConfigFile]), &config)).Should(Succeed())
+				if i == 1 || i == 2 {
+					Expect(config.FederationSenderInstances).Should(Equal(instances))
+				} else {
+					Expect(config.FederationSenderInstances).Should(BeEmpty())
+				}
+			}
+		})
+
+		It("Should not restart the main Synapse process when a federation_sender pool is scaled", func() {
+			pool := s.Spec.Workers[1]
+			pool.Autoscaling = &synapsev1alpha1.SynapseWorkerPoolAutoscaling{MaxReplicas: 5}
+			s.Spec.Workers[1] = pool
+			s.Default()
+			objectMeta := setObjectMeta("test-worker-sender1", s.Namespace, map[string]string{})
+			statefulSet := renderResource(r.asStatefulSet(r.deploymentForWorkerPool(s.Spec.Workers[1])), s, objectMeta).(*appsv1.StatefulSet)
+
+			// renderMainPodTemplate renders homeserver.yaml and the main
+			// Deployment, as they are reconciled by Reconcile
+			renderMainPodTemplate := func(replicas int32) corev1.PodTemplateSpec {
+				statefulSet.Spec.Replicas = &replicas
+				r = newTestReconciler(statefulSet.DeepCopy())
+
+				homeserverConfigMap := cm.DeepCopy()
+				renderHomeserver(&r, s, homeserverConfigMap, r.updateHomeserverWithWorkers)
+				deployment := renderResource(
+					r.withConfigChecksum(r.deploymentForSynapse, homeserverConfigMap),
+					s,
+					setObjectMeta(s.Name, s.Namespace, map[string]string{}),
+				).(*appsv1.Deployment)
+				return deployment.Spec.Template
+			}
+
+			Expect(renderMainPodTemplate(3)).Should(Equal(renderMainPodTemplate(1)))
+		})
+
+		It("Should hand the replicas over to the HorizontalPodAutoscaler once", func() {
+			managedFields := func(manager string, fields string) []metav1.ManagedFieldsEntry {
+				return []metav1.ManagedFieldsEntry{{
+					Manager:   manager,
+					Operation: metav1.ManagedFieldsOperationApply,
+					FieldsV1:  &metav1.FieldsV1{Raw: []byte(fields)},
+				}}
+			}
+
+			Expect(isReplicasManagedBy(
+				managedFields(synapseFieldManager, `{"f:spec":{"f:replicas":{},"f:template":{}}}`),
+				synapseFieldManager,
+			)).Should(BeTrue())
+			Expect(isReplicasManagedBy(
+				managedFields(synapseFieldManager, `{"f:spec":{"f:template":{}}}`),
+				synapseFieldManager,
+			)).Should(BeFalse())
+			Expect(isReplicasManagedBy(
+				managedFields(replicasHandoverFieldManager, `{"f:spec":{"f:replicas":{}}}`),
+				synapseFieldManager,
+			)).Should(BeFalse())
+
+			// Workers which don't exist yet are created without replicas
+			objectMeta := setObjectMeta("test-worker-generic", s.Namespace, map[string]string{})
+			Expect(r.handOverWorkerPoolReplicas(context.Background(), &appsv1.Deployment{}, objectMeta)).Should(Succeed())
+		})
+
+		It("Should delete the resources of the pools removed from the Spec", func() {
+			objects := []client.Object{}
+			for _, name := range []string{"generic", "removed"} {
+				objectMeta := setObjectMeta("test-worker-"+name, s.Namespace, labelsForWorkerPool(s.Name, name))
+				autoscaledPool := synapsev1alpha1.SynapseWorkerPool{
+					Name:        name,
+					Autoscaling: &synapsev1alpha1.SynapseWorkerPoolAutoscaling{MaxReplicas: 2},
+				}
+				for _, create := range []createResourceFunc{
+					r.configMapForWorkerPool(synapsev1alpha1.SynapseWorkerPool{Name: name}, nil),
+					r.serviceForWorkerPool(synapsev1alpha1.SynapseWorkerPool{Name: name}),
+					r.deploymentForWorkerPool(synapsev1alpha1.SynapseWorkerPool{Name: name}),
+					r.horizontalPodAutoscalerForWorkerPool(autoscaledPool),
+					r.asStatefulSet(r.deploymentForWorkerPool(synapsev1alpha1.SynapseWorkerPool{Name: name})),
+				} {
+					resource, err := create(&s, objectMeta)
+					Expect(err).ShouldNot(HaveOccurred())
+					objects = append(objects, resource)
+				}
+			}
+			r = newTestReconciler(objects...)
+
+			Expect(r.deleteStaleWorkerPools(context.Background(), &s)).Should(Succeed())
+
+			key := types.NamespacedName{Name: "test-worker-generic", Namespace: s.Namespace}
+			Expect(r.Client.Get(context.Background(), key, &appsv1.Deployment{})).Should(Succeed())
+			key.Name = "test-worker-removed"
+			for _, resource := range []client.Object{
+				&appsv1.Deployment{},
+				&corev1.Service{},
+				&corev1.ConfigMap{},
+				&autoscalingv2.HorizontalPodAutoscaler{},
+				&appsv1.StatefulSet{},
+			} {
+				err := r.Client.Get(context.Background(), key, resource)
+				Expect(k8serrors.IsNotFound(err)).Should(BeTrue())
+			}
+		})
+	})
+
+	Context("When configuring the Redis instance used by the workers", func() {
+		var r SynapseReconciler
+		var s synapsev1alpha1.Synapse
+
+		var renderRedis = func(password string) map[interface{}]interface{} {
+			secret := &corev1.Secret{Data: map[string][]byte{"homeserver-secrets.yaml": []byte("{}")}}
+			Expect(r.updateSecretData(secret, s, r.updateHomeserverWithRedis(password), "homeserver-secrets.yaml")).Should(Succeed())
+
+			homeserverSecrets := map[string]interface{}{}
+			Expect(yaml.Unmarshal(secret.Data["homeserver-secrets.yaml"], homeserverSecrets)).Should(Succeed())
+			return homeserverSecrets["redis"].(map[interface{}]interface{})
+		}
+
+		BeforeEach(func() {
+			r = newTestReconciler()
+			s = newTestSynapse(synapsev1alpha1.SynapseSpec{
+				Workers: []synapsev1alpha1.SynapseWorkerPool{
+					{Name: "generic", Type: synapsev1alpha1.WorkerTypeGenericWorker},
+				},
+			})
+			s.Default()
+		})
+
+		It("Should deploy a Redis instance by default", func() {
+			Expect(isRedisDeployed(s)).Should(BeTrue())
+			Expect(s.Spec.Redis.Image).Should(Equal(synapsev1alpha1.DefaultRedisImage))
+
+			redis := renderRedis("")
+			Expect(redis).Should(HaveKeyWithValue("enabled", true))
+			Expect(redis).Should(HaveKeyWithValue("host", "test-redis.default.svc.cluster.local"))
+			Expect(redis).Should(HaveKeyWithValue("port", 6379))
+			Expect(redis).ShouldNot(HaveKey("password"))
+
+			resource, err := r.deploymentForRedis(&s, setObjectMeta("test-redis", s.Namespace, map[string]string{}))
+			Expect(err).ShouldNot(HaveOccurred())
+			deployment := resource.(*appsv1.Deployment)
+			Expect(deployment.Spec.Template.Spec.Containers[0].Args).ShouldNot(ContainElement("--requirepass"))
+		})
+
+		It("Should protect the deployed Redis instance with a stable password", func() {
+			s.Spec.Redis.GeneratePassword = true
+			objectMeta := setObjectMeta("test-redis", s.Namespace, map[string]string{})
+
+			resource, err := r.secretForRedis(context.Background())(&s, objectMeta)
+			Expect(err).ShouldNot(HaveOccurred())
+			secret := resource.(*corev1.Secret)
+			Expect(secret.Data[redisPasswordKey]).Should(HaveLen(32))
+
+			Expect(r.Client.Create(context.Background(), secret)).Should(Succeed())
+			resource, err = r.secretForRedis(context.Background())(&s, objectMeta)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(resource.(*corev1.Secret).Data).Should(Equal(secret.Data))
+
+			resource, err = r.deploymentForRedis(&s, objectMeta)
+			Expect(err).ShouldNot(HaveOccurred())
+			container := resource.(*appsv1.Deployment).Spec.Template.Spec.Containers[0]
+			Expect(container.Args).Should(ContainElements("--requirepass", "$(REDIS_PASSWORD)"))
+			Expect(container.Env[0].ValueFrom.SecretKeyRef.Name).Should(Equal("test-redis"))
+
+			Expect(renderRedis(string(secret.Data[redisPasswordKey]))).Should(HaveKeyWithValue("password", string(secret.Data[redisPasswordKey])))
+		})
+
+		It("Should use an external Redis instance", func() {
+			s.Spec.Redis.External = &synapsev1alpha1.SynapseExternalRedis{
+				Host: "redis.example.com",
+				PasswordSecretKeyRef: &corev1.SecretKeySelector{
+					LocalObjectReference: corev1.LocalObjectReference{Name: "redis"},
+					Key:                  "redis-password",
+				},
+			}
+			s.Default()
+			Expect(isRedisDeployed(s)).Should(BeFalse())
+
+			_, err := r.fetchExternalRedisPassword(context.Background(), s)
+			Expect(k8serrors.IsNotFound(err)).Should(BeTrue())
+
+			Expect(r.Client.Create(context.Background(), &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "redis", Namespace: "default"},
+				Data:       map[string][]byte{"redis-password": []byte("secret")},
+			})).Should(Succeed())
+			password, err := r.fetchExternalRedisPassword(context.Background(), s)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(password).Should(Equal("secret"))
+
+			redis := renderRedis(password)
+			Expect(redis).Should(HaveKeyWithValue("host", "redis.example.com"))
+			Expect(redis).Should(HaveKeyWithValue("port", 6379))
+			Expect(redis).Should(HaveKeyWithValue("password", "secret"))
+		})
+
+		It("Should report whether the external Redis instance is reachable", func() {
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			Expect(err).ShouldNot(HaveOccurred())
+			defer listener.Close()
+
+			// A minimal Redis server, only accepting the "secret" password
+			go func() {
+				for {
+					conn, err := listener.Accept()
+					if err != nil {
+						return
+					}
+					go func(conn net.Conn) {
+						defer conn.Close()
+						reader := bufio.NewReader(conn)
+						for {
+							var args []string
+							header, err := reader.ReadString('\n')
+							if err != nil {
+								return
+							}
+							count, _ := strconv.Atoi(strings.TrimSpace(header[1:]))
+							for i := 0; i < count; i++ {
+								_, _ = reader.ReadString('\n')
+								arg, _ := reader.ReadString('\n')
+								args = append(args, strings.TrimSpace(arg))
+							}
+							switch {
+							case args[0] == "AUTH" && args[1] != "secret":
+								_, _ = conn.Write([]byte("-WRONGPASS invalid password\r\n"))
+							case args[0] == "AUTH":
+								_, _ = conn.Write([]byte("+OK\r\n"))
+							default:
+								_, _ = conn.Write([]byte("+PONG\r\n"))
+							}
+						}
+					}(conn)
+				}
+			}()
+
+			host, port, err := net.SplitHostPort(listener.Addr().String())
+			Expect(err).ShouldNot(HaveOccurred())
+			portNumber, err := strconv.Atoi(port)
+			Expect(err).ShouldNot(HaveOccurred())
+			s.Spec.Redis.External = &synapsev1alpha1.SynapseExternalRedis{Host: host, Port: int32(portNumber)}
+
+			r.setExternalRedisCondition(&s, "secret")
+			condition := meta.FindStatusCondition(s.Status.Conditions, synapsev1alpha1.ConditionTypeRedisReady)
+			Expect(condition.Status).Should(Equal(metav1.ConditionTrue))
+
+			r.setExternalRedisCondition(&s, "wrong")
+			condition = meta.FindStatusCondition(s.Status.Conditions, synapsev1alpha1.ConditionTypeRedisReady)
+			Expect(condition.Status).Should(Equal(metav1.ConditionFalse))
+			Expect(condition.Reason).Should(Equal(reasonRedisNotReachable))
+			Expect(condition.Message).Should(ContainSubstring("WRONGPASS"))
+
+			// The last probe is reused until redisProbeInterval expires
+			Expect(listener.Close()).Should(Succeed())
+			r.setExternalRedisCondition(&s, "wrong")
+			condition = meta.FindStatusCondition(s.Status.Conditions, synapsev1alpha1.ConditionTypeRedisReady)
+			Expect(condition.Message).Should(ContainSubstring("WRONGPASS"))
+
+			r.forgetExternalRedisProbe(types.NamespacedName{Name: s.Name, Namespace: s.Namespace})
+			r.setExternalRedisCondition(&s, "wrong")
+			condition = meta.FindStatusCondition(s.Status.Conditions, synapsev1alpha1.ConditionTypeRedisReady)
+			Expect(condition.Status).Should(Equal(metav1.ConditionFalse))
+			Expect(condition.Message).ShouldNot(ContainSubstring("WRONGPASS"))
+		})
+	})
+
+	Context("When routing the requests to the workers", func() {
+		var r SynapseReconciler
+		var s synapsev1alpha1.Synapse
+
+		BeforeEach(func() {
+			r = newTestReconciler()
+			s = newTestSynapse(synapsev1alpha1.SynapseSpec{
+				Workers: []synapsev1alpha1.SynapseWorkerPool{
+					{Name: "generic1", Type: synapsev1alpha1.WorkerTypeGenericWorker},
+					{Name: "generic2", Type: synapsev1alpha1.WorkerTypeGenericWorker},
+					{Name: "media", Type: synapsev1alpha1.WorkerTypeMediaRepository},
+					{Name: "sender", Type: synapsev1alpha1.WorkerTypeFederationSender},
+				},
+			})
+			s.Default()
+		})
+
+		It("Should point the Synapse Service at the reverse proxy", func() {
+			resource, err := r.serviceForSynapse(&s, setObjectMeta("test", s.Namespace, map[string]string{}))
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(resource.(*corev1.Service).Spec.Selector).Should(Equal(labelsForProxy("test")))
+
+			resource, err = r.serviceForMainProcess(&s, setObjectMeta("test-main", s.Namespace, map[string]string{}))
+			Expect(err).ShouldNot(HaveOccurred())
+			service := resource.(*corev1.Service)
+			Expect(service.Spec.Selector).Should(Equal(labelsForSynapse("test")))
+			Expect(service.Spec.Ports).Should(ContainElement(HaveField("Port", int32(9093))))
+
+			s.Spec.Workers = nil
+			resource, err = r.serviceForSynapse(&s, setObjectMeta("test", s.Namespace, map[string]string{}))
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(resource.(*corev1.Service).Spec.Selector).Should(Equal(labelsForSynapse("test")))
+		})
+
+		It("Should route the requests served by each worker type to its pools", func() {
+			conf := r.nginxConfForProxy(s)
+
+			Expect(conf).Should(ContainSubstring(`upstream synapse_main {
+    server test-main.default.svc.cluster.local:8008;
+}`))
+			Expect(conf).Should(ContainSubstring(`upstream synapse_generic_worker {
+    server test-worker-generic1.default.svc.cluster.local:8083;
+    server test-worker-generic2.default.svc.cluster.local:8083;
+}`))
+			Expect(conf).Should(ContainSubstring(`upstream synapse_media_repository {
+    server test-worker-media.default.svc.cluster.local:8083;
+}`))
+			Expect(conf).ShouldNot(ContainSubstring("test-worker-sender"))
+
+			Expect(conf).Should(ContainSubstring(`location ~ ^/_matrix/client/(r0|v3)/sync$ {
+        proxy_pass http://synapse_generic_worker;
+    }`))
+			Expect(conf).Should(ContainSubstring(`location ~ ^/_matrix/federation/v1/send/ {
+        proxy_pass http://synapse_generic_worker;
+    }`))
+			Expect(conf).Should(ContainSubstring(`location ~ ^/_matrix/media/ {
+        proxy_pass http://synapse_media_repository;
+    }`))
+			Expect(conf).Should(ContainSubstring(`location / {
+        proxy_pass http://synapse_main;
+    }`))
+			Expect(strings.Count(conf, "server {")).Should(Equal(1))
+		})
+
+		It("Should keep the federation requests on the main process when federation is disabled", func() {
+			disabled := false
+			s.Spec.Federation = &synapsev1alpha1.SynapseFederationListener{Enabled: &disabled}
+
+			Expect(r.nginxConfForProxy(s)).ShouldNot(ContainSubstring("/_matrix/federation/"))
+			Expect(workerResources(s, synapsev1alpha1.WorkerTypeGenericWorker)).Should(Equal([]string{"client"}))
+		})
+
+		It("Should serve the TLS listeners of Synapse", func() {
+			s.Spec.Federation = &synapsev1alpha1.SynapseFederationListener{TLSSecretName: "federation-tls"}
+			s.Default()
+
+			conf := r.nginxConfForProxy(s)
+			Expect(strings.Count(conf, "server {")).Should(Equal(2))
+			Expect(conf).Should(ContainSubstring(`listen 8448 ssl;
+    ssl_certificate /data-federation-tls/tls.crt;`))
+
+			resource, err := r.deploymentForProxy(&s, setObjectMeta("test-proxy", s.Namespace, map[string]string{}))
+			Expect(err).ShouldNot(HaveOccurred())
+			deployment := resource.(*appsv1.Deployment)
+			Expect(*deployment.Spec.Replicas).Should(Equal(int32(1)))
+			container := deployment.Spec.Template.Spec.Containers[0]
+			Expect(container.Image).Should(Equal(synapsev1alpha1.DefaultProxyImage))
+			Expect(container.Ports).Should(ContainElement(corev1.ContainerPort{ContainerPort: 8448}))
+			Expect(container.VolumeMounts).Should(ContainElement(corev1.VolumeMount{
+				Name:      "federation-tls",
+				MountPath: "/data-federation-tls",
+			}))
+
+			resource, err = r.serviceForFederation(&s, setObjectMeta("test-federation", s.Namespace, map[string]string{}))
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(resource.(*corev1.Service).Spec.Selector).Should(Equal(labelsForProxy("test")))
+		})
+	})
+
+	Context("When customizing the pod templates", func() {
+		var r SynapseReconciler
+		var s synapsev1alpha1.Synapse
+		var objectMeta metav1.ObjectMeta
+
+		var deploymentWithPodTemplate = func(podTemplate *corev1.PodTemplateSpec) (*appsv1.Deployment, error) {
+			resource, err := r.withPodTemplate(r.deploymentForSynapse, podTemplate)(&s, objectMeta)
+			return resource.(*appsv1.Deployment), err
+		}
+
+		BeforeEach(func() {
+			r = newTestReconciler()
+			s = newTestSynapse(synapsev1alpha1.SynapseSpec{
+				Image: "matrixdotorg/synapse:v1.60.0",
+			})
+			objectMeta = setObjectMeta(s.Name, s.Namespace, map[string]string{})
+		})
+
+		It("Should keep the generated pod template without pod template", func() {
+			generated, err := r.deploymentForSynapse(&s, objectMeta)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			deployment, err := deploymentWithPodTemplate(nil)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(deployment).Should(Equal(generated))
+		})
+
+		It("Should merge the pod template into the generated pod template", func() {
+			deployment, err := deploymentWithPodTemplate(&corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: map[string]string{"team": "matrix"},
+				},
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{{
+						Name: "synapse",
+						Env:  []corev1.EnvVar{{Name: "SYNAPSE_CACHE_FACTOR", Value: "2"}},
+						Resources: corev1.ResourceRequirements{
+							Limits: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("2Gi")},
+						},
+						VolumeMounts: []corev1.VolumeMount{{Name: "extra", MountPath: "/extra"}},
+					}},
+					Volumes: []corev1.Volume{{
+						Name:         "extra",
+						VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+					}},
+					NodeSelector:      map[string]string{"node-role.kubernetes.io/matrix": ""},
+					Tolerations:       []corev1.Toleration{{Key: "matrix", Operator: corev1.TolerationOpExists}},
+					PriorityClassName: "high-priority",
+					ImagePullSecrets:  []corev1.LocalObjectReference{{Name: "registry"}},
+				},
+			})
+			Expect(err).ShouldNot(HaveOccurred())
+
+			template := deployment.Spec.Template
+			Expect(template.Labels).Should(HaveKeyWithValue("app", "synapse"))
+			Expect(template.Labels).Should(HaveKeyWithValue("team", "matrix"))
+			Expect(template.Spec.NodeSelector).Should(HaveKey("node-role.kubernetes.io/matrix"))
+			Expect(template.Spec.Tolerations).Should(HaveLen(1))
+			Expect(template.Spec.PriorityClassName).Should(Equal("high-priority"))
+			Expect(template.Spec.ImagePullSecrets).Should(ConsistOf(corev1.LocalObjectReference{Name: "registry"}))
+			Expect(template.Spec.InitContainers).Should(HaveLen(1))
+			Expect(template.Spec.Volumes).Should(ContainElement(HaveField("Name", "homeserver")))
+			Expect(template.Spec.Volumes).Should(ContainElement(HaveField("Name", "extra")))
+
+			Expect(template.Spec.Containers).Should(HaveLen(1))
+			container := template.Spec.Containers[0]
+			Expect(container.Image).Should(Equal("matrixdotorg/synapse:v1.60.0"))
+			Expect(container.Env).Should(ContainElement(HaveField("Name", "SYNAPSE_CONFIG_PATH")))
+			Expect(container.Env).Should(ContainElement(corev1.EnvVar{Name: "SYNAPSE_CACHE_FACTOR", Value: "2"}))
+			Expect(container.Resources.Limits.Memory().String()).Should(Equal("2Gi"))
+			Expect(container.VolumeMounts).Should(ContainElement(corev1.VolumeMount{Name: "data-pv", MountPath: "/data"}))
+			Expect(container.VolumeMounts).Should(ContainElement(corev1.VolumeMount{Name: "extra", MountPath: "/extra"}))
+		})
+
+		It("Should refuse to change a required volume", func() {
+			_, err := deploymentWithPodTemplate(&corev1.PodTemplateSpec{
+				Spec: corev1.PodSpec{
+					Volumes: []corev1.Volume{{
+						Name:         "homeserver",
+						VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+					}},
+				},
+			})
+			Expect(err).Should(MatchError("volume homeserver is required and can't be changed"))
+		})
+
+		It("Should refuse to change a required volume mount", func() {
+			_, err := deploymentWithPodTemplate(&corev1.PodTemplateSpec{
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{{
+						Name:         "synapse",
+						VolumeMounts: []corev1.VolumeMount{{Name: "extra", MountPath: "/data"}},
+					}},
+				},
+			})
+			Expect(err).Should(MatchError("volume mount /data of container synapse is required and can't be changed"))
+		})
+
+		It("Should refuse to change a label selected by the Deployment", func() {
+			_, err := deploymentWithPodTemplate(&corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: map[string]string{"app": "other"},
+				},
+			})
+			Expect(err).Should(MatchError("label app is required and can't be changed"))
+		})
+
+		It("Should check the pod template of Heisenbridge", func() {
+			s.Spec.Bridges.Heisenbridge = synapsev1alpha1.SynapseHeisenbridge{
+				Enabled: true,
+				PodTemplate: &corev1.PodTemplateSpec{
+					Spec: corev1.PodSpec{
+						Volumes: []corev1.Volume{{
+							Name:         "data-heisenbridge",
+							VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+						}},
+					},
+				},
+			}
+			s.Spec.PodTemplate = &corev1.PodTemplateSpec{
+				Spec: corev1.PodSpec{NodeSelector: map[string]string{"matrix": "true"}},
+			}
+
+			err := r.checkPodTemplates(s)
+			Expect(err).Should(HaveOccurred())
+			Expect(err.Error()).Should(HavePrefix("invalid spec.bridges.heisenbridge.podTemplate: volume data-heisenbridge"))
+
+			s.Spec.Bridges.Heisenbridge.PodTemplate = nil
+			Expect(r.checkPodTemplates(s)).Should(Succeed())
+		})
+	})
+
+	Context("When reporting conditions in the Synapse Status", func() {
+		var r SynapseReconciler
+		var s synapsev1alpha1.Synapse
+
+		BeforeEach(func() {
+			r = SynapseReconciler{}
+			s = synapsev1alpha1.Synapse{
+				ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default", Generation: 3},
+			}
+		})
+
+		It("Should report the availability of a Deployment", func() {
+			deployment := appsv1.Deployment{
+				ObjectMeta: metav1.ObjectMeta{Name: "test"},
+				Status: appsv1.DeploymentStatus{
+					Conditions: []appsv1.DeploymentCondition{{
+						Type:   appsv1.DeploymentAvailable,
+						Status: corev1.ConditionTrue,
+					}},
+				},
+			}
+			r.setDeploymentCondition(&s, synapsev1alpha1.ConditionTypeDeploymentAvailable, deployment)
+
+			condition := meta.FindStatusCondition(s.Status.Conditions, synapsev1alpha1.ConditionTypeDeploymentAvailable)
+			Expect(condition).ShouldNot(BeNil())
+			Expect(condition.Status).Should(Equal(metav1.ConditionTrue))
+			Expect(condition.ObservedGeneration).Should(Equal(int64(3)))
+
+			deployment.Status.Conditions[0].Status = corev1.ConditionFalse
+			r.setDeploymentCondition(&s, synapsev1alpha1.ConditionTypeDeploymentAvailable, deployment)
+			Expect(meta.IsStatusConditionFalse(s.Status.Conditions, synapsev1alpha1.ConditionTypeDeploymentAvailable)).Should(BeTrue())
+		})
+
+		It("Should be Ready when all conditions are true", func() {
+			r.setStatusCondition(&s, synapsev1alpha1.ConditionTypeConfigurationValid, metav1.ConditionTrue, reasonConfigurationValid, "")
+			r.setStatusCondition(&s, synapsev1alpha1.ConditionTypeDeploymentAvailable, metav1.ConditionTrue, reasonDeploymentAvailable, "")
+			r.setReadyCondition(&s)
+
+			Expect(meta.IsStatusConditionTrue(s.Status.Conditions, synapsev1alpha1.ConditionTypeReady)).Should(BeTrue())
+		})
+
+		It("Should not be Ready when a condition is false", func() {
+			r.setStatusCondition(&s, synapsev1alpha1.ConditionTypeConfigurationValid, metav1.ConditionTrue, reasonConfigurationValid, "")
+			r.setStatusCondition(&s, synapsev1alpha1.ConditionTypeDatabaseReady, metav1.ConditionFalse, reasonDatabaseNotReady, "not ready")
+			r.setStatusCondition(&s, synapsev1alpha1.ConditionTypeDeploymentAvailable, metav1.ConditionTrue, reasonDeploymentAvailable, "")
+			r.setReadyCondition(&s)
+
+			condition := meta.FindStatusCondition(s.Status.Conditions, synapsev1alpha1.ConditionTypeReady)
+			Expect(condition).ShouldNot(BeNil())
+			Expect(condition.Status).Should(Equal(metav1.ConditionFalse))
+			Expect(condition.Reason).Should(Equal(reasonDatabaseNotReady))
+			Expect(condition.Message).Should(Equal("not ready"))
+		})
+
+		It("Should map a failed state onto conditions", func() {
+			r = newTestReconciler(s.DeepCopy())
+			Expect(r.Get(context.Background(), types.NamespacedName{Name: "test", Namespace: "default"}, &s)).Should(Succeed())
+
+			Expect(r.setFailedState(
+				context.Background(),
+				&s,
+				synapsev1alpha1.ConditionTypeConfigurationValid,
+				reasonSecretNotFound,
+				"Secret my-secret does not exist in namespace default",
+			)).Should(Succeed())
+
+			Expect(s.Status.State).Should(Equal("FAILED"))
+			Expect(s.Status.Reason).Should(Equal("Secret my-secret does not exist in namespace default"))
+			for _, conditionType := range []string{synapsev1alpha1.ConditionTypeConfigurationValid, synapsev1alpha1.ConditionTypeReady} {
+				condition := meta.FindStatusCondition(s.Status.Conditions, conditionType)
+				Expect(condition).ShouldNot(BeNil())
+				Expect(condition.Status).Should(Equal(metav1.ConditionFalse))
+				Expect(condition.Reason).Should(Equal("SecretNotFound"))
+			}
+		})
+	})
+
+	Context("When comparing an existing resource with the result of an apply", func() {
+		var existing *corev1.ConfigMap
+		var applied *corev1.ConfigMap
+
+		BeforeEach(func() {
+			existing = &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:            "test",
+					Namespace:       "default",
+					ResourceVersion: "1",
+				},
+				Data: map[string]string{"homeserver.yaml": "server_name: example.com"},
+			}
+			applied = existing.DeepCopy()
+			applied.ResourceVersion = "2"
+			applied.ManagedFields = []metav1.ManagedFieldsEntry{{Manager: synapseFieldManager}}
+			applied.SetGroupVersionKind(corev1.SchemeGroupVersion.WithKind("ConfigMap"))
+		})
+
+		It("Should ignore the fields maintained by the API server", func() {
+			Expect(diffResources(existing, applied)).Should(BeEmpty())
+		})
+
+		It("Should report changes to the resource", func() {
+			applied.Data["homeserver.yaml"] = "server_name: example.org"
+			Expect(diffResources(existing, applied)).Should(ContainSubstring("example.org"))
+		})
+
+		It("Should not disclose the content of Secrets", func() {
+			existingSecret := &corev1.Secret{Data: map[string][]byte{"password": []byte("old-password")}}
+			appliedSecret := &corev1.Secret{Data: map[string][]byte{"password": []byte("new-password")}}
+
+			resourceDiff := diffResources(existingSecret, appliedSecret)
+			Expect(resourceDiff).ShouldNot(BeEmpty())
+			Expect(resourceDiff).ShouldNot(ContainSubstring("old-password"))
+			Expect(resourceDiff).ShouldNot(ContainSubstring("new-password"))
+		})
+	})
+
+	Context("When addressing Synapse and the Heisenbridge", func() {
+		var r SynapseReconciler
+		var s synapsev1alpha1.Synapse
+
+		BeforeEach(func() {
+			r = SynapseReconciler{}
+			s = synapsev1alpha1.Synapse{
+				ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "matrix"},
+			}
+		})
+
+		It("Should use the Service DNS names in the cluster.local domain by default", func() {
+			heisenbridge := map[string]interface{}{}
+			Expect(r.updateHeisenbridgeWithURL(s, heisenbridge)).Should(Succeed())
+			Expect(heisenbridge["url"]).Should(Equal("http://test-heisenbridge.matrix.svc.cluster.local:9898"))
+
+			Expect(r.craftHeisenbridgeCommad(s)).Should(ContainElement("http://test.matrix.svc.cluster.local:8008"))
+		})
+
+		It("Should use the configured cluster domain", func() {
+			r.ClusterDomain = "example.internal"
+
+			heisenbridge := map[string]interface{}{}
+			Expect(r.updateHeisenbridgeWithURL(s, heisenbridge)).Should(Succeed())
+			Expect(heisenbridge["url"]).Should(Equal("http://test-heisenbridge.matrix.svc.example.internal:9898"))
+
+			Expect(r.craftHeisenbridgeCommad(s)).Should(ContainElement("http://test.matrix.svc.example.internal:8008"))
+		})
+
+		It("Should not depend on the Service IPs reported in the status", func() {
+			s.Status.IP = "10.0.0.1"
+			s.Status.BridgesConfiguration.Heisenbridge.IP = "10.0.0.2"
+
+			heisenbridge := map[string]interface{}{}
+			Expect(r.updateHeisenbridgeWithURL(s, heisenbridge)).Should(Succeed())
+			Expect(heisenbridge["url"]).ShouldNot(ContainSubstring("10.0.0.2"))
+			Expect(r.craftHeisenbridgeCommad(s)).ShouldNot(ContainElement(ContainSubstring("10.0.0.1")))
+		})
+	})
+
+	Context("When generating the Heisenbridge tokens", func() {
+		var r SynapseReconciler
+		var s synapsev1alpha1.Synapse
+		var objectMeta metav1.ObjectMeta
+		var cm corev1.ConfigMap
+		var secret *corev1.Secret
+
+		BeforeEach(func() {
+			r = newTestReconciler()
+			s = newTestSynapse(synapsev1alpha1.SynapseSpec{})
+			objectMeta = setObjectMeta("test-heisenbridge", "default", map[string]string{})
+			cm = corev1.ConfigMap{Data: map[string]string{"heisenbridge.yaml": "id: heisenbridge\n"}}
+			secret = renderResource(r.secretForHeisenbridge(context.Background(), cm), s, objectMeta).(*corev1.Secret)
+		})
+
+		It("Should generate a random value for each token", func() {
+			for _, key := range heisenbridgeTokenKeys {
+				Expect(secret.Data[key]).Should(HaveLen(64))
+			}
+			Expect(secret.Data["as_token"]).ShouldNot(Equal(secret.Data["hs_token"]))
+		})
+
+		It("Should write the tokens in heisenbridge.yaml", func() {
+			heisenbridge := map[string]interface{}{
+				"id":       "heisenbridge",
+				"as_token": "publicly-known-token",
+			}
+			Expect(r.updateHeisenbridgeWithTokens(*secret)(s, heisenbridge)).Should(Succeed())
+			Expect(heisenbridge["as_token"]).Should(Equal(string(secret.Data["as_token"])))
+			Expect(heisenbridge["hs_token"]).Should(Equal(string(secret.Data["hs_token"])))
+			Expect(heisenbridge["id"]).Should(Equal("heisenbridge"))
+		})
+
+		It("Should only write the tokens in the heisenbridge.yaml of the Secret", func() {
+			heisenbridge := map[string]interface{}{}
+			Expect(yaml.Unmarshal(secret.Data["heisenbridge.yaml"], heisenbridge)).Should(Succeed())
+			Expect(heisenbridge).Should(HaveKeyWithValue("id", "heisenbridge"))
+			Expect(heisenbridge).Should(HaveKeyWithValue("as_token", string(secret.Data["as_token"])))
+			Expect(heisenbridge).Should(HaveKeyWithValue("hs_token", string(secret.Data["hs_token"])))
+
+			heisenbridge = map[string]interface{}{
+				"id":       "heisenbridge",
+				"as_token": "publicly-known-token",
+				"hs_token": "publicly-known-token",
+			}
+			Expect(r.updateHeisenbridgeWithoutTokens(s, heisenbridge)).Should(Succeed())
+			Expect(heisenbridge).Should(Equal(map[string]interface{}{"id": "heisenbridge"}))
+		})
+
+		It("Should fail to write the tokens if the Secret is incomplete", func() {
+			delete(secret.Data, "hs_token")
+			Expect(r.updateHeisenbridgeWithTokens(*secret)(s, map[string]interface{}{})).ShouldNot(Succeed())
+		})
+
+		When("when the Heisenbridge Secret already exists", func() {
+			JustBeforeEach(func() {
+				Expect(r.Create(context.Background(), secret)).Should(Succeed())
+			})
+
+			It("Should keep the existing tokens if no rotation was requested", func() {
+				resource, err := r.secretForHeisenbridge(context.Background(), cm)(&s, objectMeta)
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(resource.(*corev1.Secret).Data).Should(Equal(secret.Data))
+			})
+
+			It("Should rotate the tokens when the rotation annotation changes", func() {
+				s.Annotations = map[string]string{rotateHeisenbridgeTokensAnnotation: "2022-06-01"}
+				resource, err := r.secretForHeisenbridge(context.Background(), cm)(&s, objectMeta)
+				Expect(err).ShouldNot(HaveOccurred())
+
+				rotated := resource.(*corev1.Secret)
+				for _, key := range heisenbridgeTokenKeys {
+					Expect(rotated.Data[key]).ShouldNot(Equal(secret.Data[key]))
+				}
+				Expect(rotated.Annotations).Should(HaveKeyWithValue(rotateHeisenbridgeTokensAnnotation, "2022-06-01"))
+			})
+		})
+	})
+
+	Context("When generating the Secret for Synapse", func() {
+		var r SynapseReconciler
+		var s synapsev1alpha1.Synapse
+		var objectMeta metav1.ObjectMeta
+
+		BeforeEach(func() {
+			r = newTestReconciler()
+			s = newTestSynapse(synapsev1alpha1.SynapseSpec{})
+			objectMeta = setObjectMeta("test-secrets", "default", map[string]string{})
+		})
+
+		It("Should generate a random value for each secret key", func() {
+			resource, err := r.secretForSynapse(context.Background())(&s, objectMeta)
+			Expect(err).ShouldNot(HaveOccurred())
+			secret, ok := resource.(*corev1.Secret)
+			Expect(ok).Should(BeTrue())
+
+			for _, key := range homeserverSecretKeys {
+				Expect(secret.Data).Should(HaveKey(key))
+				Expect(secret.Data[key]).Should(HaveLen(50))
+			}
+			Expect(r.checkHomeserverSecret(*secret)).Should(Succeed())
+		})
+
+		It("Should generate different values for different Synapse instances", func() {
+			other := synapsev1alpha1.Synapse{
+				ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: "default", UID: "other-uid"},
+			}
+			otherObjectMeta := setObjectMeta("other-secrets", "default", map[string]string{})
+
+			first, err := r.secretForSynapse(context.Background())(&s, objectMeta)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(r.Create(context.Background(), first)).Should(Succeed())
+			second, err := r.secretForSynapse(context.Background())(&other, otherObjectMeta)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(second.GetName()).Should(Equal("other-secrets"))
+
+			for _, key := range homeserverSecretKeys {
+				Expect(first.(*corev1.Secret).Data[key]).ShouldNot(Equal(second.(*corev1.Secret).Data[key]))
+			}
+		})
+
+		It("Should keep the values of the existing Secret", func() {
+			first, err := r.secretForSynapse(context.Background())(&s, objectMeta)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(r.Create(context.Background(), first)).Should(Succeed())
+
+			second, err := r.secretForSynapse(context.Background())(&s, objectMeta)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(second.(*corev1.Secret).Data).Should(Equal(first.(*corev1.Secret).Data))
+		})
+	})
+
+	Context("When updating the Synapse Secret Data with secret values", func() {
+		var r SynapseReconciler
+		var s synapsev1alpha1.Synapse
+		var configSecret corev1.Secret
+		var secret corev1.Secret
+
+		BeforeEach(func() {
+			r = SynapseReconciler{}
+			s = synapsev1alpha1.Synapse{}
+			secret = corev1.Secret{
+				Data: map[string][]byte{
+					"registration_shared_secret": []byte("registration"),
+					"macaroon_secret_key":        []byte("macaroon"),
+					"form_secret":                []byte("form"),
+				},
+			}
+			configSecret = corev1.Secret{
+				Data: map[string][]byte{
+					"homeserver-secrets.yaml": []byte("form_secret: user-defined"),
+				},
+			}
+		})
+
+		loadHomeserverSecrets := func() map[string]interface{} {
+			homeserverSecrets, err := r.loadYAMLFileFromSecretData(configSecret, "homeserver-secrets.yaml")
+			Expect(err).ShouldNot(HaveOccurred())
+			return homeserverSecrets
+		}
+
+		When("when the homeserver.yaml is generated from values", func() {
+			BeforeEach(func() {
+				s.Spec.Homeserver.Values = &synapsev1alpha1.SynapseHomeserverValues{ServerName: "example.com"}
+			})
+
+			It("Should write all secret values in homeserver-secrets.yaml", func() {
+				Expect(r.updateSecretData(&configSecret, s, r.updateHomeserverWithSecrets(secret), "homeserver-secrets.yaml")).Should(Succeed())
+
+				homeserverSecrets := loadHomeserverSecrets()
+				Expect(homeserverSecrets["registration_shared_secret"]).Should(Equal("registration"))
+				Expect(homeserverSecrets["macaroon_secret_key"]).Should(Equal("macaroon"))
+				Expect(homeserverSecrets["form_secret"]).Should(Equal("form"))
+			})
+		})
+
+		When("when the homeserver.yaml is provided by the user", func() {
+			BeforeEach(func() {
+				s.Spec.Homeserver.ConfigMap = &synapsev1alpha1.SynapseHomeserverConfigMap{Name: "input"}
+			})
+
+			It("Should keep the values defined by the user", func() {
+				Expect(r.updateSecretData(&configSecret, s, r.updateHomeserverWithSecrets(secret), "homeserver-secrets.yaml")).Should(Succeed())
+
+				homeserverSecrets := loadHomeserverSecrets()
+				Expect(homeserverSecrets["registration_shared_secret"]).Should(Equal("registration"))
+				Expect(homeserverSecrets["form_secret"]).Should(Equal("user-defined"))
+			})
+
+			It("Should overwrite the values defined by the user if a Secret is referenced", func() {
+				s.Spec.Homeserver.Secret = &synapsev1alpha1.SynapseHomeserverSecret{Name: "input-secret"}
+				Expect(r.updateSecretData(&configSecret, s, r.updateHomeserverWithSecrets(secret), "homeserver-secrets.yaml")).Should(Succeed())
+
+				homeserverSecrets := loadHomeserverSecrets()
+				Expect(homeserverSecrets["form_secret"]).Should(Equal("form"))
+			})
+		})
+
+		When("when the Secret is missing a key", func() {
+			BeforeEach(func() {
+				delete(secret.Data, "macaroon_secret_key")
+			})
+
+			It("Should fail to update the Secret data", func() {
+				Expect(r.updateSecretData(&configSecret, s, r.updateHomeserverWithSecrets(secret), "homeserver-secrets.yaml")).ShouldNot(Succeed())
+			})
+		})
+	})
+
+	Context("When moving sensitive sections out of the Synapse ConfigMap", func() {
+		var r SynapseReconciler
+		var s synapsev1alpha1.Synapse
+		var cm corev1.ConfigMap
+		var configSecret corev1.Secret
+
+		BeforeEach(func() {
+			r = SynapseReconciler{}
+			s = synapsev1alpha1.Synapse{}
+			cm = corev1.ConfigMap{
+				Data: map[string]string{
+					"homeserver.yaml": "server_name: example.com\n" +
+						"report_stats: true\n" +
+						"macaroon_secret_key: macaroon\n" +
+						"database:\n  name: psycopg2\n  args:\n    password: secret\n",
+				},
+			}
+			configSecret = corev1.Secret{
+				Data: map[string][]byte{
+					"homeserver-secrets.yaml": []byte("form_secret: form\nmacaroon_secret_key: previous"),
+				},
+			}
+		})
+
+		It("Should move sensitive sections to homeserver-secrets.yaml", func() {
+			sensitiveConfig := map[string]interface{}{}
+			Expect(r.updateConfigMapData(&cm, s, r.extractSensitiveConfig(sensitiveConfig), "homeserver.yaml")).Should(Succeed())
+			Expect(r.updateSecretData(&configSecret, s, r.mergeSensitiveConfig(sensitiveConfig), "homeserver-secrets.yaml")).Should(Succeed())
+
+			homeserver, err := r.loadYAMLFileFromConfigMapData(cm, "homeserver.yaml")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(homeserver).ShouldNot(HaveKey("database"))
+			Expect(homeserver).ShouldNot(HaveKey("macaroon_secret_key"))
+			Expect(homeserver["server_name"]).Should(Equal("example.com"))
+
+			homeserverSecrets, err := r.loadYAMLFileFromSecretData(configSecret, "homeserver-secrets.yaml")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(homeserverSecrets).Should(HaveKey("database"))
+			Expect(homeserverSecrets["macaroon_secret_key"]).Should(Equal("macaroon"))
+			Expect(homeserverSecrets["form_secret"]).Should(Equal("form"))
+			Expect(homeserverSecrets).ShouldNot(HaveKey("server_name"))
+		})
+	})
+})
+
+// noVolumeSnapshotAPIClient is a client of a cluster which doesn't serve the
+// VolumeSnapshot API.
+type noVolumeSnapshotAPIClient struct {
+	client.Client
+}
+
+func (c noVolumeSnapshotAPIClient) Get(ctx context.Context, key client.ObjectKey, obj client.Object) error {
+	if obj.GetObjectKind().GroupVersionKind() == volumeSnapshotGVK {
+		return &meta.NoKindMatchError{
+			GroupKind:        volumeSnapshotGVK.GroupKind(),
+			SearchedVersions: []string{volumeSnapshotGVK.Version},
+		}
+	}
+	return c.Client.Get(ctx, key, obj)
+}
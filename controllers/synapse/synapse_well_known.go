@@ -0,0 +1,361 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package synapse
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	synapsev1alpha1 "github.com/opdev/synapse-operator/apis/synapse/v1alpha1"
+)
+
+// The .well-known documents are served by nginx from the well-known
+// ConfigMap, which also holds the nginx configuration.
+const (
+	wellKnownPath      = "/.well-known/matrix"
+	wellKnownPort      = 8080
+	wellKnownNginxConf = `server {
+    listen 8080;
+    root /usr/share/nginx/html;
+
+    location /.well-known/matrix/ {
+        default_type application/json;
+        add_header Access-Control-Allow-Origin "*";
+    }
+}
+`
+)
+
+// labelsForWellKnown returns the labels for selecting the resources serving
+// the .well-known documents of the given synapse CR name.
+func labelsForWellKnown(name string) map[string]string {
+	return map[string]string{"app": "synapse-well-known", "synapse_cr": name}
+}
+
+// isWellKnownServedBySynapse returns true if the .well-known documents are
+// served by Synapse itself.
+func isWellKnownServedBySynapse(s synapsev1alpha1.Synapse) bool {
+	return s.Spec.WellKnown != nil && s.Spec.WellKnown.Mode == "Synapse"
+}
+
+// isWellKnownDeployed returns true if the .well-known documents are served
+// by a dedicated Deployment.
+func isWellKnownDeployed(s synapsev1alpha1.Synapse) bool {
+	return s.Spec.WellKnown != nil && s.Spec.WellKnown.Mode != "Synapse"
+}
+
+// serverNameHost returns the server name of Synapse, without its optional
+// port.
+func serverNameHost(s synapsev1alpha1.Synapse) string {
+	serverName := s.Status.HomeserverConfiguration.ServerName
+	if host, _, err := net.SplitHostPort(serverName); err == nil {
+		return host
+	}
+	return serverName
+}
+
+// publicHostForSynapse returns the public host name of Synapse, as served by
+// its Ingress or Route, if any.
+func publicHostForSynapse(s synapsev1alpha1.Synapse) string {
+	if s.Spec.Ingress != nil {
+		return s.Spec.Ingress.Host
+	}
+	if s.Spec.Route != nil {
+		if s.Spec.Route.Host != "" {
+			return s.Spec.Route.Host
+		}
+		return s.Status.Route.Host
+	}
+	return ""
+}
+
+// wellKnownServer returns the address of the federation API published in
+// /.well-known/matrix/server.
+func wellKnownServer(s synapsev1alpha1.Synapse) string {
+	if s.Spec.WellKnown.Server != "" {
+		return s.Spec.WellKnown.Server
+	}
+	if host := publicHostForSynapse(s); host != "" {
+		return host + ":443"
+	}
+	return ""
+}
+
+// wellKnownHomeserverBaseURL returns the base URL of the homeserver published
+// in /.well-known/matrix/client.
+func wellKnownHomeserverBaseURL(s synapsev1alpha1.Synapse) string {
+	if s.Spec.WellKnown.HomeserverBaseURL != "" {
+		return s.Spec.WellKnown.HomeserverBaseURL
+	}
+	if s.Spec.Ingress != nil {
+		return publicBaseURLForIngress(s)
+	}
+	if host := publicHostForSynapse(s); host != "" {
+		return "https://" + host + "/"
+	}
+	if s.Spec.Homeserver.Values != nil {
+		return s.Spec.Homeserver.Values.PublicBaseURL
+	}
+	return ""
+}
+
+// wellKnownDocuments returns the content of the /.well-known/matrix/server
+// and /.well-known/matrix/client documents, by file name.
+func wellKnownDocuments(s synapsev1alpha1.Synapse) (map[string]string, error) {
+	server := wellKnownServer(s)
+	if server == "" {
+		return nil, errors.New("spec.wellKnown.server must be set when Synapse is not exposed with an Ingress or a Route")
+	}
+
+	homeserverBaseURL := wellKnownHomeserverBaseURL(s)
+	if homeserverBaseURL == "" {
+		return nil, errors.New("spec.wellKnown.homeserverBaseURL must be set when Synapse has no public base URL")
+	}
+
+	serverDocument, err := json.Marshal(map[string]string{"m.server": server})
+	if err != nil {
+		return nil, err
+	}
+
+	clientContent := map[string]interface{}{
+		"m.homeserver": map[string]string{"base_url": homeserverBaseURL},
+	}
+	if s.Spec.WellKnown.IdentityServerBaseURL != "" {
+		clientContent["m.identity_server"] = map[string]string{"base_url": s.Spec.WellKnown.IdentityServerBaseURL}
+	}
+	clientDocument, err := json.Marshal(clientContent)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]string{
+		"server": string(serverDocument),
+		"client": string(clientDocument),
+	}, nil
+}
+
+// configMapForWellKnown returns a ConfigMap holding the .well-known
+// documents and the configuration of the web server serving them.
+func (r *SynapseReconciler) configMapForWellKnown(s *synapsev1alpha1.Synapse, objectMeta metav1.ObjectMeta) (client.Object, error) {
+	documents, err := wellKnownDocuments(*s)
+	if err != nil {
+		return &corev1.ConfigMap{}, err
+	}
+
+	cm := &corev1.ConfigMap{
+		ObjectMeta: objectMeta,
+		Data: map[string]string{
+			"server":       documents["server"],
+			"client":       documents["client"],
+			"default.conf": wellKnownNginxConf,
+		},
+	}
+
+	// Set Synapse instance as the owner and controller
+	if err := ctrl.SetControllerReference(s, cm, r.Scheme); err != nil {
+		return &corev1.ConfigMap{}, err
+	}
+	return cm, nil
+}
+
+// deploymentForWellKnown returns a Deployment running the web server serving
+// the .well-known documents.
+func (r *SynapseReconciler) deploymentForWellKnown(s *synapsev1alpha1.Synapse, objectMeta metav1.ObjectMeta) (client.Object, error) {
+	ls := labelsForWellKnown(s.Name)
+	replicas := int32(1)
+
+	dep := &appsv1.Deployment{
+		ObjectMeta: objectMeta,
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &replicas,
+			Selector: &metav1.LabelSelector{
+				MatchLabels: ls,
+			},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: ls,
+				},
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{{
+						Image: s.Spec.WellKnown.Image,
+						Name:  "well-known",
+						VolumeMounts: []corev1.VolumeMount{{
+							Name:      "nginx-conf",
+							MountPath: "/etc/nginx/conf.d",
+						}, {
+							Name:      "well-known",
+							MountPath: "/usr/share/nginx/html" + wellKnownPath,
+						}},
+						Ports: []corev1.ContainerPort{{
+							ContainerPort: wellKnownPort,
+						}},
+					}},
+					Volumes: []corev1.Volume{{
+						Name: "nginx-conf",
+						VolumeSource: corev1.VolumeSource{
+							ConfigMap: &corev1.ConfigMapVolumeSource{
+								LocalObjectReference: corev1.LocalObjectReference{
+									Name: objectMeta.Name,
+								},
+								Items: []corev1.KeyToPath{{
+									Key:  "default.conf",
+									Path: "default.conf",
+								}},
+							},
+						},
+					}, {
+						Name: "well-known",
+						VolumeSource: corev1.VolumeSource{
+							ConfigMap: &corev1.ConfigMapVolumeSource{
+								LocalObjectReference: corev1.LocalObjectReference{
+									Name: objectMeta.Name,
+								},
+								Items: []corev1.KeyToPath{{
+									Key:  "server",
+									Path: "server",
+								}, {
+									Key:  "client",
+									Path: "client",
+								}},
+							},
+						},
+					}},
+				},
+			},
+		},
+	}
+
+	// Set Synapse instance as the owner and controller
+	if err := ctrl.SetControllerReference(s, dep, r.Scheme); err != nil {
+		return &appsv1.Deployment{}, err
+	}
+	return dep, nil
+}
+
+// serviceForWellKnown returns a Service for the web server serving the
+// .well-known documents.
+func (r *SynapseReconciler) serviceForWellKnown(s *synapsev1alpha1.Synapse, objectMeta metav1.ObjectMeta) (client.Object, error) {
+	service := &corev1.Service{
+		ObjectMeta: objectMeta,
+		Spec: corev1.ServiceSpec{
+			Ports: []corev1.ServicePort{{
+				Name:       "http",
+				Protocol:   corev1.ProtocolTCP,
+				Port:       wellKnownPort,
+				TargetPort: intstr.FromInt(wellKnownPort),
+			}},
+			Selector: labelsForWellKnown(s.Name),
+			Type:     corev1.ServiceTypeClusterIP,
+		},
+	}
+
+	// Set Synapse instance as the owner and controller
+	if err := ctrl.SetControllerReference(s, service, r.Scheme); err != nil {
+		return &corev1.Service{}, err
+	}
+	return service, nil
+}
+
+// ingressForWellKnown returns an Ingress routing /.well-known/matrix on the
+// server_name domain to the web server serving the .well-known documents.
+// Unless Spec.WellKnown.Ingress is set, the IngressClass, annotations and
+// cert-manager issuer of Spec.Ingress are used.
+func (r *SynapseReconciler) ingressForWellKnown(s *synapsev1alpha1.Synapse, objectMeta metav1.ObjectMeta) (client.Object, error) {
+	wellKnownIngress := synapsev1alpha1.SynapseWellKnownIngress{}
+	if s.Spec.WellKnown.Ingress != nil {
+		wellKnownIngress = *s.Spec.WellKnown.Ingress
+	} else if s.Spec.Ingress != nil {
+		wellKnownIngress.IngressClassName = s.Spec.Ingress.IngressClassName
+		wellKnownIngress.Annotations = s.Spec.Ingress.Annotations
+		if s.Spec.Ingress.TLS != nil && s.Spec.Ingress.TLS.Issuer != nil {
+			wellKnownIngress.TLS = &synapsev1alpha1.SynapseIngressTLS{
+				Issuer: s.Spec.Ingress.TLS.Issuer,
+			}
+		}
+	}
+
+	host := serverNameHost(*s)
+	pathType := networkingv1.PathTypePrefix
+	ingress := &networkingv1.Ingress{
+		ObjectMeta: objectMeta,
+		Spec: networkingv1.IngressSpec{
+			IngressClassName: wellKnownIngress.IngressClassName,
+			Rules: []networkingv1.IngressRule{{
+				Host: host,
+				IngressRuleValue: networkingv1.IngressRuleValue{
+					HTTP: &networkingv1.HTTPIngressRuleValue{
+						Paths: []networkingv1.HTTPIngressPath{{
+							Path:     wellKnownPath,
+							PathType: &pathType,
+							Backend: networkingv1.IngressBackend{
+								Service: &networkingv1.IngressServiceBackend{
+									Name: objectMeta.Name,
+									Port: networkingv1.ServiceBackendPort{Number: wellKnownPort},
+								},
+							},
+						}},
+					},
+				},
+			}},
+		},
+	}
+
+	for key, value := range wellKnownIngress.Annotations {
+		setAnnotation(&ingress.ObjectMeta, key, value)
+	}
+
+	if tls := wellKnownIngress.TLS; tls != nil {
+		secretName := tls.SecretName
+		if secretName == "" {
+			secretName = objectMeta.Name + "-tls"
+		}
+		ingress.Spec.TLS = []networkingv1.IngressTLS{{
+			Hosts:      []string{host},
+			SecretName: secretName,
+		}}
+		setIssuerAnnotation(&ingress.ObjectMeta, tls.Issuer)
+	}
+
+	// Set Synapse instance as the owner and controller
+	if err := ctrl.SetControllerReference(s, ingress, r.Scheme); err != nil {
+		return &networkingv1.Ingress{}, err
+	}
+	return ingress, nil
+}
+
+// updateHomeserverWithWellKnown is a function of type updateDataFunc, to be
+// passed as an argument in a call to withConfigMapUpdates.
+//
+// It enables the .well-known documents served by Synapse. Synapse publishes
+// its public base URL, and the identity server set in
+// 'default_identity_server'.
+func (r *SynapseReconciler) updateHomeserverWithWellKnown(s synapsev1alpha1.Synapse, homeserver map[string]interface{}) error {
+	homeserver["serve_server_wellknown"] = true
+	if s.Spec.WellKnown.IdentityServerBaseURL != "" {
+		homeserver["default_identity_server"] = s.Spec.WellKnown.IdentityServerBaseURL
+	}
+	return nil
+}
@@ -0,0 +1,824 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package synapse
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+
+	"gopkg.in/yaml.v2"
+	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+
+	synapsev1alpha1 "github.com/opdev/synapse-operator/apis/synapse/v1alpha1"
+)
+
+// Workers serve HTTP requests on workerHTTPPort. The main Synapse process
+// and the workers serve the replication API on replicationPort.
+const (
+	workerHTTPPort  = 8083
+	replicationPort = 9093
+)
+
+// The worker configuration file is rendered in the ConfigMap of the pool,
+// and mounted in workerConfigMountPath. The name of replicated workers is
+// only known once their pod is created, and is written by an init container
+// in a configuration file of its own, in workerNameMountPath.
+const (
+	workerConfigFile      = "worker.yaml"
+	workerConfigMountPath = "/data-worker"
+	workerNameFile        = "worker_name.yaml"
+	workerNameMountPath   = "/data-worker-name"
+)
+
+// WorkerConfig holds the content of the configuration file of a worker. It
+// is used in addition to the homeserver.yaml shared with the main Synapse
+// process.
+type WorkerConfig struct {
+	WorkerApp                 string               `yaml:"worker_app"`
+	WorkerName                string               `yaml:"worker_name,omitempty"`
+	WorkerReplicationHost     string               `yaml:"worker_replication_host"`
+	WorkerReplicationHTTPPort int                  `yaml:"worker_replication_http_port"`
+	WorkerListeners           []HomeserverListener `yaml:"worker_listeners"`
+	WorkerLogConfig           string               `yaml:"worker_log_config,omitempty"`
+	FederationSenderInstances []string             `yaml:"federation_sender_instances,omitempty"`
+}
+
+// labelsForWorkerPool returns the labels for selecting the resources of a
+// pool of workers of the given synapse CR name.
+func labelsForWorkerPool(name string, pool string) map[string]string {
+	return map[string]string{"app": "synapse-worker", "synapse_cr": name, "synapse_worker_pool": pool}
+}
+
+// workerPoolResourceName returns the name of the ConfigMap, Deployment and
+// Service of a pool of workers.
+func workerPoolResourceName(s synapsev1alpha1.Synapse, pool synapsev1alpha1.SynapseWorkerPool) string {
+	return s.Name + "-worker-" + pool.Name
+}
+
+// isWorkerPoolStatefulSet returns true if the workers of a pool are run by a
+// StatefulSet rather than a Deployment. The federation_sender workers are
+// listed by name in homeserver.yaml: when autoscaled, they are named after
+// the stable names of the pods of the StatefulSet.
+func isWorkerPoolStatefulSet(pool synapsev1alpha1.SynapseWorkerPool) bool {
+	return pool.Type == synapsev1alpha1.WorkerTypeFederationSender && pool.Autoscaling != nil
+}
+
+// isWorkerNamedAfterPod returns true if the workers of a pool are named
+// after their pod rather than after their pool.
+func isWorkerNamedAfterPod(pool synapsev1alpha1.SynapseWorkerPool) bool {
+	return pool.Type.IsScalable() || isWorkerPoolStatefulSet(pool)
+}
+
+// workerPoolReplicas returns the number of workers of a pool. It's nil for
+// the autoscaled pools, whose number of workers is owned by their
+// HorizontalPodAutoscaler, see handOverWorkerPoolReplicas.
+func workerPoolReplicas(pool synapsev1alpha1.SynapseWorkerPool) *int32 {
+	if pool.Autoscaling != nil {
+		return nil
+	}
+	replicas := int32(1)
+	if pool.Replicas != nil {
+		replicas = *pool.Replicas
+	}
+	return &replicas
+}
+
+// replicasHandoverFieldManager is the field manager owning the replicas of
+// the workers of a pool while they are handed over from the Synapse Operator
+// to a HorizontalPodAutoscaler.
+const replicasHandoverFieldManager = "synapse-operator-replicas-handover"
+
+// handOverWorkerPoolReplicas hands the replicas of the existing workers of an
+// autoscaled pool over to its HorizontalPodAutoscaler.
+//
+// A field which is no longer applied by its only field manager is removed,
+// and the replicas would be reset to 1 as soon as workerPoolReplicas returns
+// nil. The current replicas are therefore first applied by the
+// replicasHandoverFieldManager, which keeps them until the
+// HorizontalPodAutoscaler scales the pool.
+func (r *SynapseReconciler) handOverWorkerPoolReplicas(ctx context.Context, workers client.Object, objectMeta metav1.ObjectMeta) error {
+	if err := r.Get(ctx, types.NamespacedName{Name: objectMeta.Name, Namespace: objectMeta.Namespace}, workers); err != nil {
+		if k8serrors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+
+	if !isReplicasManagedBy(workers.GetManagedFields(), synapseFieldManager) {
+		return nil
+	}
+
+	var replicas *int32
+	switch w := workers.(type) {
+	case *appsv1.Deployment:
+		replicas = w.Spec.Replicas
+	case *appsv1.StatefulSet:
+		replicas = w.Spec.Replicas
+	}
+	if replicas == nil {
+		return nil
+	}
+
+	gvk, err := apiutil.GVKForObject(workers, r.Scheme)
+	if err != nil {
+		return err
+	}
+	handover := &unstructured.Unstructured{}
+	handover.SetGroupVersionKind(gvk)
+	handover.SetName(objectMeta.Name)
+	handover.SetNamespace(objectMeta.Namespace)
+	if err := unstructured.SetNestedField(handover.Object, int64(*replicas), "spec", "replicas"); err != nil {
+		return err
+	}
+
+	return r.Patch(ctx, handover, client.Apply, client.FieldOwner(replicasHandoverFieldManager))
+}
+
+// isReplicasManagedBy returns true if spec.replicas is applied by the given
+// field manager.
+func isReplicasManagedBy(managedFields []metav1.ManagedFieldsEntry, manager string) bool {
+	for _, entry := range managedFields {
+		if entry.Manager != manager || entry.Operation != metav1.ManagedFieldsOperationApply || entry.FieldsV1 == nil {
+			continue
+		}
+
+		var fields map[string]interface{}
+		if err := json.Unmarshal(entry.FieldsV1.Raw, &fields); err != nil {
+			continue
+		}
+		if spec, ok := fields["f:spec"].(map[string]interface{}); ok {
+			if _, ok := spec["f:replicas"]; ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// workerApp returns the Synapse application run by the workers of the
+// given type.
+func workerApp(workerType synapsev1alpha1.SynapseWorkerType) string {
+	if workerType == synapsev1alpha1.WorkerTypeMediaRepository {
+		return "synapse.app.media_repository"
+	}
+	return "synapse.app.generic_worker"
+}
+
+// workerResources returns the resources served on the HTTP listener of the
+// workers of the given type, if any.
+func workerResources(s synapsev1alpha1.Synapse, workerType synapsev1alpha1.SynapseWorkerType) []string {
+	switch workerType {
+	case synapsev1alpha1.WorkerTypeGenericWorker:
+		if !isFederationEnabled(s) {
+			return []string{"client"}
+		}
+		return []string{"client", "federation"}
+	case synapsev1alpha1.WorkerTypeMediaRepository:
+		return []string{"media"}
+	}
+	return nil
+}
+
+// workerNames returns the names of the workers of the given type. Only
+// used for the worker types which can't be replicated, whose worker name is
+// the name of their pool.
+func workerNames(s synapsev1alpha1.Synapse, workerType synapsev1alpha1.SynapseWorkerType) []string {
+	var names []string
+	for _, pool := range s.Spec.Workers {
+		if pool.Type == workerType {
+			names = append(names, pool.Name)
+		}
+	}
+	return names
+}
+
+// replicationListener returns the listener serving the replication API.
+func replicationListener() HomeserverListener {
+	return HomeserverListener{
+		Port: replicationPort,
+		Type: "http",
+		Resources: []HomeserverListenerResource{{
+			Names:    []string{"replication"},
+			Compress: false,
+		}},
+	}
+}
+
+// workerConfigForPool returns the configuration of the workers of a pool.
+func (r *SynapseReconciler) workerConfigForPool(s synapsev1alpha1.Synapse, pool synapsev1alpha1.SynapseWorkerPool) WorkerConfig {
+	config := WorkerConfig{
+		WorkerApp:                 workerApp(pool.Type),
+		WorkerReplicationHost:     r.serviceHost(s, mainServiceName(s)),
+		WorkerReplicationHTTPPort: replicationPort,
+		WorkerListeners:           []HomeserverListener{replicationListener()},
+	}
+
+	// Replicated workers are named after their pod
+	if !isWorkerNamedAfterPod(pool) {
+		config.WorkerName = pool.Name
+	}
+
+	if resources := workerResources(s, pool.Type); resources != nil {
+		config.WorkerListeners = append(config.WorkerListeners, HomeserverListener{
+			Port:       workerHTTPPort,
+			Type:       "http",
+			XForwarded: true,
+			Resources: []HomeserverListenerResource{{
+				Names:    resources,
+				Compress: false,
+			}},
+		})
+	}
+
+	// Workers don't mount the Synapse PVC, and can only log to the console
+	if isLogConfigManaged(s) && (s.Spec.Logging == nil || s.Spec.Logging.Output != "file") {
+		config.WorkerLogConfig = logConfigPath
+	}
+
+	return config
+}
+
+// configMapForWorkerPool returns a function of type createResourceFunc, to
+// be passed as an argument in a call to reconcileResource.
+//
+// The returned function generates a ConfigMap holding the worker
+// configuration file of the given pool. The configuration of the
+// federation_sender workers lists the given federation sender instances.
+func (r *SynapseReconciler) configMapForWorkerPool(
+	pool synapsev1alpha1.SynapseWorkerPool,
+	federationSenderInstances []string,
+) createResourceFunc {
+	return func(s *synapsev1alpha1.Synapse, objectMeta metav1.ObjectMeta) (client.Object, error) {
+		config := r.workerConfigForPool(*s, pool)
+		if pool.Type == synapsev1alpha1.WorkerTypeFederationSender {
+			config.FederationSenderInstances = federationSenderInstances
+		}
+
+		workerYaml, err := yaml.Marshal(config)
+		if err != nil {
+			return &corev1.ConfigMap{}, err
+		}
+
+		cm := &corev1.ConfigMap{
+			ObjectMeta: objectMeta,
+			Data:       map[string]string{workerConfigFile: string(workerYaml)},
+		}
+
+		// Set Synapse instance as the owner and controller
+		if err := ctrl.SetControllerReference(s, cm, r.Scheme); err != nil {
+			return &corev1.ConfigMap{}, err
+		}
+		return cm, nil
+	}
+}
+
+// deploymentForWorkerPool returns a function of type createResourceFunc, to
+// be passed as an argument in a call to reconcileResource.
+//
+// The returned function generates a Deployment running the workers of the
+// given pool. The workers share homeserver.yaml, homeserver-secrets.yaml and
+// the signing key with the main Synapse process.
+func (r *SynapseReconciler) deploymentForWorkerPool(pool synapsev1alpha1.SynapseWorkerPool) createResourceFunc {
+	return func(s *synapsev1alpha1.Synapse, objectMeta metav1.ObjectMeta) (client.Object, error) {
+		ls := labelsForWorkerPool(s.Name, pool.Name)
+
+		dep := &appsv1.Deployment{
+			ObjectMeta: objectMeta,
+			Spec: appsv1.DeploymentSpec{
+				Replicas: workerPoolReplicas(pool),
+				Selector: &metav1.LabelSelector{
+					MatchLabels: ls,
+				},
+				Template: corev1.PodTemplateSpec{
+					ObjectMeta: metav1.ObjectMeta{
+						Labels: ls,
+					},
+					Spec: corev1.PodSpec{
+						Containers: []corev1.Container{{
+							Image: s.Spec.Image,
+							Name:  "synapse-worker",
+							Args: []string{
+								"run",
+								"--config-path", "/data-homeserver/homeserver.yaml",
+								"--config-path", "/data-homeserver-secrets/homeserver-secrets.yaml",
+								"--config-path", workerConfigMountPath + "/" + workerConfigFile,
+							},
+							Env: []corev1.EnvVar{{
+								Name:  "SYNAPSE_CONFIG_PATH",
+								Value: "/data-homeserver/homeserver.yaml",
+							}, {
+								Name:  "SYNAPSE_WORKER",
+								Value: workerApp(pool.Type),
+							}},
+							VolumeMounts: []corev1.VolumeMount{{
+								Name:      "homeserver",
+								MountPath: "/data-homeserver",
+							}, {
+								Name:      "homeserver-secrets",
+								MountPath: "/data-homeserver-secrets",
+							}, {
+								Name:      "signing-key",
+								MountPath: signingKeyMountPath,
+							}, {
+								Name:      "worker",
+								MountPath: workerConfigMountPath,
+							}},
+							Ports: []corev1.ContainerPort{{
+								ContainerPort: replicationPort,
+							}},
+							Resources: pool.Resources,
+						}},
+						ServiceAccountName: s.Name,
+						Volumes: []corev1.Volume{{
+							Name: "homeserver",
+							VolumeSource: corev1.VolumeSource{
+								ConfigMap: &corev1.ConfigMapVolumeSource{
+									LocalObjectReference: corev1.LocalObjectReference{
+										Name: s.Name,
+									},
+								},
+							},
+						}, {
+							Name: "homeserver-secrets",
+							VolumeSource: corev1.VolumeSource{
+								Secret: &corev1.SecretVolumeSource{
+									SecretName: s.Name,
+								},
+							},
+						}, {
+							Name: "signing-key",
+							VolumeSource: corev1.VolumeSource{
+								Secret: &corev1.SecretVolumeSource{
+									SecretName: signingKeySecretName(*s),
+								},
+							},
+						}, {
+							Name: "worker",
+							VolumeSource: corev1.VolumeSource{
+								ConfigMap: &corev1.ConfigMapVolumeSource{
+									LocalObjectReference: corev1.LocalObjectReference{
+										Name: objectMeta.Name,
+									},
+								},
+							},
+						}},
+					},
+				},
+			},
+		}
+
+		podSpec := &dep.Spec.Template.Spec
+		container := &podSpec.Containers[0]
+
+		if workerResources(*s, pool.Type) != nil {
+			container.Ports = append(container.Ports, corev1.ContainerPort{
+				ContainerPort: workerHTTPPort,
+			})
+		}
+
+		// Replicated workers are named after their pod, which name is
+		// written in a configuration file by an init container
+		if isWorkerNamedAfterPod(pool) {
+			podSpec.InitContainers = []corev1.Container{{
+				Image:   s.Spec.Image,
+				Name:    "worker-name",
+				Command: []string{"sh", "-c", `echo "worker_name: $POD_NAME" > ` + workerNameMountPath + "/" + workerNameFile},
+				Env: []corev1.EnvVar{{
+					Name: "POD_NAME",
+					ValueFrom: &corev1.EnvVarSource{
+						FieldRef: &corev1.ObjectFieldSelector{FieldPath: "metadata.name"},
+					},
+				}},
+				VolumeMounts: []corev1.VolumeMount{{
+					Name:      "worker-name",
+					MountPath: workerNameMountPath,
+				}},
+			}}
+			container.Args = append(container.Args, "--config-path", workerNameMountPath+"/"+workerNameFile)
+			container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+				Name:      "worker-name",
+				MountPath: workerNameMountPath,
+			})
+			podSpec.Volumes = append(podSpec.Volumes, corev1.Volume{
+				Name: "worker-name",
+				VolumeSource: corev1.VolumeSource{
+					EmptyDir: &corev1.EmptyDirVolumeSource{},
+				},
+			})
+		}
+
+		// The media repository workers store the media on the Synapse PVC.
+		// As the PVC is ReadWriteOnce, they must run on the node of the
+		// main Synapse process.
+		if pool.Type == synapsev1alpha1.WorkerTypeMediaRepository {
+			container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+				Name:      "data-pv",
+				MountPath: "/data",
+			})
+			podSpec.Volumes = append(podSpec.Volumes, corev1.Volume{
+				Name: "data-pv",
+				VolumeSource: corev1.VolumeSource{
+					PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+						ClaimName: s.Name,
+					},
+				},
+			})
+			podSpec.Affinity = &corev1.Affinity{
+				PodAffinity: &corev1.PodAffinity{
+					RequiredDuringSchedulingIgnoredDuringExecution: []corev1.PodAffinityTerm{{
+						LabelSelector: &metav1.LabelSelector{
+							MatchLabels: labelsForSynapse(s.Name),
+						},
+						TopologyKey: corev1.LabelHostname,
+					}},
+				},
+			}
+		}
+
+		// The registration file of the Heisenbridge is listed in
+		// homeserver.yaml
+		if s.Spec.Bridges.Heisenbridge.Enabled {
+			container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+				Name:      "data-heisenbridge",
+				MountPath: "/data-heisenbridge",
+			})
+			podSpec.Volumes = append(podSpec.Volumes, corev1.Volume{
+				Name: "data-heisenbridge",
+				VolumeSource: corev1.VolumeSource{
+					Secret: &corev1.SecretVolumeSource{
+						SecretName: s.Name + "-heisenbridge",
+					},
+				},
+			})
+		}
+
+		// The TLS certificates referenced in homeserver.yaml are loaded by
+		// the workers as well
+		if isRouteReencrypt(*s) {
+			container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+				Name:      "tls",
+				MountPath: routeTLSMountPath,
+			})
+			podSpec.Volumes = append(podSpec.Volumes, corev1.Volume{
+				Name: "tls",
+				VolumeSource: corev1.VolumeSource{
+					Secret: &corev1.SecretVolumeSource{
+						SecretName: servingCertSecretName(*s),
+					},
+				},
+			})
+		}
+		if isFederationListenerEnabled(*s) && s.Spec.Federation.TLSSecretName != "" {
+			container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+				Name:      "federation-tls",
+				MountPath: federationTLSMountPath,
+			})
+			podSpec.Volumes = append(podSpec.Volumes, corev1.Volume{
+				Name: "federation-tls",
+				VolumeSource: corev1.VolumeSource{
+					Secret: &corev1.SecretVolumeSource{
+						SecretName: s.Spec.Federation.TLSSecretName,
+					},
+				},
+			})
+		}
+
+		// Set Synapse instance as the owner and controller
+		if err := ctrl.SetControllerReference(s, dep, r.Scheme); err != nil {
+			return &appsv1.Deployment{}, err
+		}
+		return dep, nil
+	}
+}
+
+// asStatefulSet returns a function of type createResourceFunc, to be
+// passed as an argument in a call to reconcileResource.
+//
+// The returned function generates a Deployment with the given
+// createResourceFunc, and converts it to a StatefulSet with the same pod
+// template. The pods are created and deleted in parallel, as they don't
+// depend on each other.
+func (r *SynapseReconciler) asStatefulSet(createResource createResourceFunc) createResourceFunc {
+	return func(s *synapsev1alpha1.Synapse, objectMeta metav1.ObjectMeta) (client.Object, error) {
+		resource, err := createResource(s, objectMeta)
+		if err != nil {
+			return &appsv1.StatefulSet{}, err
+		}
+
+		dep, ok := resource.(*appsv1.Deployment)
+		if !ok {
+			return &appsv1.StatefulSet{}, errors.New("generated resource is not a Deployment")
+		}
+
+		return &appsv1.StatefulSet{
+			ObjectMeta: dep.ObjectMeta,
+			Spec: appsv1.StatefulSetSpec{
+				Replicas:            dep.Spec.Replicas,
+				Selector:            dep.Spec.Selector,
+				Template:            dep.Spec.Template,
+				ServiceName:         objectMeta.Name,
+				PodManagementPolicy: appsv1.ParallelPodManagement,
+			},
+		}, nil
+	}
+}
+
+// serviceForWorkerPool returns a function of type createResourceFunc, to be
+// passed as an argument in a call to reconcileResource.
+//
+// The returned function generates a Service for the workers of the given
+// pool, exposing their replication listener and, if any, their HTTP
+// listener.
+func (r *SynapseReconciler) serviceForWorkerPool(pool synapsev1alpha1.SynapseWorkerPool) createResourceFunc {
+	return func(s *synapsev1alpha1.Synapse, objectMeta metav1.ObjectMeta) (client.Object, error) {
+		service := &corev1.Service{
+			ObjectMeta: objectMeta,
+			Spec: corev1.ServiceSpec{
+				Ports: []corev1.ServicePort{{
+					Name:       "replication",
+					Protocol:   corev1.ProtocolTCP,
+					Port:       replicationPort,
+					TargetPort: intstr.FromInt(replicationPort),
+				}},
+				Selector: labelsForWorkerPool(s.Name, pool.Name),
+				Type:     corev1.ServiceTypeClusterIP,
+			},
+		}
+
+		if workerResources(*s, pool.Type) != nil {
+			service.Spec.Ports = append(service.Spec.Ports, corev1.ServicePort{
+				Name:       "http",
+				Protocol:   corev1.ProtocolTCP,
+				Port:       workerHTTPPort,
+				TargetPort: intstr.FromInt(workerHTTPPort),
+			})
+		}
+
+		// Set Synapse instance as the owner and controller
+		if err := ctrl.SetControllerReference(s, service, r.Scheme); err != nil {
+			return &corev1.Service{}, err
+		}
+		return service, nil
+	}
+}
+
+// horizontalPodAutoscalerForWorkerPool returns a function of type
+// createResourceFunc, to be passed as an argument in a call to
+// reconcileResource.
+//
+// The returned function generates a HorizontalPodAutoscaler scaling the
+// Deployment or StatefulSet of the given pool, according to its Autoscaling
+// spec.
+func (r *SynapseReconciler) horizontalPodAutoscalerForWorkerPool(pool synapsev1alpha1.SynapseWorkerPool) createResourceFunc {
+	return func(s *synapsev1alpha1.Synapse, objectMeta metav1.ObjectMeta) (client.Object, error) {
+		autoscaling := pool.Autoscaling
+
+		metrics := append([]autoscalingv2.MetricSpec{}, autoscaling.Metrics...)
+		if autoscaling.TargetCPUUtilizationPercentage != nil {
+			metrics = append(metrics, autoscalingv2.MetricSpec{
+				Type: autoscalingv2.ResourceMetricSourceType,
+				Resource: &autoscalingv2.ResourceMetricSource{
+					Name: corev1.ResourceCPU,
+					Target: autoscalingv2.MetricTarget{
+						Type:               autoscalingv2.UtilizationMetricType,
+						AverageUtilization: autoscaling.TargetCPUUtilizationPercentage,
+					},
+				},
+			})
+		}
+
+		kind := "Deployment"
+		if isWorkerPoolStatefulSet(pool) {
+			kind = "StatefulSet"
+		}
+
+		hpa := &autoscalingv2.HorizontalPodAutoscaler{
+			ObjectMeta: objectMeta,
+			Spec: autoscalingv2.HorizontalPodAutoscalerSpec{
+				ScaleTargetRef: autoscalingv2.CrossVersionObjectReference{
+					APIVersion: "apps/v1",
+					Kind:       kind,
+					Name:       objectMeta.Name,
+				},
+				MinReplicas: autoscaling.MinReplicas,
+				MaxReplicas: autoscaling.MaxReplicas,
+				Metrics:     metrics,
+			},
+		}
+
+		// Set Synapse instance as the owner and controller
+		if err := ctrl.SetControllerReference(s, hpa, r.Scheme); err != nil {
+			return &autoscalingv2.HorizontalPodAutoscaler{}, err
+		}
+		return hpa, nil
+	}
+}
+
+// federationSenderInstances returns the names of the federation_sender
+// workers. The workers of an autoscaled pool are named after the pods of its
+// StatefulSet, whose number is set by the HorizontalPodAutoscaler. Other
+// workers are named after their pool.
+//
+// Only the federation_sender workers read this list, which is therefore
+// written in the worker configuration file of the federation_sender pools
+// rather than in homeserver.yaml. Scaling an autoscaled federation_sender
+// pool only restarts the federation_sender workers, which must agree on the
+// list to share out the destinations between them.
+func (r *SynapseReconciler) federationSenderInstances(ctx context.Context, s synapsev1alpha1.Synapse) ([]string, error) {
+	var names []string
+	for _, pool := range s.Spec.Workers {
+		if pool.Type != synapsev1alpha1.WorkerTypeFederationSender {
+			continue
+		}
+		if !isWorkerPoolStatefulSet(pool) {
+			names = append(names, pool.Name)
+			continue
+		}
+
+		// A new StatefulSet is created with a single pod
+		replicas := int32(1)
+		var sts appsv1.StatefulSet
+		key := types.NamespacedName{Name: workerPoolResourceName(s, pool), Namespace: s.Namespace}
+		if err := r.Get(ctx, key, &sts); err != nil && !k8serrors.IsNotFound(err) {
+			return nil, err
+		} else if err == nil && sts.Spec.Replicas != nil {
+			replicas = *sts.Spec.Replicas
+		}
+
+		for i := int32(0); i < replicas; i++ {
+			names = append(names, key.Name+"-"+strconv.Itoa(int(i)))
+		}
+	}
+	return names, nil
+}
+
+// updateHomeserverWithWorkers is a function of type updateDataFunc, to be
+// passed as an argument in a call to withConfigMapUpdates.
+//
+// It adds a replication listener to the main Synapse process, and moves the
+// tasks handled by the workers out of the main Synapse process. Workers
+// which can't be replicated are addressed by the name of their pool. The
+// federation_sender workers are listed in their own worker configuration
+// file, see federationSenderInstances. The replication over Redis is
+// configured by updateHomeserverWithRedis.
+func (r *SynapseReconciler) updateHomeserverWithWorkers(s synapsev1alpha1.Synapse, homeserver map[string]interface{}) error {
+	// Replace any listener previously defined on the replication port
+	listeners, _ := homeserver["listeners"].([]interface{})
+	var updatedListeners []interface{}
+	for _, listener := range listeners {
+		if listener, ok := listener.(map[interface{}]interface{}); ok && listener["port"] == replicationPort {
+			continue
+		}
+		updatedListeners = append(updatedListeners, listener)
+	}
+	homeserver["listeners"] = append(updatedListeners, replicationListener())
+
+	instanceMap := map[string]interface{}{}
+	for _, pool := range s.Spec.Workers {
+		if !isWorkerNamedAfterPod(pool) {
+			instanceMap[pool.Name] = map[string]interface{}{
+				"host": r.serviceHost(s, workerPoolResourceName(s, pool)),
+				"port": replicationPort,
+			}
+		}
+	}
+	if len(instanceMap) != 0 {
+		homeserver["instance_map"] = instanceMap
+	} else {
+		delete(homeserver, "instance_map")
+	}
+
+	if names := workerNames(s, synapsev1alpha1.WorkerTypeEventPersister); len(names) != 0 {
+		homeserver["stream_writers"] = map[string]interface{}{"events": names}
+	} else {
+		delete(homeserver, "stream_writers")
+	}
+
+	if names := workerNames(s, synapsev1alpha1.WorkerTypeFederationSender); len(names) != 0 {
+		homeserver["send_federation"] = false
+		delete(homeserver, "federation_sender_instances")
+	}
+
+	if names := workerNames(s, synapsev1alpha1.WorkerTypePusher); len(names) != 0 {
+		homeserver["start_pushers"] = false
+		homeserver["pusher_instances"] = names
+	}
+
+	if names := workerNames(s, synapsev1alpha1.WorkerTypeAppservice); len(names) != 0 {
+		homeserver["notify_appservices_from_worker"] = names[0]
+	}
+
+	if len(workerNames(s, synapsev1alpha1.WorkerTypeMediaRepository)) != 0 {
+		homeserver["enable_media_repo"] = false
+	}
+
+	return nil
+}
+
+// workerManagedKeys returns the top-level homeserver.yaml keys managed by
+// the Synapse Operator when Synapse runs in worker mode.
+func workerManagedKeys(s synapsev1alpha1.Synapse) []string {
+	managedKeys := []string{"redis", "instance_map", "stream_writers"}
+
+	if len(workerNames(s, synapsev1alpha1.WorkerTypeFederationSender)) != 0 {
+		managedKeys = append(managedKeys, "send_federation", "federation_sender_instances")
+	}
+	if len(workerNames(s, synapsev1alpha1.WorkerTypePusher)) != 0 {
+		managedKeys = append(managedKeys, "start_pushers", "pusher_instances")
+	}
+	if len(workerNames(s, synapsev1alpha1.WorkerTypeAppservice)) != 0 {
+		managedKeys = append(managedKeys, "notify_appservices_from_worker")
+	}
+	if len(workerNames(s, synapsev1alpha1.WorkerTypeMediaRepository)) != 0 {
+		managedKeys = append(managedKeys, "enable_media_repo")
+	}
+
+	return managedKeys
+}
+
+// deleteStaleWorkerPools deletes the resources of the pools of workers
+// which are no longer declared in Spec.Workers.
+func (r *SynapseReconciler) deleteStaleWorkerPools(ctx context.Context, s *synapsev1alpha1.Synapse) error {
+	pools := map[string]bool{}
+	for _, pool := range s.Spec.Workers {
+		pools[pool.Name] = true
+	}
+
+	labels := client.MatchingLabels{"app": "synapse-worker", "synapse_cr": s.Name}
+
+	var deployments appsv1.DeploymentList
+	if err := r.List(ctx, &deployments, client.InNamespace(s.Namespace), labels); err != nil {
+		return err
+	}
+	var services corev1.ServiceList
+	if err := r.List(ctx, &services, client.InNamespace(s.Namespace), labels); err != nil {
+		return err
+	}
+	var configMaps corev1.ConfigMapList
+	if err := r.List(ctx, &configMaps, client.InNamespace(s.Namespace), labels); err != nil {
+		return err
+	}
+	var hpas autoscalingv2.HorizontalPodAutoscalerList
+	if err := r.List(ctx, &hpas, client.InNamespace(s.Namespace), labels); err != nil {
+		return err
+	}
+	var statefulSets appsv1.StatefulSetList
+	if err := r.List(ctx, &statefulSets, client.InNamespace(s.Namespace), labels); err != nil {
+		return err
+	}
+
+	var resources []client.Object
+	for i := range deployments.Items {
+		resources = append(resources, &deployments.Items[i])
+	}
+	for i := range services.Items {
+		resources = append(resources, &services.Items[i])
+	}
+	for i := range configMaps.Items {
+		resources = append(resources, &configMaps.Items[i])
+	}
+	for i := range hpas.Items {
+		resources = append(resources, &hpas.Items[i])
+	}
+	for i := range statefulSets.Items {
+		resources = append(resources, &statefulSets.Items[i])
+	}
+
+	for _, resource := range resources {
+		if pools[resource.GetLabels()["synapse_worker_pool"]] {
+			continue
+		}
+		objectMeta := setObjectMeta(resource.GetName(), resource.GetNamespace(), map[string]string{})
+		if err := r.deleteResource(ctx, s, resource, objectMeta); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -16,7 +16,22 @@ limitations under the License.
 
 package synapse
 
-import "gopkg.in/yaml.v2"
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"gopkg.in/yaml.v2"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	synapsev1alpha1 "github.com/opdev/synapse-operator/apis/synapse/v1alpha1"
+)
 
 func (r *SynapseReconciler) convertStructToMap(in interface{}) (map[string]interface{}, error) {
 	var intermediate []byte
@@ -39,9 +54,100 @@ func boolToYesNo(report_stats bool) string {
 	return "no"
 }
 
-func (r *SynapseReconciler) boolToString(b bool) string {
-	if b {
-		return "true"
+// generateRandomString returns a cryptographically secure random string of
+// the given length, composed of alphanumeric characters.
+func generateRandomString(length int) (string, error) {
+	const charset = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+	b := make([]byte, length)
+	for i := range b {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(charset))))
+		if err != nil {
+			return "", err
+		}
+		b[i] = charset[n.Int64()]
+	}
+
+	return string(b), nil
+}
+
+// setAnnotation sets the given annotation on an object, or removes it if the
+// value is empty.
+func setAnnotation(objectMeta *metav1.ObjectMeta, key string, value string) {
+	if value == "" {
+		delete(objectMeta.Annotations, key)
+		return
+	}
+
+	if objectMeta.Annotations == nil {
+		objectMeta.Annotations = map[string]string{}
+	}
+	objectMeta.Annotations[key] = value
+}
+
+// configChecksumAnnotation is set on the pod template of the Synapse and
+// Heisenbridge Deployments. Its value is a checksum of the configuration
+// files and Secrets used by the pods, so that any change in the rendered
+// configuration triggers a rolling update of the Deployment.
+const configChecksumAnnotation = "synapse.opdev.io/config-checksum"
+
+// withConfigChecksum returns a function of type createResourceFunc, to be
+// passed as an argument in a call to reconcileResource.
+//
+// The returned function generates a Deployment with the given
+// createResourceFunc, and sets the configChecksumAnnotation on its pod
+// template. The checksum is computed over the data of the given ConfigMaps
+// and Secrets, which are expected to be already reconciled.
+func (r *SynapseReconciler) withConfigChecksum(
+	createResource createResourceFunc,
+	configObjects ...client.Object,
+) createResourceFunc {
+	return func(s *synapsev1alpha1.Synapse, objectMeta metav1.ObjectMeta) (client.Object, error) {
+		resource, err := createResource(s, objectMeta)
+		if err != nil {
+			return &appsv1.Deployment{}, err
+		}
+
+		dep, ok := resource.(*appsv1.Deployment)
+		if !ok {
+			return &appsv1.Deployment{}, errors.New("generated resource is not a Deployment")
+		}
+
+		checksum, err := configChecksum(configObjects...)
+		if err != nil {
+			return &appsv1.Deployment{}, err
+		}
+		setAnnotation(&dep.Spec.Template.ObjectMeta, configChecksumAnnotation, checksum)
+
+		return dep, nil
 	}
-	return "false"
+}
+
+// configChecksum returns the sha256 checksum of the data of the given
+// ConfigMaps and Secrets.
+func configChecksum(configObjects ...client.Object) (string, error) {
+	hash := sha256.New()
+
+	for _, obj := range configObjects {
+		var data interface{}
+		switch o := obj.(type) {
+		case *corev1.ConfigMap:
+			data = []interface{}{o.Data, o.BinaryData}
+		case *corev1.Secret:
+			data = []interface{}{o.Data, o.StringData}
+		default:
+			return "", errors.New("cannot compute the checksum of a " + fmt.Sprintf("%T", obj))
+		}
+
+		// Map keys are sorted by json.Marshal, which makes the checksum
+		// deterministic
+		content, err := json.Marshal(data)
+		if err != nil {
+			return "", err
+		}
+		hash.Write([]byte(obj.GetName()))
+		hash.Write(content)
+	}
+
+	return fmt.Sprintf("%x", hash.Sum(nil)), nil
 }